@@ -28,30 +28,67 @@ var SecretManagerFunc = func() (SecretsManagerInterface, error) {
 // To replace it with a mock in tests.
 var loadAWSConfig = config.LoadDefaultConfig
 
-func LoadConfig() (*Config, error) {
-	secretName := "bitbucket_metrics"
+// DefaultSecretName is the Secrets Manager secret holding the configuration.
+const DefaultSecretName = "bitbucket_metrics"
+
+// Version stages of the configuration secret. Rotation moves AWSCURRENT to the new version and AWSPREVIOUS to the
+// version it replaced; the other secret providers map them onto their own versions.
+const (
+	VersionStageCurrent  = "AWSCURRENT"
+	VersionStagePrevious = "AWSPREVIOUS"
+)
+
+// AWSSecretProvider reads the configuration from an AWS Secrets Manager secret.
+type AWSSecretProvider struct {
+	SecretName string
+}
 
+// GetSecret returns the version of the secret labelled stage.
+func (p *AWSSecretProvider) GetSecret(ctx context.Context, stage string) (string, string, error) {
 	svc, err := SecretManagerFunc()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		return "", "", fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
 	input := &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(secretName),
+		SecretId:     aws.String(p.SecretName),
+		VersionStage: aws.String(stage),
 	}
 
-	result, err := svc.GetSecretValue(context.Background(), input)
+	result, err := svc.GetSecretValue(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve secret: %w", err)
+		return "", "", fmt.Errorf("failed to retrieve secret: %w", err)
+	}
+	return aws.ToString(result.SecretString), aws.ToString(result.VersionId), nil
+}
+
+// LoadConfig loads the current version of the configuration from the secret provider configured by the environment.
+func LoadConfig() (*Config, error) {
+	provider, err := SecretProviderFunc()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure secret provider: %w", err)
+	}
+	config, _, err := LoadConfigVersion(provider, VersionStageCurrent)
+	return config, err
+}
+
+// LoadConfigVersion loads the version of the configuration labelled with stage from provider and returns it with its
+// version ID.
+func LoadConfigVersion(provider SecretProvider, stage string) (*Config, string, error) {
+	secretString, version, err := provider.GetSecret(context.Background(), stage)
+	if err != nil {
+		return nil, "", err
 	}
 
-	secretString := *result.SecretString
 	config := &Config{}
 
 	err = json.Unmarshal([]byte(secretString), config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal secret string: %w", err)
+		return nil, "", fmt.Errorf("failed to unmarshal secret string: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	return config, nil
+	return config, version, nil
 }
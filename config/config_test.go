@@ -147,10 +147,12 @@ func TestLoadConfig_Success(t *testing.T) {
 				"bitbucket_access_token": "test_token",
 				"mongodb_uri": "mongodb://test_uri",
 				"region": "us-east-1",
-				"repo_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}",
+				"repo_url_template": "https://bitbucket.org/api/2.0/repositories/{username}",
 				"commits_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/commits",
 				"commit_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}",
-				"diffstat_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}"
+				"diffstat_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}",
+				"pull_requests_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/pullrequests",
+				"pipelines_url_template": "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/pipelines/"
 			}`
 			return &secretsmanager.GetSecretValueOutput{
 				SecretString: aws.String(secretString),
@@ -171,8 +173,10 @@ func TestLoadConfig_Success(t *testing.T) {
 	assert.Equal(t, "test_token", config.BitbucketAccessToken)
 	assert.Equal(t, "mongodb://test_uri", config.MongoDBURI)
 	assert.Equal(t, "us-east-1", config.Region)
-	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}", config.RepoURLTemplate)
+	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}", config.RepoURLTemplate)
 	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/commits", config.CommitsURLTemplate)
 	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}", config.CommitURLTemplate)
 	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}", config.DiffstatURLTemplate)
+	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/pullrequests", config.PullRequestsURLTemplate)
+	assert.Equal(t, "https://bitbucket.org/api/2.0/repositories/{username}/{repo_slug}/pipelines/", config.PipelinesURLTemplate)
 }
@@ -1,11 +1,97 @@
 package config
 
+// Config is the configuration of the tool. The URL templates of the Bitbucket Cloud API name their placeholders:
+// {username} is the workspace, {repo_slug} the repository, {commit_hash} the commit and {pull_request_id} the
+// pull request. The pull request commits URL defaults to the pull requests URL followed by
+// /{pull_request_id}/commits. PseudonymKey keys the pseudonyms of privacy mode and, whatever the mode, the hashes
+// the names of erased people are recorded by, so erasures need it.
 type Config struct {
-	BitbucketAccessToken string `json:"bitbucket_access_token"`
-	MongoDBURI           string `json:"mongodb_uri"`
-	Region               string `json:"region"`
-	RepoURLTemplate      string `json:"repo_url_template"`
-	CommitsURLTemplate   string `json:"commits_url_template"`
-	CommitURLTemplate    string `json:"commit_url_template"`
-	DiffstatURLTemplate  string `json:"diffstat_url_template"`
+	BitbucketAccessToken           string            `json:"bitbucket_access_token"`
+	MongoDBURI                     string            `json:"mongodb_uri"`
+	Region                         string            `json:"region"`
+	RepoURLTemplate                string            `json:"repo_url_template"`
+	CommitsURLTemplate             string            `json:"commits_url_template"`
+	CommitURLTemplate              string            `json:"commit_url_template"`
+	DiffstatURLTemplate            string            `json:"diffstat_url_template"`
+	PullRequestsURLTemplate        string            `json:"pull_requests_url_template"`
+	PipelinesURLTemplate           string            `json:"pipelines_url_template"`
+	PullRequestActivityURLTemplate string            `json:"pull_request_activity_url_template,omitempty"`
+	PullRequestCommitsURLTemplate  string            `json:"pull_request_commits_url_template,omitempty"`
+	IdentityRules                  []IdentityRule    `json:"identity_rules,omitempty"`
+	MailmapPath                    string            `json:"mailmap_path,omitempty"`
+	TeamsPath                      string            `json:"teams_path,omitempty"`
+	PrivacyMode                    bool              `json:"privacy_mode,omitempty"`
+	PseudonymKey                   string            `json:"pseudonym_key,omitempty"`
+	RedactCommitMessages           bool              `json:"redact_commit_messages,omitempty"`
+	RetentionPolicies              []RetentionPolicy `json:"retention_policies,omitempty"`
+	IssueProjects                  []string          `json:"issue_projects,omitempty"`
+	Jira                           *JiraConfig       `json:"jira,omitempty"`
+	BotAuthorPatterns              []string          `json:"bot_author_patterns,omitempty"`
+	ReworkWindowDays               int               `json:"rework_window_days,omitempty"`
+	Sources                        []Source          `json:"sources,omitempty"`
+	Auth                           *AuthConfig       `json:"auth,omitempty"`
+}
+
+// IdentityRule merges every author alias it matches into one person.
+// An alias matches when any of the listed values or the email pattern matches.
+type IdentityRule struct {
+	PersonID     string   `json:"person_id"`
+	Name         string   `json:"name,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+	EmailPattern string   `json:"email_pattern,omitempty"`
+	AccountUUIDs []string `json:"account_uuids,omitempty"`
+	Names        []string `json:"names,omitempty"`
+	Nicknames    []string `json:"nicknames,omitempty"`
+}
+
+// RetentionPolicy keeps the data of a workspace for a number of months.
+// A policy without a workspace applies to every workspace without a policy of its own.
+type RetentionPolicy struct {
+	Workspace string `json:"workspace,omitempty"`
+	Months    int    `json:"months"`
+}
+
+// JiraConfig connects to a Jira Cloud site to enrich commits with the issues they link to.
+// EpicField names the custom field holding the epic link in company-managed projects, such as customfield_10014.
+type JiraConfig struct {
+	BaseURL   string `json:"base_url"`
+	Email     string `json:"email"`
+	APIToken  string `json:"api_token"`
+	EpicField string `json:"epic_field,omitempty"`
+}
+
+// Source is an SCM instance whose repositories are collected.
+// Type is "cloud", "datacenter", "github", "gitlab" or "local". Cloud sources use the URL templates and the workspace given;
+// Data Center sources reach BaseURL with their own token and collect the listed projects, or every repository when none are listed.
+// GitHub and GitLab sources collect the organization or group named by Workspace. Their BaseURL defaults to
+// the hosted service; set it to the API URL of GitHub Enterprise or to a self-managed GitLab.
+// Local sources read the clones in the Path directory without any API calls. Their commits are stored under
+// Workspace, ProjectKey and ProjectName and tagged with Provider, so they match the commits collected from the
+// instance the clones mirror. ProjectName defaults to ProjectKey.
+// A source authenticates with Auth when set, then with Token, and otherwise as the default workspace does.
+type Source struct {
+	Type        string      `json:"type"`
+	Workspace   string      `json:"workspace,omitempty"`
+	BaseURL     string      `json:"base_url,omitempty"`
+	Token       string      `json:"token,omitempty"`
+	Projects    []string    `json:"projects,omitempty"`
+	Path        string      `json:"path,omitempty"`
+	Provider    string      `json:"provider,omitempty"`
+	ProjectKey  string      `json:"project_key,omitempty"`
+	ProjectName string      `json:"project_name,omitempty"`
+	Auth        *AuthConfig `json:"auth,omitempty"`
+}
+
+// AuthConfig selects how requests to an SCM instance are authenticated.
+// Type "token" and "workspace_token" send Token as a bearer token; "app_password" and "api_token" send
+// Username and Password with basic auth; "client_credentials" exchanges the ClientID and ClientSecret of an
+// OAuth consumer for access tokens at TokenURL, which defaults to Bitbucket Cloud.
+type AuthConfig struct {
+	Type         string `json:"type"`
+	Token        string `json:"token,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	TokenURL     string `json:"token_url,omitempty"`
 }
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// Reloader keeps the configuration in use up to date with its secret, so rotated credentials are picked up without
+// a restart.
+type Reloader struct {
+	provider SecretProvider
+	apply    func(*Config) error
+
+	mu      sync.Mutex
+	current *Config
+	version string
+	// rejected is the version apply rejected last, which is not tried again while it stays the current one.
+	rejected string
+}
+
+// NewReloader returns a reloader reading the configuration from provider and handing every new version of it to
+// apply, which validates it and puts it to use. A version apply rejects is never used. The provider is kept across
+// refreshes, so logins it makes are reused.
+func NewReloader(provider SecretProvider, apply func(*Config) error) *Reloader {
+	return &Reloader{provider: provider, apply: apply}
+}
+
+// Load applies the current version of the configuration, or the previous version when the current one is rejected.
+func (r *Reloader) Load() (*Config, error) {
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+	return r.Current(), nil
+}
+
+// Current returns the configuration in use, nil before it is loaded.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Refresh applies the current version of the configuration when it is not in use yet. When apply rejects it, the
+// previous version takes its place, unless that version is the one in use already, in which case nothing changes and
+// the rejection is returned. A rejected version is skipped by later refreshes until the version changes.
+func (r *Reloader) Refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, version, err := LoadConfigVersion(r.provider, VersionStageCurrent)
+	if err != nil {
+		return err
+	}
+	if r.current != nil && (version == r.version || version == r.rejected) {
+		return nil
+	}
+	rejected := r.apply(config)
+	if rejected == nil {
+		r.use(config, version)
+		return nil
+	}
+	r.rejected = version
+	slog.Warn("Configuration version was rejected", "version", version, logging.KeyError, rejected)
+
+	previous, previousVersion, err := LoadConfigVersion(r.provider, VersionStagePrevious)
+	if err != nil {
+		return fmt.Errorf("configuration version %s was rejected: %w, and the previous version is unavailable: %v", version, rejected, err)
+	}
+	if r.current != nil && previousVersion == r.version {
+		return fmt.Errorf("configuration version %s was rejected, keeping version %s: %w", version, r.version, rejected)
+	}
+	if err := r.apply(previous); err != nil {
+		return fmt.Errorf("configuration version %s was rejected: %w, and so was the previous version %s: %v", version, rejected, previousVersion, err)
+	}
+	r.use(previous, previousVersion)
+	return nil
+}
+
+func (r *Reloader) use(config *Config, version string) {
+	r.current, r.version = config, version
+	slog.Info("Using configuration version", "version", version)
+}
+
+// RefreshPeriodically refreshes the configuration once per interval, logging failures and keeping the configuration
+// in use when a refresh fails.
+func (r *Reloader) RefreshPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.Refresh(); err != nil {
+			slog.Error("Failed to refresh configuration", logging.KeyError, err)
+		}
+	}
+}
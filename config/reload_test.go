@@ -0,0 +1,142 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecret serves versions of the configuration secret, each holding the access token it is named after, by the
+// stage labelling them.
+type fakeSecret struct {
+	stages map[string]string
+}
+
+func (f *fakeSecret) rotate(version string) {
+	f.stages[VersionStagePrevious] = f.stages[VersionStageCurrent]
+	f.stages[VersionStageCurrent] = version
+}
+
+func useFakeSecret(t *testing.T, current, previous string) *fakeSecret {
+	secret := &fakeSecret{stages: map[string]string{VersionStageCurrent: current, VersionStagePrevious: previous}}
+	mockSM := &MockSecretsManager{
+		GetSecretValueFunc: func(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+			version, ok := secret.stages[aws.ToString(params.VersionStage)]
+			if !ok || version == "" {
+				return nil, errors.New("version not found")
+			}
+			return &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(secretFor(version)),
+				VersionId:    aws.String(version),
+			}, nil
+		},
+	}
+
+	originalSecretManagerFunc := SecretManagerFunc
+	t.Cleanup(func() { SecretManagerFunc = originalSecretManagerFunc })
+	SecretManagerFunc = func() (SecretsManagerInterface, error) {
+		return mockSM, nil
+	}
+	return secret
+}
+
+// awsProvider reads the secret served by useFakeSecret.
+var awsProvider = &AWSSecretProvider{SecretName: DefaultSecretName}
+
+// recordingApply records the access tokens of the applied configurations and rejects those in rejected.
+func recordingApply(applied *[]string, rejected map[string]bool) func(*Config) error {
+	return func(config *Config) error {
+		if rejected[config.BitbucketAccessToken] {
+			return errors.New("invalid credentials")
+		}
+		*applied = append(*applied, config.BitbucketAccessToken)
+		return nil
+	}
+}
+
+func TestReloader_Rotation(t *testing.T) {
+	secret := useFakeSecret(t, "v1", "")
+	var applied []string
+	reloader := NewReloader(awsProvider, recordingApply(&applied, nil))
+
+	config, err := reloader.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", config.BitbucketAccessToken)
+
+	// An unchanged version is not applied again
+	assert.NoError(t, reloader.Refresh())
+	assert.Equal(t, []string{"v1"}, applied)
+
+	secret.rotate("v2")
+	assert.NoError(t, reloader.Refresh())
+	assert.Equal(t, "v2", reloader.Current().BitbucketAccessToken)
+	assert.Equal(t, []string{"v1", "v2"}, applied)
+}
+
+func TestReloader_RejectedVersionKeepsVersionInUse(t *testing.T) {
+	secret := useFakeSecret(t, "v1", "")
+	var applied []string
+	apply := recordingApply(&applied, map[string]bool{"v2": true})
+	attempts := 0
+	reloader := NewReloader(awsProvider, func(config *Config) error {
+		attempts++
+		return apply(config)
+	})
+	_, err := reloader.Load()
+	assert.NoError(t, err)
+
+	secret.rotate("v2")
+	err = reloader.Refresh()
+	assert.EqualError(t, err, "configuration version v2 was rejected, keeping version v1: invalid credentials")
+	assert.Equal(t, "v1", reloader.Current().BitbucketAccessToken)
+	assert.Equal(t, []string{"v1"}, applied)
+	assert.Equal(t, 2, attempts)
+
+	// The rejected version is not tried again until it changes
+	assert.NoError(t, reloader.Refresh())
+	assert.Equal(t, 2, attempts)
+
+	secret.rotate("v3")
+	assert.NoError(t, reloader.Refresh())
+	assert.Equal(t, "v3", reloader.Current().BitbucketAccessToken)
+	assert.Equal(t, []string{"v1", "v3"}, applied)
+}
+
+func TestReloader_FallsBackToPreviousVersion(t *testing.T) {
+	useFakeSecret(t, "v2", "v1")
+	var applied []string
+	reloader := NewReloader(awsProvider, recordingApply(&applied, map[string]bool{"v2": true}))
+
+	config, err := reloader.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", config.BitbucketAccessToken)
+	assert.Equal(t, []string{"v1"}, applied)
+}
+
+func TestReloader_NoUsableVersion(t *testing.T) {
+	useFakeSecret(t, "v2", "v1")
+	reloader := NewReloader(awsProvider, func(config *Config) error {
+		return errors.New("invalid credentials")
+	})
+
+	config, err := reloader.Load()
+	assert.Nil(t, config)
+	assert.EqualError(t, err, "configuration version v2 was rejected: invalid credentials, and so was the previous version v1: invalid credentials")
+
+	useFakeSecret(t, "v2", "")
+	_, err = reloader.Load()
+	assert.EqualError(t, err, "configuration version v2 was rejected: invalid credentials, and the previous version is unavailable: failed to retrieve secret: version not found")
+}
+
+func TestLoadConfigVersion(t *testing.T) {
+	useFakeSecret(t, "v2", "v1")
+
+	config, version, err := LoadConfigVersion(awsProvider, VersionStagePrevious)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", version)
+	assert.Equal(t, "v1", config.BitbucketAccessToken)
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// SecretProvider reads the secret holding the configuration as JSON.
+type SecretProvider interface {
+	// GetSecret returns the version of the secret labelled stage, VersionStageCurrent or VersionStagePrevious,
+	// and an ID that changes with every version.
+	GetSecret(ctx context.Context, stage string) (string, string, error)
+}
+
+// Secret providers selectable through EnvSecretProvider.
+const (
+	SecretProviderAWS   = "aws"
+	SecretProviderVault = "vault"
+	SecretProviderFile  = "file"
+	SecretProviderEnv   = "env"
+)
+
+// Environment variables selecting and configuring the secret provider. Vault is further configured by the
+// variables of its own CLI: VAULT_ADDR, VAULT_NAMESPACE, VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID for AppRole.
+const (
+	EnvSecretProvider = "BITBUCKET_METRICS_SECRET_PROVIDER"
+	EnvSecretName     = "BITBUCKET_METRICS_SECRET_NAME"
+	EnvVaultMount     = "BITBUCKET_METRICS_VAULT_MOUNT"
+	EnvVaultPath      = "BITBUCKET_METRICS_VAULT_PATH"
+	EnvConfigFile     = "BITBUCKET_METRICS_CONFIG_FILE"
+	EnvConfig         = "BITBUCKET_METRICS_CONFIG"
+)
+
+// SecretProviderFunc returns the secret provider the configuration is loaded from. It can be replaced in tests.
+var SecretProviderFunc = NewSecretProviderFromEnv
+
+// NewSecretProviderFromEnv returns the secret provider selected by EnvSecretProvider, AWS Secrets Manager by default.
+func NewSecretProviderFromEnv() (SecretProvider, error) {
+	switch provider := os.Getenv(EnvSecretProvider); provider {
+	case "", SecretProviderAWS:
+		return &AWSSecretProvider{SecretName: envOrDefault(EnvSecretName, DefaultSecretName)}, nil
+	case SecretProviderVault:
+		return NewVaultSecretProviderFromEnv()
+	case SecretProviderFile:
+		path := os.Getenv(EnvConfigFile)
+		if path == "" {
+			return nil, fmt.Errorf("file secrets need %s", EnvConfigFile)
+		}
+		return &FileSecretProvider{Path: path}, nil
+	case SecretProviderEnv:
+		return &EnvironmentSecretProvider{Variable: EnvConfig}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q", provider)
+	}
+}
+
+// FileSecretProvider reads the configuration from a local file, such as a mounted Kubernetes secret. The file is
+// read again on every refresh, but its previous content is not kept.
+type FileSecretProvider struct {
+	Path string
+}
+
+// GetSecret returns the content of the file, versioned by its hash.
+func (p *FileSecretProvider) GetSecret(ctx context.Context, stage string) (string, string, error) {
+	if stage != VersionStageCurrent {
+		return "", "", fmt.Errorf("file secrets keep no %s version", stage)
+	}
+	content, err := os.ReadFile(p.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return string(content), contentVersion(content), nil
+}
+
+// EnvironmentSecretProvider reads the configuration from an environment variable holding it as JSON.
+type EnvironmentSecretProvider struct {
+	Variable string
+}
+
+// GetSecret returns the value of the variable, versioned by its hash.
+func (p *EnvironmentSecretProvider) GetSecret(ctx context.Context, stage string) (string, string, error) {
+	if stage != VersionStageCurrent {
+		return "", "", fmt.Errorf("environment secrets keep no %s version", stage)
+	}
+	value, ok := os.LookupEnv(p.Variable)
+	if !ok {
+		return "", "", fmt.Errorf("environment variable %s is not set", p.Variable)
+	}
+	return value, contentVersion([]byte(value)), nil
+}
+
+// contentVersion identifies a secret without a version of its own by its content.
+func contentVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+func envOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecretProviderFromEnv(t *testing.T) {
+	t.Setenv(EnvSecretProvider, "")
+	provider, err := NewSecretProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &AWSSecretProvider{SecretName: DefaultSecretName}, provider)
+
+	t.Setenv(EnvSecretProvider, SecretProviderAWS)
+	t.Setenv(EnvSecretName, "metrics/prod")
+	provider, err = NewSecretProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &AWSSecretProvider{SecretName: "metrics/prod"}, provider)
+
+	t.Setenv(EnvSecretProvider, SecretProviderFile)
+	_, err = NewSecretProviderFromEnv()
+	assert.EqualError(t, err, "file secrets need BITBUCKET_METRICS_CONFIG_FILE")
+	t.Setenv(EnvConfigFile, "/etc/bitbucket_metrics/config.json")
+	provider, err = NewSecretProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &FileSecretProvider{Path: "/etc/bitbucket_metrics/config.json"}, provider)
+
+	t.Setenv(EnvSecretProvider, SecretProviderEnv)
+	provider, err = NewSecretProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &EnvironmentSecretProvider{Variable: EnvConfig}, provider)
+
+	t.Setenv(EnvSecretProvider, "gcp")
+	_, err = NewSecretProviderFromEnv()
+	assert.EqualError(t, err, `unknown secret provider "gcp"`)
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"bitbucket_access_token": "v1"}`), 0o600))
+	provider := &FileSecretProvider{Path: path}
+
+	secret, version, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"bitbucket_access_token": "v1"}`, secret)
+
+	// A rewritten file is a new version
+	assert.NoError(t, os.WriteFile(path, []byte(`{"bitbucket_access_token": "v2"}`), 0o600))
+	_, rotated, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.NoError(t, err)
+	assert.NotEqual(t, version, rotated)
+
+	_, _, err = provider.GetSecret(context.Background(), VersionStagePrevious)
+	assert.EqualError(t, err, "file secrets keep no AWSPREVIOUS version")
+
+	_, _, err = (&FileSecretProvider{Path: filepath.Join(t.TempDir(), "missing.json")}).GetSecret(context.Background(), VersionStageCurrent)
+	assert.ErrorContains(t, err, "failed to read secret file")
+}
+
+func TestEnvironmentSecretProvider(t *testing.T) {
+	provider := &EnvironmentSecretProvider{Variable: EnvConfig}
+	t.Setenv(EnvConfig, "")
+	os.Unsetenv(EnvConfig)
+	_, _, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.EqualError(t, err, "environment variable BITBUCKET_METRICS_CONFIG is not set")
+
+	t.Setenv(EnvConfig, secretFor("env_token"))
+	t.Setenv(EnvSecretProvider, SecretProviderEnv)
+	config, err := LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "env_token", config.BitbucketAccessToken)
+
+	config, version, err := LoadConfigVersion(provider, VersionStageCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, "env_token", config.BitbucketAccessToken)
+	assert.Len(t, version, 16)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/lep13/bitbucket_metrics/internal/urltemplate"
+)
+
+// Validate checks that every configured URL template is a well-formed URL holding exactly the placeholders it is
+// filled with. The repository, commits, commit and diffstat templates are required when Bitbucket Cloud is collected,
+// through a cloud source or the default workspace used without sources; the other templates may be left empty.
+func (c *Config) Validate() error {
+	cloud := c.collectsCloud()
+	templates := []struct {
+		name         string
+		template     string
+		required     bool
+		placeholders []string
+	}{
+		{"repo_url_template", c.RepoURLTemplate, cloud, []string{urltemplate.Workspace}},
+		{"commits_url_template", c.CommitsURLTemplate, cloud, []string{urltemplate.Workspace, urltemplate.RepoSlug}},
+		{"commit_url_template", c.CommitURLTemplate, cloud, []string{urltemplate.Workspace, urltemplate.RepoSlug, urltemplate.CommitHash}},
+		{"diffstat_url_template", c.DiffstatURLTemplate, cloud, []string{urltemplate.Workspace, urltemplate.RepoSlug, urltemplate.CommitHash}},
+		{"pull_requests_url_template", c.PullRequestsURLTemplate, false, []string{urltemplate.Workspace, urltemplate.RepoSlug}},
+		{"pipelines_url_template", c.PipelinesURLTemplate, false, []string{urltemplate.Workspace, urltemplate.RepoSlug}},
+		{"pull_request_activity_url_template", c.PullRequestActivityURLTemplate, false, []string{urltemplate.Workspace, urltemplate.RepoSlug, urltemplate.PullRequestID}},
+		{"pull_request_commits_url_template", c.PullRequestCommitsURLTemplate, false, []string{urltemplate.Workspace, urltemplate.RepoSlug, urltemplate.PullRequestID}},
+	}
+	for _, t := range templates {
+		if t.template == "" {
+			if t.required {
+				return fmt.Errorf("missing %s, which Bitbucket Cloud needs", t.name)
+			}
+			continue
+		}
+		if err := urltemplate.Validate(t.template, t.placeholders...); err != nil {
+			return fmt.Errorf("invalid %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+// collectsCloud reports whether Bitbucket Cloud is collected: through the default workspace when no sources are
+// configured, or through a source of type cloud, the type of sources that name none.
+func (c *Config) collectsCloud() bool {
+	if len(c.Sources) == 0 {
+		return true
+	}
+	for _, source := range c.Sources {
+		if source.Type == "" || source.Type == "cloud" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// secretFor returns a configuration holding accessToken and the URL templates Bitbucket Cloud needs, as JSON.
+func secretFor(accessToken string) string {
+	secret, _ := json.Marshal(map[string]string{
+		"bitbucket_access_token": accessToken,
+		"repo_url_template":      "https://api.bitbucket.org/2.0/repositories/{username}",
+		"commits_url_template":   "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commits",
+		"commit_url_template":    "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}",
+		"diffstat_url_template":  "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}",
+	})
+	return string(secret)
+}
+
+func TestValidate(t *testing.T) {
+	config := &Config{
+		RepoURLTemplate:                "https://api.bitbucket.org/2.0/repositories/{username}",
+		CommitsURLTemplate:             "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commits",
+		CommitURLTemplate:              "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}",
+		DiffstatURLTemplate:            "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}",
+		PullRequestActivityURLTemplate: "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pullrequests/{pull_request_id}/activity",
+	}
+	assert.NoError(t, config.Validate())
+
+	config.PullRequestCommitsURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pullrequests/commits"
+	assert.EqualError(t, config.Validate(), "invalid pull_request_commits_url_template: missing placeholder {pull_request_id} in https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pullrequests/commits")
+	config.PullRequestCommitsURLTemplate = ""
+
+	config.DiffstatURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/diffstat/"
+	assert.EqualError(t, config.Validate(), "invalid diffstat_url_template: missing placeholder {commit_hash} in https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/diffstat/")
+}
+
+func TestValidate_RequiredTemplates(t *testing.T) {
+	// The default workspace is collected from Bitbucket Cloud when no sources are configured
+	config := &Config{}
+	assert.EqualError(t, config.Validate(), "missing repo_url_template, which Bitbucket Cloud needs")
+
+	config.Sources = []Source{{Type: "github", Workspace: "acme"}, {Type: "local", Path: "/srv/clones"}}
+	assert.NoError(t, config.Validate())
+
+	// Sources without a type are cloud sources
+	config.Sources = append(config.Sources, Source{Workspace: "team"})
+	assert.EqualError(t, config.Validate(), "missing repo_url_template, which Bitbucket Cloud needs")
+
+	config.RepoURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}"
+	config.CommitsURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commits"
+	config.CommitURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}"
+	assert.EqualError(t, config.Validate(), "missing diffstat_url_template, which Bitbucket Cloud needs")
+}
+
+func TestLoadConfigVersion_InvalidTemplate(t *testing.T) {
+	t.Setenv(EnvConfig, `{"sources": [{"type": "github", "workspace": "acme"}], "commits_url_template": "https://api.bitbucket.org/2.0/repositories/%s/%s/commits"}`)
+
+	config, _, err := LoadConfigVersion(&EnvironmentSecretProvider{Variable: EnvConfig}, VersionStageCurrent)
+	assert.Nil(t, config)
+	assert.EqualError(t, err, "invalid configuration: invalid commits_url_template: missing placeholder {username} in https://api.bitbucket.org/2.0/repositories/%s/%s/commits")
+}
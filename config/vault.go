@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of VaultSecretProvider.
+const (
+	DefaultVaultMount   = "secret"
+	DefaultAppRoleMount = "approle"
+)
+
+// vaultLoginMargin is how long before its lease ends an AppRole token is replaced by a new login.
+const vaultLoginMargin = time.Minute
+
+// VaultSecretProvider reads the configuration from a KV version 2 secret in HashiCorp Vault, whose keys are the
+// configuration fields. It authenticates with Token, or logs in through AppRole when RoleID is set.
+type VaultSecretProvider struct {
+	Address      string
+	Namespace    string
+	Mount        string
+	Path         string
+	Token        string
+	RoleID       string
+	SecretID     string
+	AppRoleMount string
+	Client       *http.Client
+
+	mu           sync.Mutex
+	loginToken   string
+	loginExpires time.Time
+}
+
+// NewVaultSecretProviderFromEnv configures a Vault secret provider from the environment variables of the Vault CLI,
+// EnvVaultMount and EnvVaultPath.
+func NewVaultSecretProviderFromEnv() (*VaultSecretProvider, error) {
+	p := &VaultSecretProvider{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Mount:     envOrDefault(EnvVaultMount, DefaultVaultMount),
+		Path:      envOrDefault(EnvVaultPath, DefaultSecretName),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		RoleID:    os.Getenv("VAULT_ROLE_ID"),
+		SecretID:  os.Getenv("VAULT_SECRET_ID"),
+		Client:    http.DefaultClient,
+	}
+	if p.Address == "" {
+		return nil, fmt.Errorf("vault secrets need VAULT_ADDR")
+	}
+	if p.Token == "" && (p.RoleID == "" || p.SecretID == "") {
+		return nil, fmt.Errorf("vault secrets need VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID")
+	}
+	return p, nil
+}
+
+// GetSecret returns the latest version of the secret for VersionStageCurrent and the one before it for
+// VersionStagePrevious, identified by its version number.
+func (p *VaultSecretProvider) GetSecret(ctx context.Context, stage string) (string, string, error) {
+	token, err := p.token(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	version := 0
+	if stage == VersionStagePrevious {
+		_, latest, err := p.read(ctx, token, 0)
+		if err != nil {
+			return "", "", err
+		}
+		if latest <= 1 {
+			return "", "", fmt.Errorf("vault secret %s has no previous version", p.Path)
+		}
+		version = latest - 1
+	}
+
+	data, version, err := p.read(ctx, token, version)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), strconv.Itoa(version), nil
+}
+
+// read returns the data and number of the given version of the secret, or of its latest version when version is 0.
+func (p *VaultSecretProvider) read(ctx context.Context, token string, version int) (json.RawMessage, int, error) {
+	endpoint := p.Address + "/v1/" + strings.Trim(p.Mount, "/") + "/data/" + strings.Trim(p.Path, "/")
+	if version > 0 {
+		endpoint += "?" + url.Values{"version": {strconv.Itoa(version)}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	var secret struct {
+		Data struct {
+			Data     json.RawMessage `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := p.do(req, &secret); err != nil {
+		return nil, 0, fmt.Errorf("failed to retrieve secret from Vault: %w", err)
+	}
+	if len(secret.Data.Data) == 0 || string(secret.Data.Data) == "null" {
+		return nil, 0, fmt.Errorf("failed to retrieve secret from Vault: version %d of %s holds no data", secret.Data.Metadata.Version, p.Path)
+	}
+	return secret.Data.Data, secret.Data.Metadata.Version, nil
+}
+
+// token returns Token, or the token of an AppRole login, logging in again shortly before its lease ends.
+func (p *VaultSecretProvider) token(ctx context.Context) (string, error) {
+	if p.RoleID == "" {
+		return p.Token, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.loginToken != "" && (p.loginExpires.IsZero() || time.Now().Add(vaultLoginMargin).Before(p.loginExpires)) {
+		return p.loginToken, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"role_id": p.RoleID, "secret_id": p.SecretID})
+	if err != nil {
+		return "", err
+	}
+	mount := p.AppRoleMount
+	if mount == "" {
+		mount = DefaultAppRoleMount
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Address+"/v1/auth/"+mount+"/login", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := p.do(req, &login); err != nil {
+		return "", fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return "", fmt.Errorf("failed to log in to Vault: response holds no token")
+	}
+
+	p.loginToken = login.Auth.ClientToken
+	p.loginExpires = time.Time{}
+	if login.Auth.LeaseDuration > 0 {
+		p.loginExpires = time.Now().Add(time.Duration(login.Auth.LeaseDuration) * time.Second)
+	}
+	return p.loginToken, nil
+}
+
+// do sends req to Vault and decodes its JSON response into v.
+func (p *VaultSecretProvider) do(req *http.Request, v interface{}) error {
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
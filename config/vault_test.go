@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault serves the KV version 2 secret kv/bitbucket_metrics in namespace team, with one access token per version,
+// to the token root_token and the tokens of AppRole logins of role-1.
+type fakeVault struct {
+	versions []string
+	logins   int
+	lease    int
+}
+
+func (f *fakeVault) serve(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var login map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+		if login["role_id"] != "role-1" || login["secret_id"] != "secret-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": ["invalid role or secret ID"]}`))
+			return
+		}
+		f.logins++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "login_" + strconv.Itoa(f.logins), "lease_duration": f.lease},
+		})
+	})
+	mux.HandleFunc("GET /v1/kv/data/bitbucket_metrics", func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-Vault-Token"); token != "root_token" && token != "login_"+strconv.Itoa(f.logins) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		assert.Equal(t, "team", r.Header.Get("X-Vault-Namespace"))
+		version := len(f.versions)
+		if requested := r.URL.Query().Get("version"); requested != "" {
+			version, _ = strconv.Atoi(requested)
+		}
+		if version < 1 || version > len(f.versions) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     json.RawMessage(secretFor(f.versions[version-1])),
+				"metadata": map[string]int{"version": version},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultSecretProvider_Token(t *testing.T) {
+	vault := &fakeVault{versions: []string{"v1", "v2"}}
+	server := vault.serve(t)
+	provider := &VaultSecretProvider{Address: server.URL, Namespace: "team", Mount: "kv", Path: "bitbucket_metrics", Token: "root_token", Client: server.Client()}
+
+	secret, version, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.NoError(t, err)
+	assert.JSONEq(t, secretFor("v2"), secret)
+	assert.Equal(t, "2", version)
+
+	secret, version, err = provider.GetSecret(context.Background(), VersionStagePrevious)
+	assert.NoError(t, err)
+	assert.JSONEq(t, secretFor("v1"), secret)
+	assert.Equal(t, "1", version)
+
+	vault.versions = vault.versions[:1]
+	_, _, err = provider.GetSecret(context.Background(), VersionStagePrevious)
+	assert.EqualError(t, err, "vault secret bitbucket_metrics has no previous version")
+
+	provider.Token = "revoked_token"
+	_, _, err = provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.EqualError(t, err, `failed to retrieve secret from Vault: status 403: {"errors": ["permission denied"]}`)
+}
+
+func TestVaultSecretProvider_AppRole(t *testing.T) {
+	vault := &fakeVault{versions: []string{"v1"}, lease: 3600}
+	server := vault.serve(t)
+	provider := &VaultSecretProvider{Address: server.URL, Namespace: "team", Mount: "kv", Path: "bitbucket_metrics", RoleID: "role-1", SecretID: "secret-1", Client: server.Client()}
+
+	for i := 0; i < 2; i++ {
+		secret, _, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+		assert.NoError(t, err)
+		assert.JSONEq(t, secretFor("v1"), secret)
+	}
+	assert.Equal(t, 1, vault.logins, "the token of a login is reused during its lease")
+
+	// Tokens whose lease is about to end are replaced
+	vault.lease = 30
+	provider.loginToken = ""
+	for i := 0; i < 2; i++ {
+		_, _, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, vault.logins)
+
+	provider = &VaultSecretProvider{Address: server.URL, Mount: "kv", Path: "bitbucket_metrics", RoleID: "role-1", SecretID: "wrong", Client: server.Client()}
+	_, _, err := provider.GetSecret(context.Background(), VersionStageCurrent)
+	assert.EqualError(t, err, `failed to log in to Vault: status 400: {"errors": ["invalid role or secret ID"]}`)
+}
+
+func TestNewVaultSecretProviderFromEnv(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_ROLE_ID", "")
+	t.Setenv("VAULT_SECRET_ID", "")
+	_, err := NewVaultSecretProviderFromEnv()
+	assert.EqualError(t, err, "vault secrets need VAULT_ADDR")
+
+	t.Setenv("VAULT_ADDR", "https://vault.example.com")
+	t.Setenv("VAULT_ROLE_ID", "role-1")
+	_, err = NewVaultSecretProviderFromEnv()
+	assert.EqualError(t, err, "vault secrets need VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID")
+
+	t.Setenv("VAULT_SECRET_ID", "secret-1")
+	t.Setenv(EnvVaultPath, "teams/metrics")
+	provider, err := NewVaultSecretProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultVaultMount, provider.Mount)
+	assert.Equal(t, "teams/metrics", provider.Path)
+	assert.Equal(t, "role-1", provider.RoleID)
+}
+
+func TestLoadConfigVersion_Vault(t *testing.T) {
+	vault := &fakeVault{versions: []string{"v1", "v2"}}
+	server := vault.serve(t)
+
+	provider := &VaultSecretProvider{Address: server.URL, Namespace: "team", Mount: "kv", Path: "bitbucket_metrics", Token: "root_token", Client: server.Client()}
+
+	config, version, err := LoadConfigVersion(provider, VersionStageCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", config.BitbucketAccessToken)
+	assert.Equal(t, "2", version)
+}
+
+func TestReloader_VaultLoginReused(t *testing.T) {
+	vault := &fakeVault{versions: []string{"v1"}, lease: 3600}
+	server := vault.serve(t)
+	provider := &VaultSecretProvider{Address: server.URL, Namespace: "team", Mount: "kv", Path: "bitbucket_metrics", RoleID: "role-1", SecretID: "secret-1", Client: server.Client()}
+	reloader := NewReloader(provider, func(*Config) error { return nil })
+
+	_, err := reloader.Load()
+	assert.NoError(t, err)
+	vault.versions = append(vault.versions, "v2")
+	assert.NoError(t, reloader.Refresh())
+	assert.Equal(t, "v2", reloader.Current().BitbucketAccessToken)
+	assert.Equal(t, 1, vault.logins, "refreshes reuse the login of the provider")
+}
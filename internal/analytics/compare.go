@@ -0,0 +1,324 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Groupings of a comparison.
+const (
+	GroupByRepository = "repository"
+	GroupByTeam       = "team"
+)
+
+// Compared metrics.
+const (
+	MetricCommits             = "commits"
+	MetricChurn               = "churn"
+	MetricHoursToMerge        = "median_hours_to_merge"
+	MetricReviewParticipation = "review_participation"
+)
+
+// SignificanceLevel is the p-value below which a change is flagged as significant.
+const SignificanceLevel = 0.05
+
+// minSamples is the number of pull requests or commits each period needs before their distributions are compared.
+const minSamples = 5
+
+// Period is a half-open time range.
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+// CompareOptions selects what to compare. The filter period is the current one; a zero Previous period
+// defaults to the period of the same length right before it. Grouping by team needs Filter.Teams.
+type CompareOptions struct {
+	Filter   db.Filter
+	Previous Period
+	GroupBy  string
+}
+
+// Comparison holds the metrics of two periods, overall and per repository or team.
+type Comparison struct {
+	Current  Period
+	Previous Period
+	GroupBy  string
+	Total    Group
+	Groups   []Group
+}
+
+// Group is the comparison of one repository or team, or of everything for the total.
+type Group struct {
+	Key     string
+	Metrics []Metric
+}
+
+// Metric compares one metric between the two periods.
+//
+// Commits and churn are totals, tested with Welch's t-test on their daily values. The time to merge is the
+// median over the pull requests merged in the period, tested with the Mann-Whitney U test. Review participation
+// is the share of commits with a reviewer, tested with a two-proportion z-test.
+type Metric struct {
+	Name     string
+	Previous float64
+	Current  float64
+	// Change is relative to the previous value, zero when the previous value is zero.
+	Change      float64
+	PValue      float64
+	Significant bool
+}
+
+// samples holds the raw observations of one group in one period.
+type samples struct {
+	commitsPerDay []float64
+	churnPerDay   []float64
+	commits       int
+	reviewed      int
+	hoursToMerge  []float64
+}
+
+// Compare computes the metrics of the current and previous periods and tests their changes.
+func Compare(ctx context.Context, opts CompareOptions) (*Comparison, error) {
+	filter := opts.Filter
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return nil, fmt.Errorf("comparison period needs both a start and an end")
+	}
+	if opts.GroupBy != GroupByRepository && opts.GroupBy != GroupByTeam {
+		return nil, fmt.Errorf("unknown grouping %q, expected %s or %s", opts.GroupBy, GroupByRepository, GroupByTeam)
+	}
+	if opts.GroupBy == GroupByTeam && filter.Teams == nil {
+		return nil, fmt.Errorf("grouping by team needs the team registry")
+	}
+
+	current := Period{From: filter.From, To: filter.To}
+	previous := opts.Previous
+	if previous.From.IsZero() && previous.To.IsZero() {
+		previous = Period{From: current.From.Add(-current.To.Sub(current.From)), To: current.From}
+	}
+	if !previous.From.Before(previous.To) {
+		return nil, fmt.Errorf("previous period must start before it ends")
+	}
+
+	currentSamples, err := collectSamples(ctx, filter, current, opts.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+	previousSamples, err := collectSamples(ctx, filter, previous, opts.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	for key := range currentSamples {
+		keys[key] = true
+	}
+	for key := range previousSamples {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	comparison := &Comparison{
+		Current:  current,
+		Previous: previous,
+		GroupBy:  opts.GroupBy,
+		Total:    Group{Key: "all", Metrics: compareSamples(mergeSamples(previousSamples, days(previous)), mergeSamples(currentSamples, days(current)))},
+		Groups:   make([]Group, len(sortedKeys)),
+	}
+	for i, key := range sortedKeys {
+		comparison.Groups[i] = Group{Key: key, Metrics: compareSamples(
+			orEmpty(previousSamples[key], days(previous)),
+			orEmpty(currentSamples[key], days(current)),
+		)}
+	}
+	return comparison, nil
+}
+
+// collectSamples gathers the daily commit activity and the merged pull requests of each group in period.
+func collectSamples(ctx context.Context, filter db.Filter, period Period, groupBy string) (map[string]*samples, error) {
+	filter.From, filter.To = period.From, period.To
+	numDays := days(period)
+	firstDay := period.From.UTC().Truncate(24 * time.Hour)
+	bySample := map[string]*samples{}
+	samplesOf := func(key string) *samples {
+		if s, ok := bySample[key]; ok {
+			return s
+		}
+		s := orEmpty(nil, numDays)
+		bySample[key] = s
+		return s
+	}
+
+	hasReviewer := bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$reviewed_by", ""}}, ""}}
+	var commitDays []struct {
+		ID struct {
+			Group string `bson:"group"`
+			Day   string `bson:"day"`
+		} `bson:"_id"`
+		Commits  int `bson:"commits"`
+		Churn    int `bson:"churn"`
+		Reviewed int `bson:"reviewed"`
+	}
+	err := aggregateAll(ctx, db.GetCollection(), mongo.Pipeline{
+		{{Key: "$match", Value: filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"group": groupKey(filter, groupBy, "person_id", "commit_date"),
+				"day":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$commit_date"}},
+			},
+			"commits":  bson.M{"$sum": 1},
+			"churn":    bson.M{"$sum": bson.M{"$add": bson.A{"$lines_added", "$lines_deleted"}}},
+			"reviewed": bson.M{"$sum": bson.M{"$cond": bson.A{hasReviewer, 1, 0}}},
+		}}},
+	}, &commitDays)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range commitDays {
+		day, err := time.Parse(time.DateOnly, d.ID.Day)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected day %q: %w", d.ID.Day, err)
+		}
+		index := int(day.Sub(firstDay).Hours() / 24)
+		if index < 0 || index >= numDays {
+			continue
+		}
+		s := samplesOf(d.ID.Group)
+		s.commitsPerDay[index] += float64(d.Commits)
+		s.churnPerDay[index] += float64(d.Churn)
+		s.commits += d.Commits
+		s.reviewed += d.Reviewed
+	}
+
+	// Pull requests belong to the period they were merged in
+	mergedFilter := filter
+	mergedFilter.From, mergedFilter.To = time.Time{}, time.Time{}
+	query := mergedFilter.PullRequestQuery()
+	query["state"] = "MERGED"
+	query["closed_on"] = bson.M{"$gte": period.From, "$lt": period.To}
+
+	var merged []struct {
+		Group  string  `bson:"group"`
+		Millis float64 `bson:"millis"`
+	}
+	err = aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$project", Value: bson.M{
+			"group":  groupKey(filter, groupBy, "author_person_id", "created_on"),
+			"millis": bson.M{"$subtract": bson.A{"$closed_on", "$created_on"}},
+		}}},
+	}, &merged)
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range merged {
+		s := samplesOf(pr.Group)
+		s.hoursToMerge = append(s.hoursToMerge, time.Duration(pr.Millis*float64(time.Millisecond)).Hours())
+	}
+	return bySample, nil
+}
+
+// groupKey is the expression naming the group of a commit or pull request, "all" without grouping.
+func groupKey(filter db.Filter, groupBy, personField, dateField string) interface{} {
+	switch groupBy {
+	case GroupByTeam:
+		return filter.Teams.TeamExpression(personField, dateField)
+	case GroupByRepository:
+		return bson.M{"$concat": bson.A{"$workspace", "/", "$repo_name"}}
+	default:
+		return bson.M{"$literal": "all"}
+	}
+}
+
+// compareSamples computes and tests every metric.
+func compareSamples(previous, current *samples) []Metric {
+	sort.Float64s(previous.hoursToMerge)
+	sort.Float64s(current.hoursToMerge)
+
+	mergePValue := 1.0
+	if len(previous.hoursToMerge) >= minSamples && len(current.hoursToMerge) >= minSamples {
+		mergePValue = MannWhitneyU(previous.hoursToMerge, current.hoursToMerge)
+	}
+	reviewPValue := 1.0
+	if previous.commits >= minSamples && current.commits >= minSamples {
+		reviewPValue = TwoProportionZTest(previous.reviewed, previous.commits, current.reviewed, current.commits)
+	}
+
+	return []Metric{
+		newMetric(MetricCommits, sum(previous.commitsPerDay), sum(current.commitsPerDay), WelchTTest(previous.commitsPerDay, current.commitsPerDay)),
+		newMetric(MetricChurn, sum(previous.churnPerDay), sum(current.churnPerDay), WelchTTest(previous.churnPerDay, current.churnPerDay)),
+		newMetric(MetricHoursToMerge, Percentile(previous.hoursToMerge, 50), Percentile(current.hoursToMerge, 50), mergePValue),
+		newMetric(MetricReviewParticipation, ratio(previous.reviewed, previous.commits), ratio(current.reviewed, current.commits), reviewPValue),
+	}
+}
+
+func newMetric(name string, previous, current, pValue float64) Metric {
+	m := Metric{Name: name, Previous: previous, Current: current, PValue: pValue, Significant: pValue < SignificanceLevel}
+	if previous != 0 {
+		m.Change = (current - previous) / previous
+	}
+	return m
+}
+
+// mergeSamples adds up the samples of every group.
+func mergeSamples(bySample map[string]*samples, numDays int) *samples {
+	total := orEmpty(nil, numDays)
+	for _, s := range bySample {
+		for i := range s.commitsPerDay {
+			total.commitsPerDay[i] += s.commitsPerDay[i]
+			total.churnPerDay[i] += s.churnPerDay[i]
+		}
+		total.commits += s.commits
+		total.reviewed += s.reviewed
+		total.hoursToMerge = append(total.hoursToMerge, s.hoursToMerge...)
+	}
+	return total
+}
+
+// orEmpty returns s, or samples without any activity over numDays days when s is nil.
+func orEmpty(s *samples, numDays int) *samples {
+	if s != nil {
+		return s
+	}
+	return &samples{commitsPerDay: make([]float64, numDays), churnPerDay: make([]float64, numDays)}
+}
+
+// days counts the calendar days, in UTC, that period touches.
+func days(period Period) int {
+	first := period.From.UTC().Truncate(24 * time.Hour)
+	last := period.To.Add(-time.Nanosecond).UTC().Truncate(24 * time.Hour)
+	return int(last.Sub(first).Hours()/24) + 1
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+func aggregateAll(ctx context.Context, collection db.CollectionInterface, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics store: %w", err)
+	}
+	return cursor.All(ctx, results)
+}
@@ -0,0 +1,174 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	previousFrom = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	currentFrom  = time.Date(2024, 7, 8, 0, 0, 0, 0, time.UTC)
+	currentTo    = time.Date(2024, 7, 15, 0, 0, 0, 0, time.UTC)
+)
+
+func commitDay(group string, day time.Time, commits, churn, reviewed int) bson.M {
+	return bson.M{
+		"_id":      bson.M{"group": group, "day": day.Format(time.DateOnly)},
+		"commits":  commits,
+		"churn":    churn,
+		"reviewed": reviewed,
+	}
+}
+
+func mergedPRs(group string, count int, hours float64) []interface{} {
+	docs := make([]interface{}, count)
+	for i := range docs {
+		docs[i] = bson.M{"group": group, "millis": hours * float64(time.Hour/time.Millisecond)}
+	}
+	return docs
+}
+
+func TestCompare(t *testing.T) {
+	var currentDays, previousDays []interface{}
+	for day := 0; day < 7; day++ {
+		currentDays = append(currentDays, commitDay("lep13/repo1", currentFrom.AddDate(0, 0, day), 10, 100, 10))
+		previousDays = append(previousDays, commitDay("lep13/repo1", previousFrom.AddDate(0, 0, day), 1, 90+day*3, 0))
+	}
+	previousDays = append(previousDays, commitDay("lep13/repo2", previousFrom, 2, 20, 2))
+
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t, currentDays...), nil).Once()
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t, previousDays...), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t, mergedPRs("lep13/repo1", 5, 2)...), nil).Once()
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t, mergedPRs("lep13/repo1", 5, 20)...), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	comparison, err := Compare(context.Background(), CompareOptions{
+		Filter:  db.Filter{Workspace: "lep13", From: currentFrom, To: currentTo},
+		GroupBy: GroupByRepository,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, Period{From: previousFrom, To: currentFrom}, comparison.Previous)
+	assert.Equal(t, Period{From: currentFrom, To: currentTo}, comparison.Current)
+	assert.Equal(t, "all", comparison.Total.Key)
+	assert.Equal(t, 9.0, comparison.Total.Metrics[0].Previous)
+	assert.Equal(t, 70.0, comparison.Total.Metrics[0].Current)
+
+	if assert.Len(t, comparison.Groups, 2) {
+		repo1 := comparison.Groups[0]
+		assert.Equal(t, "lep13/repo1", repo1.Key)
+
+		commitsMetric := repo1.Metrics[0]
+		assert.Equal(t, MetricCommits, commitsMetric.Name)
+		assert.Equal(t, Metric{Name: MetricCommits, Previous: 7, Current: 70, Change: 9, PValue: 0, Significant: true}, commitsMetric)
+
+		churn := repo1.Metrics[1]
+		assert.Equal(t, 693.0, churn.Previous)
+		assert.Equal(t, 700.0, churn.Current)
+		assert.False(t, churn.Significant)
+
+		merge := repo1.Metrics[2]
+		assert.Equal(t, 20.0, merge.Previous)
+		assert.Equal(t, 2.0, merge.Current)
+		assert.InDelta(t, -0.9, merge.Change, 1e-9)
+		assert.True(t, merge.Significant)
+
+		review := repo1.Metrics[3]
+		assert.Equal(t, 0.0, review.Previous)
+		assert.Equal(t, 1.0, review.Current)
+		assert.Equal(t, 0.0, review.Change)
+		assert.True(t, review.Significant)
+
+		repo2 := comparison.Groups[1]
+		assert.Equal(t, "lep13/repo2", repo2.Key)
+		assert.Equal(t, 2.0, repo2.Metrics[0].Previous)
+		assert.Equal(t, 0.0, repo2.Metrics[0].Current)
+		assert.False(t, repo2.Metrics[0].Significant)
+		assert.False(t, repo2.Metrics[3].Significant, "too few commits to compare")
+	}
+
+	// Each period queries its own commit_date and closed_on range
+	previousMatch := commits.Calls[1].Arguments.Get(1).(mongo.Pipeline)[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{"$gte": previousFrom, "$lt": currentFrom}, previousMatch["commit_date"])
+	assert.Equal(t, "lep13", previousMatch["workspace"])
+	prMatch := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{"$gte": currentFrom, "$lt": currentTo}, prMatch["closed_on"])
+	assert.NotContains(t, prMatch, "created_on")
+}
+
+type stubTeams struct{}
+
+func (stubTeams) TeamExpression(personField, dateField string) interface{} {
+	return bson.M{"$literal": personField + "@" + dateField}
+}
+
+func TestCompare_ByTeam(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	previous := Period{From: previousFrom, To: previousFrom.AddDate(0, 0, 2)}
+	comparison, err := Compare(context.Background(), CompareOptions{
+		Filter:   db.Filter{Teams: stubTeams{}, From: currentFrom, To: currentTo},
+		Previous: previous,
+		GroupBy:  GroupByTeam,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, previous, comparison.Previous)
+	assert.Empty(t, comparison.Groups)
+	assert.Equal(t, 1.0, comparison.Total.Metrics[0].PValue)
+
+	group := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)[1][0].Value.(bson.M)
+	assert.Equal(t, stubTeams{}.TeamExpression("person_id", "commit_date"), group["_id"].(bson.M)["group"])
+	project := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)[1][0].Value.(bson.M)
+	assert.Equal(t, stubTeams{}.TeamExpression("author_person_id", "created_on"), project["group"])
+}
+
+func TestCompare_Invalid(t *testing.T) {
+	period := db.Filter{From: currentFrom, To: currentTo}
+	tests := map[string]CompareOptions{
+		"needs both a start and an end": {Filter: db.Filter{From: currentFrom}, GroupBy: GroupByRepository},
+		"unknown grouping":              {Filter: period, GroupBy: "author"},
+		"needs the team registry":       {Filter: period, GroupBy: GroupByTeam},
+		"must start before it ends":     {Filter: period, GroupBy: GroupByRepository, Previous: Period{From: currentTo, To: currentFrom}},
+	}
+	for msg, opts := range tests {
+		_, err := Compare(context.Background(), opts)
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestCompare_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := Compare(context.Background(), CompareOptions{Filter: db.Filter{From: currentFrom, To: currentTo}, GroupBy: GroupByRepository})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
+
+func TestDays(t *testing.T) {
+	assert.Equal(t, 7, days(Period{From: currentFrom, To: currentTo}))
+	assert.Equal(t, 1, days(Period{From: currentFrom.Add(time.Hour), To: currentFrom.Add(2 * time.Hour)}))
+	assert.Equal(t, 2, days(Period{From: currentFrom.Add(23 * time.Hour), To: currentFrom.Add(25 * time.Hour)}))
+}
@@ -0,0 +1,89 @@
+package analytics
+
+import (
+	"context"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ConventionStats summarizes how the commit messages of one repository or team follow the Conventional
+// Commits format and link to issues.
+type ConventionStats struct {
+	Key               string  `json:"key"`
+	Commits           int     `json:"commits"`
+	Conventional      int     `json:"conventional"`
+	ConventionalShare float64 `json:"conventional_share"`
+	Breaking          int     `json:"breaking"`
+	Unlinked          int     `json:"unlinked"`
+	UnlinkedShare     float64 `json:"unlinked_share"`
+	// Types counts the conventional commits of each type.
+	Types map[string]int `json:"types"`
+}
+
+// ConventionOptions selects the commits within the filter period and how to group them.
+// Without grouping, every commit falls in the "all" group.
+type ConventionOptions struct {
+	Filter  db.Filter
+	GroupBy string
+}
+
+// CommitConventions computes the commit message convention statistics of each group, sorted by key.
+func CommitConventions(ctx context.Context, opts ConventionOptions) ([]ConventionStats, error) {
+	if err := validateGrouping(opts.Filter, opts.GroupBy); err != nil {
+		return nil, err
+	}
+
+	count := func(condition interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{condition, 1, 0}}}
+	}
+
+	var rows []struct {
+		ID struct {
+			Group string `bson:"group"`
+			Type  string `bson:"type"`
+		} `bson:"_id"`
+		Commits      int `bson:"commits"`
+		Conventional int `bson:"conventional"`
+		Breaking     int `bson:"breaking"`
+		Unlinked     int `bson:"unlinked"`
+	}
+	err := aggregateAll(ctx, db.GetCollection(), mongo.Pipeline{
+		{{Key: "$match", Value: opts.Filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"group": groupKey(opts.Filter, opts.GroupBy, "person_id", "commit_date"),
+				"type":  bson.M{"$ifNull": bson.A{"$commit_type", ""}},
+			},
+			"commits":      bson.M{"$sum": 1},
+			"conventional": count(bson.M{"$eq": bson.A{"$conventional", true}}),
+			"breaking":     count(bson.M{"$eq": bson.A{"$breaking", true}}),
+			"unlinked":     count(bson.M{"$eq": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$issue_keys", bson.A{}}}}, 0}}),
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.group", Value: 1}, {Key: "_id.type", Value: 1}}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []ConventionStats
+	for _, row := range rows {
+		if len(stats) == 0 || stats[len(stats)-1].Key != row.ID.Group {
+			stats = append(stats, ConventionStats{Key: row.ID.Group, Types: map[string]int{}})
+		}
+		s := &stats[len(stats)-1]
+		s.Commits += row.Commits
+		s.Conventional += row.Conventional
+		s.Breaking += row.Breaking
+		s.Unlinked += row.Unlinked
+		if row.ID.Type != "" && row.Conventional > 0 {
+			s.Types[row.ID.Type] += row.Conventional
+		}
+	}
+	for i := range stats {
+		stats[i].ConventionalShare = ratio(stats[i].Conventional, stats[i].Commits)
+		stats[i].UnlinkedShare = ratio(stats[i].Unlinked, stats[i].Commits)
+	}
+	return stats, nil
+}
@@ -0,0 +1,64 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func conventionRow(group, commitType string, commits, conventional, breaking, unlinked int) bson.M {
+	return bson.M{
+		"_id":          bson.M{"group": group, "type": commitType},
+		"commits":      commits,
+		"conventional": conventional,
+		"breaking":     breaking,
+		"unlinked":     unlinked,
+	}
+}
+
+func TestCommitConventions(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		conventionRow("lep13/repo1", "", 2, 0, 0, 2),
+		conventionRow("lep13/repo1", "feat", 3, 3, 1, 0),
+		conventionRow("lep13/repo1", "fix", 3, 3, 0, 1),
+		conventionRow("lep13/repo2", "", 1, 0, 0, 0),
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	stats, err := CommitConventions(context.Background(), ConventionOptions{
+		Filter:  db.Filter{Workspace: "lep13", From: currentFrom, To: currentTo},
+		GroupBy: GroupByRepository,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ConventionStats{
+		{Key: "lep13/repo1", Commits: 8, Conventional: 6, ConventionalShare: 0.75, Breaking: 1, Unlinked: 3, UnlinkedShare: 0.375,
+			Types: map[string]int{"feat": 3, "fix": 3}},
+		{Key: "lep13/repo2", Commits: 1, Types: map[string]int{}},
+	}, stats)
+
+	pipeline := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	match := pipeline[0][0].Value.(bson.M)
+	assert.Equal(t, "lep13", match["workspace"])
+	assert.Equal(t, bson.M{"$gte": currentFrom, "$lt": currentTo}, match["commit_date"])
+}
+
+func TestCommitConventions_Invalid(t *testing.T) {
+	_, err := CommitConventions(context.Background(), ConventionOptions{GroupBy: "author"})
+	assert.ErrorContains(t, err, "unknown grouping")
+}
+
+func TestCommitConventions_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := CommitConventions(context.Background(), ConventionOptions{})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
@@ -0,0 +1,218 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PullRequestCycleTime holds the milestones of one merged pull request. Unknown milestones are zero: pull requests
+// whose commits were not fetched, merged without review or approval, or never deployed.
+type PullRequestCycleTime struct {
+	Workspace     string    `bson:"workspace" json:"workspace"`
+	RepoName      string    `bson:"repo_name" json:"repo_name"`
+	PullRequestID string    `bson:"pull_request_id" json:"pull_request_id"`
+	Group         string    `bson:"group" json:"group"`
+	FirstCommitOn time.Time `bson:"first_commit_on" json:"first_commit_on"`
+	CreatedOn     time.Time `bson:"created_on" json:"created_on"`
+	FirstReviewOn time.Time `bson:"first_review_on" json:"first_review_on"`
+	ApprovedOn    time.Time `bson:"approved_on" json:"approved_on"`
+	MergedOn      time.Time `bson:"closed_on" json:"merged_on"`
+	DeployedOn    time.Time `bson:"deployed_on" json:"deployed_on"`
+}
+
+// Coding is the time from the first commit to opening the pull request.
+func (c PullRequestCycleTime) Coding() (time.Duration, bool) {
+	return phase(c.FirstCommitOn, c.CreatedOn)
+}
+
+// Pickup is the time from opening the pull request to its first review.
+func (c PullRequestCycleTime) Pickup() (time.Duration, bool) {
+	return phase(c.CreatedOn, c.FirstReviewOn)
+}
+
+// Review is the time from the first review to the first approval.
+func (c PullRequestCycleTime) Review() (time.Duration, bool) {
+	return phase(c.FirstReviewOn, c.ApprovedOn)
+}
+
+// Deploy is the time from merging to the first successful pipeline of the merge commit.
+func (c PullRequestCycleTime) Deploy() (time.Duration, bool) {
+	return phase(c.MergedOn, c.DeployedOn)
+}
+
+// phase returns the time between two milestones, if both are known. Out of order milestones, such as commits
+// pushed after opening the pull request, count as no time at all.
+func phase(start, end time.Time) (time.Duration, bool) {
+	if start.IsZero() || end.IsZero() {
+		return 0, false
+	}
+	if end.Before(start) {
+		return 0, true
+	}
+	return end.Sub(start), true
+}
+
+// PhaseStats summarizes one phase over the pull requests where it is known.
+type PhaseStats struct {
+	Samples  int     `json:"samples"`
+	P50Hours float64 `json:"p50_hours"`
+	P75Hours float64 `json:"p75_hours"`
+	P90Hours float64 `json:"p90_hours"`
+}
+
+// CycleTimeBreakdown summarizes the cycle time phases of the pull requests of one repository or team.
+type CycleTimeBreakdown struct {
+	Key          string     `json:"key"`
+	PullRequests int        `json:"pull_requests"`
+	Coding       PhaseStats `json:"coding"`
+	Pickup       PhaseStats `json:"pickup"`
+	Review       PhaseStats `json:"review"`
+	Deploy       PhaseStats `json:"deploy"`
+}
+
+// CycleTimeOptions selects the pull requests merged within the filter period and how to group them.
+// Without grouping, every pull request falls in the "all" group.
+type CycleTimeOptions struct {
+	Filter  db.Filter
+	GroupBy string
+}
+
+// CycleTimes returns the milestones of every pull request merged within the filter period. The first commit comes
+// from the stored commits of the pull request and the deployment from the stored pipelines of its merge commit.
+func CycleTimes(ctx context.Context, opts CycleTimeOptions) ([]PullRequestCycleTime, error) {
+	filter := opts.Filter
+	if err := validateGrouping(filter, opts.GroupBy); err != nil {
+		return nil, err
+	}
+
+	// Pull requests belong to the period they were merged in
+	mergedFilter := filter
+	mergedFilter.From, mergedFilter.To = time.Time{}, time.Time{}
+	query := mergedFilter.PullRequestQuery()
+	query["state"] = "MERGED"
+	closedOn := bson.M{}
+	if !filter.From.IsZero() {
+		closedOn["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		closedOn["$lt"] = filter.To
+	}
+	if len(closedOn) > 0 {
+		query["closed_on"] = closedOn
+	}
+
+	// Merge commits are stored abbreviated while pipelines record full hashes
+	mergeCommit := bson.M{"$ifNull": bson.A{"$merge_commit", ""}}
+	isMergeCommit := bson.M{"$and": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$strLenCP": "$$merge"}, 0}},
+		bson.M{"$eq": bson.A{bson.M{"$substrCP": bson.A{"$commit_hash", 0, bson.M{"$strLenCP": "$$merge"}}}, "$$merge"}},
+	}}
+
+	// Provider and project are omitted when empty, so missing fields are compared as empty strings
+	orEmpty := func(field string) bson.M { return bson.M{"$ifNull": bson.A{field, ""}} }
+
+	var cycleTimes []PullRequestCycleTime
+	err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": db.PipelinesCollection,
+			"let": bson.M{
+				"provider":  orEmpty("$provider"),
+				"workspace": "$workspace",
+				"project":   orEmpty("$project_key"),
+				"repo":      "$repo_name",
+				"merge":     mergeCommit,
+				"merged":    "$closed_on",
+			},
+			"pipeline": mongo.Pipeline{
+				{{Key: "$match", Value: bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{orEmpty("$provider"), "$$provider"}},
+					bson.M{"$eq": bson.A{"$workspace", "$$workspace"}},
+					bson.M{"$eq": bson.A{orEmpty("$project_key"), "$$project"}},
+					bson.M{"$eq": bson.A{"$repo_name", "$$repo"}},
+					bson.M{"$eq": bson.A{"$result", "SUCCESSFUL"}},
+					bson.M{"$gte": bson.A{"$completed_on", "$$merged"}},
+					isMergeCommit,
+				}}}}},
+				{{Key: "$group", Value: bson.M{"_id": nil, "first": bson.M{"$min": "$completed_on"}}}},
+			},
+			"as": "deployments",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"workspace":       1,
+			"repo_name":       1,
+			"pull_request_id": 1,
+			"group":           groupKey(filter, opts.GroupBy, "author_person_id", "created_on"),
+			"first_commit_on": 1,
+			"created_on":      1,
+			"first_review_on": 1,
+			"approved_on":     1,
+			"closed_on":       1,
+			"deployed_on":     bson.M{"$arrayElemAt": bson.A{"$deployments.first", 0}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "closed_on", Value: 1}, {Key: "_id", Value: 1}}}},
+	}, &cycleTimes)
+	if err != nil {
+		return nil, err
+	}
+	return cycleTimes, nil
+}
+
+// SummarizeCycleTimes computes the percentiles of every phase per group, sorted by group.
+func SummarizeCycleTimes(cycleTimes []PullRequestCycleTime) []CycleTimeBreakdown {
+	type phases struct {
+		pullRequests                   int
+		coding, pickup, review, deploy []float64
+	}
+	byGroup := map[string]*phases{}
+	add := func(samples *[]float64, d time.Duration, ok bool) {
+		if ok {
+			*samples = append(*samples, d.Hours())
+		}
+	}
+	for _, c := range cycleTimes {
+		p, ok := byGroup[c.Group]
+		if !ok {
+			p = &phases{}
+			byGroup[c.Group] = p
+		}
+		p.pullRequests++
+		d, ok := c.Coding()
+		add(&p.coding, d, ok)
+		d, ok = c.Pickup()
+		add(&p.pickup, d, ok)
+		d, ok = c.Review()
+		add(&p.review, d, ok)
+		d, ok = c.Deploy()
+		add(&p.deploy, d, ok)
+	}
+
+	breakdowns := make([]CycleTimeBreakdown, 0, len(byGroup))
+	for key, p := range byGroup {
+		breakdowns = append(breakdowns, CycleTimeBreakdown{
+			Key:          key,
+			PullRequests: p.pullRequests,
+			Coding:       phaseStats(p.coding),
+			Pickup:       phaseStats(p.pickup),
+			Review:       phaseStats(p.review),
+			Deploy:       phaseStats(p.deploy),
+		})
+	}
+	sort.Slice(breakdowns, func(i, j int) bool { return breakdowns[i].Key < breakdowns[j].Key })
+	return breakdowns
+}
+
+func phaseStats(hours []float64) PhaseStats {
+	sort.Float64s(hours)
+	return PhaseStats{
+		Samples:  len(hours),
+		P50Hours: Percentile(hours, 50),
+		P75Hours: Percentile(hours, 75),
+		P90Hours: Percentile(hours, 90),
+	}
+}
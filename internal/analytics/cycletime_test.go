@@ -0,0 +1,104 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func at(hour int) time.Time {
+	return currentFrom.Add(time.Duration(hour) * time.Hour)
+}
+
+func TestCycleTimes(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"workspace": "lep13", "repo_name": "repo1", "pull_request_id": "7", "group": "lep13/repo1",
+			"first_commit_on": at(0), "created_on": at(4), "first_review_on": at(6), "approved_on": at(9), "closed_on": at(10), "deployed_on": at(11)},
+		bson.M{"workspace": "lep13", "repo_name": "repo1", "pull_request_id": "8", "group": "lep13/repo1",
+			"created_on": at(4), "closed_on": at(5)},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	cycleTimes, err := CycleTimes(context.Background(), CycleTimeOptions{
+		Filter:  db.Filter{Repo: "repo1", From: currentFrom, To: currentTo},
+		GroupBy: GroupByRepository,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, cycleTimes, 2) {
+		assert.Equal(t, "7", cycleTimes[0].PullRequestID)
+		assert.Equal(t, at(10), cycleTimes[0].MergedOn.UTC())
+		assert.True(t, cycleTimes[1].FirstReviewOn.IsZero())
+	}
+
+	pipeline := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	match := pipeline[0][0].Value.(bson.M)
+	assert.Equal(t, "MERGED", match["state"])
+	assert.Equal(t, "repo1", match["repo_name"])
+	assert.Equal(t, bson.M{"$gte": currentFrom, "$lt": currentTo}, match["closed_on"])
+	assert.NotContains(t, match, "created_on")
+	lookup := pipeline[1][0].Value.(bson.M)
+	assert.Equal(t, db.PipelinesCollection, lookup["from"])
+	assert.Contains(t, lookup["let"], "provider", "deployments are matched within the same provider and project")
+	assert.Contains(t, lookup["let"], "project")
+	assert.Equal(t, 1, pipeline[2][0].Value.(bson.M)["first_commit_on"], "the first commit date is stored on the pull request")
+}
+
+func TestCycleTimes_Invalid(t *testing.T) {
+	_, err := CycleTimes(context.Background(), CycleTimeOptions{GroupBy: GroupByTeam})
+	assert.ErrorContains(t, err, "needs the team registry")
+	_, err = CycleTimes(context.Background(), CycleTimeOptions{GroupBy: "author"})
+	assert.ErrorContains(t, err, "unknown grouping")
+}
+
+func TestCycleTimes_StoreError(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	_, err := CycleTimes(context.Background(), CycleTimeOptions{})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
+
+func TestCycleTimePhases(t *testing.T) {
+	c := PullRequestCycleTime{FirstCommitOn: at(5), CreatedOn: at(4), FirstReviewOn: at(6), MergedOn: at(10)}
+
+	d, ok := c.Coding()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d, "commits pushed after opening count as no coding time")
+	d, ok = c.Pickup()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Hour, d)
+	_, ok = c.Review()
+	assert.False(t, ok)
+	_, ok = c.Deploy()
+	assert.False(t, ok)
+}
+
+func TestSummarizeCycleTimes(t *testing.T) {
+	var cycleTimes []PullRequestCycleTime
+	for i := 1; i <= 5; i++ {
+		cycleTimes = append(cycleTimes, PullRequestCycleTime{
+			Group: "lep13/repo1", FirstCommitOn: at(0), CreatedOn: at(i), FirstReviewOn: at(i + 1), ApprovedOn: at(i + 1), MergedOn: at(10),
+		})
+	}
+	cycleTimes = append(cycleTimes, PullRequestCycleTime{Group: "lep13/infra", CreatedOn: at(0), MergedOn: at(1), DeployedOn: at(3)})
+
+	assert.Equal(t, []CycleTimeBreakdown{
+		{Key: "lep13/infra", PullRequests: 1, Deploy: PhaseStats{Samples: 1, P50Hours: 2, P75Hours: 2, P90Hours: 2}},
+		{
+			Key:          "lep13/repo1",
+			PullRequests: 5,
+			Coding:       PhaseStats{Samples: 5, P50Hours: 3, P75Hours: 4, P90Hours: 4.6},
+			Pickup:       PhaseStats{Samples: 5, P50Hours: 1, P75Hours: 1, P90Hours: 1},
+			Review:       PhaseStats{Samples: 5},
+		},
+	}, SummarizeCycleTimes(cycleTimes))
+}
@@ -0,0 +1,92 @@
+package analytics
+
+import (
+	"context"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IssueTypeNone is the issue type of commits not linked to any known issue.
+const IssueTypeNone = "none"
+
+// IssueChurn splits the churn of one repository or team by the type of the issues the commits link to.
+type IssueChurn struct {
+	Key   string           `json:"key"`
+	Churn int              `json:"churn"`
+	Types []IssueTypeChurn `json:"types"`
+}
+
+// IssueTypeChurn is the churn of the commits linked to one issue type. A commit counts towards the type of
+// the first issue it links to. Share is its part of the churn of the group.
+type IssueTypeChurn struct {
+	Type         string  `json:"type"`
+	Commits      int     `json:"commits"`
+	LinesAdded   int     `json:"lines_added"`
+	LinesDeleted int     `json:"lines_deleted"`
+	Share        float64 `json:"share"`
+}
+
+// IssueChurnOptions selects the commits within the filter period and how to group them.
+// Without grouping, every commit falls in the "all" group.
+type IssueChurnOptions struct {
+	Filter  db.Filter
+	GroupBy string
+}
+
+// ChurnByIssueType splits the churn of each group by issue type, groups sorted by key and types by churn.
+func ChurnByIssueType(ctx context.Context, opts IssueChurnOptions) ([]IssueChurn, error) {
+	if err := validateGrouping(opts.Filter, opts.GroupBy); err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID struct {
+			Group string `bson:"group"`
+			Type  string `bson:"type"`
+		} `bson:"_id"`
+		Commits      int `bson:"commits"`
+		LinesAdded   int `bson:"lines_added"`
+		LinesDeleted int `bson:"lines_deleted"`
+	}
+	err := aggregateAll(ctx, db.GetCollection(), mongo.Pipeline{
+		{{Key: "$match", Value: opts.Filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"group": groupKey(opts.Filter, opts.GroupBy, "person_id", "commit_date"),
+				"type":  bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$issues.type", 0}}, IssueTypeNone}},
+			},
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"churn": bson.M{"$add": bson.A{"$lines_added", "$lines_deleted"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.group", Value: 1}, {Key: "churn", Value: -1}, {Key: "_id.type", Value: 1}}}},
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var churns []IssueChurn
+	for _, row := range rows {
+		if len(churns) == 0 || churns[len(churns)-1].Key != row.ID.Group {
+			churns = append(churns, IssueChurn{Key: row.ID.Group})
+		}
+		c := &churns[len(churns)-1]
+		c.Churn += row.LinesAdded + row.LinesDeleted
+		c.Types = append(c.Types, IssueTypeChurn{
+			Type:         row.ID.Type,
+			Commits:      row.Commits,
+			LinesAdded:   row.LinesAdded,
+			LinesDeleted: row.LinesDeleted,
+		})
+	}
+	for i := range churns {
+		for j := range churns[i].Types {
+			t := &churns[i].Types[j]
+			t.Share = ratio(t.LinesAdded+t.LinesDeleted, churns[i].Churn)
+		}
+	}
+	return churns, nil
+}
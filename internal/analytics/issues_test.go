@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func issueChurnRow(group, issueType string, commits, added, deleted int) bson.M {
+	return bson.M{
+		"_id":           bson.M{"group": group, "type": issueType},
+		"commits":       commits,
+		"lines_added":   added,
+		"lines_deleted": deleted,
+	}
+}
+
+func TestChurnByIssueType(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		issueChurnRow("lep13/repo1", "story", 4, 50, 10),
+		issueChurnRow("lep13/repo1", "bug", 3, 15, 5),
+		issueChurnRow("lep13/repo2", IssueTypeNone, 1, 0, 0),
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	churns, err := ChurnByIssueType(context.Background(), IssueChurnOptions{
+		Filter:  db.Filter{Project: "Project1"},
+		GroupBy: GroupByRepository,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []IssueChurn{
+		{Key: "lep13/repo1", Churn: 80, Types: []IssueTypeChurn{
+			{Type: "story", Commits: 4, LinesAdded: 50, LinesDeleted: 10, Share: 0.75},
+			{Type: "bug", Commits: 3, LinesAdded: 15, LinesDeleted: 5, Share: 0.25},
+		}},
+		{Key: "lep13/repo2", Types: []IssueTypeChurn{{Type: IssueTypeNone, Commits: 1}}},
+	}, churns)
+
+	pipeline := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	assert.Equal(t, "Project1", pipeline[0][0].Value.(bson.M)["project_name"])
+}
+
+func TestChurnByIssueType_Invalid(t *testing.T) {
+	_, err := ChurnByIssueType(context.Background(), IssueChurnOptions{GroupBy: GroupByTeam})
+	assert.ErrorContains(t, err, "needs the team registry")
+}
+
+func TestChurnByIssueType_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := ChurnByIssueType(context.Background(), IssueChurnOptions{})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
@@ -0,0 +1,157 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReviewDepth summarizes how thoroughly the pull requests of one repository or team were reviewed.
+// Comments only count when made by someone other than the author.
+type ReviewDepth struct {
+	Key                          string  `bson:"_id" json:"key"`
+	PullRequests                 int     `bson:"pull_requests" json:"pull_requests"`
+	UnreviewedPullRequests       int     `bson:"unreviewed" json:"unreviewed_pull_requests"`
+	CommentsPerPullRequest       float64 `bson:"comments" json:"comments_per_pull_request"`
+	InlineCommentsPerPullRequest float64 `bson:"inline_comments" json:"inline_comments_per_pull_request"`
+	AvgReviewRounds              float64 `bson:"review_rounds" json:"avg_review_rounds"`
+	AvgUpdatesAfterReview        float64 `bson:"updates_after_review" json:"avg_updates_after_review"`
+	ChangesRequestedRate         float64 `bson:"changes_requested" json:"changes_requested_rate"`
+}
+
+// ReviewerLoad is the review work of one person. Share is their part of all pull request reviews.
+type ReviewerLoad struct {
+	PersonID         string  `bson:"_id" json:"person_id"`
+	Reviewer         string  `bson:"reviewer" json:"reviewer"`
+	PullRequests     int     `bson:"pull_requests" json:"pull_requests"`
+	Comments         int     `bson:"comments" json:"comments"`
+	InlineComments   int     `bson:"inline_comments" json:"inline_comments"`
+	Approvals        int     `bson:"approvals" json:"approvals"`
+	ChangesRequested int     `bson:"changes_requested" json:"changes_requested"`
+	Share            float64 `bson:"-" json:"share"`
+}
+
+// ReviewOptions selects the pull requests created within the filter period and how to group them.
+// Without grouping, every pull request falls in the "all" group.
+type ReviewOptions struct {
+	Filter  db.Filter
+	GroupBy string
+}
+
+// Review event types, as stored in the events of a pull request.
+const (
+	eventComment          = "comment"
+	eventInlineComment    = "inline_comment"
+	eventApproval         = "approval"
+	eventChangesRequested = "changes_requested"
+	eventUpdate           = "update"
+)
+
+// reviewedQuery matches the pull requests of filter whose review timeline was ingested.
+func reviewedQuery(filter db.Filter) bson.M {
+	query := filter.PullRequestQuery()
+	query["events.0"] = bson.M{"$exists": true}
+	return query
+}
+
+// ReviewDepths computes the review depth of the pull requests with an ingested review timeline, per group.
+func ReviewDepths(ctx context.Context, opts ReviewOptions) ([]ReviewDepth, error) {
+	if err := validateGrouping(opts.Filter, opts.GroupBy); err != nil {
+		return nil, err
+	}
+
+	countEvents := func(types ...string) bson.M {
+		return bson.M{"$size": bson.M{"$filter": bson.M{
+			"input": "$events",
+			"cond": bson.M{"$and": bson.A{
+				bson.M{"$in": bson.A{"$$this.type", types}},
+				bson.M{"$not": bson.A{"$$this.by_author"}},
+			}},
+		}}}
+	}
+	rounds := bson.M{"$ifNull": bson.A{"$review_rounds", 0}}
+
+	var depths []ReviewDepth
+	err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), mongo.Pipeline{
+		{{Key: "$match", Value: reviewedQuery(opts.Filter)}},
+		{{Key: "$project", Value: bson.M{
+			"group":                groupKey(opts.Filter, opts.GroupBy, "author_person_id", "created_on"),
+			"comments":             countEvents(eventComment, eventInlineComment),
+			"inline_comments":      countEvents(eventInlineComment),
+			"changes_requested":    countEvents(eventChangesRequested),
+			"review_rounds":        rounds,
+			"updates_after_review": bson.M{"$ifNull": bson.A{"$updates_after_review", 0}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":                  "$group",
+			"pull_requests":        bson.M{"$sum": 1},
+			"unreviewed":           bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$review_rounds", 0}}, 1, 0}}},
+			"comments":             bson.M{"$avg": "$comments"},
+			"inline_comments":      bson.M{"$avg": "$inline_comments"},
+			"review_rounds":        bson.M{"$avg": "$review_rounds"},
+			"updates_after_review": bson.M{"$avg": "$updates_after_review"},
+			"changes_requested":    bson.M{"$avg": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$changes_requested", 0}}, 1, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}, &depths)
+	if err != nil {
+		return nil, err
+	}
+	return depths, nil
+}
+
+// ReviewerLoads returns how the reviews of the pull requests matching filter are spread across reviewers,
+// busiest first.
+func ReviewerLoads(ctx context.Context, filter db.Filter) ([]ReviewerLoad, error) {
+	countType := func(eventType string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$events.type", eventType}}, 1, 0}}}
+	}
+
+	var loads []ReviewerLoad
+	err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), mongo.Pipeline{
+		{{Key: "$match", Value: reviewedQuery(filter)}},
+		{{Key: "$unwind", Value: "$events"}},
+		{{Key: "$match", Value: bson.M{"events.by_author": false, "events.type": bson.M{"$ne": eventUpdate}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$events.actor_person_id",
+			"reviewer":          bson.M{"$last": "$events.actor"},
+			"pull_requests":     bson.M{"$addToSet": "$_id"},
+			"comments":          countType(eventComment),
+			"inline_comments":   countType(eventInlineComment),
+			"approvals":         countType(eventApproval),
+			"changes_requested": countType(eventChangesRequested),
+		}}},
+		{{Key: "$addFields", Value: bson.M{"pull_requests": bson.M{"$size": "$pull_requests"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "pull_requests", Value: -1}, {Key: "_id", Value: 1}}}},
+	}, &loads)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, load := range loads {
+		total += load.PullRequests
+	}
+	for i := range loads {
+		loads[i].Share = ratio(loads[i].PullRequests, total)
+	}
+	return loads, nil
+}
+
+// validateGrouping checks that groupBy is known and that grouping by team comes with the team registry.
+func validateGrouping(filter db.Filter, groupBy string) error {
+	switch groupBy {
+	case "", GroupByRepository:
+		return nil
+	case GroupByTeam:
+		if filter.Teams == nil {
+			return fmt.Errorf("grouping by team needs the team registry")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown grouping %q, expected %s or %s", groupBy, GroupByRepository, GroupByTeam)
+	}
+}
@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestReviewDepths(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "lep13/repo1", "pull_requests": 4, "unreviewed": 1, "comments": 2.5, "inline_comments": 1.5,
+			"review_rounds": 1.25, "updates_after_review": 0.75, "changes_requested": 0.25},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	depths, err := ReviewDepths(context.Background(), ReviewOptions{
+		Filter:  db.Filter{Repo: "repo1", From: currentFrom, To: currentTo},
+		GroupBy: GroupByRepository,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReviewDepth{{
+		Key: "lep13/repo1", PullRequests: 4, UnreviewedPullRequests: 1, CommentsPerPullRequest: 2.5,
+		InlineCommentsPerPullRequest: 1.5, AvgReviewRounds: 1.25, AvgUpdatesAfterReview: 0.75, ChangesRequestedRate: 0.25,
+	}}, depths)
+
+	pipeline := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	match := pipeline[0][0].Value.(bson.M)
+	assert.Equal(t, "repo1", match["repo_name"])
+	assert.Equal(t, bson.M{"$exists": true}, match["events.0"])
+	assert.Equal(t, bson.M{"$gte": currentFrom, "$lt": currentTo}, match["created_on"])
+}
+
+func TestReviewDepths_Invalid(t *testing.T) {
+	_, err := ReviewDepths(context.Background(), ReviewOptions{GroupBy: GroupByTeam})
+	assert.ErrorContains(t, err, "needs the team registry")
+	_, err = ReviewDepths(context.Background(), ReviewOptions{GroupBy: "author"})
+	assert.ErrorContains(t, err, "unknown grouping")
+}
+
+func TestReviewerLoads(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "p-2", "reviewer": "Bob", "pull_requests": 3, "comments": 5, "inline_comments": 2, "approvals": 3},
+		bson.M{"_id": "p-3", "reviewer": "Carol", "pull_requests": 1, "changes_requested": 1},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	loads, err := ReviewerLoads(context.Background(), db.Filter{Workspace: "lep13"})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReviewerLoad{
+		{PersonID: "p-2", Reviewer: "Bob", PullRequests: 3, Comments: 5, InlineComments: 2, Approvals: 3, Share: 0.75},
+		{PersonID: "p-3", Reviewer: "Carol", PullRequests: 1, ChangesRequested: 1, Share: 0.25},
+	}, loads)
+
+	pipeline := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	assert.Equal(t, "lep13", pipeline[0][0].Value.(bson.M)["workspace"])
+	assert.Equal(t, "$events", pipeline[1][0].Value)
+	assert.Equal(t, false, pipeline[2][0].Value.(bson.M)["events.by_author"])
+}
+
+func TestReviewerLoads_StoreError(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	_, err := ReviewerLoads(context.Background(), db.Filter{})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
@@ -0,0 +1,65 @@
+package analytics
+
+import (
+	"context"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReworkStats is the rework of one repository or team. ReworkRate is the part of the changed lines that
+// changed recent code again, and RevertRate the part of the commits that revert an earlier one.
+type ReworkStats struct {
+	Key              string  `bson:"_id" json:"key"`
+	Commits          int     `bson:"commits" json:"commits"`
+	LinesChanged     int     `bson:"lines_changed" json:"lines_changed"`
+	ReworkLines      int     `bson:"rework_lines" json:"rework_lines"`
+	ReworkOfOwnLines int     `bson:"rework_own_lines" json:"rework_of_own_lines"`
+	ReworkRate       float64 `bson:"-" json:"rework_rate"`
+	Reverts          int     `bson:"reverts" json:"reverts"`
+	RevertRate       float64 `bson:"-" json:"revert_rate"`
+}
+
+// ReworkOptions selects the commits within the filter period and how to group them.
+// Without grouping, every commit falls in the "all" group.
+type ReworkOptions struct {
+	Filter  db.Filter
+	GroupBy string
+}
+
+// ReworkRates computes the rework and revert rates of each group, sorted by key, from the links stored by
+// rework detection.
+func ReworkRates(ctx context.Context, opts ReworkOptions) ([]ReworkStats, error) {
+	if err := validateGrouping(opts.Filter, opts.GroupBy); err != nil {
+		return nil, err
+	}
+
+	ownLines := bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{"$rework", bson.A{}}}, "cond": "$$this.same_author"}},
+		"in":    "$$this.lines",
+	}}}
+
+	var stats []ReworkStats
+	err := aggregateAll(ctx, db.GetCollection(), mongo.Pipeline{
+		{{Key: "$match", Value: opts.Filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":              groupKey(opts.Filter, opts.GroupBy, "person_id", "commit_date"),
+			"commits":          bson.M{"$sum": 1},
+			"lines_changed":    bson.M{"$sum": bson.M{"$add": bson.A{"$lines_added", "$lines_deleted"}}},
+			"rework_lines":     bson.M{"$sum": "$rework_lines"},
+			"rework_own_lines": bson.M{"$sum": ownLines},
+			"reverts":          bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$reverts", ""}}, 1, 0}}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		stats[i].ReworkRate = ratio(stats[i].ReworkLines, stats[i].LinesChanged)
+		stats[i].RevertRate = ratio(stats[i].Reverts, stats[i].Commits)
+	}
+	return stats, nil
+}
@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestReworkRates(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "lep13/repo1", "commits": 8, "lines_changed": 400, "rework_lines": 60, "rework_own_lines": 20, "reverts": 2},
+		bson.M{"_id": "lep13/repo2", "commits": 1, "lines_changed": 0},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	stats, err := ReworkRates(context.Background(), ReworkOptions{Filter: db.Filter{From: currentFrom}, GroupBy: GroupByRepository})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReworkStats{
+		{Key: "lep13/repo1", Commits: 8, LinesChanged: 400, ReworkLines: 60, ReworkOfOwnLines: 20, ReworkRate: 0.15, Reverts: 2, RevertRate: 0.25},
+		{Key: "lep13/repo2", Commits: 1},
+	}, stats)
+
+	match := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{"$gte": currentFrom}, match["commit_date"])
+}
+
+func TestReworkRates_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := ReworkRates(context.Background(), ReworkOptions{})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
@@ -0,0 +1,184 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+)
+
+// Percentile returns the p-th percentile of sorted values, interpolating between the closest ranks.
+// It returns zero for no values.
+func Percentile(sorted []float64, p float64) float64 {
+	switch len(sorted) {
+	case 0:
+		return 0
+	case 1:
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// Mean returns the arithmetic mean of values, zero for no values.
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func variance(values []float64, mean float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return sum / float64(len(values)-1)
+}
+
+// WelchTTest returns the two-sided p-value of Welch's t-test for a difference between the means of a and b.
+// Samples need at least two values each; smaller ones yield a p-value of one.
+func WelchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	meanA, meanB := Mean(a), Mean(b)
+	errA := variance(a, meanA) / float64(len(a))
+	errB := variance(b, meanB) / float64(len(b))
+	if errA+errB == 0 {
+		if meanA == meanB {
+			return 1
+		}
+		return 0
+	}
+
+	t := (meanA - meanB) / math.Sqrt(errA+errB)
+	df := (errA + errB) * (errA + errB) / (errA*errA/float64(len(a)-1) + errB*errB/float64(len(b)-1))
+	return regularizedBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// MannWhitneyU returns the two-sided p-value of the Mann-Whitney U test for a shift between the distributions
+// of a and b, using the normal approximation with tie and continuity corrections.
+func MannWhitneyU(a, b []float64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 1
+	}
+	type sample struct {
+		value float64
+		fromA bool
+	}
+	samples := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range b {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+
+	// Tied values share the average of their ranks
+	rankSumA, tieCorrection := 0.0, 0.0
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if samples[k].fromA {
+				rankSumA += rank
+			}
+		}
+		ties := float64(j - i)
+		tieCorrection += ties*ties*ties - ties
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u := rankSumA - n1*(n1+1)/2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := math.Max(math.Abs(u-n1*n2/2)-0.5, 0) / sigma
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// TwoProportionZTest returns the two-sided p-value of the z-test for a difference between the proportions
+// successesA/totalA and successesB/totalB.
+func TwoProportionZTest(successesA, totalA, successesB, totalB int) float64 {
+	if totalA == 0 || totalB == 0 {
+		return 1
+	}
+	pooled := float64(successesA+successesB) / float64(totalA+totalB)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(totalA) + 1/float64(totalB)))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(successesA)/float64(totalA) - float64(successesB)/float64(totalB)) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// regularizedBeta returns the regularized incomplete beta function I_x(a, b).
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lgA, _ := math.Lgamma(a)
+	lgB, _ := math.Lgamma(b)
+	lgAB, _ := math.Lgamma(a + b)
+	front := math.Exp(lgAB - lgA - lgB + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly below the mean of the distribution, use the symmetry above it
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(x, a, b) / a
+	}
+	return 1 - front*betaFraction(1-x, b, a)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta function with Lentz's method.
+func betaFraction(x, a, b float64) float64 {
+	const (
+		maxIterations = 300
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	result := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		for _, numerator := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			result *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5}
+	assert.Equal(t, 3.0, Percentile(values, 50))
+	assert.InDelta(t, 4.6, Percentile(values, 90), 1e-9)
+	assert.Equal(t, 5.0, Percentile(values, 100))
+	assert.Equal(t, 7.0, Percentile([]float64{7}, 90))
+	assert.Equal(t, 0.0, Percentile(nil, 50))
+}
+
+func TestWelchTTest(t *testing.T) {
+	assert.InDelta(t, 0.0010528, WelchTTest([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}), 1e-6)
+	assert.InDelta(t, 1.0, WelchTTest([]float64{1, 2, 3}, []float64{3, 2, 1}), 1e-9)
+	assert.Equal(t, 1.0, WelchTTest([]float64{1}, []float64{6, 7}))
+	assert.Equal(t, 0.0, WelchTTest([]float64{1, 1}, []float64{2, 2}))
+	assert.Equal(t, 1.0, WelchTTest([]float64{2, 2}, []float64{2, 2}))
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	assert.InDelta(t, 0.012186, MannWhitneyU([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}), 1e-5)
+	assert.InDelta(t, 0.012186, MannWhitneyU([]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}), 1e-5)
+	assert.Equal(t, 1.0, MannWhitneyU([]float64{3, 3}, []float64{3, 3, 3}))
+	assert.Equal(t, 1.0, MannWhitneyU(nil, []float64{1}))
+}
+
+func TestTwoProportionZTest(t *testing.T) {
+	assert.InDelta(t, 0.028459, TwoProportionZTest(45, 100, 30, 100), 1e-5)
+	assert.InDelta(t, 1.0, TwoProportionZTest(3, 10, 3, 10), 1e-9)
+	assert.Equal(t, 1.0, TwoProportionZTest(0, 10, 0, 10))
+	assert.Equal(t, 1.0, TwoProportionZTest(0, 0, 1, 10))
+}
+
+func TestRegularizedBeta(t *testing.T) {
+	assert.InDelta(t, 0.5, regularizedBeta(0.5, 2, 2), 1e-12)
+	assert.InDelta(t, 0.104, regularizedBeta(0.2, 2, 2), 1e-12)
+	assert.InDelta(t, 0.896, regularizedBeta(0.8, 2, 2), 1e-12)
+}
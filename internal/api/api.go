@@ -0,0 +1,467 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/bitbucket"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page is the envelope of every list response. NextCursor is empty on the last page.
+type Page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// storedCommit is a commit document together with its MongoDB id, which backs the pagination cursor.
+type storedCommit struct {
+	ID               primitive.ObjectID `bson:"_id" json:"-"`
+	bitbucket.Commit `bson:",inline"`
+}
+
+// storedPullRequest is a pull request document together with its MongoDB id.
+type storedPullRequest struct {
+	ID                    primitive.ObjectID `bson:"_id" json:"-"`
+	bitbucket.PullRequest `bson:",inline"`
+}
+
+// AuthorStats aggregates the commits of one person across all their aliases.
+type AuthorStats struct {
+	PersonID     string    `bson:"person_id" json:"person_id"`
+	Author       string    `bson:"author" json:"author"`
+	Aliases      []string  `bson:"aliases" json:"aliases"`
+	Commits      int       `bson:"commits" json:"commits"`
+	LinesAdded   int       `bson:"lines_added" json:"lines_added"`
+	LinesDeleted int       `bson:"lines_deleted" json:"lines_deleted"`
+	FilesChanged int       `bson:"files_changed" json:"files_changed"`
+	Repositories int       `bson:"repositories" json:"repositories"`
+	FirstCommit  time.Time `bson:"first_commit" json:"first_commit"`
+	LastCommit   time.Time `bson:"last_commit" json:"last_commit"`
+}
+
+// RepositoryStats aggregates the commits of one repository.
+type RepositoryStats struct {
+	Workspace    string    `bson:"workspace" json:"workspace"`
+	ProjectName  string    `bson:"project_name" json:"project_name"`
+	RepoName     string    `bson:"repo_name" json:"repo_name"`
+	Commits      int       `bson:"commits" json:"commits"`
+	Authors      int       `bson:"authors" json:"authors"`
+	LinesAdded   int       `bson:"lines_added" json:"lines_added"`
+	LinesDeleted int       `bson:"lines_deleted" json:"lines_deleted"`
+	FilesChanged int       `bson:"files_changed" json:"files_changed"`
+	FirstCommit  time.Time `bson:"first_commit" json:"first_commit"`
+	LastCommit   time.Time `bson:"last_commit" json:"last_commit"`
+}
+
+// Metrics holds the figures computed over every commit and pull request matching a filter.
+type Metrics struct {
+	Commits             int     `json:"commits"`
+	Authors             int     `json:"authors"`
+	Repositories        int     `json:"repositories"`
+	LinesAdded          int     `json:"lines_added"`
+	LinesDeleted        int     `json:"lines_deleted"`
+	ActiveDays          int     `json:"active_days"`
+	CommitsPerActiveDay float64 `json:"commits_per_active_day"`
+	LinesPerCommit      float64 `json:"lines_per_commit"`
+	PullRequests        int     `json:"pull_requests"`
+	OpenPullRequests    int     `json:"open_pull_requests"`
+	MergedPullRequests  int     `json:"merged_pull_requests"`
+	AvgHoursToMerge     float64 `json:"avg_hours_to_merge"`
+}
+
+// NewHandler returns the HTTP handler serving the read-only API.
+func NewHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	Register(mux)
+	return mux
+}
+
+// Register adds the API routes to mux.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/commits", listCommits)
+	mux.HandleFunc("GET /api/v1/commits/classifications", listClassifications)
+	mux.HandleFunc("GET /api/v1/authors", listAuthors)
+	mux.HandleFunc("GET /api/v1/repositories", listRepositories)
+	mux.HandleFunc("GET /api/v1/pullrequests", listPullRequests)
+	mux.HandleFunc("GET /api/v1/teams", listTeams)
+	mux.HandleFunc("GET /api/v1/metrics", getMetrics)
+	mux.HandleFunc("GET /api/v1/cycle-time", getCycleTime)
+	mux.HandleFunc("GET /api/v1/cycle-time/pullrequests", listCycleTimes)
+	mux.HandleFunc("GET /api/v1/reviews", getReviewDepth)
+	mux.HandleFunc("GET /api/v1/reviewers", listReviewers)
+	mux.HandleFunc("GET /api/v1/conventions", getConventions)
+	mux.HandleFunc("GET /api/v1/churn/issue-types", getIssueChurn)
+	mux.HandleFunc("GET /api/v1/rework", getRework)
+}
+
+func listCommits(w http.ResponseWriter, r *http.Request) {
+	filter, limit, ok := parseListParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Listings show every commit, while aggregates leave out merges and bots unless asked otherwise
+	if len(filter.Classifications) == 0 {
+		filter.Classifications = []string{db.AllClassifications}
+	}
+	query := filter.CommitQuery()
+	if commitType := r.URL.Query().Get("type"); commitType != "" {
+		query["commit_type"] = commitType
+	}
+	switch linked := r.URL.Query().Get("linked"); linked {
+	case "":
+	case "true":
+		query["issue_keys.0"] = bson.M{"$exists": true}
+	case "false":
+		query["issue_keys.0"] = bson.M{"$exists": false}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid linked: %q", linked))
+		return
+	}
+	query, err := afterTimeCursor(query, "commit_date", r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "commit_date", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := db.GetCollection().Find(r.Context(), query, opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	commits := []storedCommit{}
+	if err := cursor.All(r.Context(), &commits); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	page := Page{Data: commits}
+	if len(commits) > limit {
+		last := commits[limit-1]
+		page.Data = commits[:limit]
+		page.NextCursor = encodeCursor(timeCursor{Date: last.CommitDate, ID: last.ID.Hex()})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func listPullRequests(w http.ResponseWriter, r *http.Request) {
+	filter, limit, ok := parseListParams(w, r)
+	if !ok {
+		return
+	}
+
+	query := filter.PullRequestQuery()
+	if state := r.URL.Query().Get("state"); state != "" {
+		query["state"] = state
+	}
+	query, err := afterTimeCursor(query, "created_on", r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_on", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := db.GetNamedCollection(db.PullRequestsCollection).Find(r.Context(), query, opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	pullRequests := []storedPullRequest{}
+	if err := cursor.All(r.Context(), &pullRequests); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	page := Page{Data: pullRequests}
+	if len(pullRequests) > limit {
+		last := pullRequests[limit-1]
+		page.Data = pullRequests[:limit]
+		page.NextCursor = encodeCursor(timeCursor{Date: last.CreatedOn, ID: last.ID.Hex()})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func listAuthors(w http.ResponseWriter, r *http.Request) {
+	filter, limit, ok := parseListParams(w, r)
+	if !ok {
+		return
+	}
+
+	// Commits stored before identity resolution have no person_id and are grouped by name instead
+	keyFields := []string{"person_id"}
+	group := bson.M{
+		"_id":           bson.D{{Key: "person_id", Value: bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}}},
+		"author":        bson.M{"$last": "$committed_by"},
+		"aliases":       bson.M{"$addToSet": "$committed_by"},
+		"commits":       bson.M{"$sum": 1},
+		"lines_added":   bson.M{"$sum": "$lines_added"},
+		"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		"files_changed": bson.M{"$sum": bson.M{"$add": bson.A{"$files_added", "$files_deleted", "$files_updated"}}},
+		"repositories":  bson.M{"$addToSet": "$repo_name"},
+		"first_commit":  bson.M{"$min": "$commit_date"},
+		"last_commit":   bson.M{"$max": "$commit_date"},
+	}
+	project := bson.M{
+		"person_id":    "$_id.person_id",
+		"repositories": bson.M{"$size": "$repositories"},
+	}
+
+	authors := []AuthorStats{}
+	if err := aggregatePage(r, filter.CommitQuery(), group, project, keyFields, limit, &authors); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	page := Page{Data: authors}
+	if len(authors) > limit {
+		page.Data = authors[:limit]
+		page.NextCursor = encodeCursor(keyCursor{Key: []string{authors[limit-1].PersonID}})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func listRepositories(w http.ResponseWriter, r *http.Request) {
+	filter, limit, ok := parseListParams(w, r)
+	if !ok {
+		return
+	}
+
+	keyFields := []string{"workspace", "project_name", "repo_name"}
+	group := bson.M{
+		"_id": bson.D{
+			{Key: "workspace", Value: "$workspace"},
+			{Key: "project_name", Value: "$project_name"},
+			{Key: "repo_name", Value: "$repo_name"},
+		},
+		"commits":       bson.M{"$sum": 1},
+		"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+		"lines_added":   bson.M{"$sum": "$lines_added"},
+		"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		"files_changed": bson.M{"$sum": bson.M{"$add": bson.A{"$files_added", "$files_deleted", "$files_updated"}}},
+		"first_commit":  bson.M{"$min": "$commit_date"},
+		"last_commit":   bson.M{"$max": "$commit_date"},
+	}
+	project := bson.M{
+		"workspace":    "$_id.workspace",
+		"project_name": "$_id.project_name",
+		"repo_name":    "$_id.repo_name",
+		"authors":      bson.M{"$size": "$authors"},
+	}
+
+	repositories := []RepositoryStats{}
+	if err := aggregatePage(r, filter.CommitQuery(), group, project, keyFields, limit, &repositories); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	page := Page{Data: repositories}
+	if len(repositories) > limit {
+		last := repositories[limit-1]
+		page.Data = repositories[:limit]
+		page.NextCursor = encodeCursor(keyCursor{Key: []string{last.Workspace, last.ProjectName, last.RepoName}})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyTeam(r.Context(), &filter); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	metrics, err := computeMetrics(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+// computeMetrics runs one aggregation over the commits and one over the pull requests matching filter.
+func computeMetrics(ctx context.Context, filter db.Filter) (Metrics, error) {
+	var metrics Metrics
+
+	commitPipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+			"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+			"repositories":  bson.M{"$addToSet": bson.A{"$workspace", "$project_name", "$repo_name"}},
+			"days":          bson.M{"$addToSet": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$commit_date"}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"commits":       1,
+			"lines_added":   1,
+			"lines_deleted": 1,
+			"authors":       bson.M{"$size": "$authors"},
+			"repositories":  bson.M{"$size": "$repositories"},
+			"active_days":   bson.M{"$size": "$days"},
+		}}},
+	}
+	var commitTotals []struct {
+		Commits      int `bson:"commits"`
+		LinesAdded   int `bson:"lines_added"`
+		LinesDeleted int `bson:"lines_deleted"`
+		Authors      int `bson:"authors"`
+		Repositories int `bson:"repositories"`
+		ActiveDays   int `bson:"active_days"`
+	}
+	if err := aggregateAll(ctx, db.GetCollection(), commitPipeline, &commitTotals); err != nil {
+		return Metrics{}, err
+	}
+	if len(commitTotals) > 0 {
+		totals := commitTotals[0]
+		metrics.Commits = totals.Commits
+		metrics.Authors = totals.Authors
+		metrics.Repositories = totals.Repositories
+		metrics.LinesAdded = totals.LinesAdded
+		metrics.LinesDeleted = totals.LinesDeleted
+		metrics.ActiveDays = totals.ActiveDays
+		if totals.ActiveDays > 0 {
+			metrics.CommitsPerActiveDay = float64(totals.Commits) / float64(totals.ActiveDays)
+		}
+		if totals.Commits > 0 {
+			metrics.LinesPerCommit = float64(totals.LinesAdded+totals.LinesDeleted) / float64(totals.Commits)
+		}
+	}
+
+	isMerged := bson.M{"$eq": bson.A{"$state", "MERGED"}}
+	prPipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter.PullRequestQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"pull_requests": bson.M{"$sum": 1},
+			"open":          bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$state", "OPEN"}}, 1, 0}}},
+			"merged":        bson.M{"$sum": bson.M{"$cond": bson.A{isMerged, 1, 0}}},
+			"merge_millis":  bson.M{"$avg": bson.M{"$cond": bson.A{isMerged, bson.M{"$subtract": bson.A{"$closed_on", "$created_on"}}, nil}}},
+		}}},
+	}
+	var prTotals []struct {
+		PullRequests int     `bson:"pull_requests"`
+		Open         int     `bson:"open"`
+		Merged       int     `bson:"merged"`
+		MergeMillis  float64 `bson:"merge_millis"`
+	}
+	if err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), prPipeline, &prTotals); err != nil {
+		return Metrics{}, err
+	}
+	if len(prTotals) > 0 {
+		totals := prTotals[0]
+		metrics.PullRequests = totals.PullRequests
+		metrics.OpenPullRequests = totals.Open
+		metrics.MergedPullRequests = totals.Merged
+		metrics.AvgHoursToMerge = time.Duration(totals.MergeMillis * float64(time.Millisecond)).Hours()
+	}
+
+	return metrics, nil
+}
+
+// queryError is a malformed request parameter detected while building a query.
+type queryError struct {
+	msg string
+}
+
+func (e queryError) Error() string {
+	return e.msg
+}
+
+// aggregatePage groups the commits matching query and decodes one page of groups, sorted by group key, into results.
+// It fetches one extra group so the caller can tell whether a next page exists.
+func aggregatePage(r *http.Request, query, group, project bson.M, keyFields []string, limit int, results interface{}) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$group", Value: group}},
+	}
+
+	after, err := afterKeyCursor(r.URL.Query().Get("cursor"), keyFields)
+	if err != nil {
+		return queryError{msg: err.Error()}
+	}
+	if after != nil {
+		pipeline = append(pipeline, after)
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		bson.D{{Key: "$limit", Value: limit + 1}},
+		bson.D{{Key: "$addFields", Value: project}},
+	)
+
+	return aggregateAll(r.Context(), db.GetCollection(), pipeline, results)
+}
+
+func aggregateAll(ctx context.Context, collection db.CollectionInterface, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+func parseListParams(w http.ResponseWriter, r *http.Request) (db.Filter, int, bool) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return db.Filter{}, 0, false
+	}
+	limit, err := parseLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return db.Filter{}, 0, false
+	}
+	if err := applyTeam(r.Context(), &filter); err != nil {
+		writeQueryError(w, err)
+		return db.Filter{}, 0, false
+	}
+	return filter, limit, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", logging.KeyError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	if _, ok := err.(queryError); ok {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeStoreError(w, err)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	slog.Error("Failed to query metrics store", logging.KeyError, err)
+	writeError(w, http.StatusInternalServerError, "failed to query metrics store")
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func serve(t *testing.T, url string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	NewHandler().ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec, body
+}
+
+func TestListCommits(t *testing.T) {
+	date := time.Date(2024, 7, 16, 10, 28, 45, 0, time.UTC)
+	id1, id2 := primitive.NewObjectID(), primitive.NewObjectID()
+
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, bson.M{"repo_name": "repo1"}, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": id1, "commit_id": "commit1", "repo_name": "repo1", "committed_by": "User1", "commit_date": date},
+		bson.M{"_id": id2, "commit_id": "commit2", "repo_name": "repo1", "committed_by": "User2", "commit_date": date},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/commits?repo=repo1&limit=1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	data := body["data"].([]interface{})
+	assert.Len(t, data, 1)
+	assert.Equal(t, "commit1", data[0].(map[string]interface{})["commit_id"])
+	assert.Equal(t, encodeCursor(timeCursor{Date: date, ID: id1.Hex()}), body["next_cursor"])
+
+	commits.AssertExpectations(t)
+}
+
+func TestListCommits_LastPage(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": primitive.NewObjectID(), "commit_id": "commit1"},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/commits")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, body["data"], 1)
+	assert.NotContains(t, body, "next_cursor")
+}
+
+func TestListCommits_Unlinked(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, bson.M{"commit_type": "fix", "issue_keys.0": bson.M{"$exists": false}}, mock.Anything).
+		Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/commits?type=fix&linked=false")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	commits.AssertExpectations(t)
+}
+
+func TestListCommits_BadRequest(t *testing.T) {
+	for _, url := range []string{
+		"/api/v1/commits?linked=maybe",
+		"/api/v1/commits?from=soon",
+		"/api/v1/commits?limit=-1",
+		"/api/v1/commits?cursor=garbage!",
+	} {
+		rec, body := serve(t, url)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, url)
+		assert.NotEmpty(t, body["error"], url)
+	}
+}
+
+func TestListCommits_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("connection refused")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/commits")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "failed to query metrics store", body["error"])
+}
+
+func TestListPullRequests(t *testing.T) {
+	created := time.Date(2024, 7, 16, 9, 0, 0, 0, time.UTC)
+
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Find", mock.Anything, bson.M{"author": "User1", "state": "MERGED"}, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": primitive.NewObjectID(), "pull_request_id": "1", "state": "MERGED", "author": "User1", "created_on": created},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, body := serve(t, "/api/v1/pullrequests?author=User1&state=MERGED")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	data := body["data"].([]interface{})
+	assert.Len(t, data, 1)
+	assert.Equal(t, "1", data[0].(map[string]interface{})["pull_request_id"])
+
+	pullRequests.AssertExpectations(t)
+}
+
+func TestListAuthors(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.MatchedBy(func(pipeline mongo.Pipeline) bool {
+		// match, group, cursor, sort, limit, addFields
+		return len(pipeline) == 6 && pipeline[2][0].Key == "$match"
+	}), mock.Anything).Return(db.NewCursor(t,
+		bson.M{"person_id": "p-2", "author": "User2", "aliases": bson.A{"User2", "user2"}, "commits": 3, "lines_added": 30, "repositories": 2},
+		bson.M{"person_id": "p-3", "author": "User3", "aliases": bson.A{"User3"}, "commits": 1, "lines_added": 5, "repositories": 1},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	cursor := encodeCursor(keyCursor{Key: []string{"p-1"}})
+	rec, body := serve(t, "/api/v1/authors?limit=1&cursor="+cursor)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	data := body["data"].([]interface{})
+	assert.Len(t, data, 1)
+	author := data[0].(map[string]interface{})
+	assert.Equal(t, "p-2", author["person_id"])
+	assert.Equal(t, "User2", author["author"])
+	assert.Equal(t, []interface{}{"User2", "user2"}, author["aliases"])
+	assert.Equal(t, float64(3), author["commits"])
+	assert.Equal(t, encodeCursor(keyCursor{Key: []string{"p-2"}}), body["next_cursor"])
+
+	commits.AssertExpectations(t)
+}
+
+func TestListAuthors_InvalidCursor(t *testing.T) {
+	cursor := encodeCursor(keyCursor{Key: []string{"lep13", "Project1", "repo1"}})
+	rec, _ := serve(t, "/api/v1/authors?cursor="+cursor)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestListRepositories(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"workspace": "lep13", "project_name": "Project1", "repo_name": "repo1", "commits": 2, "authors": 2},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/repositories?workspace=lep13")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	data := body["data"].([]interface{})
+	assert.Len(t, data, 1)
+	repo := data[0].(map[string]interface{})
+	assert.Equal(t, "repo1", repo["repo_name"])
+	assert.Equal(t, float64(2), repo["authors"])
+	assert.NotContains(t, body, "next_cursor")
+}
+
+func TestListRepositories_Empty(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/repositories")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []interface{}{}, body["data"])
+}
+
+func TestGetMetrics(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"commits": 4, "lines_added": 50, "lines_deleted": 10, "authors": 3, "repositories": 2, "active_days": 2},
+	), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"pull_requests": 3, "open": 1, "merged": 2, "merge_millis": float64(90 * time.Minute / time.Millisecond)},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	rec := httptest.NewRecorder()
+	NewHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/metrics?project=Project1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var metrics Metrics
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metrics))
+	assert.Equal(t, Metrics{
+		Commits:             4,
+		Authors:             3,
+		Repositories:        2,
+		LinesAdded:          50,
+		LinesDeleted:        10,
+		ActiveDays:          2,
+		CommitsPerActiveDay: 2,
+		LinesPerCommit:      15,
+		PullRequests:        3,
+		OpenPullRequests:    1,
+		MergedPullRequests:  2,
+		AvgHoursToMerge:     1.5,
+	}, metrics)
+}
+
+func TestGetMetrics_NoData(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	rec := httptest.NewRecorder()
+	NewHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var metrics Metrics
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metrics))
+	assert.Equal(t, Metrics{}, metrics)
+}
+
+func TestGetMetrics_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/metrics")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/commits", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
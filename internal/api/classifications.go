@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ClassificationStats sums the commits of one classification.
+type ClassificationStats struct {
+	Classification string `bson:"_id" json:"classification"`
+	Commits        int    `bson:"commits" json:"commits"`
+	Authors        int    `bson:"authors" json:"authors"`
+	LinesAdded     int    `bson:"lines_added" json:"lines_added"`
+	LinesDeleted   int    `bson:"lines_deleted" json:"lines_deleted"`
+}
+
+// listClassifications returns the commits matching the filter per classification, including the merges
+// and bot commits aggregates leave out by default.
+func listClassifications(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyTeam(r.Context(), &filter); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if len(filter.Classifications) == 0 {
+		filter.Classifications = []string{db.AllClassifications}
+	}
+
+	stats := []ClassificationStats{}
+	err = aggregateAll(r.Context(), db.GetCollection(), mongo.Pipeline{
+		{{Key: "$match", Value: filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"$ifNull": bson.A{"$classification", classify.Regular}},
+			"commits":       bson.M{"$sum": 1},
+			"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"authors": bson.M{"$size": "$authors"}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}, &stats)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Page{Data: stats})
+}
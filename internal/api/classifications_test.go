@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestListClassifications(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": classify.Bot, "commits": 4, "authors": 1, "lines_added": 40},
+		bson.M{"_id": classify.Regular, "commits": 10, "authors": 3, "lines_added": 100, "lines_deleted": 20},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, body := serve(t, "/api/v1/commits/classifications?repo=repo1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"classification": "bot", "commits": float64(4), "authors": float64(1), "lines_added": float64(40), "lines_deleted": float64(0)},
+		map[string]interface{}{"classification": "regular", "commits": float64(10), "authors": float64(3), "lines_added": float64(100), "lines_deleted": float64(20)},
+	}, body["data"])
+
+	match := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{"repo_name": "repo1"}, match, "every classification is counted")
+}
+
+func TestListCommits_Classification(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, bson.M{"classification": bson.M{"$in": bson.A{classify.Merge}}}, mock.Anything).
+		Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/commits?classification=merge")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	commits.AssertExpectations(t)
+
+	rec, body := serve(t, "/api/v1/commits?classification=squash")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, body["error"], "invalid classification")
+}
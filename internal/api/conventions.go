@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+)
+
+// getConventions returns how the commit messages of the period follow the Conventional Commits format
+// and link to issues, grouped by the group_by parameter: repository, team or nothing.
+func getConventions(w http.ResponseWriter, r *http.Request) {
+	filter, groupBy, ok := parseGrouping(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := analytics.CommitConventions(r.Context(), analytics.ConventionOptions{Filter: filter, GroupBy: groupBy})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if stats == nil {
+		stats = []analytics.ConventionStats{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: stats})
+}
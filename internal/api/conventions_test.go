@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetConventions(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": bson.M{"group": "all", "type": ""}, "commits": 1, "unlinked": 1},
+		bson.M{"_id": bson.M{"group": "all", "type": "feat"}, "commits": 1, "conventional": 1},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/conventions")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.ConventionStats `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.ConventionStats{{
+		Key: "all", Commits: 2, Conventional: 1, ConventionalShare: 0.5, Unlinked: 1, UnlinkedShare: 0.5,
+		Types: map[string]int{"feat": 1},
+	}}, page.Data)
+}
+
+func TestGetConventions_InvalidGrouping(t *testing.T) {
+	rec, body := serve(t, "/api/v1/conventions?group_by=author")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid group_by: expected repository or team", body["error"])
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+)
+
+// getCycleTime returns the cycle time phase percentiles of the pull requests merged within the period,
+// grouped by the group_by parameter: repository, team or nothing.
+func getCycleTime(w http.ResponseWriter, r *http.Request) {
+	cycleTimes, ok := queryCycleTimes(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, Page{Data: analytics.SummarizeCycleTimes(cycleTimes)})
+}
+
+// listCycleTimes returns the milestones of every pull request merged within the period.
+func listCycleTimes(w http.ResponseWriter, r *http.Request) {
+	cycleTimes, ok := queryCycleTimes(w, r)
+	if !ok {
+		return
+	}
+	if cycleTimes == nil {
+		cycleTimes = []analytics.PullRequestCycleTime{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: cycleTimes})
+}
+
+func queryCycleTimes(w http.ResponseWriter, r *http.Request) ([]analytics.PullRequestCycleTime, bool) {
+	filter, groupBy, ok := parseGrouping(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	cycleTimes, err := analytics.CycleTimes(r.Context(), analytics.CycleTimeOptions{Filter: filter, GroupBy: groupBy})
+	if err != nil {
+		writeStoreError(w, err)
+		return nil, false
+	}
+	return cycleTimes, true
+}
+
+// parseGrouping parses the filter and the group_by parameter, attaching the team registry when either
+// needs it, and writes the error response on failure.
+func parseGrouping(w http.ResponseWriter, r *http.Request) (db.Filter, string, bool) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return filter, "", false
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	switch groupBy {
+	case "", analytics.GroupByRepository:
+		err = applyTeam(r.Context(), &filter)
+	case analytics.GroupByTeam:
+		err = applyTeamGrouping(r.Context(), &filter)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid group_by: expected repository or team")
+		return filter, "", false
+	}
+	if err != nil {
+		writeQueryError(w, err)
+		return filter, "", false
+	}
+	return filter, groupBy, true
+}
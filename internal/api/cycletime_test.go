@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGetCycleTime(t *testing.T) {
+	merged := time.Date(2024, 7, 2, 10, 0, 0, 0, time.UTC)
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"pull_request_id": "7", "group": "lep13/repo1", "created_on": merged.Add(-5 * time.Hour), "first_review_on": merged.Add(-3 * time.Hour), "closed_on": merged},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, _ := serve(t, "/api/v1/cycle-time?group_by=repository&from=2024-07-01")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.CycleTimeBreakdown `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.CycleTimeBreakdown{{
+		Key:          "lep13/repo1",
+		PullRequests: 1,
+		Pickup:       analytics.PhaseStats{Samples: 1, P50Hours: 2, P75Hours: 2, P90Hours: 2},
+	}}, page.Data)
+}
+
+func TestGetCycleTime_ByTeam(t *testing.T) {
+	registry := newTeamRegistry(t)
+	useTeams(t, registry, nil)
+
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, body := serve(t, "/api/v1/cycle-time?group_by=team")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []interface{}{}, body["data"])
+
+	project := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)[2][0].Value.(bson.M)
+	assert.Equal(t, registry.TeamExpression("author_person_id", "created_on"), project["group"])
+}
+
+func TestGetCycleTime_InvalidGrouping(t *testing.T) {
+	rec, body := serve(t, "/api/v1/cycle-time?group_by=author")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid group_by: expected repository or team", body["error"])
+}
+
+func TestListCycleTimes(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"workspace": "lep13", "repo_name": "repo1", "pull_request_id": "7", "group": "all"},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, _ := serve(t, "/api/v1/cycle-time/pullrequests")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.PullRequestCycleTime `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.PullRequestCycleTime{{Workspace: "lep13", RepoName: "repo1", PullRequestID: "7", Group: "all"}}, page.Data)
+}
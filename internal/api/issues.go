@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+)
+
+// getIssueChurn returns the churn of the period split by the type of the linked issues, grouped by the
+// group_by parameter: repository, team or nothing.
+func getIssueChurn(w http.ResponseWriter, r *http.Request) {
+	filter, groupBy, ok := parseGrouping(w, r)
+	if !ok {
+		return
+	}
+
+	churns, err := analytics.ChurnByIssueType(r.Context(), analytics.IssueChurnOptions{Filter: filter, GroupBy: groupBy})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if churns == nil {
+		churns = []analytics.IssueChurn{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: churns})
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetIssueChurn(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": bson.M{"group": "lep13/repo1", "type": "bug"}, "commits": 2, "lines_added": 6, "lines_deleted": 4},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/churn/issue-types?group_by=repository")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.IssueChurn `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.IssueChurn{{Key: "lep13/repo1", Churn: 10, Types: []analytics.IssueTypeChurn{
+		{Type: "bug", Commits: 2, LinesAdded: 6, LinesDeleted: 4, Share: 1},
+	}}}, page.Data)
+}
+
+func TestGetIssueChurn_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/churn/issue-types")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
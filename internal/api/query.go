@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// parseFilter reads the provider, workspace, project, repo, author, person, team, from, to and classification
+// query parameters.
+func parseFilter(r *http.Request) (db.Filter, error) {
+	q := r.URL.Query()
+	filter := db.Filter{
+		Provider:  q.Get("provider"),
+		Workspace: q.Get("workspace"),
+		Project:   q.Get("project"),
+		Repo:      q.Get("repo"),
+		Author:    q.Get("author"),
+		Person:    q.Get("person"),
+		Team:      q.Get("team"),
+	}
+
+	var err error
+	if filter.From, filter.To, err = db.ParseDateRange(q.Get("from"), q.Get("to")); err != nil {
+		return db.Filter{}, err
+	}
+	if filter.Classifications, err = db.ParseClassifications(q.Get("classification")); err != nil {
+		return db.Filter{}, err
+	}
+
+	return filter, nil
+}
+
+// parseLimit reads the page size, defaulting to defaultLimit and capped at maxLimit.
+func parseLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit: %q", v)
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+// timeCursor points after the last document of a page sorted by a date field and _id, both descending.
+type timeCursor struct {
+	Date time.Time `json:"d"`
+	ID   string    `json:"id"`
+}
+
+// keyCursor points after the last group of an aggregate page sorted by group key ascending.
+type keyCursor struct {
+	Key []string `json:"k"`
+}
+
+func encodeCursor(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	return nil
+}
+
+// afterTimeCursor restricts query to the documents following the cursor in dateField/_id descending order.
+func afterTimeCursor(query bson.M, dateField, cursor string) (bson.M, error) {
+	if cursor == "" {
+		return query, nil
+	}
+
+	var c timeCursor
+	if err := decodeCursor(cursor, &c); err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return bson.M{"$and": bson.A{
+		query,
+		bson.M{"$or": bson.A{
+			bson.M{dateField: bson.M{"$lt": c.Date}},
+			bson.M{dateField: c.Date, "_id": bson.M{"$lt": id}},
+		}},
+	}}, nil
+}
+
+// afterKeyCursor returns the $match stage skipping the groups up to the cursor, or nil for the first page.
+// keyFields lists the fields of the group _id in the order they were declared in the $group stage.
+func afterKeyCursor(cursor string, keyFields []string) (bson.D, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	var c keyCursor
+	if err := decodeCursor(cursor, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Key) != len(keyFields) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	key := bson.D{}
+	for i, field := range keyFields {
+		key = append(key, bson.E{Key: field, Value: c.Key[i]})
+	}
+	return bson.D{{Key: "$match", Value: bson.M{"_id": bson.M{"$gt": key}}}}, nil
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseFilter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/commits?provider=github&workspace=lep13&project=Project1&repo=repo1&author=User1&from=2024-07-01&to=2024-07-31", nil)
+
+	filter, err := parseFilter(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "github", filter.Provider)
+	assert.Equal(t, "lep13", filter.Workspace)
+	assert.Equal(t, "Project1", filter.Project)
+	assert.Equal(t, "repo1", filter.Repo)
+	assert.Equal(t, "User1", filter.Author)
+	assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), filter.From)
+	// A plain "to" date includes the whole day
+	assert.Equal(t, time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), filter.To)
+}
+
+func TestParseFilter_RFC3339(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/commits?to=2024-07-31T12:00:00Z", nil)
+
+	filter, err := parseFilter(req)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 7, 31, 12, 0, 0, 0, time.UTC), filter.To)
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	tests := map[string]string{
+		"invalid from":   "/api/v1/commits?from=yesterday",
+		"invalid to":     "/api/v1/commits?to=07/31/2024",
+		"from before to": "/api/v1/commits?from=2024-08-01&to=2024-07-01",
+	}
+	for name, url := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseFilter(httptest.NewRequest("GET", url, nil))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := parseLimit(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, defaultLimit, limit)
+
+	limit, err = parseLimit(httptest.NewRequest("GET", "/?limit=5", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, limit)
+
+	limit, err = parseLimit(httptest.NewRequest("GET", "/?limit=100000", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, maxLimit, limit)
+
+	_, err = parseLimit(httptest.NewRequest("GET", "/?limit=0", nil))
+	assert.Error(t, err)
+
+	_, err = parseLimit(httptest.NewRequest("GET", "/?limit=ten", nil))
+	assert.Error(t, err)
+}
+
+func TestAfterTimeCursor(t *testing.T) {
+	query := bson.M{"repo_name": "repo1"}
+
+	unchanged, err := afterTimeCursor(query, "commit_date", "")
+	assert.NoError(t, err)
+	assert.Equal(t, query, unchanged)
+
+	id := primitive.NewObjectID()
+	date := time.Date(2024, 7, 16, 10, 28, 45, 0, time.UTC)
+	cursor := encodeCursor(timeCursor{Date: date, ID: id.Hex()})
+
+	paged, err := afterTimeCursor(query, "commit_date", cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": bson.A{
+		query,
+		bson.M{"$or": bson.A{
+			bson.M{"commit_date": bson.M{"$lt": date}},
+			bson.M{"commit_date": date, "_id": bson.M{"$lt": id}},
+		}},
+	}}, paged)
+
+	_, err = afterTimeCursor(query, "commit_date", "not-a-cursor!")
+	assert.Error(t, err)
+
+	_, err = afterTimeCursor(query, "commit_date", encodeCursor(timeCursor{Date: date, ID: "nope"}))
+	assert.Error(t, err)
+}
+
+func TestAfterKeyCursor(t *testing.T) {
+	stage, err := afterKeyCursor("", []string{"author"})
+	assert.NoError(t, err)
+	assert.Nil(t, stage)
+
+	cursor := encodeCursor(keyCursor{Key: []string{"lep13", "Project1", "repo1"}})
+	stage, err = afterKeyCursor(cursor, []string{"workspace", "project_name", "repo_name"})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "$match", Value: bson.M{"_id": bson.M{"$gt": bson.D{
+		{Key: "workspace", Value: "lep13"},
+		{Key: "project_name", Value: "Project1"},
+		{Key: "repo_name", Value: "repo1"},
+	}}}}}, stage)
+
+	_, err = afterKeyCursor(cursor, []string{"author"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+)
+
+// getReviewDepth returns the review depth of the pull requests created within the period whose review
+// timeline was ingested, grouped by the group_by parameter: repository, team or nothing.
+func getReviewDepth(w http.ResponseWriter, r *http.Request) {
+	filter, groupBy, ok := parseGrouping(w, r)
+	if !ok {
+		return
+	}
+
+	depths, err := analytics.ReviewDepths(r.Context(), analytics.ReviewOptions{Filter: filter, GroupBy: groupBy})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if depths == nil {
+		depths = []analytics.ReviewDepth{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: depths})
+}
+
+// listReviewers returns the review load of every reviewer of the pull requests created within the period.
+func listReviewers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := applyTeam(r.Context(), &filter); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	loads, err := analytics.ReviewerLoads(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if loads == nil {
+		loads = []analytics.ReviewerLoad{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: loads})
+}
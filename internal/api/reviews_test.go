@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGetReviewDepth(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "lep13/repo1", "pull_requests": 2, "comments": 3.0, "review_rounds": 1.5},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, _ := serve(t, "/api/v1/reviews?group_by=repository&repo=repo1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.ReviewDepth `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.ReviewDepth{{Key: "lep13/repo1", PullRequests: 2, CommentsPerPullRequest: 3, AvgReviewRounds: 1.5}}, page.Data)
+
+	match := pullRequests.Calls[0].Arguments.Get(1).(mongo.Pipeline)[0][0].Value.(bson.M)
+	assert.Equal(t, "repo1", match["repo_name"])
+}
+
+func TestGetReviewDepth_InvalidGrouping(t *testing.T) {
+	rec, body := serve(t, "/api/v1/reviews?group_by=author")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid group_by: expected repository or team", body["error"])
+}
+
+func TestListReviewers(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "p-2", "reviewer": "Bob", "pull_requests": 1, "approvals": 1},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, body := serve(t, "/api/v1/reviewers")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"person_id": "p-2", "reviewer": "Bob", "pull_requests": float64(1), "comments": float64(0),
+		"inline_comments": float64(0), "approvals": float64(1), "changes_requested": float64(0), "share": float64(1),
+	}}, body["data"])
+}
+
+func TestListReviewers_StoreError(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	rec, _ := serve(t, "/api/v1/reviewers")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
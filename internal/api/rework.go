@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+)
+
+// getRework returns the rework and revert rates of the period, grouped by the group_by parameter:
+// repository, team or nothing.
+func getRework(w http.ResponseWriter, r *http.Request) {
+	filter, groupBy, ok := parseGrouping(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := analytics.ReworkRates(r.Context(), analytics.ReworkOptions{Filter: filter, GroupBy: groupBy})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if stats == nil {
+		stats = []analytics.ReworkStats{}
+	}
+	writeJSON(w, http.StatusOK, Page{Data: stats})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGetRework(t *testing.T) {
+	registry := newTeamRegistry(t)
+	useTeams(t, registry, nil)
+
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "payments", "commits": 4, "lines_changed": 100, "rework_lines": 10, "reverts": 1},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	rec, _ := serve(t, "/api/v1/rework?group_by=team")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []analytics.ReworkStats `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []analytics.ReworkStats{
+		{Key: "payments", Commits: 4, LinesChanged: 100, ReworkLines: 10, ReworkRate: 0.1, Reverts: 1, RevertRate: 0.25},
+	}, page.Data)
+
+	group := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)[1][0].Value.(bson.M)
+	assert.Equal(t, registry.TeamExpression("person_id", "commit_date"), group["_id"])
+}
+
+func TestGetRework_InvalidGrouping(t *testing.T) {
+	rec, body := serve(t, "/api/v1/rework?group_by=author")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "invalid group_by: expected repository or team", body["error"])
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/teams"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TeamSource loads the team registry used by the team filter and the team aggregates.
+var TeamSource teams.Source = teams.LoadCollection
+
+// TeamStats aggregates the commits and pull requests attributed to one team.
+type TeamStats struct {
+	Team               string  `json:"team"`
+	Authors            int     `json:"authors"`
+	Repositories       int     `json:"repositories"`
+	Commits            int     `json:"commits"`
+	LinesAdded         int     `json:"lines_added"`
+	LinesDeleted       int     `json:"lines_deleted"`
+	Churn              int     `json:"churn"`
+	PullRequests       int     `json:"pull_requests"`
+	OpenPullRequests   int     `json:"open_pull_requests"`
+	MergedPullRequests int     `json:"merged_pull_requests"`
+	AvgHoursToMerge    float64 `json:"avg_hours_to_merge"`
+}
+
+// applyTeam attaches the team registry to filter when it selects a team.
+func applyTeam(ctx context.Context, filter *db.Filter) error {
+	if filter.Team == "" {
+		return nil
+	}
+	registry, err := TeamSource(ctx)
+	if err != nil {
+		return err
+	}
+	if !registry.Has(filter.Team) {
+		return queryError{msg: fmt.Sprintf("unknown team %q", filter.Team)}
+	}
+	filter.Teams = registry
+	return nil
+}
+
+// applyTeamGrouping attaches the team registry to filter, whether it selects a team or not.
+func applyTeamGrouping(ctx context.Context, filter *db.Filter) error {
+	if filter.Team != "" {
+		return applyTeam(ctx, filter)
+	}
+	registry, err := TeamSource(ctx)
+	if err != nil {
+		return err
+	}
+	filter.Teams = registry
+	return nil
+}
+
+// listTeams returns one entry per registered team, in registry order, followed by the unassigned work if any.
+func listTeams(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	registry, err := TeamSource(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if filter.Team != "" && !registry.Has(filter.Team) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown team %q", filter.Team))
+		return
+	}
+	filter.Teams = registry
+
+	stats, err := computeTeamStats(r.Context(), filter, registry)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Page{Data: stats})
+}
+
+func computeTeamStats(ctx context.Context, filter db.Filter, registry *teams.Registry) ([]TeamStats, error) {
+	byTeam := map[string]*TeamStats{}
+	stats := []*TeamStats{}
+	statsFor := func(team string) *TeamStats {
+		if s, ok := byTeam[team]; ok {
+			return s
+		}
+		s := &TeamStats{Team: team}
+		byTeam[team] = s
+		stats = append(stats, s)
+		return s
+	}
+	for _, name := range registry.Names() {
+		if filter.Team == "" || filter.Team == name {
+			statsFor(name)
+		}
+	}
+
+	commitPipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           registry.TeamExpression("person_id", "commit_date"),
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+			"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+			"repositories":  bson.M{"$addToSet": bson.A{"$workspace", "$project_name", "$repo_name"}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"commits":       1,
+			"lines_added":   1,
+			"lines_deleted": 1,
+			"authors":       bson.M{"$size": "$authors"},
+			"repositories":  bson.M{"$size": "$repositories"},
+		}}},
+	}
+	var commitTotals []struct {
+		Team         string `bson:"_id"`
+		Commits      int    `bson:"commits"`
+		LinesAdded   int    `bson:"lines_added"`
+		LinesDeleted int    `bson:"lines_deleted"`
+		Authors      int    `bson:"authors"`
+		Repositories int    `bson:"repositories"`
+	}
+	if err := aggregateAll(ctx, db.GetCollection(), commitPipeline, &commitTotals); err != nil {
+		return nil, err
+	}
+
+	isMerged := bson.M{"$eq": bson.A{"$state", "MERGED"}}
+	prPipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter.PullRequestQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           registry.TeamExpression("author_person_id", "created_on"),
+			"pull_requests": bson.M{"$sum": 1},
+			"open":          bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$state", "OPEN"}}, 1, 0}}},
+			"merged":        bson.M{"$sum": bson.M{"$cond": bson.A{isMerged, 1, 0}}},
+			"merge_millis":  bson.M{"$avg": bson.M{"$cond": bson.A{isMerged, bson.M{"$subtract": bson.A{"$closed_on", "$created_on"}}, nil}}},
+		}}},
+	}
+	var prTotals []struct {
+		Team         string  `bson:"_id"`
+		PullRequests int     `bson:"pull_requests"`
+		Open         int     `bson:"open"`
+		Merged       int     `bson:"merged"`
+		MergeMillis  float64 `bson:"merge_millis"`
+	}
+	if err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), prPipeline, &prTotals); err != nil {
+		return nil, err
+	}
+
+	// Unassigned work sorts after the registered teams, as it only shows up in the aggregation results
+	for _, totals := range commitTotals {
+		s := statsFor(totals.Team)
+		s.Commits = totals.Commits
+		s.Authors = totals.Authors
+		s.Repositories = totals.Repositories
+		s.LinesAdded = totals.LinesAdded
+		s.LinesDeleted = totals.LinesDeleted
+		s.Churn = totals.LinesAdded + totals.LinesDeleted
+	}
+	for _, totals := range prTotals {
+		s := statsFor(totals.Team)
+		s.PullRequests = totals.PullRequests
+		s.OpenPullRequests = totals.Open
+		s.MergedPullRequests = totals.Merged
+		s.AvgHoursToMerge = time.Duration(totals.MergeMillis * float64(time.Millisecond)).Hours()
+	}
+
+	result := make([]TeamStats, len(stats))
+	for i, s := range stats {
+		result[i] = *s
+	}
+	return result, nil
+}
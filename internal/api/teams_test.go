@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/teams"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// useTeams serves registry as the team registry for the duration of the test.
+func useTeams(t *testing.T, registry *teams.Registry, err error) {
+	old := TeamSource
+	t.Cleanup(func() { TeamSource = old })
+	TeamSource = func(context.Context) (*teams.Registry, error) {
+		return registry, err
+	}
+}
+
+func newTeamRegistry(t *testing.T) *teams.Registry {
+	registry, err := teams.NewRegistry([]teams.Team{
+		{Name: "payments", Members: []teams.Member{{PersonID: "p-1"}}},
+		{Name: "platform", Repositories: []teams.Repository{{Repo: "infra"}}},
+	})
+	assert.NoError(t, err)
+	return registry
+}
+
+func TestListTeams(t *testing.T) {
+	useTeams(t, newTeamRegistry(t), nil)
+
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "payments", "commits": 3, "lines_added": 30, "lines_deleted": 5, "authors": 2, "repositories": 1},
+		bson.M{"_id": teams.Unassigned, "commits": 1, "lines_added": 1, "lines_deleted": 1, "authors": 1, "repositories": 1},
+	), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "payments", "pull_requests": 2, "open": 1, "merged": 1, "merge_millis": float64(2 * 3600 * 1000)},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	rec, _ := serve(t, "/api/v1/teams?from=2024-07-01")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var page struct {
+		Data []TeamStats `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, []TeamStats{
+		{Team: "payments", Authors: 2, Repositories: 1, Commits: 3, LinesAdded: 30, LinesDeleted: 5, Churn: 35,
+			PullRequests: 2, OpenPullRequests: 1, MergedPullRequests: 1, AvgHoursToMerge: 2},
+		{Team: "platform"},
+		{Team: teams.Unassigned, Authors: 1, Repositories: 1, Commits: 1, LinesAdded: 1, LinesDeleted: 1, Churn: 2},
+	}, page.Data)
+
+	pipeline := commits.Calls[0].Arguments.Get(1).(mongo.Pipeline)
+	group := pipeline[1][0].Value.(bson.M)
+	assert.Equal(t, newTeamRegistry(t).TeamExpression("person_id", "commit_date"), group["_id"])
+}
+
+func TestListTeams_UnknownTeam(t *testing.T) {
+	useTeams(t, newTeamRegistry(t), nil)
+
+	rec, body := serve(t, "/api/v1/teams?team=marketing")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, `unknown team "marketing"`, body["error"])
+}
+
+func TestListTeams_RegistryError(t *testing.T) {
+	useTeams(t, nil, errors.New("timeout"))
+
+	rec, _ := serve(t, "/api/v1/teams")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestGetMetrics_TeamFilter(t *testing.T) {
+	registry := newTeamRegistry(t)
+	useTeams(t, registry, nil)
+
+	teamMatch := bson.M{"$eq": bson.A{registry.TeamExpression("person_id", "commit_date"), "payments"}}
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.MatchedBy(func(pipeline mongo.Pipeline) bool {
+		match := pipeline[0][0].Value.(bson.M)
+		return assert.ObjectsAreEqual(teamMatch, match["$expr"])
+	}), mock.Anything).Return(db.NewCursor(t), nil).Once()
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+
+	rec, _ := serve(t, "/api/v1/metrics?team=payments")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	commits.AssertExpectations(t)
+}
+
+func TestListCommits_UnknownTeam(t *testing.T) {
+	useTeams(t, newTeamRegistry(t), nil)
+
+	rec, body := serve(t, "/api/v1/commits?team=marketing")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, `unknown team "marketing"`, body["error"])
+}
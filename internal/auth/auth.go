@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/config"
+)
+
+// Authentication types of AuthConfig.
+const (
+	TypeToken             = "token"
+	TypeWorkspaceToken    = "workspace_token"
+	TypeAppPassword       = "app_password"
+	TypeAPIToken          = "api_token"
+	TypeClientCredentials = "client_credentials"
+)
+
+// Authenticator sets the credentials of a request.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// HTTPClient is the part of http.Client the authenticators use to obtain tokens.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Bearer authenticates with a static bearer token, such as an access token of a workspace, project or repository.
+type Bearer struct {
+	Token string
+}
+
+// Authenticate sets the bearer token of req.
+func (b Bearer) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+// Basic authenticates with basic auth, as app passwords and API tokens do.
+type Basic struct {
+	Username string
+	Password string
+}
+
+// Authenticate sets the basic auth credentials of req.
+func (b Basic) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+// NewFromConfig returns the authenticator configured by cfg. Client credentials obtain their tokens through client.
+func NewFromConfig(cfg config.AuthConfig, client HTTPClient) (Authenticator, error) {
+	switch cfg.Type {
+	case TypeToken, TypeWorkspaceToken:
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("%s authentication needs a token", cfg.Type)
+		}
+		return Bearer{Token: cfg.Token}, nil
+	case TypeAppPassword, TypeAPIToken:
+		if cfg.Username == "" || cfg.Password == "" {
+			return nil, fmt.Errorf("%s authentication needs a username and a password", cfg.Type)
+		}
+		return Basic{Username: cfg.Username, Password: cfg.Password}, nil
+	case TypeClientCredentials:
+		if cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, fmt.Errorf("%s authentication needs a client ID and a client secret", cfg.Type)
+		}
+		return NewClientCredentials(cfg.ClientID, cfg.ClientSecret, cfg.TokenURL, client), nil
+	}
+	return nil, fmt.Errorf("unknown authentication type %q", cfg.Type)
+}
+
+// Transport is an http.RoundTripper that authenticates every request before passing it to Base.
+type Transport struct {
+	Base http.RoundTripper
+	Auth Authenticator
+}
+
+// RoundTrip authenticates a copy of req and sends it through Base.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A round tripper must not change the request it is given
+	authenticated := req.Clone(req.Context())
+	if err := t.Auth.Authenticate(authenticated); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to authenticate request: %w", err)
+	}
+	return t.Base.RoundTrip(authenticated)
+}
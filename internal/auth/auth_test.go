@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type failingAuthenticator struct{}
+
+func (failingAuthenticator) Authenticate(req *http.Request) error {
+	return errors.New("no token")
+}
+
+func TestBearer(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	assert.NoError(t, Bearer{Token: "workspace_token"}.Authenticate(req))
+	assert.Equal(t, "Bearer workspace_token", req.Header.Get("Authorization"))
+}
+
+func TestBasic(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	assert.NoError(t, Basic{Username: "jdoe", Password: "app_password"}.Authenticate(req))
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "jdoe", username)
+	assert.Equal(t, "app_password", password)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	authenticator, err := NewFromConfig(config.AuthConfig{Type: TypeWorkspaceToken, Token: "token"}, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, Bearer{Token: "token"}, authenticator)
+
+	authenticator, err = NewFromConfig(config.AuthConfig{Type: TypeAppPassword, Username: "jdoe", Password: "secret"}, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, Basic{Username: "jdoe", Password: "secret"}, authenticator)
+
+	authenticator, err = NewFromConfig(config.AuthConfig{Type: TypeClientCredentials, ClientID: "id", ClientSecret: "secret"}, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Equal(t, NewClientCredentials("id", "secret", DefaultTokenURL, http.DefaultClient), authenticator)
+
+	_, err = NewFromConfig(config.AuthConfig{Type: TypeToken}, http.DefaultClient)
+	assert.EqualError(t, err, "token authentication needs a token")
+
+	_, err = NewFromConfig(config.AuthConfig{Type: TypeAPIToken, Username: "jdoe"}, http.DefaultClient)
+	assert.EqualError(t, err, "api_token authentication needs a username and a password")
+
+	_, err = NewFromConfig(config.AuthConfig{Type: TypeClientCredentials, ClientID: "id"}, http.DefaultClient)
+	assert.EqualError(t, err, "client_credentials authentication needs a client ID and a client secret")
+
+	_, err = NewFromConfig(config.AuthConfig{Type: "kerberos"}, http.DefaultClient)
+	assert.EqualError(t, err, `unknown authentication type "kerberos"`)
+}
+
+func TestTransport(t *testing.T) {
+	var sent *http.Request
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}),
+		Auth: Bearer{Token: "token"},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Bearer token", sent.Header.Get("Authorization"))
+	assert.Empty(t, req.Header.Get("Authorization"), "the original request must not change")
+}
+
+func TestTransport_AuthenticationError(t *testing.T) {
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			t.Fatal("an unauthenticated request must not be sent")
+			return nil, nil
+		}),
+		Auth: failingAuthenticator{},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	_, err := transport.RoundTrip(req)
+	assert.EqualError(t, err, "failed to authenticate request: no token")
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenURL is the token endpoint of Bitbucket Cloud OAuth consumers.
+const DefaultTokenURL = "https://bitbucket.org/site/oauth2/access_token"
+
+// refreshMargin is how long before it expires an access token is replaced, so no request carries a token that
+// expires in flight.
+const refreshMargin = time.Minute
+
+var now = time.Now
+
+// ClientCredentials authenticates as an OAuth consumer through the client credentials grant. It reuses its
+// access token until shortly before the token expires.
+type ClientCredentials struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	client       HTTPClient
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewClientCredentials returns an authenticator for the given consumer. An empty tokenURL uses DefaultTokenURL.
+func NewClientCredentials(clientID, clientSecret, tokenURL string, client HTTPClient) *ClientCredentials {
+	if tokenURL == "" {
+		tokenURL = DefaultTokenURL
+	}
+	return &ClientCredentials{clientID: clientID, clientSecret: clientSecret, tokenURL: tokenURL, client: client}
+}
+
+// Authenticate sets the current access token of the consumer on req, first obtaining a new one when there is
+// none or it is about to expire.
+func (c *ClientCredentials) Authenticate(req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" || !now().Add(refreshMargin).Before(c.expires) {
+		if err := c.refresh(); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	return nil
+}
+
+func (c *ClientCredentials) refresh() error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to fetch access token: status %d: %s", resp.StatusCode, string(body))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("failed to fetch access token: response holds no token")
+	}
+
+	c.token = token.AccessToken
+	c.expires = now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return nil
+}
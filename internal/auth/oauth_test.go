@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeTokenEndpoint issues the tokens token1, token2, ... valid for an hour to the consumer id:secret and
+// counts how many it issued.
+func newFakeTokenEndpoint(t *testing.T, issued *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || clientID != "id" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		*issued++
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "bearer", "expires_in": 3600}`, *issued)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func setNow(t *testing.T, at time.Time) {
+	oldNow := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = oldNow })
+}
+
+func TestClientCredentials_ReusesToken(t *testing.T) {
+	var issued int
+	server := newFakeTokenEndpoint(t, &issued)
+	start := time.Date(2024, 7, 16, 10, 0, 0, 0, time.UTC)
+	setNow(t, start)
+	credentials := NewClientCredentials("id", "secret", server.URL, server.Client())
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+		assert.NoError(t, credentials.Authenticate(req))
+		assert.Equal(t, "Bearer token1", req.Header.Get("Authorization"))
+	}
+	assert.Equal(t, 1, issued)
+}
+
+func TestClientCredentials_RefreshesBeforeExpiry(t *testing.T) {
+	var issued int
+	server := newFakeTokenEndpoint(t, &issued)
+	start := time.Date(2024, 7, 16, 10, 0, 0, 0, time.UTC)
+	setNow(t, start)
+	credentials := NewClientCredentials("id", "secret", server.URL, server.Client())
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	assert.NoError(t, credentials.Authenticate(req))
+
+	setNow(t, start.Add(59*time.Minute+30*time.Second))
+	req, _ = http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	assert.NoError(t, credentials.Authenticate(req))
+	assert.Equal(t, "Bearer token2", req.Header.Get("Authorization"))
+	assert.Equal(t, 2, issued)
+}
+
+func TestClientCredentials_Error(t *testing.T) {
+	var issued int
+	server := newFakeTokenEndpoint(t, &issued)
+	credentials := NewClientCredentials("id", "wrong_secret", server.URL, server.Client())
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.bitbucket.org/2.0/repositories/lep13", nil)
+	err := credentials.Authenticate(req)
+	assert.EqualError(t, err, `failed to fetch access token: status 401: {"error": "invalid_client"}`)
+	assert.Empty(t, req.Header.Get("Authorization"))
+}
@@ -3,19 +3,57 @@ package bitbucket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lep13/bitbucket_metrics/config"
-	db "github.com/lep13/bitbucket_metrics/internal/database"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"github.com/lep13/bitbucket_metrics/internal/conventions"
+	"github.com/lep13/bitbucket_metrics/internal/identity"
+	"github.com/lep13/bitbucket_metrics/internal/issues"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+	"github.com/lep13/bitbucket_metrics/internal/metrics"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/lep13/bitbucket_metrics/internal/urltemplate"
 )
 
+// cfg is the configuration set by Configure.
 var cfg *config.Config
 
+// resolver maps commit and pull request authors to stable person identities.
+var resolver, _ = identity.NewResolver(nil, nil)
+
+// messageParser extracts the Conventional Commit header and issue keys of commit messages.
+var messageParser = conventions.NewParser(nil)
+
+// classifier tells regular commits from merges, reverts and bot commits.
+var classifier, _ = classify.NewClassifier(nil)
+
+// issueTracker enriches commits with the issues they link to. It is nil when no tracker is configured.
+var issueTracker issues.Tracker
+
+// pseudonymizer replaces personal data before storage in privacy mode. It is nil, leaving data as is, otherwise.
+var pseudonymizer *privacy.Pseudonymizer
+
+// aliasHasher hashes names to match them against the aliases of erased people. It is nil when no pseudonym key
+// is configured.
+var aliasHasher *privacy.Pseudonymizer
+
+// workspace is the Bitbucket Cloud workspace whose repositories are collected when no sources are configured.
+var workspace = "lep13"
+
+// cloudPullRequestStates are the pull request states asked of Bitbucket Cloud, which lists only open pull requests
+// by default.
+var cloudPullRequestStates = []string{"MERGED", "DECLINED", "SUPERSEDED", "OPEN"}
+
 // HTTPClient defines the methods that our client should implement
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
@@ -23,149 +61,238 @@ type HTTPClient interface {
 
 var httpClient HTTPClient = &http.Client{}
 
-// var loadConfigFunc = config.LoadConfig
+// maxRetries is how many times a throttled or unavailable Bitbucket call is retried.
+const maxRetries = 3
+
+// retryDelay is the wait before the first retry, doubled on every further attempt.
+var retryDelay = time.Second
+
+// FetchAndSaveCommits syncs every configured source to MongoDB and records a summary of the run in the runs
+// collection. It returns the summary together with an error when anything failed.
+func FetchAndSaveCommits(accessToken string) (*RunSummary, error) {
+	start := time.Now()
+	defer func() {
+		metrics.RunDuration.Observe(time.Since(start).Seconds())
+		metrics.LastRunTimestamp.SetToCurrentTime()
+	}()
+
+	summary := newRunSummary()
+	summary.finish(syncProviders(accessToken, mongoStore{}, summary))
+	logRunSummary(summary)
+	if err := recordRun(summary); err != nil {
+		summary.logger.Error("Failed to record sync run", logging.KeyError, err)
+	}
+	return summary, summary.Err()
+}
 
-func init() {
-	var err error
-	cfg, err = config.LoadConfig()
+// syncProviders saves the data of every configured source to s, counting what it does in summary. A source whose
+// repositories cannot be listed is recorded in summary and skipped. It returns the error that stopped it before
+// going through every repository, which includes every source failing.
+func syncProviders(accessToken string, s store, summary *RunSummary) error {
+	providers, err := configuredProviders(cfg, accessToken)
+	if err != nil {
+		return err
+	}
+	// Syncing without the erasures would restore what they removed
+	suppressions, err := loadSuppressions(context.Background(), aliasHasher)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return err
+	}
+	s = suppressingStore{next: s, suppressions: suppressions}
+
+	issueEnricher := issues.NewEnricher(issueTracker)
+	var failures []error
+	for _, provider := range providers {
+		if err := syncProvider(provider, issueEnricher, s, summary); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	if len(providers) > 0 && len(failures) == len(providers) {
+		return errors.Join(failures...)
 	}
+	return nil
 }
 
-func FetchAndSaveCommits(accessToken string) error {
-	repos, err := fetchRepositories(accessToken)
+// syncProvider saves the commits, pull requests and pipelines of every repository of provider to s.
+func syncProvider(provider Provider, issueEnricher *issues.Enricher, s store, summary *RunSummary) error {
+	logger := summary.logger.With(logging.KeyWorkspace, provider.Workspace())
+	repos, err := provider.Repositories()
 	if err != nil {
-		return fmt.Errorf("failed to fetch repositories: %v", err)
+		err = fmt.Errorf("failed to fetch repositories of %s: %v", provider.Workspace(), err)
+		logger.Error("Failed to fetch repositories", logging.KeyError, err)
+		summary.recordError(CauseFetchRepositories, err)
+		return err
 	}
 
 	for _, repo := range repos {
-		log.Printf("Processing repository: %s", repo.Name)
-		commits, err := fetchCommits(accessToken, repo.Slug)
+		repoLogger := logger.With(logging.KeyRepo, repo.Slug)
+		repoLogger.Info("Syncing repository")
+		summary.ReposProcessed++
+		saveCommits(provider, issueEnricher, s, repo, summary)
+		// Pull requests follow the commits, whose authors link their accounts to the emails they are resolved by
+		savePullRequests(provider, s, repo, summary)
+		savePipelines(provider, s, repo, summary)
+	}
+
+	return nil
+}
+
+// saveCommits saves the commits of repo to s, together with their details and linked issues.
+func saveCommits(provider Provider, issueEnricher *issues.Enricher, s store, repo Repository, summary *RunSummary) {
+	repoLogger := summary.logger.With(logging.KeyWorkspace, provider.Workspace(), logging.KeyRepo, repo.Slug)
+	commits, err := provider.Commits(repo)
+	if err != nil {
+		repoLogger.Warn("Failed to fetch commits", logging.KeyError, err)
+		summary.recordError(CauseFetchCommits, fmt.Errorf("repository %s: %v", repo.Slug, err))
+		return
+	}
+	summary.CommitsFetched += len(commits)
+
+	for _, commit := range commits {
+		commitLogger := repoLogger.With(logging.KeyCommit, commit.Hash)
+		commitLogger.Debug("Syncing commit")
+		detailedCommit, err := provider.CommitDetails(repo, commit.Hash)
 		if err != nil {
-			log.Printf("Failed to fetch commits for repository %s: %v", repo.Slug, err)
+			commitLogger.Warn("Failed to fetch commit details", logging.KeyError, err)
+			summary.CommitsFailed++
+			summary.recordError(CauseFetchCommitDetails, fmt.Errorf("commit %s: %v", commit.Hash, err))
 			continue
 		}
 
-		for _, commit := range commits {
-			log.Printf("Processing commit: %s", commit.Hash)
-			detailedCommit, err := fetchCommitDetails(accessToken, repo.Slug, commit.Hash)
-			if err != nil {
-				log.Printf("Failed to fetch detailed commit info for %s: %v", commit.Hash, err)
-				continue
-			}
-
-			filesAdded, filesDeleted, filesUpdated := 0, 0, 0
-
-			for _, file := range detailedCommit.Files {
-				switch file.Type {
-				case "added":
-					filesAdded++
-				case "removed":
-					filesDeleted++
-				case "modified":
-					filesUpdated++
-				}
-			}
+		filesAdded, filesDeleted, filesUpdated := 0, 0, 0
 
-			newCommit := Commit{
-				ProjectName:   repo.Project.Name,
-				RepoName:      repo.Name,
-				CommitMessage: detailedCommit.Message,
-				CommitID:      detailedCommit.Hash,
-				CommittedBy:   detailedCommit.Author.User.DisplayName,
-				LinesAdded:    detailedCommit.Summary.LinesAdded,
-				LinesDeleted:  detailedCommit.Summary.LinesDeleted,
-				CommitDate:    detailedCommit.Date,
-				FilesAdded:    filesAdded,
-				FilesDeleted:  filesDeleted,
-				FilesUpdated:  filesUpdated,
-				ReviewedBy:    detailedCommit.ReviewedBy.User.DisplayName,
-				PullRequestID: detailedCommit.PullRequest.ID,
+		for _, file := range detailedCommit.Files {
+			switch file.Type {
+			case "added":
+				filesAdded++
+			case "removed":
+				filesDeleted++
+			case "modified":
+				filesUpdated++
 			}
+		}
 
-			log.Printf("Upserting commit: %+v", newCommit)
-
-			collection := db.GetCollection()
-			log.Printf("Using collection: %v", collection)
-			updateResult, err := collection.UpdateOne(
-				context.Background(),
-				bson.M{"commit_id": newCommit.CommitID},
-				bson.M{"$set": newCommit},
-				options.Update().SetUpsert(true),
-			)
-			if err != nil {
-				log.Printf("Failed to upsert commit %s: %v", newCommit.CommitID, err)
-			} else {
-				log.Printf("Successfully upserted commit: %s, MatchedCount: %d, ModifiedCount: %d, UpsertedCount: %d, UpsertedID: %v",
-					newCommit.CommitID, updateResult.MatchedCount, updateResult.ModifiedCount, updateResult.UpsertedCount, updateResult.UpsertedID)
-			}
+		author := resolver.Resolve(identity.Author{
+			Raw:         detailedCommit.Author.Raw,
+			DisplayName: detailedCommit.Author.User.DisplayName,
+			AccountUUID: detailedCommit.Author.User.UUID,
+			Nickname:    detailedCommit.Author.User.Nickname,
+		})
+
+		newCommit := Commit{
+			Provider:       provider.Name(),
+			Workspace:      provider.Workspace(),
+			ProjectKey:     repo.Project.Key,
+			ProjectName:    repo.Project.Name,
+			RepoName:       repo.Name,
+			CommitMessage:  detailedCommit.Message,
+			CommitID:       detailedCommit.Hash,
+			CommittedBy:    author.Name,
+			PersonID:       author.PersonID,
+			AuthorRaw:      detailedCommit.Author.Raw,
+			AuthorEmail:    author.Email,
+			AuthorUUID:     detailedCommit.Author.User.UUID,
+			AuthorNickname: detailedCommit.Author.User.Nickname,
+			LinesAdded:     detailedCommit.Summary.LinesAdded,
+			LinesDeleted:   detailedCommit.Summary.LinesDeleted,
+			CommitDate:     detailedCommit.Date,
+			FilesAdded:     filesAdded,
+			FilesDeleted:   filesDeleted,
+			FilesUpdated:   filesUpdated,
+			ReviewedBy:     detailedCommit.ReviewedBy.User.DisplayName,
+			PullRequestID:  detailedCommit.PullRequest.ID,
+			Files:          detailedCommit.Files,
+		}
+		for _, parent := range detailedCommit.Parents {
+			newCommit.ParentHashes = append(newCommit.ParentHashes, parent.Hash)
 		}
+		// The message and author are classified and parsed before privacy mode may redact them
+		newCommit.Classification = classifier.Classify(classify.Commit{
+			Message:      detailedCommit.Message,
+			ParentHashes: newCommit.ParentHashes,
+			Authors:      []string{detailedCommit.Author.Raw, detailedCommit.Author.User.DisplayName, detailedCommit.Author.User.Nickname},
+		})
+		message := messageParser.Parse(detailedCommit.Message)
+		newCommit.Conventional = message.Conventional
+		newCommit.CommitType = message.Type
+		newCommit.CommitScope = message.Scope
+		newCommit.Breaking = message.Breaking
+		newCommit.IssueKeys = message.IssueKeys
+		newCommit.Issues = issueEnricher.Lookup(context.Background(), message.IssueKeys)
+		newCommit = pseudonymizeCommit(newCommit)
+
+		if err := s.saveCommit(newCommit); errors.Is(err, errErased) {
+			commitLogger.Debug("Left out commit of erased person")
+			summary.Erased++
+			continue
+		} else if err != nil {
+			commitLogger.Warn("Failed to save commit", logging.KeyError, err)
+			summary.CommitsFailed++
+			summary.recordError(CauseUpsertCommit, fmt.Errorf("commit %s: %v", newCommit.CommitID, err))
+			continue
+		}
+		commitLogger.Debug("Saved commit")
+		summary.CommitsUpserted++
 	}
-
-	return nil
 }
 
-func fetchRepositories(accessToken string) ([]Repository, error) {
-	url := fmt.Sprintf(cfg.RepoURLTemplate, "lep13")
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+// pseudonymizeCommit replaces the personal data of commit with pseudonyms when privacy mode is on.
+func pseudonymizeCommit(commit Commit) Commit {
+	commit.CommittedBy = pseudonymizer.Pseudonym(commit.CommittedBy)
+	commit.PersonID = pseudonymizer.Pseudonym(commit.PersonID)
+	commit.AuthorRaw = pseudonymizer.Pseudonym(commit.AuthorRaw)
+	commit.AuthorEmail = pseudonymizer.Pseudonym(commit.AuthorEmail)
+	commit.AuthorUUID = pseudonymizer.Pseudonym(commit.AuthorUUID)
+	commit.AuthorNickname = pseudonymizer.Pseudonym(commit.AuthorNickname)
+	commit.ReviewedBy = pseudonymizer.Pseudonym(commit.ReviewedBy)
+	commit.CommitMessage = pseudonymizer.Message(commit.CommitMessage)
+	return commit
+}
 
-	resp, err := httpClient.Do(req)
+// Repositories fetches the repositories of the workspace.
+func (c *cloudProvider) Repositories() ([]Repository, error) {
+	url, err := urltemplate.Expand(cfg.RepoURLTemplate, urltemplate.Values{urltemplate.Workspace: c.workspace})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to fetch repositories: %s", string(body))
-	}
-
-	var result struct {
-		Values []Repository `json:"values"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	repos, err := fetchCloudPages[Repository]("repositories", "repositories", url, c.client)
+	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Fetched %d repositories", len(result.Values))
-	return result.Values, nil
+	slog.Debug("Fetched repositories", logging.KeyWorkspace, c.workspace, "count", len(repos))
+	return repos, nil
 }
 
-func fetchCommits(accessToken, repoSlug string) ([]CommitDetails, error) {
-	url := fmt.Sprintf(cfg.CommitsURLTemplate, "lep13", repoSlug)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	resp, err := httpClient.Do(req)
+// Commits fetches the commits of repo, following their pages.
+func (c *cloudProvider) Commits(repo Repository) ([]CommitDetails, error) {
+	url, err := urltemplate.Expand(cfg.CommitsURLTemplate, c.repoValues(repo))
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to fetch commits: %s", string(body))
-	}
-
-	var result struct {
-		Values []CommitDetails `json:"values"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	commits, err := fetchCloudPages[CommitDetails]("commits", "commits", url, c.client)
+	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Fetched %d commits for repository %s", len(result.Values), repoSlug)
-	return result.Values, nil
+	slog.Debug("Fetched commits", logging.KeyWorkspace, c.workspace, logging.KeyRepo, repo.Slug, "count", len(commits))
+	return commits, nil
 }
 
-func fetchCommitDetails(accessToken, repoSlug, commitHash string) (CommitDetails, error) {
-	commitURL := fmt.Sprintf(cfg.CommitURLTemplate, "lep13", repoSlug, commitHash)
-	commitReq, _ := http.NewRequest("GET", commitURL, nil)
-	commitReq.Header.Set("Authorization", "Bearer "+accessToken)
+// CommitDetails fetches a commit of repo together with its diffstat.
+func (c *cloudProvider) CommitDetails(repo Repository, commitHash string) (CommitDetails, error) {
+	values := c.repoValues(repo)
+	values[urltemplate.CommitHash] = commitHash
+	commitURL, err := urltemplate.Expand(cfg.CommitURLTemplate, values)
+	if err != nil {
+		return CommitDetails{}, err
+	}
+	diffstatURL, err := urltemplate.Expand(cfg.DiffstatURLTemplate, values)
+	if err != nil {
+		return CommitDetails{}, err
+	}
 
-	commitResp, err := httpClient.Do(commitReq)
+	commitResp, err := doRequest("commit", commitURL, c.client)
 	if err != nil {
 		return CommitDetails{}, err
 	}
@@ -181,11 +308,7 @@ func fetchCommitDetails(accessToken, repoSlug, commitHash string) (CommitDetails
 		return CommitDetails{}, err
 	}
 
-	diffstatURL := fmt.Sprintf(cfg.DiffstatURLTemplate, "lep13", repoSlug, commitHash)
-	diffstatReq, _ := http.NewRequest("GET", diffstatURL, nil)
-	diffstatReq.Header.Set("Authorization", "Bearer "+accessToken)
-
-	diffstatResp, err := httpClient.Do(diffstatReq)
+	diffstatResp, err := doRequest("diffstat", diffstatURL, c.client)
 	if err != nil {
 		return CommitDetails{}, err
 	}
@@ -198,25 +321,381 @@ func fetchCommitDetails(accessToken, repoSlug, commitHash string) (CommitDetails
 
 	var diffstatResult struct {
 		Values []struct {
-			Type string `json:"type"`
-			Path struct {
+			Type         string `json:"type"`
+			LinesAdded   int    `json:"lines_added"`
+			LinesRemoved int    `json:"lines_removed"`
+			Path         struct {
 				To string `json:"to"`
 			} `json:"path"`
+			New struct {
+				Path string `json:"path"`
+			} `json:"new"`
+			Old struct {
+				Path string `json:"path"`
+			} `json:"old"`
 		} `json:"values"`
 	}
 	if err := json.NewDecoder(diffstatResp.Body).Decode(&diffstatResult); err != nil {
 		return CommitDetails{}, err
 	}
 
-	commitDetails.Files = make([]struct {
-		Type string `json:"type"`
-		Path string `json:"path"`
-	}, len(diffstatResult.Values))
+	commitDetails.Files = make([]FileChange, len(diffstatResult.Values))
 	for i, file := range diffstatResult.Values {
-		commitDetails.Files[i].Type = file.Type
-		commitDetails.Files[i].Path = file.Path.To
+		// Removed files only have an old path
+		path := file.Path.To
+		if path == "" {
+			path = file.New.Path
+		}
+		if path == "" {
+			path = file.Old.Path
+		}
+		commitDetails.Files[i] = FileChange{
+			Path:         path,
+			Type:         file.Type,
+			LinesAdded:   file.LinesAdded,
+			LinesDeleted: file.LinesRemoved,
+		}
 	}
 
-	log.Printf("Fetched detailed commit information and diffstat for commit %s", commitHash)
+	slog.Debug("Fetched commit details and diffstat", logging.KeyWorkspace, c.workspace, logging.KeyRepo, repo.Slug, logging.KeyCommit, commitHash)
 	return commitDetails, nil
-}
\ No newline at end of file
+}
+
+// savePullRequests fetches the pull requests of a repository and saves them to s.
+func savePullRequests(provider Provider, s store, repo Repository, summary *RunSummary) {
+	logger := summary.logger.With(logging.KeyWorkspace, provider.Workspace(), logging.KeyRepo, repo.Slug)
+	pullRequests, err := provider.PullRequests(repo)
+	if err != nil {
+		logger.Warn("Failed to fetch pull requests", logging.KeyError, err)
+		summary.recordError(CauseFetchPullRequests, fmt.Errorf("repository %s: %v", repo.Slug, err))
+		return
+	}
+
+	for _, pr := range pullRequests {
+		author := resolver.Resolve(identity.Author{
+			DisplayName: pr.Author.DisplayName,
+			AccountUUID: pr.Author.UUID,
+			Nickname:    pr.Author.Nickname,
+		})
+
+		newPullRequest := PullRequest{
+			Provider:          provider.Name(),
+			Workspace:         provider.Workspace(),
+			ProjectKey:        repo.Project.Key,
+			ProjectName:       repo.Project.Name,
+			RepoName:          repo.Name,
+			PullRequestID:     strconv.Itoa(pr.ID),
+			Title:             pr.Title,
+			State:             pr.State,
+			Author:            author.Name,
+			AuthorPersonID:    author.PersonID,
+			SourceBranch:      pr.Source.Branch.Name,
+			DestinationBranch: pr.Destination.Branch.Name,
+			MergeCommit:       pr.MergeCommit.Hash,
+			CommentCount:      pr.CommentCount,
+			CreatedOn:         pr.CreatedOn,
+			UpdatedOn:         pr.UpdatedOn,
+		}
+		if pr.State != "OPEN" {
+			newPullRequest.ClosedOn = pr.ClosedOn
+			if newPullRequest.ClosedOn.IsZero() {
+				newPullRequest.ClosedOn = pr.UpdatedOn
+			}
+		}
+		activity, err := provider.PullRequestActivity(repo, newPullRequest.PullRequestID)
+		if err != nil {
+			logger.Warn("Failed to fetch pull request activity", "pull_request", newPullRequest.PullRequestID, logging.KeyError, err)
+			summary.recordError(CauseFetchPRActivity, fmt.Errorf("pull request %s/%s: %v", repo.Slug, newPullRequest.PullRequestID, err))
+		} else if activity != nil {
+			newPullRequest.Events = reviewEvents(activity, pr.Author.UUID, pr.Author.DisplayName)
+			summarizeReview(&newPullRequest)
+		}
+		commits, err := provider.PullRequestCommits(repo, newPullRequest.PullRequestID)
+		if err != nil {
+			logger.Warn("Failed to fetch pull request commits", "pull_request", newPullRequest.PullRequestID, logging.KeyError, err)
+			summary.recordError(CauseFetchPRCommits, fmt.Errorf("pull request %s/%s: %v", repo.Slug, newPullRequest.PullRequestID, err))
+		} else {
+			newPullRequest.FirstCommitOn = firstCommitDate(commits)
+		}
+		newPullRequest.Author = pseudonymizer.Pseudonym(newPullRequest.Author)
+		newPullRequest.AuthorPersonID = pseudonymizer.Pseudonym(newPullRequest.AuthorPersonID)
+		for i := range newPullRequest.Events {
+			newPullRequest.Events[i].Actor = pseudonymizer.Pseudonym(newPullRequest.Events[i].Actor)
+			newPullRequest.Events[i].ActorPersonID = pseudonymizer.Pseudonym(newPullRequest.Events[i].ActorPersonID)
+		}
+
+		if err := s.savePullRequest(newPullRequest); errors.Is(err, errErased) {
+			logger.Debug("Left out pull request of erased person", "pull_request", newPullRequest.PullRequestID)
+			summary.Erased++
+			continue
+		} else if err != nil {
+			logger.Warn("Failed to save pull request", "pull_request", newPullRequest.PullRequestID, logging.KeyError, err)
+			summary.PullRequestsFailed++
+			summary.recordError(CauseUpsertPullRequest, fmt.Errorf("pull request %s/%s: %v", repo.Slug, newPullRequest.PullRequestID, err))
+			continue
+		}
+		summary.PullRequestsUpserted++
+	}
+}
+
+// PullRequests fetches the pull requests of repo in every state, following their pages. Bitbucket Cloud lists only
+// open pull requests unless the states are asked for.
+func (c *cloudProvider) PullRequests(repo Repository) ([]PullRequestDetails, error) {
+	requestURL, err := urltemplate.Expand(cfg.PullRequestsURLTemplate, c.repoValues(repo))
+	if err != nil {
+		return nil, err
+	}
+	requestURL, err = withQuery(requestURL, url.Values{"state": cloudPullRequestStates})
+	if err != nil {
+		return nil, err
+	}
+	pullRequests, err := fetchCloudPages[PullRequestDetails]("pullrequests", "pull requests", requestURL, c.client)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Fetched pull requests", logging.KeyWorkspace, c.workspace, logging.KeyRepo, repo.Slug, "count", len(pullRequests))
+	return pullRequests, nil
+}
+
+// PullRequestActivity fetches the whole activity feed of a pull request, following its pages. It returns nil
+// when no activity URL template is configured.
+func (c *cloudProvider) PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error) {
+	if cfg.PullRequestActivityURLTemplate == "" {
+		return nil, nil
+	}
+	values := c.repoValues(repo)
+	values[urltemplate.PullRequestID] = pullRequestID
+	url, err := urltemplate.Expand(cfg.PullRequestActivityURLTemplate, values)
+	if err != nil {
+		return nil, err
+	}
+	return fetchCloudPages[PullRequestActivity]("pullrequest_activity", "pull request activity", url, c.client)
+}
+
+// PullRequestCommits fetches the commits of a pull request, following their pages. The URL defaults to the pull
+// requests URL followed by /{pull_request_id}/commits, and no commits are fetched when neither is configured.
+func (c *cloudProvider) PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error) {
+	template := cfg.PullRequestCommitsURLTemplate
+	if template == "" {
+		if cfg.PullRequestsURLTemplate == "" {
+			return nil, nil
+		}
+		template = strings.TrimSuffix(cfg.PullRequestsURLTemplate, "/") + "/{" + urltemplate.PullRequestID + "}/commits"
+	}
+	values := c.repoValues(repo)
+	values[urltemplate.PullRequestID] = pullRequestID
+	url, err := urltemplate.Expand(template, values)
+	if err != nil {
+		return nil, err
+	}
+	return fetchCloudPages[CommitDetails]("pullrequest_commits", "pull request commits", url, c.client)
+}
+
+// firstCommitDate returns the date of the earliest of commits, or the zero time when there are none.
+func firstCommitDate(commits []CommitDetails) time.Time {
+	var first time.Time
+	for _, commit := range commits {
+		if !commit.Date.IsZero() && (first.IsZero() || commit.Date.Before(first)) {
+			first = commit.Date
+		}
+	}
+	return first
+}
+
+// reviewEvents turns the activity feed of a pull request into its review timeline, oldest first.
+// Updates changing the state of the pull request, such as merging it, are left out.
+func reviewEvents(activity []PullRequestActivity, authorUUID, authorName string) []ReviewEvent {
+	isAuthor := func(user Account) bool {
+		if user.UUID != "" && authorUUID != "" {
+			return user.UUID == authorUUID
+		}
+		return user.DisplayName == authorName
+	}
+	newEvent := func(eventType string, user Account, at time.Time) ReviewEvent {
+		person := resolver.Resolve(identity.Author{DisplayName: user.DisplayName, AccountUUID: user.UUID, Nickname: user.Nickname})
+		return ReviewEvent{Type: eventType, Actor: person.Name, ActorPersonID: person.PersonID, ByAuthor: isAuthor(user), At: at}
+	}
+
+	var events []ReviewEvent
+	for _, a := range activity {
+		switch {
+		case a.Update != nil && a.Update.State == "OPEN":
+			events = append(events, newEvent(EventUpdate, a.Update.Author, a.Update.Date))
+		case a.Approval != nil:
+			events = append(events, newEvent(EventApproval, a.Approval.User, a.Approval.Date))
+		case a.ChangesRequested != nil:
+			events = append(events, newEvent(EventChangesRequested, a.ChangesRequested.User, a.ChangesRequested.Date))
+		case a.Comment != nil && a.Comment.Inline != nil:
+			event := newEvent(EventInlineComment, a.Comment.User, a.Comment.CreatedOn)
+			event.Path = a.Comment.Inline.Path
+			events = append(events, event)
+		case a.Comment != nil:
+			events = append(events, newEvent(EventComment, a.Comment.User, a.Comment.CreatedOn))
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events
+}
+
+// summarizeReview derives the review milestones and rounds of pr from its events. The first review is the first
+// comment, approval or change request by someone other than the author. A review round is a run of such reviews
+// up to the next update.
+func summarizeReview(pr *PullRequest) {
+	inRound := false
+	for _, e := range pr.Events {
+		switch {
+		case e.Type == EventUpdate:
+			if !pr.FirstReviewOn.IsZero() {
+				pr.UpdatesAfterReview++
+			}
+			inRound = false
+		case !e.ByAuthor:
+			if pr.FirstReviewOn.IsZero() {
+				pr.FirstReviewOn = e.At
+			}
+			if e.Type == EventApproval && pr.ApprovedOn.IsZero() {
+				pr.ApprovedOn = e.At
+			}
+			if !inRound {
+				pr.ReviewRounds++
+				inRound = true
+			}
+		}
+	}
+}
+
+// savePipelines fetches the pipelines of a repository and saves them to s.
+func savePipelines(provider Provider, s store, repo Repository, summary *RunSummary) {
+	logger := summary.logger.With(logging.KeyWorkspace, provider.Workspace(), logging.KeyRepo, repo.Slug)
+	pipelines, err := provider.Pipelines(repo)
+	if err != nil {
+		logger.Warn("Failed to fetch pipelines", logging.KeyError, err)
+		summary.recordError(CauseFetchPipelines, fmt.Errorf("repository %s: %v", repo.Slug, err))
+		return
+	}
+
+	for _, p := range pipelines {
+		newPipeline := Pipeline{
+			Provider:        provider.Name(),
+			Workspace:       provider.Workspace(),
+			ProjectKey:      repo.Project.Key,
+			ProjectName:     repo.Project.Name,
+			RepoName:        repo.Name,
+			PipelineID:      p.UUID,
+			BuildNumber:     p.BuildNumber,
+			State:           p.State.Name,
+			Result:          p.State.Result.Name,
+			RefName:         p.Target.RefName,
+			CommitHash:      p.Target.Commit.Hash,
+			CreatedOn:       p.CreatedOn,
+			CompletedOn:     p.CompletedOn,
+			DurationSeconds: p.DurationInSeconds,
+		}
+
+		if err := s.savePipeline(newPipeline); err != nil {
+			logger.Warn("Failed to save pipeline", "pipeline", newPipeline.PipelineID, logging.KeyError, err)
+			summary.PipelinesFailed++
+			summary.recordError(CauseUpsertPipeline, fmt.Errorf("pipeline %s/%d: %v", repo.Slug, newPipeline.BuildNumber, err))
+			continue
+		}
+		summary.PipelinesUpserted++
+	}
+}
+
+// Pipelines fetches the pipelines of repo, following their pages.
+func (c *cloudProvider) Pipelines(repo Repository) ([]PipelineDetails, error) {
+	url, err := urltemplate.Expand(cfg.PipelinesURLTemplate, c.repoValues(repo))
+	if err != nil {
+		return nil, err
+	}
+	pipelines, err := fetchCloudPages[PipelineDetails]("pipelines", "pipelines", url, c.client)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("Fetched pipelines", logging.KeyWorkspace, c.workspace, logging.KeyRepo, repo.Slug, "count", len(pipelines))
+	return pipelines, nil
+}
+
+// fetchCloudPages fetches every page of a paged Bitbucket Cloud resource, following the "next" URL of each page.
+// what names the resource in errors.
+func fetchCloudPages[T any](endpoint, what, url string, client HTTPClient) ([]T, error) {
+	all := []T{}
+	for url != "" {
+		resp, err := doRequest(endpoint, url, client)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch %s: %s", what, string(body))
+		}
+
+		var page struct {
+			Values []T    `json:"values"`
+			Next   string `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Values...)
+		url = page.Next
+	}
+	return all, nil
+}
+
+// withQuery adds query to the query string of rawURL.
+func withQuery(rawURL string, query url.Values) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	params := parsed.Query()
+	for key, values := range query {
+		params[key] = append(params[key], values...)
+	}
+	parsed.RawQuery = params.Encode()
+	return parsed.String(), nil
+}
+
+// doRequest sends an authenticated GET request to the Bitbucket API, retrying throttled and unavailable responses.
+// endpoint names the kind of call in the exported metrics.
+func doRequest(endpoint, url string, client HTTPClient) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequest("GET", url, nil)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			metrics.HTTPRequests.WithLabelValues(endpoint, "error").Inc()
+			return nil, err
+		}
+		metrics.HTTPRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+
+		if !isRetryable(resp.StatusCode) || attempt == maxRetries {
+			return resp, nil
+		}
+
+		delay := retryDelay << attempt
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			delay = time.Duration(seconds) * time.Second
+		}
+		resp.Body.Close()
+
+		metrics.HTTPRetries.WithLabelValues(endpoint).Inc()
+		slog.Warn("Retrying request", "endpoint", endpoint, "status", resp.StatusCode, "delay", delay)
+		time.Sleep(delay)
+	}
+}
+
+func isRetryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -2,16 +2,26 @@ package bitbucket
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	// "github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/auth"
 	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"github.com/lep13/bitbucket_metrics/internal/identity"
+	"github.com/lep13/bitbucket_metrics/internal/issues"
+	"github.com/lep13/bitbucket_metrics/internal/metrics"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -39,6 +49,49 @@ func (m *MockCollection) UpdateOne(ctx context.Context, filter interface{}, upda
 	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
 }
 
+func (m *MockCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	args := m.Called(ctx, filter, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.Cursor), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	args := m.Called(ctx, pipeline, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.Cursor), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	args := m.Called(ctx, document, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.InsertOneResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	args := m.Called(ctx, filter, update, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.UpdateResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	args := m.Called(ctx, filter, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.DeleteResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// testCloud is the Cloud provider of the default workspace the tests collect.
+var testCloud = newCloudProvider("lep13", auth.Bearer{Token: "fake_token"})
+
 func TestFetchRepositories(t *testing.T) {
 	mockClient := new(MockHTTPClient)
 	mockClient.On("Do", mock.Anything).Return(&http.Response{
@@ -56,7 +109,7 @@ func TestFetchRepositories(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	repos, err := fetchRepositories("fake_token")
+	repos, err := testCloud.Repositories()
 	assert.NoError(t, err)
 	assert.Len(t, repos, 2)
 	assert.Equal(t, "repo1", repos[0].Name)
@@ -65,12 +118,16 @@ func TestFetchRepositories(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
-// TestFetchRepositories_Error tests the fetchRepositories function for error case.
+// TestFetchRepositories_Error tests the Repositories method of the Cloud provider for error case.
 func TestFetchRepositories_Error(t *testing.T) {
 	mockClient := new(MockHTTPClient)
 	mockClient.On("Do", mock.Anything).Return(nil, errors.New("failed to fetch repositories"))
 
-	repos, err := fetchRepositories("fake_token")
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	repos, err := testCloud.Repositories()
 	assert.Error(t, err)
 	assert.Nil(t, repos)
 	assert.Contains(t, err.Error(), "failed to fetch repositories")
@@ -95,7 +152,7 @@ func TestFetchCommits(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	commits, err := fetchCommits("fake_token", "repo1")
+	commits, err := testCloud.Commits(Repository{Slug: "repo1"})
 	assert.NoError(t, err)
 	assert.Len(t, commits, 2)
 	assert.Equal(t, "commit1", commits[0].Hash)
@@ -104,12 +161,16 @@ func TestFetchCommits(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
-// TestFetchCommits_Error tests the fetchCommits function for error case.
+// TestFetchCommits_Error tests the Commits method of the Cloud provider for error case.
 func TestFetchCommits_Error(t *testing.T) {
 	mockClient := new(MockHTTPClient)
 	mockClient.On("Do", mock.Anything).Return(nil, errors.New("failed to fetch commits"))
 
-	commits, err := fetchCommits("fake_token", "repo1")
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	commits, err := testCloud.Commits(Repository{Slug: "repo1"})
 	assert.Error(t, err)
 	assert.Nil(t, commits)
 	assert.Contains(t, err.Error(), "failed to fetch commits")
@@ -144,7 +205,7 @@ func TestFetchCommitDetails(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	commitDetails, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	commitDetails, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.NoError(t, err)
 	assert.Equal(t, "commit1", commitDetails.Hash)
 	assert.Equal(t, 10, commitDetails.Summary.LinesAdded)
@@ -154,12 +215,44 @@ func TestFetchCommitDetails(t *testing.T) {
 	mockClient.AssertExpectations(t)
 }
 
-// TestFetchCommitDetails_Error tests the fetchCommitDetails function for error case.
+func TestFetchCommitDetails_FileChanges(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"hash": "commit1"}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [
+				{"type": "modified", "lines_added": 4, "lines_removed": 1, "new": {"path": "main.go"}, "old": {"path": "main.go"}},
+				{"type": "removed", "lines_removed": 9, "new": null, "old": {"path": "old.go"}}
+			]
+		}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	commitDetails, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
+	assert.NoError(t, err)
+	assert.Equal(t, []FileChange{
+		{Path: "main.go", Type: "modified", LinesAdded: 4, LinesDeleted: 1},
+		{Path: "old.go", Type: "removed", LinesDeleted: 9},
+	}, commitDetails.Files)
+}
+
+// TestFetchCommitDetails_Error tests the CommitDetails method of the Cloud provider for error case.
 func TestFetchCommitDetails_Error(t *testing.T) {
 	mockClient := new(MockHTTPClient)
 	mockClient.On("Do", mock.Anything).Return(nil, errors.New("failed to fetch commit details"))
 
-	commitDetails, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	commitDetails, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.Error(t, err)
 	assert.Empty(t, commitDetails)
 	assert.Contains(t, err.Error(), "failed to fetch commit details")
@@ -188,14 +281,14 @@ func TestFetchCommitDetails_Error(t *testing.T) {
 // 	httpClient = mockClient
 // 	defer func() { httpClient = oldHTTPClient }()
 
-// 	_, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+// 	_, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 // 	assert.Error(t, err)
 // 	assert.True(t, strings.Contains(err.Error(), "unexpected end of JSON input") || strings.Contains(err.Error(), "unexpected EOF"))
 
 // 	mockClient.AssertExpectations(t)
 // }
 
-// TestFetchCommitDetails_NonOKStatusCode tests the fetchCommitDetails function for non-OK status code.
+// TestFetchCommitDetails_NonOKStatusCode tests the CommitDetails method of the Cloud provider for non-OK status code.
 func TestFetchCommitDetails_NonOKStatusCode(t *testing.T) {
 	mockClient := new(MockHTTPClient)
 	mockClient.On("Do", mock.Anything).Return(&http.Response{
@@ -203,7 +296,11 @@ func TestFetchCommitDetails_NonOKStatusCode(t *testing.T) {
 		Body:       io.NopCloser(strings.NewReader("")),
 	}, nil)
 
-	commitDetails, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	commitDetails, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.Error(t, err)
 	assert.Empty(t, commitDetails)
 	assert.Contains(t, err.Error(), "failed to fetch commit details")
@@ -224,7 +321,7 @@ func TestFetchAndSaveCommits_Error(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	err := FetchAndSaveCommits("invalid_token")
+	_, err := FetchAndSaveCommits("invalid_token")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to fetch repositories")
 
@@ -247,6 +344,50 @@ func TestFetchAndSaveCommits(t *testing.T) {
         }`)),
 	}, nil).Once()
 
+	// Mock the responses for fetching pull requests
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/1/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"hash": "commit1", "date": "2024-07-16T08:30:00.000+00:00"}]}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.Contains(req.URL.String(), "repo1/pullrequests")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+            "values": [
+                {"id": 1, "title": "Add feature", "state": "MERGED", "author": {"display_name": "User1"},
+                    "created_on": "2024-07-16T09:00:00.000+00:00", "updated_on": "2024-07-16T12:00:00.000+00:00"}
+            ]
+        }`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.Contains(req.URL.String(), "repo2/pullrequests")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	// Mock the responses for fetching pipelines
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.Contains(req.URL.String(), "repo1/pipelines")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+            "values": [
+                {"uuid": "{p1}", "build_number": 1, "state": {"name": "COMPLETED", "result": {"name": "SUCCESSFUL"}},
+                    "target": {"ref_name": "main", "commit": {"hash": "commit2"}}, "created_on": "2024-07-17T11:40:00.000+00:00"}
+            ]
+        }`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.Contains(req.URL.String(), "repo2/pipelines")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
 	// Mock the response for fetching commits for repo1
 	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
 		return strings.Contains(req.URL.String(), "repo1/commits")
@@ -403,11 +544,469 @@ func TestFetchAndSaveCommits(t *testing.T) {
 	}
 	defer func() { db.GetCollectionFunc = oldGetCollection }()
 
-	err := FetchAndSaveCommits("fake_token")
+	// Mock the pull request and pipeline collections
+	mockPRCollection := new(MockCollection)
+	mockPRCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.MatchedBy(func(update bson.M) bool {
+		pr := update["$set"].(PullRequest)
+		return pr.FirstCommitOn.Equal(time.Date(2024, 7, 16, 8, 30, 0, 0, time.UTC))
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+	mockPipelineCollection := new(MockCollection)
+	mockPipelineCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		if name == db.PipelinesCollection {
+			return mockPipelineCollection
+		}
+		return mockPRCollection
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	_, err := FetchAndSaveCommits("fake_token")
 	assert.NoError(t, err)
 
 	mockClient.AssertExpectations(t)
 	mockCollection.AssertExpectations(t)
+	mockPRCollection.AssertExpectations(t)
+	mockPipelineCollection.AssertExpectations(t)
+}
+
+func TestFetchAndSaveCommits_ResolvesAuthors(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "/repositories/lep13")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"name": "repo1", "slug": "repo1", "project": {"name": "Project1"}}]}`)),
+	}, nil).Once()
+	for _, endpoint := range []string{"pullrequests", "pipelines"} {
+		mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+			return strings.Contains(req.URL.Path, endpoint)
+		})).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+		}, nil).Once()
+	}
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"hash": "commit1"}]}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "commit/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"hash": "commit1",
+			"date": "2024-07-16T10:28:45.000+00:00",
+			"author": {"raw": "jane <Jane@Example.com>", "user": {"display_name": "", "uuid": "", "nickname": ""}}
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "diffstat/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	expected := resolver.Resolve(identity.Author{Raw: "Jane <jane@example.com>"})
+
+	mockCollection := new(MockCollection)
+	mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.CommittedBy == "jane" &&
+			commit.PersonID == expected.PersonID &&
+			commit.AuthorEmail == "jane@example.com" &&
+			commit.AuthorRaw == "jane <Jane@Example.com>"
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return mockCollection
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	_, err := FetchAndSaveCommits("fake_token")
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+	mockCollection.AssertExpectations(t)
+}
+
+func TestFetchPullRequests(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [
+				{"id": 1, "title": "Add feature", "state": "OPEN", "author": {"display_name": "User1"},
+					"source": {"branch": {"name": "feature"}}, "destination": {"branch": {"name": "main"}},
+					"created_on": "2024-07-16T09:00:00.000+00:00", "updated_on": "2024-07-16T12:00:00.000+00:00"},
+				{"id": 2, "title": "Fix bug", "state": "MERGED", "author": {"display_name": "User2"},
+					"merge_commit": {"hash": "commit9"}, "comment_count": 3,
+					"created_on": "2024-07-17T09:00:00.000+00:00", "updated_on": "2024-07-18T12:00:00.000+00:00"}
+			]
+		}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	pullRequests, err := testCloud.PullRequests(Repository{Slug: "repo1"})
+	assert.NoError(t, err)
+	assert.Len(t, pullRequests, 2)
+	assert.Equal(t, 1, pullRequests[0].ID)
+	assert.Equal(t, "feature", pullRequests[0].Source.Branch.Name)
+	assert.Equal(t, "commit9", pullRequests[1].MergeCommit.Hash)
+	assert.Equal(t, 3, pullRequests[1].CommentCount)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestFetchPullRequests_NonOKStatusCode(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusForbidden,
+		Body:       io.NopCloser(strings.NewReader("forbidden")),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	pullRequests, err := testCloud.PullRequests(Repository{Slug: "repo1"})
+	assert.Error(t, err)
+	assert.Nil(t, pullRequests)
+	assert.Contains(t, err.Error(), "failed to fetch pull requests")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestFetchPullRequests_AllStatesAndPages(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Query().Get("page") == ""
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [{"id": 2, "title": "Fix bug", "state": "MERGED"}],
+			"next": "https://api.bitbucket.org/2.0/repositories/lep13/repo1/pullrequests?page=2"
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Query().Get("page") == "2"
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"id": 1, "title": "Add feature", "state": "OPEN"}]}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	pullRequests, err := testCloud.PullRequests(Repository{Slug: "repo1"})
+	assert.NoError(t, err)
+	assert.Len(t, pullRequests, 2)
+	assert.Equal(t, "MERGED", pullRequests[0].State)
+	assert.Equal(t, "OPEN", pullRequests[1].State)
+
+	// Bitbucket Cloud lists only open pull requests unless every state is asked for
+	first := mockClient.Calls[0].Arguments.Get(0).(*http.Request)
+	assert.Equal(t, []string{"MERGED", "DECLINED", "SUPERSEDED", "OPEN"}, first.URL.Query()["state"])
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSavePullRequests(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [
+				{"id": 7, "title": "Fix bug", "state": "MERGED", "author": {"display_name": "User2"},
+					"created_on": "2024-07-17T09:00:00.000+00:00", "updated_on": "2024-07-18T12:00:00.000+00:00"}
+			]
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/7/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	mockPRCollection := new(MockCollection)
+	mockPRCollection.On("UpdateOne", mock.Anything,
+		bson.M{"provider": ProviderBitbucket, "workspace": "lep13", "project_key": "P1", "repo_name": "Repo One", "pull_request_id": "7"},
+		mock.MatchedBy(func(update bson.M) bool {
+			pr := update["$set"].(PullRequest)
+			return pr.ProjectName == "Project1" && pr.Author == "User2" && pr.AuthorPersonID != "" && pr.ClosedOn.Equal(pr.UpdatedOn)
+		}),
+		mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	var requested string
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		requested = name
+		return mockPRCollection
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	repo := Repository{Name: "Repo One", Slug: "repo1"}
+	repo.Project.Key = "P1"
+	repo.Project.Name = "Project1"
+	savePullRequests(testCloud, mongoStore{}, repo, newRunSummary())
+
+	assert.Equal(t, db.PullRequestsCollection, requested)
+	mockClient.AssertExpectations(t)
+	mockPRCollection.AssertExpectations(t)
+}
+
+func TestSavePullRequests_PrivacyMode(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [{"id": 7, "state": "OPEN", "author": {"display_name": "User2", "uuid": "{u2}"}}]
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/7/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	p, err := privacy.New("0123456789abcdef0123456789abcdef", true)
+	assert.NoError(t, err)
+	oldPseudonymizer := pseudonymizer
+	pseudonymizer = p
+	defer func() { pseudonymizer = oldPseudonymizer }()
+
+	clear := resolver.Resolve(identity.Author{DisplayName: "User2", AccountUUID: "{u2}"})
+	mockPRCollection := new(MockCollection)
+	mockPRCollection.On("UpdateOne", mock.Anything, mock.Anything,
+		mock.MatchedBy(func(update bson.M) bool {
+			pr := update["$set"].(PullRequest)
+			return pr.Author == p.Pseudonym("User2") && pr.AuthorPersonID == p.Pseudonym(clear.PersonID)
+		}),
+		mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return mockPRCollection
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	savePullRequests(testCloud, mongoStore{}, Repository{Name: "repo1", Slug: "repo1"}, newRunSummary())
+
+	mockPRCollection.AssertExpectations(t)
+}
+
+func TestPseudonymizeCommit(t *testing.T) {
+	commit := Commit{
+		CommitID:       "commit1",
+		CommitMessage:  "Fix login for jane",
+		CommittedBy:    "Jane Doe",
+		PersonID:       "p-1",
+		AuthorRaw:      "Jane Doe <jane@example.com>",
+		AuthorEmail:    "jane@example.com",
+		AuthorUUID:     "{u1}",
+		AuthorNickname: "jdoe",
+		ReviewedBy:     "John",
+		LinesAdded:     3,
+	}
+	assert.Equal(t, commit, pseudonymizeCommit(commit))
+
+	p, err := privacy.New("0123456789abcdef0123456789abcdef", true)
+	assert.NoError(t, err)
+	oldPseudonymizer := pseudonymizer
+	pseudonymizer = p
+	defer func() { pseudonymizer = oldPseudonymizer }()
+
+	assert.Equal(t, Commit{
+		CommitID:       "commit1",
+		CommitMessage:  privacy.RedactedMessage,
+		CommittedBy:    p.Pseudonym("Jane Doe"),
+		PersonID:       p.Pseudonym("p-1"),
+		AuthorRaw:      p.Pseudonym("Jane Doe <jane@example.com>"),
+		AuthorEmail:    p.Pseudonym("jane@example.com"),
+		AuthorUUID:     p.Pseudonym("{u1}"),
+		AuthorNickname: p.Pseudonym("jdoe"),
+		ReviewedBy:     p.Pseudonym("John"),
+		LinesAdded:     3,
+	}, pseudonymizeCommit(commit))
+}
+
+func TestFetchPipelines(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [
+				{"uuid": "{p1}", "build_number": 12, "state": {"name": "COMPLETED", "result": {"name": "FAILED"}},
+					"target": {"ref_name": "main", "commit": {"hash": "commit1"}},
+					"created_on": "2024-07-16T10:30:00.000+00:00", "completed_on": "2024-07-16T10:35:00.000+00:00",
+					"duration_in_seconds": 300},
+				{"uuid": "{p2}", "build_number": 13, "state": {"name": "IN_PROGRESS"},
+					"target": {"ref_name": "main", "commit": {"hash": "commit2"}},
+					"created_on": "2024-07-16T11:00:00.000+00:00", "completed_on": null}
+			]
+		}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	pipelines, err := testCloud.Pipelines(Repository{Slug: "repo1"})
+	assert.NoError(t, err)
+	assert.Len(t, pipelines, 2)
+	assert.Equal(t, "FAILED", pipelines[0].State.Result.Name)
+	assert.Equal(t, "commit1", pipelines[0].Target.Commit.Hash)
+	assert.Equal(t, 300, pipelines[0].DurationInSeconds)
+	assert.True(t, pipelines[1].CompletedOn.IsZero())
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestFetchPipelines_NonOKStatusCode(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       io.NopCloser(strings.NewReader("pipelines not enabled")),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	pipelines, err := testCloud.Pipelines(Repository{Slug: "repo1"})
+	assert.Error(t, err)
+	assert.Nil(t, pipelines)
+	assert.Contains(t, err.Error(), "failed to fetch pipelines")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSavePipelines(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [
+				{"uuid": "{p1}", "build_number": 12, "state": {"name": "COMPLETED", "result": {"name": "SUCCESSFUL"}},
+					"target": {"ref_name": "main", "commit": {"hash": "commit1"}}, "created_on": "2024-07-16T10:30:00.000+00:00"}
+			]
+		}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	mockPipelineCollection := new(MockCollection)
+	mockPipelineCollection.On("UpdateOne", mock.Anything,
+		bson.M{"provider": ProviderBitbucket, "workspace": "lep13", "project_key": "P1", "repo_name": "repo1", "pipeline_id": "{p1}"},
+		mock.MatchedBy(func(update bson.M) bool {
+			p := update["$set"].(Pipeline)
+			return p.Result == "SUCCESSFUL" && p.CommitHash == "commit1" && p.ProjectName == "Project1"
+		}),
+		mock.Anything).Return(&mongo.UpdateResult{}, errors.New("write failed")).Once()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return mockPipelineCollection
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	before := testutil.ToFloat64(metrics.UpsertFailures.WithLabelValues(db.PipelinesCollection))
+
+	repo := Repository{Name: "repo1", Slug: "repo1"}
+	repo.Project.Key = "P1"
+	repo.Project.Name = "Project1"
+	savePipelines(testCloud, mongoStore{}, repo, newRunSummary())
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.UpsertFailures.WithLabelValues(db.PipelinesCollection)))
+	mockClient.AssertExpectations(t)
+	mockPipelineCollection.AssertExpectations(t)
+}
+
+func TestDoRequest_RetriesThrottledResponses(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"0"}},
+		Body:       io.NopCloser(strings.NewReader("slow down")),
+	}, nil).Once()
+	mockClient.On("Do", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       io.NopCloser(strings.NewReader("unavailable")),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer fake_token"
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("{}")),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	oldRetryDelay := retryDelay
+	retryDelay = 0
+	defer func() { retryDelay = oldRetryDelay }()
+
+	before := testutil.ToFloat64(metrics.HTTPRetries.WithLabelValues("test"))
+
+	resp, err := doRequest("test", "https://api.bitbucket.org/2.0/repositories/lep13", testCloud.client)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.HTTPRetries.WithLabelValues("test")))
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestDoRequest_GivesUpAfterMaxRetries(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.Anything).Return(func() *http.Response {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(strings.NewReader("bad gateway"))}
+	}(), nil).Times(maxRetries + 1)
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	oldRetryDelay := retryDelay
+	retryDelay = 0
+	defer func() { retryDelay = oldRetryDelay }()
+
+	resp, err := doRequest("test", "https://api.bitbucket.org/2.0/repositories/lep13", testCloud.client)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	mockClient.AssertExpectations(t)
 }
 
 func TestFetchCommitDetails_FailedToFetchDiffstat(t *testing.T) {
@@ -436,7 +1035,7 @@ func TestFetchCommitDetails_FailedToFetchDiffstat(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	_, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	_, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to fetch diffstat")
 
@@ -482,7 +1081,7 @@ func TestFetchRepositories_HTTPError(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	repos, err := fetchRepositories("fake_token")
+	repos, err := testCloud.Repositories()
 	assert.Error(t, err)
 	assert.Nil(t, repos)
 	assert.Contains(t, err.Error(), "HTTP error")
@@ -500,7 +1099,7 @@ func TestFetchCommits_HTTPError(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	commits, err := fetchCommits("fake_token", "repo1")
+	commits, err := testCloud.Commits(Repository{Slug: "repo1"})
 	assert.Error(t, err)
 	assert.Nil(t, commits)
 	assert.Contains(t, err.Error(), "HTTP error")
@@ -518,7 +1117,7 @@ func TestFetchCommitDetails_HTTPError(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	commitDetails, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	commitDetails, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.Error(t, err)
 	assert.Empty(t, commitDetails)
 	assert.Contains(t, err.Error(), "HTTP error")
@@ -536,7 +1135,7 @@ func TestFetchAndSaveCommits_RepoFetchError(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	err := FetchAndSaveCommits("fake_token")
+	_, err := FetchAndSaveCommits("fake_token")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to fetch repositories")
 
@@ -557,7 +1156,7 @@ func TestFetchCommitDetails_InvalidJSON(t *testing.T) {
 	httpClient = mockClient
 	defer func() { httpClient = oldHTTPClient }()
 
-	_, err := fetchCommitDetails("fake_token", "repo1", "commit1")
+	_, err := testCloud.CommitDetails(Repository{Slug: "repo1"}, "commit1")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unexpected") // Check for a part of the error message
 
@@ -592,11 +1191,291 @@ func TestFetchCommitDetails_InvalidJSON(t *testing.T) {
 // 	defer func() { httpClient = oldHTTPClient }()
 
 // 	// Call the function to test
-// 	err := FetchAndSaveCommits("fake_token")
+// 	_, err := FetchAndSaveCommits("fake_token")
 
 // 	// Check for the presence of an error
 // 	assert.Error(t, err, "Expected an error due to failed commit fetch, but got nil")
 
 // 	// Assert that the expectations were met
 // 	mockClient.AssertExpectations(t)
-// }
\ No newline at end of file
+// }
+func TestReviewEvents(t *testing.T) {
+	var activity struct {
+		Values []PullRequestActivity `json:"values"`
+	}
+	err := json.Unmarshal([]byte(`{"values": [
+		{"update": {"state": "MERGED", "date": "2024-07-18T11:00:00+00:00", "author": {"display_name": "User2", "uuid": "{u2}"}}},
+		{"approval": {"date": "2024-07-18T10:00:00+00:00", "user": {"display_name": "User3", "uuid": "{u3}"}}},
+		{"update": {"state": "OPEN", "date": "2024-07-18T09:00:00+00:00", "author": {"display_name": "User2", "uuid": "{u2}"}}},
+		{"comment": {"created_on": "2024-07-17T15:00:00+00:00", "user": {"display_name": "User2", "uuid": "{u2}"}}},
+		{"changes_requested": {"date": "2024-07-17T13:00:00+00:00", "user": {"display_name": "User3", "uuid": "{u3}"}}},
+		{"comment": {"created_on": "2024-07-17T12:00:00+00:00", "user": {"display_name": "User3", "uuid": "{u3}"}, "inline": {"path": "main.go"}}},
+		{"update": {"state": "OPEN", "date": "2024-07-17T09:00:00+00:00", "author": {"display_name": "User2", "uuid": "{u2}"}}}
+	]}`), &activity)
+	assert.NoError(t, err)
+
+	at := func(day, hour int) time.Time { return time.Date(2024, 7, day, hour, 0, 0, 0, time.UTC) }
+	user2 := resolver.Resolve(identity.Author{DisplayName: "User2", AccountUUID: "{u2}"})
+	user3 := resolver.Resolve(identity.Author{DisplayName: "User3", AccountUUID: "{u3}"})
+
+	events := reviewEvents(activity.Values, "{u2}", "User2")
+	for i := range events {
+		events[i].At = events[i].At.UTC()
+	}
+	assert.Equal(t, []ReviewEvent{
+		{Type: EventUpdate, Actor: user2.Name, ActorPersonID: user2.PersonID, ByAuthor: true, At: at(17, 9)},
+		{Type: EventInlineComment, Actor: user3.Name, ActorPersonID: user3.PersonID, Path: "main.go", At: at(17, 12)},
+		{Type: EventChangesRequested, Actor: user3.Name, ActorPersonID: user3.PersonID, At: at(17, 13)},
+		{Type: EventComment, Actor: user2.Name, ActorPersonID: user2.PersonID, ByAuthor: true, At: at(17, 15)},
+		{Type: EventUpdate, Actor: user2.Name, ActorPersonID: user2.PersonID, ByAuthor: true, At: at(18, 9)},
+		{Type: EventApproval, Actor: user3.Name, ActorPersonID: user3.PersonID, At: at(18, 10)},
+	}, events)
+
+	pr := PullRequest{Events: events}
+	summarizeReview(&pr)
+	assert.Equal(t, at(17, 12), pr.FirstReviewOn)
+	assert.Equal(t, at(18, 10), pr.ApprovedOn)
+	assert.Equal(t, 2, pr.ReviewRounds)
+	assert.Equal(t, 1, pr.UpdatesAfterReview)
+
+	// Without account IDs, authors are recognized by name
+	events = reviewEvents(activity.Values[5:], "", "User3")
+	assert.True(t, events[1].ByAuthor)
+	pr = PullRequest{Events: events}
+	summarizeReview(&pr)
+	assert.True(t, pr.FirstReviewOn.IsZero())
+	assert.Equal(t, 0, pr.ReviewRounds)
+}
+
+func TestSavePullRequests_Activity(t *testing.T) {
+	oldTemplate := cfg.PullRequestActivityURLTemplate
+	cfg.PullRequestActivityURLTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pullrequests/{pull_request_id}/activity"
+	defer func() { cfg.PullRequestActivityURLTemplate = oldTemplate }()
+
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"values": [{"id": 7, "state": "MERGED", "author": {"display_name": "User2"},
+				"created_on": "2024-07-17T09:00:00.000+00:00", "updated_on": "2024-07-18T12:00:00.000+00:00"}]
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/7/activity") && req.URL.Query().Get("page") == ""
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{"values": [
+			{"approval": {"date": "2024-07-18T10:00:00+00:00", "user": {"display_name": "User3"}}}
+		], "next": "https://api.bitbucket.org/2.0/repositories/lep13/repo1/pullrequests/7/activity?page=2"}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/7/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/pullrequests/7/activity") && req.URL.Query().Get("page") == "2"
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{"values": [
+			{"comment": {"created_on": "2024-07-17T15:00:00+00:00", "user": {"display_name": "User3"}}}
+		]}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	mockPRCollection := new(MockCollection)
+	mockPRCollection.On("UpdateOne", mock.Anything, mock.Anything,
+		mock.MatchedBy(func(update bson.M) bool {
+			pr := update["$set"].(PullRequest)
+			return len(pr.Events) == 2 && pr.Events[0].Type == EventComment && pr.ReviewRounds == 1 &&
+				pr.FirstReviewOn.Equal(time.Date(2024, 7, 17, 15, 0, 0, 0, time.UTC)) &&
+				pr.ApprovedOn.Equal(time.Date(2024, 7, 18, 10, 0, 0, 0, time.UTC))
+		}),
+		mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return mockPRCollection
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	savePullRequests(testCloud, mongoStore{}, Repository{Name: "repo1", Slug: "repo1"}, newRunSummary())
+
+	mockClient.AssertExpectations(t)
+	mockPRCollection.AssertExpectations(t)
+}
+
+// stubTracker knows a fixed set of issues.
+type stubTracker map[string]issues.Issue
+
+func (s stubTracker) Issues(ctx context.Context, keys []string) (map[string]issues.Issue, error) {
+	found := map[string]issues.Issue{}
+	for _, key := range keys {
+		if issue, ok := s[key]; ok {
+			found[key] = issue
+		}
+	}
+	return found, nil
+}
+
+func TestFetchAndSaveCommits_ParsesAndEnrichesMessage(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "/repositories/lep13")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"name": "repo1", "slug": "repo1", "project": {"name": "Project1"}}]}`)),
+	}, nil).Once()
+	for _, endpoint := range []string{"pullrequests", "pipelines"} {
+		mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+			return strings.Contains(req.URL.Path, endpoint)
+		})).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+		}, nil).Once()
+	}
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"hash": "commit1"}]}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "commit/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"hash": "commit1",
+			"message": "feat(api)!: add export\n\nRefs OPS-12",
+			"date": "2024-07-16T10:28:45.000+00:00",
+			"parents": [{"hash": "parent1"}]
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "diffstat/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	bug := issues.Issue{Key: "OPS-12", Type: "bug", Status: "Done"}
+	oldIssueTracker := issueTracker
+	issueTracker = stubTracker{"OPS-12": bug}
+	defer func() { issueTracker = oldIssueTracker }()
+
+	mockCollection := new(MockCollection)
+	mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.Conventional &&
+			commit.CommitType == "feat" &&
+			commit.CommitScope == "api" &&
+			commit.Breaking &&
+			assert.ObjectsAreEqual([]string{"OPS-12"}, commit.IssueKeys) &&
+			assert.ObjectsAreEqual([]issues.Issue{bug}, commit.Issues) &&
+			assert.ObjectsAreEqual([]string{"parent1"}, commit.ParentHashes) &&
+			commit.Classification == classify.Regular
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return mockCollection
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	_, err := FetchAndSaveCommits("fake_token")
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+	mockCollection.AssertExpectations(t)
+}
+
+func TestFetchAndSaveCommits_ClassifiesBots(t *testing.T) {
+	mockClient := new(MockHTTPClient)
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "/repositories/lep13")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"name": "repo1", "slug": "repo1", "project": {"name": "Project1"}}]}`)),
+	}, nil).Once()
+	for _, endpoint := range []string{"pullrequests", "pipelines"} {
+		mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+			return strings.Contains(req.URL.Path, endpoint)
+		})).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+		}, nil).Once()
+	}
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "repo1/commits")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": [{"hash": "commit1"}]}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "commit/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{
+			"hash": "commit1",
+			"message": "Merge branch main",
+			"date": "2024-07-16T10:28:45.000+00:00",
+			"author": {"raw": "release-bot <ci@example.com>"},
+			"parents": [{"hash": "parent1"}, {"hash": "parent2"}]
+		}`)),
+	}, nil).Once()
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return strings.HasSuffix(req.URL.Path, "diffstat/commit1")
+	})).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"values": []}`)),
+	}, nil).Once()
+
+	oldHTTPClient := httpClient
+	httpClient = mockClient
+	defer func() { httpClient = oldHTTPClient }()
+
+	oldClassifier := classifier
+	classifier, _ = classify.NewClassifier([]string{`^release-bot\b`})
+	defer func() { classifier = oldClassifier }()
+
+	mockCollection := new(MockCollection)
+	mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.Classification == classify.Bot &&
+			assert.ObjectsAreEqual([]string{"parent1", "parent2"}, commit.ParentHashes)
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return mockCollection
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	_, err := FetchAndSaveCommits("fake_token")
+	assert.NoError(t, err)
+
+	mockClient.AssertExpectations(t)
+	mockCollection.AssertExpectations(t)
+}
@@ -0,0 +1,93 @@
+package bitbucket
+
+import (
+	"fmt"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"github.com/lep13/bitbucket_metrics/internal/conventions"
+	"github.com/lep13/bitbucket_metrics/internal/identity"
+	"github.com/lep13/bitbucket_metrics/internal/issues"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+)
+
+// Configure makes c the configuration of the following syncs once its URL templates, identity rules, bot patterns,
+// issue tracker, privacy settings and sources are valid, leaving the configuration in use unchanged otherwise. It must not be
+// called during a sync; between syncs it swaps in rotated credentials.
+func Configure(c *config.Config) error {
+	prepared, err := Prepare(c)
+	if err != nil {
+		return err
+	}
+	prepared.Apply()
+	return nil
+}
+
+// Prepared is a configuration checked by Prepare, ready to be applied.
+type Prepared struct {
+	cfg           *config.Config
+	resolver      *identity.Resolver
+	messageParser *conventions.Parser
+	classifier    *classify.Classifier
+	issueTracker  issues.Tracker
+	pseudonymizer *privacy.Pseudonymizer
+	aliasHasher   *privacy.Pseudonymizer
+}
+
+// Prepare checks c as Configure does and builds what the syncs need from it, without applying it, so callers can
+// check a configuration before changing anything else.
+func Prepare(c *config.Config) (*Prepared, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	newResolver, err := identity.NewResolverFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity rules: %w", err)
+	}
+
+	newClassifier, err := classify.NewClassifier(c.BotAuthorPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot author patterns: %w", err)
+	}
+
+	newIssueTracker, err := issues.NewFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure issue tracker: %w", err)
+	}
+
+	newPseudonymizer, err := privacy.NewFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure privacy mode: %w", err)
+	}
+
+	newAliasHasher, err := privacy.NewHasherFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure pseudonym key: %w", err)
+	}
+
+	if _, err := configuredProviders(c, c.BitbucketAccessToken); err != nil {
+		return nil, err
+	}
+
+	return &Prepared{
+		cfg:           c,
+		resolver:      newResolver,
+		messageParser: conventions.NewParser(c.IssueProjects),
+		classifier:    newClassifier,
+		issueTracker:  newIssueTracker,
+		pseudonymizer: newPseudonymizer,
+		aliasHasher:   newAliasHasher,
+	}, nil
+}
+
+// Apply makes p the configuration of the following syncs. Like Configure, it must not be called during a sync.
+func (p *Prepared) Apply() {
+	cfg = p.cfg
+	resolver = p.resolver
+	messageParser = p.messageParser
+	classifier = p.classifier
+	issueTracker = p.issueTracker
+	pseudonymizer = p.pseudonymizer
+	aliasHasher = p.aliasHasher
+}
@@ -0,0 +1,79 @@
+package bitbucket
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"github.com/lep13/bitbucket_metrics/internal/maintenance"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig points the Cloud provider at the Bitbucket Cloud API.
+var testConfig = &config.Config{
+	RepoURLTemplate:         "https://api.bitbucket.org/2.0/repositories/{username}",
+	CommitsURLTemplate:      "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commits",
+	CommitURLTemplate:       "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}",
+	DiffstatURLTemplate:     "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/diffstat/{commit_hash}",
+	PullRequestsURLTemplate: "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pullrequests",
+	PipelinesURLTemplate:    "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/pipelines/",
+}
+
+func TestMain(m *testing.M) {
+	if err := Configure(testConfig); err != nil {
+		panic(err)
+	}
+	// Runs are recorded by the tests that look at them
+	recordRun = func(*RunSummary) error { return nil }
+	// Erasures are loaded by the tests that look at them
+	loadSuppressions = func(context.Context, *privacy.Pseudonymizer) (*maintenance.Suppressions, error) { return nil, nil }
+	os.Exit(m.Run())
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(testConfig)
+
+	rotated := *testConfig
+	rotated.BitbucketAccessToken = "rotated_token"
+	rotated.BotAuthorPatterns = []string{"^ci-bot$"}
+	assert.NoError(t, Configure(&rotated))
+	assert.Same(t, &rotated, cfg)
+	assert.Equal(t, classify.Bot, classifier.Classify(classify.Commit{Authors: []string{"ci-bot"}}))
+
+	invalid := rotated
+	invalid.BotAuthorPatterns = []string{"("}
+	assert.ErrorContains(t, Configure(&invalid), "failed to load bot author patterns")
+
+	invalid = rotated
+	invalid.CommitURLTemplate = "https://api.bitbucket.org/2.0/repositories/%s/%s/commit/%s"
+	assert.ErrorContains(t, Configure(&invalid), "invalid commit_url_template: missing placeholder {username}")
+
+	invalid = rotated
+	invalid.Sources = []config.Source{{Type: "gitea"}}
+	assert.EqualError(t, Configure(&invalid), `invalid source 0: unknown source type "gitea"`)
+
+	// A rejected configuration leaves the one in use in place
+	assert.Same(t, &rotated, cfg)
+	assert.Equal(t, classify.Bot, classifier.Classify(classify.Commit{Authors: []string{"ci-bot"}}))
+}
+
+func TestPrepare(t *testing.T) {
+	defer Configure(testConfig)
+
+	rotated := *testConfig
+	rotated.BitbucketAccessToken = "rotated_token"
+	prepared, err := Prepare(&rotated)
+	assert.NoError(t, err)
+	assert.Same(t, testConfig, cfg, "a prepared configuration is only used once applied")
+
+	prepared.Apply()
+	assert.Same(t, &rotated, cfg)
+
+	invalid := rotated
+	invalid.Sources = []config.Source{{Type: "gitea"}}
+	_, err = Prepare(&invalid)
+	assert.EqualError(t, err, `invalid source 0: unknown source type "gitea"`)
+}
@@ -0,0 +1,366 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// dataCenterPageSize is how many values are requested per page from Data Center.
+const dataCenterPageSize = 100
+
+// dataCenterProvider reads the projects of a Bitbucket Server or Data Center instance through its REST API 1.0.
+type dataCenterProvider struct {
+	workspace string
+	baseURL   string
+	client    HTTPClient
+	projects  []string
+}
+
+func newDataCenterProvider(workspace, baseURL string, authenticator auth.Authenticator, projects []string) *dataCenterProvider {
+	return &dataCenterProvider{workspace: workspace, baseURL: baseURL, client: authenticatedClient(authenticator), projects: projects}
+}
+
+// dcUser is a Data Center user.
+type dcUser struct {
+	Name         string `json:"name"`
+	EmailAddress string `json:"emailAddress"`
+	DisplayName  string `json:"displayName"`
+}
+
+func (u dcUser) account() Account {
+	return Account{DisplayName: u.DisplayName, Nickname: u.Name}
+}
+
+type dcRepository struct {
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Project struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type dcCommit struct {
+	ID              string `json:"id"`
+	Message         string `json:"message"`
+	Author          dcUser `json:"author"`
+	AuthorTimestamp int64  `json:"authorTimestamp"`
+	Parents         []struct {
+		ID string `json:"id"`
+	} `json:"parents"`
+}
+
+type dcPullRequest struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Author struct {
+		User dcUser `json:"user"`
+	} `json:"author"`
+	FromRef struct {
+		DisplayID string `json:"displayId"`
+	} `json:"fromRef"`
+	ToRef struct {
+		DisplayID string `json:"displayId"`
+	} `json:"toRef"`
+	CreatedDate int64 `json:"createdDate"`
+	UpdatedDate int64 `json:"updatedDate"`
+	ClosedDate  int64 `json:"closedDate"`
+	Properties  struct {
+		CommentCount int `json:"commentCount"`
+		MergeCommit  struct {
+			ID string `json:"id"`
+		} `json:"mergeCommit"`
+	} `json:"properties"`
+}
+
+type dcActivity struct {
+	Action      string `json:"action"`
+	CreatedDate int64  `json:"createdDate"`
+	User        dcUser `json:"user"`
+	Comment     *struct {
+		CreatedDate int64 `json:"createdDate"`
+	} `json:"comment"`
+	CommentAnchor *struct {
+		Path string `json:"path"`
+	} `json:"commentAnchor"`
+}
+
+type dcDiff struct {
+	Diffs []struct {
+		Source *struct {
+			ToString string `json:"toString"`
+		} `json:"source"`
+		Destination *struct {
+			ToString string `json:"toString"`
+		} `json:"destination"`
+		Hunks []struct {
+			Segments []struct {
+				Type  string            `json:"type"`
+				Lines []json.RawMessage `json:"lines"`
+			} `json:"segments"`
+		} `json:"hunks"`
+	} `json:"diffs"`
+}
+
+// Name returns ProviderBitbucketDataCenter.
+func (d *dataCenterProvider) Name() string {
+	return ProviderBitbucketDataCenter
+}
+
+// Workspace returns the name the data of the instance is stored under.
+func (d *dataCenterProvider) Workspace() string {
+	return d.workspace
+}
+
+// Repositories fetches the repositories of the configured projects, or every repository the token can read.
+func (d *dataCenterProvider) Repositories() ([]Repository, error) {
+	var values []dcRepository
+	if len(d.projects) == 0 {
+		all, err := fetchDataCenterPages[dcRepository](d, "dc_repositories", "/rest/api/1.0/repos", nil)
+		if err != nil {
+			return nil, err
+		}
+		values = all
+	}
+	for _, project := range d.projects {
+		projectRepos, err := fetchDataCenterPages[dcRepository](d, "dc_repositories", "/rest/api/1.0/projects/"+url.PathEscape(project)+"/repos", nil)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, projectRepos...)
+	}
+
+	repos := make([]Repository, len(values))
+	for i, value := range values {
+		repos[i].Name = value.Name
+		repos[i].Slug = value.Slug
+		repos[i].Project.Key = value.Project.Key
+		repos[i].Project.Name = value.Project.Name
+	}
+	slog.Debug("Fetched repositories", logging.KeyWorkspace, d.Workspace(), "base_url", d.baseURL, "count", len(repos))
+	return repos, nil
+}
+
+// Commits fetches the commits of repo.
+func (d *dataCenterProvider) Commits(repo Repository) ([]CommitDetails, error) {
+	values, err := fetchDataCenterPages[dcCommit](d, "dc_commits", d.repoPath(repo)+"/commits", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	slog.Debug("Fetched commits", logging.KeyWorkspace, d.Workspace(), logging.KeyRepo, repo.Slug, "count", len(commits))
+	return commits, nil
+}
+
+// CommitDetails fetches a commit of repo and counts its changed files and lines from its diff.
+func (d *dataCenterProvider) CommitDetails(repo Repository, commitHash string) (CommitDetails, error) {
+	var commit dcCommit
+	if err := d.get("dc_commit", d.repoPath(repo)+"/commits/"+url.PathEscape(commitHash), nil, &commit); err != nil {
+		return CommitDetails{}, err
+	}
+
+	var diff dcDiff
+	query := url.Values{"contextLines": {"0"}}
+	if err := d.get("dc_diff", d.repoPath(repo)+"/commits/"+url.PathEscape(commitHash)+"/diff", query, &diff); err != nil {
+		return CommitDetails{}, err
+	}
+
+	details := commit.details()
+	details.Files = make([]FileChange, len(diff.Diffs))
+	for i, file := range diff.Diffs {
+		change := FileChange{Type: "modified"}
+		switch {
+		case file.Source == nil && file.Destination != nil:
+			change.Type = "added"
+			change.Path = file.Destination.ToString
+		case file.Destination == nil && file.Source != nil:
+			change.Type = "removed"
+			change.Path = file.Source.ToString
+		case file.Source != nil && file.Destination != nil:
+			if file.Source.ToString != file.Destination.ToString {
+				change.Type = "renamed"
+			}
+			change.Path = file.Destination.ToString
+		}
+		for _, hunk := range file.Hunks {
+			for _, segment := range hunk.Segments {
+				switch segment.Type {
+				case "ADDED":
+					change.LinesAdded += len(segment.Lines)
+				case "REMOVED":
+					change.LinesDeleted += len(segment.Lines)
+				}
+			}
+		}
+		details.Summary.LinesAdded += change.LinesAdded
+		details.Summary.LinesDeleted += change.LinesDeleted
+		details.Files[i] = change
+	}
+
+	slog.Debug("Fetched commit details and diff", logging.KeyWorkspace, d.Workspace(), logging.KeyRepo, repo.Slug, logging.KeyCommit, commitHash)
+	return details, nil
+}
+
+// PullRequests fetches the pull requests of repo in any state.
+func (d *dataCenterProvider) PullRequests(repo Repository) ([]PullRequestDetails, error) {
+	query := url.Values{"state": {"ALL"}}
+	values, err := fetchDataCenterPages[dcPullRequest](d, "dc_pullrequests", d.repoPath(repo)+"/pull-requests", query)
+	if err != nil {
+		return nil, err
+	}
+
+	pullRequests := make([]PullRequestDetails, len(values))
+	for i, value := range values {
+		pr := &pullRequests[i]
+		pr.ID = value.ID
+		pr.Title = value.Title
+		pr.State = value.State
+		pr.Author.DisplayName = value.Author.User.DisplayName
+		pr.Author.Nickname = value.Author.User.Name
+		pr.Source.Branch.Name = value.FromRef.DisplayID
+		pr.Destination.Branch.Name = value.ToRef.DisplayID
+		pr.MergeCommit.Hash = value.Properties.MergeCommit.ID
+		pr.CommentCount = value.Properties.CommentCount
+		pr.CreatedOn = dcTime(value.CreatedDate)
+		pr.UpdatedOn = dcTime(value.UpdatedDate)
+		pr.ClosedOn = dcTime(value.ClosedDate)
+	}
+	slog.Debug("Fetched pull requests", logging.KeyWorkspace, d.Workspace(), logging.KeyRepo, repo.Slug, "count", len(pullRequests))
+	return pullRequests, nil
+}
+
+// PullRequestActivity fetches the activity of a pull request. Rescoping a pull request with new commits is
+// reported as an update and marking it as needing work as a change request.
+func (d *dataCenterProvider) PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error) {
+	values, err := fetchDataCenterPages[dcActivity](d, "dc_pullrequest_activity", d.repoPath(repo)+"/pull-requests/"+url.PathEscape(pullRequestID)+"/activities", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := []PullRequestActivity{}
+	for _, value := range values {
+		at := dcTime(value.CreatedDate)
+		user := value.User.account()
+		switch value.Action {
+		case "RESCOPED":
+			activity = append(activity, PullRequestActivity{Update: &ActivityUpdate{State: "OPEN", Date: at, Author: user}})
+		case "APPROVED":
+			activity = append(activity, PullRequestActivity{Approval: &ActivityReview{Date: at, User: user}})
+		case "REVIEWED":
+			activity = append(activity, PullRequestActivity{ChangesRequested: &ActivityReview{Date: at, User: user}})
+		case "COMMENTED":
+			comment := &ActivityComment{CreatedOn: at, User: user}
+			if value.Comment != nil && value.Comment.CreatedDate != 0 {
+				comment.CreatedOn = dcTime(value.Comment.CreatedDate)
+			}
+			if value.CommentAnchor != nil && value.CommentAnchor.Path != "" {
+				comment.Inline = &CommentInline{Path: value.CommentAnchor.Path}
+			}
+			activity = append(activity, PullRequestActivity{Comment: comment})
+		}
+	}
+	return activity, nil
+}
+
+// PullRequestCommits fetches the commits of a pull request.
+func (d *dataCenterProvider) PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error) {
+	values, err := fetchDataCenterPages[dcCommit](d, "dc_pullrequest_commits", d.repoPath(repo)+"/pull-requests/"+url.PathEscape(pullRequestID)+"/commits", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	return commits, nil
+}
+
+// Pipelines returns nothing, as Data Center has no built-in pipelines.
+func (d *dataCenterProvider) Pipelines(repo Repository) ([]PipelineDetails, error) {
+	return nil, nil
+}
+
+func (d *dataCenterProvider) repoPath(repo Repository) string {
+	return "/rest/api/1.0/projects/" + url.PathEscape(repo.Project.Key) + "/repos/" + url.PathEscape(repo.Slug)
+}
+
+// get fetches path from the instance and decodes the JSON response into v.
+func (d *dataCenterProvider) get(endpoint, path string, query url.Values, v interface{}) error {
+	requestURL := d.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	_, err := getJSON(endpoint, requestURL, d.client, v)
+	return err
+}
+
+// fetchDataCenterPages fetches every page of a paged Data Center resource.
+func fetchDataCenterPages[T any](d *dataCenterProvider, endpoint, path string, query url.Values) ([]T, error) {
+	params := url.Values{}
+	for key, values := range query {
+		params[key] = values
+	}
+	params.Set("limit", strconv.Itoa(dataCenterPageSize))
+
+	var all []T
+	for start := 0; ; {
+		params.Set("start", strconv.Itoa(start))
+		var page struct {
+			Values        []T  `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+		if err := d.get(endpoint, path, params, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Values...)
+		// A page that does not advance would otherwise be requested forever
+		if page.IsLastPage || page.NextPageStart <= start {
+			return all, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (c dcCommit) details() CommitDetails {
+	var details CommitDetails
+	details.Hash = c.ID
+	details.Message = c.Message
+	details.Date = dcTime(c.AuthorTimestamp)
+	// Authors linked to a user report their username as name, so the display name is preferred
+	name := c.Author.DisplayName
+	if name == "" {
+		name = c.Author.Name
+	}
+	details.Author.Raw = name
+	if c.Author.EmailAddress != "" {
+		details.Author.Raw = fmt.Sprintf("%s <%s>", name, c.Author.EmailAddress)
+	}
+	details.Author.User.DisplayName = c.Author.DisplayName
+	details.Author.User.Nickname = c.Author.Name
+	for _, parent := range c.Parents {
+		details.Parents = append(details.Parents, CommitParent{Hash: parent.ID})
+	}
+	return details
+}
+
+// dcTime converts a Data Center timestamp in milliseconds since the epoch. Zero stays the zero time.
+func dcTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis).UTC()
+}
@@ -0,0 +1,329 @@
+package bitbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const repoPath = "/rest/api/1.0/projects/PRJ/repos/repo1"
+
+// newFakeDataCenter serves a Data Center instance with project PRJ holding repo1 and repo2, listed over two pages.
+// repo1 has one commit and one merged pull request.
+func newFakeDataCenter(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rest/api/1.0/projects/PRJ/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("start") == "1" {
+			w.Write([]byte(`{"values": [{"slug": "repo2", "name": "Repo 2", "project": {"key": "PRJ", "name": "Project"}}], "isLastPage": true}`))
+			return
+		}
+		w.Write([]byte(`{"values": [{"slug": "repo1", "name": "Repo 1", "project": {"key": "PRJ", "name": "Project"}}], "isLastPage": false, "nextPageStart": 1}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"values": [{"id": "commit1"}], "isLastPage": true}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/commits/commit1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "commit1",
+			"message": "fix: handle empty pages",
+			"author": {"name": "jdoe", "emailAddress": "jdoe@example.com", "displayName": "Jane Doe"},
+			"authorTimestamp": 1721125725000,
+			"parents": [{"id": "parent1"}]
+		}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/commits/commit1/diff", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "0", r.URL.Query().Get("contextLines"))
+		w.Write([]byte(`{"diffs": [
+			{"source": {"toString": "main.go"}, "destination": {"toString": "main.go"}, "hunks": [{"segments": [
+				{"type": "REMOVED", "lines": [{}, {}]},
+				{"type": "ADDED", "lines": [{}, {}, {}]}
+			]}]},
+			{"source": null, "destination": {"toString": "docs/README.md"}, "hunks": [{"segments": [{"type": "ADDED", "lines": [{}]}]}]},
+			{"source": {"toString": "old.go"}, "destination": null, "hunks": [{"segments": [{"type": "REMOVED", "lines": [{}, {}, {}, {}]}]}]},
+			{"source": {"toString": "a.go"}, "destination": {"toString": "b.go"}}
+		]}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/pull-requests", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "ALL", r.URL.Query().Get("state"))
+		w.Write([]byte(`{"values": [{
+			"id": 7,
+			"title": "Handle empty pages",
+			"state": "MERGED",
+			"author": {"user": {"name": "jdoe", "displayName": "Jane Doe"}},
+			"fromRef": {"displayId": "feature"},
+			"toRef": {"displayId": "main"},
+			"createdDate": 1721120400000,
+			"updatedDate": 1721210400000,
+			"closedDate": 1721206800000,
+			"properties": {"commentCount": 2, "mergeCommit": {"id": "merge1"}}
+		}], "isLastPage": true}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/pull-requests/7/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"values": [
+			{"id": "commit1", "author": {"name": "jdoe", "displayName": "Jane Doe"}, "authorTimestamp": 1721116800000}
+		], "isLastPage": true}`))
+	})
+	mux.HandleFunc("GET "+repoPath+"/pull-requests/7/activities", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"values": [
+			{"action": "MERGED", "createdDate": 1721206800000, "user": {"name": "jdoe", "displayName": "Jane Doe"}},
+			{"action": "APPROVED", "createdDate": 1721203200000, "user": {"name": "rroe", "displayName": "Rick Roe"}},
+			{"action": "RESCOPED", "createdDate": 1721199600000, "user": {"name": "jdoe", "displayName": "Jane Doe"}},
+			{"action": "COMMENTED", "createdDate": 1721124000000, "user": {"name": "rroe", "displayName": "Rick Roe"},
+				"comment": {"createdDate": 1721124000000}, "commentAnchor": {"path": "main.go"}},
+			{"action": "REVIEWED", "createdDate": 1721127600000, "user": {"name": "rroe", "displayName": "Rick Roe"}}
+		], "isLastPage": true}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer dc_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	oldHTTPClient := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = oldHTTPClient })
+	return server
+}
+
+func testRepo() Repository {
+	repo := Repository{Name: "Repo 1", Slug: "repo1"}
+	repo.Project.Key = "PRJ"
+	repo.Project.Name = "Project"
+	return repo
+}
+
+func TestDataCenter_Repositories(t *testing.T) {
+	server := newFakeDataCenter(t)
+	provider := newDataCenterProvider("dc", server.URL, auth.Bearer{Token: "dc_token"}, []string{"PRJ"})
+
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	if assert.Len(t, repos, 2) {
+		assert.Equal(t, testRepo(), repos[0])
+		assert.Equal(t, "repo2", repos[1].Slug)
+	}
+}
+
+func TestDataCenter_CommitDetails(t *testing.T) {
+	server := newFakeDataCenter(t)
+	provider := newDataCenterProvider("dc", server.URL, auth.Bearer{Token: "dc_token"}, nil)
+
+	commits, err := provider.Commits(testRepo())
+	assert.NoError(t, err)
+	if assert.Len(t, commits, 1) {
+		assert.Equal(t, "commit1", commits[0].Hash)
+	}
+
+	details, err := provider.CommitDetails(testRepo(), "commit1")
+	assert.NoError(t, err)
+	assert.Equal(t, "fix: handle empty pages", details.Message)
+	assert.Equal(t, time.Date(2024, 7, 16, 10, 28, 45, 0, time.UTC), details.Date)
+	assert.Equal(t, "Jane Doe <jdoe@example.com>", details.Author.Raw)
+	assert.Equal(t, "Jane Doe", details.Author.User.DisplayName)
+	assert.Equal(t, "jdoe", details.Author.User.Nickname)
+	assert.Equal(t, []CommitParent{{Hash: "parent1"}}, details.Parents)
+	assert.Equal(t, 4, details.Summary.LinesAdded)
+	assert.Equal(t, 6, details.Summary.LinesDeleted)
+	assert.Equal(t, []FileChange{
+		{Path: "main.go", Type: "modified", LinesAdded: 3, LinesDeleted: 2},
+		{Path: "docs/README.md", Type: "added", LinesAdded: 1},
+		{Path: "old.go", Type: "removed", LinesDeleted: 4},
+		{Path: "b.go", Type: "renamed"},
+	}, details.Files)
+}
+
+func TestDataCenter_PullRequests(t *testing.T) {
+	server := newFakeDataCenter(t)
+	provider := newDataCenterProvider("dc", server.URL, auth.Bearer{Token: "dc_token"}, nil)
+
+	pullRequests, err := provider.PullRequests(testRepo())
+	assert.NoError(t, err)
+	if assert.Len(t, pullRequests, 1) {
+		pr := pullRequests[0]
+		assert.Equal(t, 7, pr.ID)
+		assert.Equal(t, "MERGED", pr.State)
+		assert.Equal(t, "Jane Doe", pr.Author.DisplayName)
+		assert.Equal(t, "feature", pr.Source.Branch.Name)
+		assert.Equal(t, "main", pr.Destination.Branch.Name)
+		assert.Equal(t, "merge1", pr.MergeCommit.Hash)
+		assert.Equal(t, 2, pr.CommentCount)
+		assert.Equal(t, time.Date(2024, 7, 17, 9, 0, 0, 0, time.UTC), pr.ClosedOn)
+	}
+
+	activity, err := provider.PullRequestActivity(testRepo(), "7")
+	assert.NoError(t, err)
+	assert.Len(t, activity, 4, "merges are not review activity")
+
+	commits, err := provider.PullRequestCommits(testRepo(), "7")
+	assert.NoError(t, err)
+	if assert.Len(t, commits, 1) {
+		assert.Equal(t, "commit1", commits[0].Hash)
+		assert.Equal(t, time.Date(2024, 7, 16, 8, 0, 0, 0, time.UTC), commits[0].Date.UTC())
+	}
+}
+
+func TestDataCenter_Error(t *testing.T) {
+	server := newFakeDataCenter(t)
+	provider := newDataCenterProvider("dc", server.URL, auth.Bearer{Token: "wrong_token"}, nil)
+
+	_, err := provider.Repositories()
+	assert.ErrorContains(t, err, "status 401")
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := newProvider(config.Source{Type: SourceDataCenter, BaseURL: "https://git.example.com/"}, auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, newDataCenterProvider("git.example.com", "https://git.example.com", auth.Bearer{Token: "token"}, nil), provider)
+
+	provider, err = newProvider(config.Source{Workspace: "other", Token: "own_token"}, auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, newCloudProvider("other", auth.Bearer{Token: "own_token"}), provider)
+
+	_, err = newProvider(config.Source{Type: SourceDataCenter}, auth.Bearer{Token: "token"})
+	assert.ErrorContains(t, err, "invalid base URL")
+
+	provider, err = newProvider(config.Source{Type: SourceGitLab, Workspace: "acme"}, auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, newGitLabProvider("acme", "https://gitlab.com", auth.Bearer{Token: "token"}), provider)
+
+	_, err = newProvider(config.Source{Type: SourceGitHub}, auth.Bearer{Token: "token"})
+	assert.ErrorContains(t, err, "need a workspace")
+
+	provider, err = newProvider(config.Source{Type: SourceLocal, Path: "/srv/clones"}, auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, newLocalProvider(ProviderBitbucket, "lep13", "/srv/clones", "", ""), provider)
+
+	provider, err = newProvider(config.Source{Type: SourceLocal, Path: "/srv/clones", ProjectKey: "MIR"}, auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, newLocalProvider(ProviderBitbucket, "lep13", "/srv/clones", "MIR", "MIR"), provider, "the project name defaults to the key")
+
+	_, err = newProvider(config.Source{Type: SourceLocal}, auth.Bearer{Token: "token"})
+	assert.ErrorContains(t, err, "need a path")
+
+	_, err = newProvider(config.Source{Type: "gitea"}, auth.Bearer{Token: "token"})
+	assert.ErrorContains(t, err, "unknown source type")
+}
+
+func TestNewProvider_Authentication(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			if clientID, clientSecret, _ := r.BasicAuth(); clientID == "id" && clientSecret == "secret" {
+				w.Write([]byte(`{"access_token": "consumer_token", "expires_in": 7200}`))
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer consumer_token":
+			w.Write([]byte(`{"values": [{"slug": "consumer"}], "isLastPage": true}`))
+		case "Basic amRvZTphcHBfcGFzc3dvcmQ=":
+			w.Write([]byte(`{"values": [{"slug": "app_password"}], "isLastPage": true}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	oldHTTPClient := httpClient
+	httpClient = server.Client()
+	defer func() { httpClient = oldHTTPClient }()
+
+	// A source's own authentication takes precedence over the default
+	provider, err := newProvider(config.Source{Type: SourceDataCenter, BaseURL: server.URL, Projects: []string{"PRJ"},
+		Auth: &config.AuthConfig{Type: auth.TypeClientCredentials, ClientID: "id", ClientSecret: "secret", TokenURL: server.URL + "/oauth/token"}},
+		auth.Bearer{Token: "token"})
+	assert.NoError(t, err)
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	assert.Equal(t, "consumer", repos[0].Slug)
+
+	provider, err = newProvider(config.Source{Type: SourceDataCenter, BaseURL: server.URL, Projects: []string{"PRJ"}},
+		auth.Basic{Username: "jdoe", Password: "app_password"})
+	assert.NoError(t, err)
+	repos, err = provider.Repositories()
+	assert.NoError(t, err)
+	assert.Equal(t, "app_password", repos[0].Slug)
+
+	_, err = newProvider(config.Source{Auth: &config.AuthConfig{Type: auth.TypeAppPassword}}, auth.Bearer{Token: "token"})
+	assert.EqualError(t, err, "app_password authentication needs a username and a password")
+}
+
+func TestFetchAndSaveCommits_DataCenterSource(t *testing.T) {
+	server := newFakeDataCenter(t)
+	host, _ := url.Parse(server.URL)
+
+	oldCfg := cfg
+	sourceCfg := *cfg
+	sourceCfg.Sources = []config.Source{{Type: SourceDataCenter, BaseURL: server.URL, Token: "dc_token", Projects: []string{"PRJ"}}}
+	cfg = &sourceCfg
+	defer func() { cfg = oldCfg }()
+
+	commits := new(MockCollection)
+	commits.On("UpdateOne", mock.Anything, bson.M{
+		"provider": ProviderBitbucketDataCenter, "workspace": host.Host, "project_key": "PRJ", "repo_name": "Repo 1", "commit_id": "commit1",
+	}, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.Provider == ProviderBitbucketDataCenter &&
+			commit.Workspace == host.Host &&
+			commit.ProjectName == "Project" &&
+			commit.CommittedBy == "Jane Doe" &&
+			commit.LinesAdded == 4 &&
+			commit.FilesAdded == 1 &&
+			commit.FilesDeleted == 1 &&
+			commit.FilesUpdated == 1 &&
+			commit.CommitType == "fix"
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+	pullRequests := new(MockCollection)
+	pullRequests.On("UpdateOne", mock.Anything, bson.M{"provider": ProviderBitbucketDataCenter, "workspace": host.Host, "project_key": "PRJ", "repo_name": "Repo 1", "pull_request_id": "7"}, mock.MatchedBy(func(update bson.M) bool {
+		pr := update["$set"].(PullRequest)
+		return pr.ClosedOn.Equal(time.Date(2024, 7, 17, 9, 0, 0, 0, time.UTC)) &&
+			pr.FirstReviewOn.Equal(time.Date(2024, 7, 16, 10, 0, 0, 0, time.UTC)) &&
+			pr.ReviewRounds == 2 &&
+			pr.UpdatesAfterReview == 1 &&
+			pr.FirstCommitOn.Equal(time.Date(2024, 7, 16, 8, 0, 0, 0, time.UTC))
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return commits
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		if name == db.PullRequestsCollection {
+			return pullRequests
+		}
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	// repo2 is served without commits or pull requests, which leaves the run partial
+	summary, err := FetchAndSaveCommits("fake_token")
+	assert.Error(t, err)
+	assert.Equal(t, RunPartial, summary.Status)
+	assert.Equal(t, 2, summary.ReposProcessed)
+	assert.Equal(t, 1, summary.CommitsUpserted)
+	assert.Contains(t, err.Error(), CauseFetchCommits)
+	assert.Contains(t, err.Error(), CauseFetchPullRequests)
+
+	commits.AssertExpectations(t)
+	pullRequests.AssertExpectations(t)
+}
@@ -0,0 +1,178 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RepoChanges counts what a sync would write for one repository. Commits are new, changed or unchanged compared
+// with the stored ones, or unknown when they could not be looked up.
+type RepoChanges struct {
+	Workspace        string
+	Repo             string
+	NewCommits       int
+	ChangedCommits   int
+	UnchangedCommits int
+	UnknownCommits   int
+	PullRequests     int
+	Pipelines        int
+}
+
+// DryRunReport lists the changes of every repository a dry run went through, in sync order.
+type DryRunReport struct {
+	Repos []*RepoChanges
+}
+
+// DryRun fetches and transforms the data of every configured source as FetchAndSaveCommits does, but writes
+// nothing. It compares the commits with the stored ones and, when dump is not nil, writes every document a sync
+// would write to it as a JSON line.
+func DryRun(accessToken string, dump io.Writer) (*DryRunReport, error) {
+	s := &dryRunStore{report: &DryRunReport{}, repos: map[[2]string]*RepoChanges{}}
+	if dump != nil {
+		s.dump = json.NewEncoder(dump)
+	}
+
+	if err := syncProviders(accessToken, s, newRunSummary()); err != nil {
+		return nil, err
+	}
+	if s.dumpErr != nil {
+		return s.report, fmt.Errorf("failed to dump documents: %w", s.dumpErr)
+	}
+	return s.report, nil
+}
+
+// Print writes the report as a table with a row per repository and a total.
+func (r *DryRunReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKSPACE\tREPOSITORY\tNEW\tCHANGED\tUNCHANGED\tUNKNOWN\tPULL REQUESTS\tPIPELINES")
+	total := RepoChanges{Workspace: "TOTAL"}
+	for _, repo := range r.Repos {
+		printChanges(tw, repo)
+		total.NewCommits += repo.NewCommits
+		total.ChangedCommits += repo.ChangedCommits
+		total.UnchangedCommits += repo.UnchangedCommits
+		total.UnknownCommits += repo.UnknownCommits
+		total.PullRequests += repo.PullRequests
+		total.Pipelines += repo.Pipelines
+	}
+	printChanges(tw, &total)
+	return tw.Flush()
+}
+
+func printChanges(w io.Writer, c *RepoChanges) {
+	fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n", c.Workspace, c.Repo,
+		c.NewCommits, c.ChangedCommits, c.UnchangedCommits, c.UnknownCommits, c.PullRequests, c.Pipelines)
+}
+
+// dryRunStore counts and optionally dumps the documents instead of writing them.
+type dryRunStore struct {
+	report  *DryRunReport
+	repos   map[[2]string]*RepoChanges
+	dump    *json.Encoder
+	dumpErr error
+}
+
+// dumpedDocument is a line of the dump.
+type dumpedDocument struct {
+	Collection string      `json:"collection"`
+	Document   interface{} `json:"document"`
+}
+
+func (s *dryRunStore) saveCommit(commit Commit) error {
+	changes := s.changes(commit.Workspace, commit.RepoName)
+	switch state, err := storedState(commit); {
+	case err != nil:
+		slog.Warn("Failed to look up stored commit", logging.KeyWorkspace, commit.Workspace, logging.KeyRepo, commit.RepoName,
+			logging.KeyCommit, commit.CommitID, logging.KeyError, err)
+		changes.UnknownCommits++
+	case state == stateNew:
+		changes.NewCommits++
+	case state == stateChanged:
+		changes.ChangedCommits++
+	default:
+		changes.UnchangedCommits++
+	}
+	s.write(db.CommitsCollection, commit)
+	return nil
+}
+
+func (s *dryRunStore) savePullRequest(pr PullRequest) error {
+	s.changes(pr.Workspace, pr.RepoName).PullRequests++
+	s.write(db.PullRequestsCollection, pr)
+	return nil
+}
+
+func (s *dryRunStore) savePipeline(pipeline Pipeline) error {
+	s.changes(pipeline.Workspace, pipeline.RepoName).Pipelines++
+	s.write(db.PipelinesCollection, pipeline)
+	return nil
+}
+
+// changes returns the counts of a repository, adding it to the report when it is first seen.
+func (s *dryRunStore) changes(workspace, repo string) *RepoChanges {
+	key := [2]string{workspace, repo}
+	if s.repos[key] == nil {
+		s.repos[key] = &RepoChanges{Workspace: workspace, Repo: repo}
+		s.report.Repos = append(s.report.Repos, s.repos[key])
+	}
+	return s.repos[key]
+}
+
+// write dumps document, remembering the first failure.
+func (s *dryRunStore) write(collection string, document interface{}) {
+	if s.dump == nil || s.dumpErr != nil {
+		return
+	}
+	s.dumpErr = s.dump.Encode(dumpedDocument{Collection: collection, Document: document})
+}
+
+// States of a commit compared with the store.
+const (
+	stateNew       = "new"
+	stateChanged   = "changed"
+	stateUnchanged = "unchanged"
+)
+
+// storedState tells whether commit is new to the store, or whether upserting it would change its stored document.
+func storedState(commit Commit) (string, error) {
+	ctx := context.Background()
+	cursor, err := db.GetCollection().Find(ctx, commitFilter(commit), options.Find().SetLimit(1))
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		return stateNew, cursor.Err()
+	}
+	var stored Commit
+	if err := cursor.Decode(&stored); err != nil {
+		return "", err
+	}
+
+	// Rework is linked after syncing, and the upsert leaves it in place
+	commit.Reverts, commit.Rework, commit.ReworkLines = stored.Reverts, stored.Rework, stored.ReworkLines
+	// Both are compared as written, which also truncates their dates to what MongoDB stores
+	want, err := bson.Marshal(commit)
+	if err != nil {
+		return "", err
+	}
+	have, err := bson.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(want, have) {
+		return stateChanged, nil
+	}
+	return stateUnchanged, nil
+}
@@ -0,0 +1,113 @@
+package bitbucket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// gitHubCommitFilter selects the stored document of the commit served by the fake GitHub organization acme.
+var gitHubCommitFilter = bson.M{"provider": ProviderGitHub, "workspace": "acme", "project_key": "acme", "repo_name": "repo1", "commit_id": "commit1"}
+
+// useGitHubSource syncs from the fake GitHub organization acme, with commits looked up in the given collection.
+func useGitHubSource(t *testing.T, commits *MockCollection) {
+	server := newFakeGitHub(t)
+
+	oldCfg := cfg
+	sourceCfg := *cfg
+	sourceCfg.Sources = []config.Source{{Type: SourceGitHub, Workspace: "acme", BaseURL: server.URL, Token: "gh_token"}}
+	cfg = &sourceCfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return commits
+	}
+	t.Cleanup(func() { db.GetCollectionFunc = oldGetCollection })
+
+	// Writes to any other collection fail the test, as the mock expects none
+	writes := new(MockCollection)
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return writes
+	}
+	t.Cleanup(func() { db.GetNamedCollectionFunc = oldGetNamedCollection })
+}
+
+func storedCommits(t *testing.T, commits ...interface{}) *mongo.Cursor {
+	cursor, err := mongo.NewCursorFromDocuments(commits, nil, nil)
+	require.NoError(t, err)
+	return cursor
+}
+
+func TestDryRun(t *testing.T) {
+	commits := new(MockCollection)
+	useGitHubSource(t, commits)
+	commits.On("Find", mock.Anything, gitHubCommitFilter, mock.Anything).Return(storedCommits(t), nil).Once()
+
+	var dump bytes.Buffer
+	report, err := DryRun("fake_token", &dump)
+	require.NoError(t, err)
+	assert.Equal(t, []*RepoChanges{{Workspace: "acme", Repo: "repo1", NewCommits: 1, PullRequests: 3}}, report.Repos)
+	commits.AssertNotCalled(t, "UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var dumped []struct {
+		Collection string          `json:"collection"`
+		Document   json.RawMessage `json:"document"`
+	}
+	scanner := bufio.NewScanner(&dump)
+	for scanner.Scan() {
+		var line struct {
+			Collection string          `json:"collection"`
+			Document   json.RawMessage `json:"document"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		dumped = append(dumped, line)
+	}
+	require.Len(t, dumped, 4)
+	assert.Equal(t, db.CommitsCollection, dumped[0].Collection)
+	assert.Equal(t, db.PullRequestsCollection, dumped[1].Collection)
+
+	// The dumped commit is what a sync stores, so storing it leaves nothing to change
+	var commit Commit
+	require.NoError(t, json.Unmarshal(dumped[0].Document, &commit))
+	assert.Equal(t, "commit1", commit.CommitID)
+	commit.Rework = []ReworkLink{{CommitID: "commit0", Path: "search.go", Lines: 2}}
+	commits.On("Find", mock.Anything, gitHubCommitFilter, mock.Anything).Return(storedCommits(t, commit), nil).Once()
+
+	report, err = DryRun("fake_token", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Repos[0].UnchangedCommits)
+
+	commit.LinesAdded = 100
+	commits.On("Find", mock.Anything, gitHubCommitFilter, mock.Anything).Return(storedCommits(t, commit), nil).Once()
+
+	report, err = DryRun("fake_token", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Repos[0].ChangedCommits)
+	commits.AssertExpectations(t)
+}
+
+func TestDryRunReport_Print(t *testing.T) {
+	report := &DryRunReport{Repos: []*RepoChanges{
+		{Workspace: "acme", Repo: "repo1", NewCommits: 2, ChangedCommits: 1, PullRequests: 3},
+		{Workspace: "acme", Repo: "repo2", UnchangedCommits: 4, UnknownCommits: 1, Pipelines: 2},
+	}}
+
+	var out bytes.Buffer
+	require.NoError(t, report.Print(&out))
+	assert.Equal(t, `WORKSPACE  REPOSITORY  NEW  CHANGED  UNCHANGED  UNKNOWN  PULL REQUESTS  PIPELINES
+acme       repo1       2    1        0          0        3              0
+acme       repo2       0    0        4          1        0              2
+TOTAL                  2    1        4          1        3              2
+`, out.String())
+}
@@ -0,0 +1,63 @@
+package bitbucket
+
+import (
+	"errors"
+
+	"github.com/lep13/bitbucket_metrics/internal/maintenance"
+)
+
+// errErased is returned for the documents of people erased by deletion, which a sync leaves out.
+var errErased = errors.New("person was erased")
+
+// loadSuppressions reads the recorded erasures. It is a variable so tests can keep them out of MongoDB.
+var loadSuppressions = maintenance.LoadSuppressions
+
+// suppressingStore applies the recorded erasures to the documents before saving them to next, so a sync does
+// not restore what an erasure removed. Documents of people erased by deletion are left out with errErased, and
+// those of people erased by anonymization are saved anonymized as the erasure left them.
+type suppressingStore struct {
+	next         store
+	suppressions *maintenance.Suppressions
+}
+
+func (s suppressingStore) saveCommit(commit Commit) error {
+	if erasure, ok := s.suppressions.Person(commit.PersonID); ok {
+		if erasure.Mode == maintenance.ModeDelete {
+			return errErased
+		}
+		commit.CommittedBy, commit.PersonID = maintenance.ErasedName, erasure.ErasedID
+		commit.AuthorRaw, commit.AuthorEmail, commit.AuthorUUID, commit.AuthorNickname = "", "", "", ""
+	}
+	if s.suppressions.Alias(commit.ReviewedBy) {
+		commit.ReviewedBy = maintenance.ErasedName
+	}
+	return s.next.saveCommit(commit)
+}
+
+func (s suppressingStore) savePullRequest(pr PullRequest) error {
+	if erasure, ok := s.suppressions.Person(pr.AuthorPersonID); ok {
+		if erasure.Mode == maintenance.ModeDelete {
+			return errErased
+		}
+		pr.Author, pr.AuthorPersonID = maintenance.ErasedName, erasure.ErasedID
+	}
+
+	events := make([]ReviewEvent, 0, len(pr.Events))
+	for _, event := range pr.Events {
+		if erasure, ok := s.suppressions.Person(event.ActorPersonID); ok {
+			if erasure.Mode == maintenance.ModeDelete {
+				continue
+			}
+			event.Actor, event.ActorPersonID = maintenance.ErasedName, erasure.ErasedID
+		}
+		events = append(events, event)
+	}
+	if pr.Events != nil {
+		pr.Events = events
+	}
+	return s.next.savePullRequest(pr)
+}
+
+func (s suppressingStore) savePipeline(pipeline Pipeline) error {
+	return s.next.savePipeline(pipeline)
+}
@@ -0,0 +1,155 @@
+package bitbucket
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/maintenance"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testAliasHasher hashes the names of erased people.
+var testAliasHasher, _ = privacy.New("0123456789abcdef0123456789abcdef", false)
+
+// syncedDocuments are the documents a sync of the fake GitHub organization saved.
+type syncedDocuments struct {
+	commits      []Commit
+	pullRequests []PullRequest
+}
+
+// syncGitHub syncs the fake GitHub organization, with erasures read from the given collection.
+func syncGitHub(t *testing.T, erasures *MockCollection) syncedDocuments {
+	var synced syncedDocuments
+	commits := new(MockCollection)
+	commits.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		synced.commits = append(synced.commits, args.Get(2).(bson.M)["$set"].(Commit))
+	}).Return(&mongo.UpdateResult{}, nil)
+	pullRequests := new(MockCollection)
+	pullRequests.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		synced.pullRequests = append(synced.pullRequests, args.Get(2).(bson.M)["$set"].(PullRequest))
+	}).Return(&mongo.UpdateResult{}, nil)
+
+	useGitHubSource(t, commits)
+	aliasHasher = testAliasHasher
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		if name == db.ErasuresCollection {
+			return erasures
+		}
+		assert.Equal(t, db.PullRequestsCollection, name)
+		return pullRequests
+	}
+
+	FetchAndSaveCommits("fake_token")
+	return synced
+}
+
+// erase runs an erasure of personID against the synced documents, returning the erasure it recorded.
+func erase(t *testing.T, synced syncedDocuments, personID, mode string) bson.M {
+	var ownCommits, ownPullRequests []interface{}
+	for _, commit := range synced.commits {
+		if commit.PersonID == personID {
+			ownCommits = append(ownCommits, commit)
+		}
+	}
+	for _, pr := range synced.pullRequests {
+		if pr.AuthorPersonID == personID {
+			ownPullRequests = append(ownPullRequests, pr)
+		}
+	}
+
+	commits, pullRequests, erasures, others := new(MockCollection), new(MockCollection), new(MockCollection), new(MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(storedCommits(t, ownCommits...), nil).Once()
+	pullRequests.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(storedCommits(t, ownPullRequests...), nil).Once()
+	for _, collection := range []*MockCollection{commits, pullRequests, others} {
+		collection.On("DeleteMany", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.DeleteResult{}, nil)
+		collection.On("UpdateMany", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil)
+	}
+	others.On("InsertOne", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.InsertOneResult{}, nil)
+	var recorded bson.M
+	erasures.On("UpdateOne", mock.Anything, bson.M{"person_id": personID}, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		recorded = args.Get(2).(bson.M)
+	}).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		switch name {
+		case db.CommitsCollection:
+			return commits
+		case db.PullRequestsCollection:
+			return pullRequests
+		case db.ErasuresCollection:
+			return erasures
+		}
+		return others
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	require.NoError(t, maintenance.Erase(context.Background(), maintenance.ErasureRequest{PersonID: personID, Mode: mode, Actor: "dpo", Hasher: testAliasHasher}))
+	require.NotNil(t, recorded)
+
+	set := recorded["$set"].(bson.M)
+	return bson.M{
+		"person_id": personID,
+		"mode":      set["mode"],
+		"erased_id": set["erased_id"],
+		"aliases":   recorded["$addToSet"].(bson.M)["aliases"].(bson.M)["$each"],
+	}
+}
+
+func TestSync_KeepsErasures(t *testing.T) {
+	oldLoadSuppressions := loadSuppressions
+	oldAliasHasher := aliasHasher
+	loadSuppressions = maintenance.LoadSuppressions
+	defer func() { loadSuppressions, aliasHasher = oldLoadSuppressions, oldAliasHasher }()
+
+	for _, mode := range []string{maintenance.ModeDelete, maintenance.ModeAnonymize} {
+		t.Run(mode, func(t *testing.T) {
+			noErasures := new(MockCollection)
+			noErasures.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(storedCommits(t), nil).Once()
+			before := syncGitHub(t, noErasures)
+			require.Len(t, before.commits, 1)
+			personID := before.commits[0].PersonID
+			require.NotEmpty(t, personID)
+
+			erasure := erase(t, before, personID, mode)
+			aliases := erasure["aliases"].([]string)
+			require.NotEmpty(t, aliases)
+			for _, commit := range before.commits {
+				assert.NotContains(t, aliases, commit.CommittedBy, "names are recorded as hashes")
+			}
+
+			erasures := new(MockCollection)
+			erasures.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(storedCommits(t, erasure), nil).Once()
+			after := syncGitHub(t, erasures)
+
+			// Nothing the sync saved links back to the person
+			for _, commit := range after.commits {
+				assert.NotEqual(t, personID, commit.PersonID)
+				assert.NotContains(t, aliases, testAliasHasher.Pseudonym(commit.CommittedBy))
+				assert.NotContains(t, aliases, testAliasHasher.Pseudonym(commit.ReviewedBy))
+			}
+			for _, pr := range after.pullRequests {
+				assert.NotEqual(t, personID, pr.AuthorPersonID)
+				for _, event := range pr.Events {
+					assert.NotEqual(t, personID, event.ActorPersonID)
+				}
+			}
+
+			if mode == maintenance.ModeDelete {
+				assert.Empty(t, after.commits)
+				return
+			}
+			require.Len(t, after.commits, 1)
+			assert.Equal(t, maintenance.ErasedName, after.commits[0].CommittedBy)
+			assert.Equal(t, erasure["erased_id"], after.commits[0].PersonID)
+			assert.Empty(t, after.commits[0].AuthorEmail)
+			assert.Empty(t, after.commits[0].AuthorRaw)
+		})
+	}
+}
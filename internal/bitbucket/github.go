@@ -0,0 +1,267 @@
+package bitbucket
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// gitHubProvider reads the repositories of a GitHub organization through the REST API.
+type gitHubProvider struct {
+	owner  string
+	apiURL string
+	client HTTPClient
+}
+
+func newGitHubProvider(owner, apiURL string, authenticator auth.Authenticator) *gitHubProvider {
+	return &gitHubProvider{owner: owner, apiURL: apiURL, client: authenticatedClient(authenticator)}
+}
+
+type ghUser struct {
+	Login string `json:"login"`
+}
+
+func (u ghUser) account() Account {
+	return Account{DisplayName: u.Login, Nickname: u.Login}
+}
+
+type ghRepository struct {
+	Name  string `json:"name"`
+	Owner ghUser `json:"owner"`
+}
+
+type ghCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string    `json:"name"`
+			Email string    `json:"email"`
+			Date  time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+	Author  *ghUser `json:"author"`
+	Parents []struct {
+		SHA string `json:"sha"`
+	} `json:"parents"`
+	Stats struct {
+		Additions int `json:"additions"`
+		Deletions int `json:"deletions"`
+	} `json:"stats"`
+	Files []struct {
+		Filename  string `json:"filename"`
+		Status    string `json:"status"`
+		Additions int    `json:"additions"`
+		Deletions int    `json:"deletions"`
+	} `json:"files"`
+}
+
+type ghPullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	User   ghUser `json:"user"`
+	Head   struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	MergeCommitSHA string     `json:"merge_commit_sha"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ClosedAt       *time.Time `json:"closed_at"`
+	MergedAt       *time.Time `json:"merged_at"`
+}
+
+type ghReview struct {
+	User        ghUser    `json:"user"`
+	State       string    `json:"state"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Name returns ProviderGitHub.
+func (g *gitHubProvider) Name() string {
+	return ProviderGitHub
+}
+
+// Workspace returns the organization.
+func (g *gitHubProvider) Workspace() string {
+	return g.owner
+}
+
+// Repositories fetches the repositories of the organization. The organization doubles as their project.
+func (g *gitHubProvider) Repositories() ([]Repository, error) {
+	values, err := fetchLinkedPages[ghRepository]("github_repositories", g.apiURL+"/orgs/"+url.PathEscape(g.owner)+"/repos?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]Repository, len(values))
+	for i, value := range values {
+		repos[i].Name = value.Name
+		repos[i].Slug = value.Name
+		repos[i].Project.Key = value.Owner.Login
+		repos[i].Project.Name = value.Owner.Login
+	}
+	slog.Debug("Fetched repositories", logging.KeyWorkspace, g.Workspace(), "count", len(repos))
+	return repos, nil
+}
+
+// Commits fetches the commits of the default branch of repo.
+func (g *gitHubProvider) Commits(repo Repository) ([]CommitDetails, error) {
+	values, err := fetchLinkedPages[ghCommit]("github_commits", g.repoURL(repo)+"/commits?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	slog.Debug("Fetched commits", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, "count", len(commits))
+	return commits, nil
+}
+
+// CommitDetails fetches a commit of repo together with its changed files.
+func (g *gitHubProvider) CommitDetails(repo Repository, commitHash string) (CommitDetails, error) {
+	var commit ghCommit
+	if _, err := getJSON("github_commit", g.repoURL(repo)+"/commits/"+url.PathEscape(commitHash), g.client, &commit); err != nil {
+		return CommitDetails{}, err
+	}
+
+	details := commit.details()
+	details.Summary.LinesAdded = commit.Stats.Additions
+	details.Summary.LinesDeleted = commit.Stats.Deletions
+	details.Files = make([]FileChange, len(commit.Files))
+	for i, file := range commit.Files {
+		details.Files[i] = FileChange{
+			Path:         file.Filename,
+			Type:         gitHubFileType(file.Status),
+			LinesAdded:   file.Additions,
+			LinesDeleted: file.Deletions,
+		}
+	}
+
+	slog.Debug("Fetched commit details", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, logging.KeyCommit, commitHash)
+	return details, nil
+}
+
+// PullRequests fetches the pull requests of repo in any state. Closed pull requests that were not merged
+// are reported as declined, as Bitbucket does.
+func (g *gitHubProvider) PullRequests(repo Repository) ([]PullRequestDetails, error) {
+	values, err := fetchLinkedPages[ghPullRequest]("github_pullrequests", g.repoURL(repo)+"/pulls?state=all&per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	pullRequests := make([]PullRequestDetails, len(values))
+	for i, value := range values {
+		pr := &pullRequests[i]
+		pr.ID = value.Number
+		pr.Title = value.Title
+		switch {
+		case value.MergedAt != nil:
+			pr.State = "MERGED"
+			pr.ClosedOn = *value.MergedAt
+		case value.State == "closed":
+			pr.State = "DECLINED"
+			if value.ClosedAt != nil {
+				pr.ClosedOn = *value.ClosedAt
+			}
+		default:
+			pr.State = "OPEN"
+		}
+		pr.Author.DisplayName = value.User.Login
+		pr.Author.Nickname = value.User.Login
+		pr.Source.Branch.Name = value.Head.Ref
+		pr.Destination.Branch.Name = value.Base.Ref
+		if pr.State == "MERGED" {
+			pr.MergeCommit.Hash = value.MergeCommitSHA
+		}
+		pr.CreatedOn = value.CreatedAt
+		pr.UpdatedOn = value.UpdatedAt
+	}
+	slog.Debug("Fetched pull requests", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, "count", len(pullRequests))
+	return pullRequests, nil
+}
+
+// PullRequestActivity fetches the reviews of a pull request. Reviews that only comment count as comments.
+func (g *gitHubProvider) PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error) {
+	values, err := fetchLinkedPages[ghReview]("github_reviews", g.repoURL(repo)+"/pulls/"+url.PathEscape(pullRequestID)+"/reviews?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := []PullRequestActivity{}
+	for _, value := range values {
+		user := value.User.account()
+		switch value.State {
+		case "APPROVED":
+			activity = append(activity, PullRequestActivity{Approval: &ActivityReview{Date: value.SubmittedAt, User: user}})
+		case "CHANGES_REQUESTED":
+			activity = append(activity, PullRequestActivity{ChangesRequested: &ActivityReview{Date: value.SubmittedAt, User: user}})
+		case "COMMENTED":
+			activity = append(activity, PullRequestActivity{Comment: &ActivityComment{CreatedOn: value.SubmittedAt, User: user}})
+		}
+	}
+	return activity, nil
+}
+
+// PullRequestCommits fetches the commits of a pull request.
+func (g *gitHubProvider) PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error) {
+	values, err := fetchLinkedPages[ghCommit]("github_pullrequest_commits", g.repoURL(repo)+"/pulls/"+url.PathEscape(pullRequestID)+"/commits?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	return commits, nil
+}
+
+// Pipelines returns nothing, as GitHub Actions runs are not collected.
+func (g *gitHubProvider) Pipelines(repo Repository) ([]PipelineDetails, error) {
+	return nil, nil
+}
+
+func (g *gitHubProvider) repoURL(repo Repository) string {
+	return g.apiURL + "/repos/" + url.PathEscape(g.owner) + "/" + url.PathEscape(repo.Slug)
+}
+
+func (c ghCommit) details() CommitDetails {
+	var details CommitDetails
+	details.Hash = c.SHA
+	details.Message = c.Commit.Message
+	details.Date = c.Commit.Author.Date
+	details.Author.Raw = c.Commit.Author.Name
+	if c.Commit.Author.Email != "" {
+		details.Author.Raw = fmt.Sprintf("%s <%s>", c.Commit.Author.Name, c.Commit.Author.Email)
+	}
+	details.Author.User.DisplayName = c.Commit.Author.Name
+	// The author is only known as a user when the commit email belongs to a GitHub account
+	if c.Author != nil {
+		details.Author.User.Nickname = c.Author.Login
+	}
+	for _, parent := range c.Parents {
+		details.Parents = append(details.Parents, CommitParent{Hash: parent.SHA})
+	}
+	return details
+}
+
+// gitHubFileType maps the status of a changed file to the Bitbucket diffstat types.
+func gitHubFileType(status string) string {
+	switch status {
+	case "added", "copied":
+		return "added"
+	case "removed", "renamed":
+		return status
+	}
+	return "modified"
+}
@@ -0,0 +1,256 @@
+package bitbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newFakeGitHub serves the GitHub organization acme with repo1 and repo2, listed over two pages.
+// repo1 has one commit and one merged pull request.
+func newFakeGitHub(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orgs/acme/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"name": "repo2", "owner": {"login": "acme"}}]`))
+			return
+		}
+		w.Header().Set("Link", `<http://`+r.Host+`/orgs/acme/repos?per_page=100&page=2>; rel="next", <http://`+r.Host+`/orgs/acme/repos?per_page=100&page=2>; rel="last"`)
+		w.Write([]byte(`[{"name": "repo1", "owner": {"login": "acme"}}]`))
+	})
+	mux.HandleFunc("GET /repos/acme/repo1/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"sha": "commit1"}]`))
+	})
+	mux.HandleFunc("GET /repos/acme/repo1/commits/commit1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"sha": "commit1",
+			"commit": {"message": "feat: add search", "author": {"name": "Jane Doe", "email": "jane@example.com", "date": "2024-07-16T10:28:45Z"}},
+			"author": {"login": "jdoe"},
+			"parents": [{"sha": "parent1"}],
+			"stats": {"additions": 12, "deletions": 3},
+			"files": [
+				{"filename": "search.go", "status": "added", "additions": 10},
+				{"filename": "main.go", "status": "modified", "additions": 2, "deletions": 1},
+				{"filename": "old.go", "status": "removed", "deletions": 2},
+				{"filename": "go.sum", "status": "changed"}
+			]
+		}`))
+	})
+	mux.HandleFunc("GET /repos/acme/repo1/pulls", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "all", r.URL.Query().Get("state"))
+		w.Write([]byte(`[
+			{"number": 7, "title": "Add search", "state": "closed", "user": {"login": "jdoe"},
+				"head": {"ref": "search"}, "base": {"ref": "main"}, "merge_commit_sha": "merge1",
+				"created_at": "2024-07-16T09:00:00Z", "updated_at": "2024-07-17T10:00:00Z",
+				"closed_at": "2024-07-17T09:00:00Z", "merged_at": "2024-07-17T09:00:00Z"},
+			{"number": 8, "title": "Try sorting", "state": "closed", "user": {"login": "jdoe"},
+				"merge_commit_sha": "test-merge", "created_at": "2024-07-16T09:00:00Z", "closed_at": "2024-07-16T12:00:00Z"},
+			{"number": 9, "title": "Add filters", "state": "open", "user": {"login": "jdoe"}, "created_at": "2024-07-18T09:00:00Z"}
+		]`))
+	})
+	mux.HandleFunc("GET /repos/acme/repo1/pulls/7/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"sha": "commit2", "commit": {"author": {"name": "Jane Doe", "date": "2024-07-16T08:00:00Z"}}},
+			{"sha": "commit1", "commit": {"author": {"name": "Jane Doe", "date": "2024-07-16T07:30:00Z"}}}
+		]`))
+	})
+	mux.HandleFunc("GET /repos/acme/repo1/pulls/7/reviews", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"user": {"login": "rroe"}, "state": "CHANGES_REQUESTED", "submitted_at": "2024-07-16T10:00:00Z"},
+			{"user": {"login": "jdoe"}, "state": "COMMENTED", "submitted_at": "2024-07-16T11:00:00Z"},
+			{"user": {"login": "rroe"}, "state": "DISMISSED", "submitted_at": "2024-07-16T12:00:00Z"},
+			{"user": {"login": "rroe"}, "state": "APPROVED", "submitted_at": "2024-07-17T08:00:00Z"}
+		]`))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	oldHTTPClient := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = oldHTTPClient })
+	return server
+}
+
+func TestGitHub_Repositories(t *testing.T) {
+	server := newFakeGitHub(t)
+	provider := newGitHubProvider("acme", server.URL, auth.Bearer{Token: "gh_token"})
+
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	if assert.Len(t, repos, 2) {
+		assert.Equal(t, "repo1", repos[0].Slug)
+		assert.Equal(t, "acme", repos[0].Project.Name)
+		assert.Equal(t, "repo2", repos[1].Slug)
+	}
+}
+
+func TestGitHub_CommitDetails(t *testing.T) {
+	server := newFakeGitHub(t)
+	provider := newGitHubProvider("acme", server.URL, auth.Bearer{Token: "gh_token"})
+
+	commits, err := provider.Commits(Repository{Slug: "repo1"})
+	assert.NoError(t, err)
+	assert.Len(t, commits, 1)
+
+	details, err := provider.CommitDetails(Repository{Slug: "repo1"}, "commit1")
+	assert.NoError(t, err)
+	assert.Equal(t, "feat: add search", details.Message)
+	assert.Equal(t, time.Date(2024, 7, 16, 10, 28, 45, 0, time.UTC), details.Date)
+	assert.Equal(t, "Jane Doe <jane@example.com>", details.Author.Raw)
+	assert.Equal(t, "jdoe", details.Author.User.Nickname)
+	assert.Equal(t, []CommitParent{{Hash: "parent1"}}, details.Parents)
+	assert.Equal(t, 12, details.Summary.LinesAdded)
+	assert.Equal(t, 3, details.Summary.LinesDeleted)
+	assert.Equal(t, []FileChange{
+		{Path: "search.go", Type: "added", LinesAdded: 10},
+		{Path: "main.go", Type: "modified", LinesAdded: 2, LinesDeleted: 1},
+		{Path: "old.go", Type: "removed", LinesDeleted: 2},
+		{Path: "go.sum", Type: "modified"},
+	}, details.Files)
+}
+
+func TestGitHub_PullRequests(t *testing.T) {
+	server := newFakeGitHub(t)
+	provider := newGitHubProvider("acme", server.URL, auth.Bearer{Token: "gh_token"})
+
+	pullRequests, err := provider.PullRequests(Repository{Slug: "repo1"})
+	assert.NoError(t, err)
+	if assert.Len(t, pullRequests, 3) {
+		assert.Equal(t, "MERGED", pullRequests[0].State)
+		assert.Equal(t, "merge1", pullRequests[0].MergeCommit.Hash)
+		assert.Equal(t, "search", pullRequests[0].Source.Branch.Name)
+		assert.Equal(t, time.Date(2024, 7, 17, 9, 0, 0, 0, time.UTC), pullRequests[0].ClosedOn)
+		assert.Equal(t, "DECLINED", pullRequests[1].State)
+		assert.Empty(t, pullRequests[1].MergeCommit.Hash, "unmerged pull requests only have a test merge")
+		assert.Equal(t, "OPEN", pullRequests[2].State)
+		assert.True(t, pullRequests[2].ClosedOn.IsZero())
+	}
+
+	activity, err := provider.PullRequestActivity(Repository{Slug: "repo1"}, "7")
+	assert.NoError(t, err)
+	if assert.Len(t, activity, 3) {
+		assert.NotNil(t, activity[0].ChangesRequested)
+		assert.NotNil(t, activity[1].Comment)
+		assert.Equal(t, "rroe", activity[2].Approval.User.Nickname)
+	}
+
+	commits, err := provider.PullRequestCommits(Repository{Slug: "repo1"}, "7")
+	assert.NoError(t, err)
+	if assert.Len(t, commits, 2) {
+		assert.Equal(t, "commit2", commits[0].Hash)
+		assert.Equal(t, time.Date(2024, 7, 16, 7, 30, 0, 0, time.UTC), commits[1].Date)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	assert.Equal(t, "https://api.github.com/x?page=2", nextLink(`<https://api.github.com/x?page=1>; rel="prev", <https://api.github.com/x?page=2>; rel="next"`))
+	assert.Equal(t, "", nextLink(`<https://api.github.com/x?page=1>; rel="first"`))
+	assert.Equal(t, "", nextLink(""))
+}
+
+func TestFetchAndSaveCommits_GitHubSource(t *testing.T) {
+	server := newFakeGitHub(t)
+
+	oldCfg := cfg
+	sourceCfg := *cfg
+	sourceCfg.Sources = []config.Source{{Type: SourceGitHub, Workspace: "acme", BaseURL: server.URL, Token: "gh_token"}}
+	cfg = &sourceCfg
+	defer func() { cfg = oldCfg }()
+
+	commits := new(MockCollection)
+	commits.On("UpdateOne", mock.Anything, gitHubCommitFilter, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.Provider == ProviderGitHub &&
+			commit.Workspace == "acme" &&
+			commit.RepoName == "repo1" &&
+			commit.FilesAdded == 1 &&
+			commit.CommitType == "feat"
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+	pullRequests := new(MockCollection)
+	pullRequests.On("UpdateOne", mock.Anything, bson.M{"provider": ProviderGitHub, "workspace": "acme", "project_key": "acme", "repo_name": "repo1", "pull_request_id": "7"}, mock.MatchedBy(func(update bson.M) bool {
+		pr := update["$set"].(PullRequest)
+		return pr.Provider == ProviderGitHub && pr.ReviewRounds == 1 && !pr.ApprovedOn.IsZero() &&
+			pr.FirstCommitOn.Equal(time.Date(2024, 7, 16, 7, 30, 0, 0, time.UTC))
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+	pullRequests.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil).Twice()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return commits
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		if name == db.PullRequestsCollection {
+			return pullRequests
+		}
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	// repo2 is served without commits or pull requests, which leaves the run partial
+	summary, err := FetchAndSaveCommits("fake_token")
+	assert.Error(t, err)
+	assert.Equal(t, RunPartial, summary.Status)
+	assert.Equal(t, 2, summary.ReposProcessed)
+	assert.Equal(t, 1, summary.CommitsUpserted)
+	assert.Contains(t, err.Error(), CauseFetchCommits)
+	assert.Contains(t, err.Error(), CauseFetchPRActivity)
+	assert.Contains(t, err.Error(), CauseFetchPullRequests)
+
+	commits.AssertExpectations(t)
+	pullRequests.AssertExpectations(t)
+}
+
+func TestFetchAndSaveCommits_SkipsFailingSource(t *testing.T) {
+	server := newFakeGitHub(t)
+	denied := config.Source{Type: SourceGitHub, Workspace: "acme", BaseURL: server.URL, Token: "revoked_token"}
+	allowed := config.Source{Type: SourceGitHub, Workspace: "acme", BaseURL: server.URL, Token: "gh_token"}
+
+	collection := new(MockCollection)
+	collection.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil)
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface { return collection }
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface { return collection }
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	sync := func(sources ...config.Source) (*RunSummary, error) {
+		sourceCfg := *oldCfg
+		sourceCfg.Sources = sources
+		cfg = &sourceCfg
+		return FetchAndSaveCommits("fake_token")
+	}
+
+	summary, err := sync(denied, allowed)
+	assert.Equal(t, RunPartial, summary.Status, "the sources after a failing one are synced")
+	assert.Empty(t, summary.Error)
+	assert.Equal(t, 2, summary.ReposProcessed)
+	assert.Equal(t, 1, summary.CommitsUpserted)
+	assert.Contains(t, err.Error(), CauseFetchRepositories+" (1)")
+
+	summary, err = sync(denied, denied)
+	assert.Equal(t, RunFailed, summary.Status)
+	assert.Equal(t, 0, summary.ReposProcessed)
+	assert.ErrorContains(t, err, "failed to fetch repositories of acme")
+}
@@ -0,0 +1,284 @@
+package bitbucket
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// gitLabProvider reads the projects of a GitLab group and its subgroups through the REST API v4.
+type gitLabProvider struct {
+	group  string
+	apiURL string
+	client HTTPClient
+}
+
+func newGitLabProvider(group, baseURL string, authenticator auth.Authenticator) *gitLabProvider {
+	return &gitLabProvider{group: group, apiURL: baseURL + "/api/v4", client: authenticatedClient(authenticator)}
+}
+
+type glUser struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+func (u glUser) account() Account {
+	return Account{DisplayName: u.Name, Nickname: u.Username}
+}
+
+type glProject struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Namespace struct {
+		Name     string `json:"name"`
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+}
+
+type glCommit struct {
+	ID           string    `json:"id"`
+	Message      string    `json:"message"`
+	AuthorName   string    `json:"author_name"`
+	AuthorEmail  string    `json:"author_email"`
+	AuthoredDate time.Time `json:"authored_date"`
+	ParentIDs    []string  `json:"parent_ids"`
+}
+
+type glDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	Diff        string `json:"diff"`
+}
+
+type glMergeRequest struct {
+	IID             int        `json:"iid"`
+	Title           string     `json:"title"`
+	State           string     `json:"state"`
+	Author          glUser     `json:"author"`
+	SourceBranch    string     `json:"source_branch"`
+	TargetBranch    string     `json:"target_branch"`
+	MergeCommitSHA  string     `json:"merge_commit_sha"`
+	SquashCommitSHA string     `json:"squash_commit_sha"`
+	UserNotesCount  int        `json:"user_notes_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	MergedAt        *time.Time `json:"merged_at"`
+	ClosedAt        *time.Time `json:"closed_at"`
+}
+
+type glNote struct {
+	Type      string    `json:"type"`
+	Body      string    `json:"body"`
+	System    bool      `json:"system"`
+	Author    glUser    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Position  *struct {
+		NewPath string `json:"new_path"`
+	} `json:"position"`
+}
+
+// Name returns ProviderGitLab.
+func (g *gitLabProvider) Name() string {
+	return ProviderGitLab
+}
+
+// Workspace returns the group.
+func (g *gitLabProvider) Workspace() string {
+	return g.group
+}
+
+// Repositories fetches the projects of the group and its subgroups. The namespace of a project is its project.
+func (g *gitLabProvider) Repositories() ([]Repository, error) {
+	values, err := fetchLinkedPages[glProject]("gitlab_repositories", g.apiURL+"/groups/"+url.PathEscape(g.group)+"/projects?include_subgroups=true&per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]Repository, len(values))
+	for i, value := range values {
+		repos[i].Name = value.Name
+		repos[i].Slug = value.Path
+		repos[i].Project.Key = value.Namespace.FullPath
+		repos[i].Project.Name = value.Namespace.Name
+	}
+	slog.Debug("Fetched repositories", logging.KeyWorkspace, g.Workspace(), "count", len(repos))
+	return repos, nil
+}
+
+// Commits fetches the commits of the default branch of repo.
+func (g *gitLabProvider) Commits(repo Repository) ([]CommitDetails, error) {
+	values, err := fetchLinkedPages[glCommit]("gitlab_commits", g.projectURL(repo)+"/repository/commits?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	slog.Debug("Fetched commits", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, "count", len(commits))
+	return commits, nil
+}
+
+// CommitDetails fetches a commit of repo and counts its changed files and lines from its diff.
+func (g *gitLabProvider) CommitDetails(repo Repository, commitHash string) (CommitDetails, error) {
+	commitURL := g.projectURL(repo) + "/repository/commits/" + url.PathEscape(commitHash)
+	var commit glCommit
+	if _, err := getJSON("gitlab_commit", commitURL, g.client, &commit); err != nil {
+		return CommitDetails{}, err
+	}
+	diffs, err := fetchLinkedPages[glDiff]("gitlab_diff", commitURL+"/diff?per_page=100", g.client)
+	if err != nil {
+		return CommitDetails{}, err
+	}
+
+	details := commit.details()
+	details.Files = make([]FileChange, len(diffs))
+	for i, diff := range diffs {
+		change := FileChange{Path: diff.NewPath, Type: "modified"}
+		switch {
+		case diff.NewFile:
+			change.Type = "added"
+		case diff.DeletedFile:
+			change.Type = "removed"
+			change.Path = diff.OldPath
+		case diff.RenamedFile:
+			change.Type = "renamed"
+		}
+		// GitLab diffs carry no file headers, so every line starting with + or - is a changed line
+		for _, line := range strings.Split(diff.Diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				change.LinesAdded++
+			case strings.HasPrefix(line, "-"):
+				change.LinesDeleted++
+			}
+		}
+		details.Summary.LinesAdded += change.LinesAdded
+		details.Summary.LinesDeleted += change.LinesDeleted
+		details.Files[i] = change
+	}
+
+	slog.Debug("Fetched commit details and diff", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, logging.KeyCommit, commitHash)
+	return details, nil
+}
+
+// PullRequests fetches the merge requests of repo in any state. Closed merge requests are reported as declined,
+// as Bitbucket does.
+func (g *gitLabProvider) PullRequests(repo Repository) ([]PullRequestDetails, error) {
+	values, err := fetchLinkedPages[glMergeRequest]("gitlab_mergerequests", g.projectURL(repo)+"/merge_requests?state=all&per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	pullRequests := make([]PullRequestDetails, len(values))
+	for i, value := range values {
+		pr := &pullRequests[i]
+		pr.ID = value.IID
+		pr.Title = value.Title
+		switch value.State {
+		case "merged":
+			pr.State = "MERGED"
+			if value.MergedAt != nil {
+				pr.ClosedOn = *value.MergedAt
+			}
+		case "closed":
+			pr.State = "DECLINED"
+			if value.ClosedAt != nil {
+				pr.ClosedOn = *value.ClosedAt
+			}
+		default:
+			pr.State = "OPEN"
+		}
+		pr.Author.DisplayName = value.Author.Name
+		pr.Author.Nickname = value.Author.Username
+		pr.Source.Branch.Name = value.SourceBranch
+		pr.Destination.Branch.Name = value.TargetBranch
+		pr.MergeCommit.Hash = value.MergeCommitSHA
+		if pr.MergeCommit.Hash == "" {
+			pr.MergeCommit.Hash = value.SquashCommitSHA
+		}
+		pr.CommentCount = value.UserNotesCount
+		pr.CreatedOn = value.CreatedAt
+		pr.UpdatedOn = value.UpdatedAt
+	}
+	slog.Debug("Fetched merge requests", logging.KeyWorkspace, g.Workspace(), logging.KeyRepo, repo.Slug, "count", len(pullRequests))
+	return pullRequests, nil
+}
+
+// PullRequestActivity fetches the notes of a merge request. Comments are user notes, while approvals, change
+// requests and pushed commits are recognized from the system notes GitLab records for them.
+func (g *gitLabProvider) PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error) {
+	values, err := fetchLinkedPages[glNote]("gitlab_notes", g.projectURL(repo)+"/merge_requests/"+url.PathEscape(pullRequestID)+"/notes?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := []PullRequestActivity{}
+	for _, value := range values {
+		user := value.Author.account()
+		switch {
+		case !value.System:
+			comment := &ActivityComment{CreatedOn: value.CreatedAt, User: user}
+			if value.Type == "DiffNote" && value.Position != nil {
+				comment.Inline = &CommentInline{Path: value.Position.NewPath}
+			}
+			activity = append(activity, PullRequestActivity{Comment: comment})
+		case strings.HasPrefix(value.Body, "approved this merge request"):
+			activity = append(activity, PullRequestActivity{Approval: &ActivityReview{Date: value.CreatedAt, User: user}})
+		case strings.HasPrefix(value.Body, "requested changes"):
+			activity = append(activity, PullRequestActivity{ChangesRequested: &ActivityReview{Date: value.CreatedAt, User: user}})
+		case strings.HasPrefix(value.Body, "added ") && strings.Contains(value.Body, "commit"):
+			activity = append(activity, PullRequestActivity{Update: &ActivityUpdate{State: "OPEN", Date: value.CreatedAt, Author: user}})
+		}
+	}
+	return activity, nil
+}
+
+// PullRequestCommits fetches the commits of a merge request.
+func (g *gitLabProvider) PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error) {
+	values, err := fetchLinkedPages[glCommit]("gitlab_mergerequest_commits", g.projectURL(repo)+"/merge_requests/"+url.PathEscape(pullRequestID)+"/commits?per_page=100", g.client)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]CommitDetails, len(values))
+	for i, value := range values {
+		commits[i] = value.details()
+	}
+	return commits, nil
+}
+
+// Pipelines returns nothing, as GitLab CI pipelines are not collected.
+func (g *gitLabProvider) Pipelines(repo Repository) ([]PipelineDetails, error) {
+	return nil, nil
+}
+
+// projectURL addresses a project by its URL-encoded full path.
+func (g *gitLabProvider) projectURL(repo Repository) string {
+	return g.apiURL + "/projects/" + url.PathEscape(repo.Project.Key+"/"+repo.Slug)
+}
+
+func (c glCommit) details() CommitDetails {
+	var details CommitDetails
+	details.Hash = c.ID
+	details.Message = c.Message
+	details.Date = c.AuthoredDate
+	details.Author.Raw = c.AuthorName
+	if c.AuthorEmail != "" {
+		details.Author.Raw = fmt.Sprintf("%s <%s>", c.AuthorName, c.AuthorEmail)
+	}
+	details.Author.User.DisplayName = c.AuthorName
+	for _, parent := range c.ParentIDs {
+		details.Parents = append(details.Parents, CommitParent{Hash: parent})
+	}
+	return details
+}
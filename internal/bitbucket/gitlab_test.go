@@ -0,0 +1,153 @@
+package bitbucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeGitLab serves the GitLab group acme with the project acme/backend/api, which has one commit
+// and one merged merge request.
+func newFakeGitLab(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/groups/acme/projects", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("include_subgroups"))
+		w.Write([]byte(`[{"name": "API", "path": "api", "namespace": {"name": "Backend", "full_path": "acme/backend"}}]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/repository/commits", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v4/projects/acme%2Fbackend%2Fapi/repository/commits", r.URL.EscapedPath())
+		w.Write([]byte(`[{"id": "commit1"}]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/repository/commits/commit1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "commit1",
+			"message": "fix(api): reject empty queries",
+			"author_name": "Jane Doe",
+			"author_email": "jane@example.com",
+			"authored_date": "2024-07-16T12:28:45.000+02:00",
+			"parent_ids": ["parent1"]
+		}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/repository/commits/commit1/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"old_path": "old.go", "new_path": "old.go", "deleted_file": true, "diff": "@@ -1,2 +0,0 @@\n-package old\n-\n"}]`))
+			return
+		}
+		w.Header().Set("Link", `<http://`+r.Host+r.URL.EscapedPath()+`?page=2>; rel="next"`)
+		w.Write([]byte(`[
+			{"old_path": "query.go", "new_path": "query.go", "diff": "@@ -1,3 +1,4 @@\n-func q() {}\n+func q() error {\n+\treturn nil\n+}\n"},
+			{"old_path": "a.go", "new_path": "b.go", "renamed_file": true, "diff": ""}
+		]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"iid": 3, "title": "Reject empty queries", "state": "merged", "author": {"username": "jdoe", "name": "Jane Doe"},
+				"source_branch": "empty", "target_branch": "main", "squash_commit_sha": "squash1", "user_notes_count": 2,
+				"created_at": "2024-07-16T09:00:00Z", "updated_at": "2024-07-17T10:00:00Z", "merged_at": "2024-07-17T09:00:00Z"},
+			{"iid": 4, "title": "Drop search", "state": "closed", "author": {"username": "jdoe", "name": "Jane Doe"},
+				"created_at": "2024-07-16T09:00:00Z", "closed_at": "2024-07-16T12:00:00Z"}
+		]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/merge_requests/3/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": "commit1", "author_name": "Jane Doe", "authored_date": "2024-07-16T08:00:00Z"}]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{project}/merge_requests/3/notes", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"type": "DiffNote", "body": "Check nil", "author": {"username": "rroe", "name": "Rick Roe"}, "created_at": "2024-07-16T10:00:00Z",
+				"position": {"new_path": "query.go"}},
+			{"body": "added 1 commit\n\n* commit2", "system": true, "author": {"username": "jdoe", "name": "Jane Doe"}, "created_at": "2024-07-16T11:00:00Z"},
+			{"body": "approved this merge request", "system": true, "author": {"username": "rroe", "name": "Rick Roe"}, "created_at": "2024-07-17T08:00:00Z"},
+			{"body": "changed the description", "system": true, "author": {"username": "jdoe", "name": "Jane Doe"}, "created_at": "2024-07-17T08:30:00Z"}
+		]`))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gl_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	oldHTTPClient := httpClient
+	httpClient = server.Client()
+	t.Cleanup(func() { httpClient = oldHTTPClient })
+	return server
+}
+
+func gitLabRepo() Repository {
+	repo := Repository{Name: "API", Slug: "api"}
+	repo.Project.Key = "acme/backend"
+	repo.Project.Name = "Backend"
+	return repo
+}
+
+func TestGitLab_Repositories(t *testing.T) {
+	server := newFakeGitLab(t)
+	provider := newGitLabProvider("acme", server.URL, auth.Bearer{Token: "gl_token"})
+
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	assert.Equal(t, []Repository{gitLabRepo()}, repos)
+}
+
+func TestGitLab_CommitDetails(t *testing.T) {
+	server := newFakeGitLab(t)
+	provider := newGitLabProvider("acme", server.URL, auth.Bearer{Token: "gl_token"})
+
+	commits, err := provider.Commits(gitLabRepo())
+	assert.NoError(t, err)
+	assert.Len(t, commits, 1)
+
+	details, err := provider.CommitDetails(gitLabRepo(), "commit1")
+	assert.NoError(t, err)
+	assert.Equal(t, "fix(api): reject empty queries", details.Message)
+	assert.True(t, time.Date(2024, 7, 16, 10, 28, 45, 0, time.UTC).Equal(details.Date))
+	assert.Equal(t, "Jane Doe <jane@example.com>", details.Author.Raw)
+	assert.Equal(t, []CommitParent{{Hash: "parent1"}}, details.Parents)
+	assert.Equal(t, 3, details.Summary.LinesAdded)
+	assert.Equal(t, 3, details.Summary.LinesDeleted)
+	assert.Equal(t, []FileChange{
+		{Path: "query.go", Type: "modified", LinesAdded: 3, LinesDeleted: 1},
+		{Path: "b.go", Type: "renamed"},
+		{Path: "old.go", Type: "removed", LinesDeleted: 2},
+	}, details.Files)
+}
+
+func TestGitLab_MergeRequests(t *testing.T) {
+	server := newFakeGitLab(t)
+	provider := newGitLabProvider("acme", server.URL, auth.Bearer{Token: "gl_token"})
+
+	pullRequests, err := provider.PullRequests(gitLabRepo())
+	assert.NoError(t, err)
+	if assert.Len(t, pullRequests, 2) {
+		merged := pullRequests[0]
+		assert.Equal(t, 3, merged.ID)
+		assert.Equal(t, "MERGED", merged.State)
+		assert.Equal(t, "squash1", merged.MergeCommit.Hash)
+		assert.Equal(t, 2, merged.CommentCount)
+		assert.Equal(t, "Jane Doe", merged.Author.DisplayName)
+		assert.Equal(t, time.Date(2024, 7, 17, 9, 0, 0, 0, time.UTC), merged.ClosedOn)
+		assert.Equal(t, "DECLINED", pullRequests[1].State)
+	}
+
+	activity, err := provider.PullRequestActivity(gitLabRepo(), "3")
+	assert.NoError(t, err)
+	if assert.Len(t, activity, 3) {
+		assert.Equal(t, &CommentInline{Path: "query.go"}, activity[0].Comment.Inline)
+		assert.Equal(t, "OPEN", activity[1].Update.State)
+		assert.Equal(t, "rroe", activity[2].Approval.User.Nickname)
+	}
+
+	commits, err := provider.PullRequestCommits(gitLabRepo(), "3")
+	assert.NoError(t, err)
+	if assert.Len(t, commits, 1) {
+		assert.Equal(t, "commit1", commits[0].Hash)
+		assert.Equal(t, time.Date(2024, 7, 16, 8, 0, 0, 0, time.UTC), commits[0].Date)
+	}
+}
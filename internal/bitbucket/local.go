@@ -0,0 +1,226 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// localProvider reads commits from local clones, bare or not, without any API calls. It has no pull requests
+// or pipelines. Every clone belongs to the project given.
+type localProvider struct {
+	name        string
+	workspace   string
+	path        string
+	projectKey  string
+	projectName string
+	repos       map[string]*git.Repository
+}
+
+func newLocalProvider(name, workspace, path, projectKey, projectName string) *localProvider {
+	return &localProvider{
+		name:        name,
+		workspace:   workspace,
+		path:        path,
+		projectKey:  projectKey,
+		projectName: projectName,
+		repos:       map[string]*git.Repository{},
+	}
+}
+
+// Name returns the provider the clones mirror.
+func (l *localProvider) Name() string {
+	return l.name
+}
+
+// Workspace returns the workspace the clones are stored under.
+func (l *localProvider) Workspace() string {
+	return l.workspace
+}
+
+// Repositories opens the clone at the path of the provider or, when the path is no clone itself, every clone
+// directly inside it. A clone is named after its directory without the .git suffix.
+func (l *localProvider) Repositories() ([]Repository, error) {
+	if r, err := git.PlainOpen(l.path); err == nil {
+		return []Repository{l.add(l.path, r)}, nil
+	}
+
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clones: %w", err)
+	}
+	var repos []Repository
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(l.path, entry.Name())
+		r, err := git.PlainOpen(dir)
+		if err != nil {
+			slog.Warn("Skipping directory that is not a git clone", logging.KeyWorkspace, l.Workspace(), "dir", dir, logging.KeyError, err)
+			continue
+		}
+		repos = append(repos, l.add(dir, r))
+	}
+	slog.Debug("Opened local clones", logging.KeyWorkspace, l.Workspace(), "path", l.path, "count", len(repos))
+	return repos, nil
+}
+
+func (l *localProvider) add(dir string, r *git.Repository) Repository {
+	slug := strings.TrimSuffix(filepath.Base(dir), ".git")
+	l.repos[slug] = r
+	repo := Repository{Name: slug, Slug: slug}
+	repo.Project.Key = l.projectKey
+	repo.Project.Name = l.projectName
+	return repo
+}
+
+// Commits walks the history of the HEAD of repo, newest first.
+func (l *localProvider) Commits(repo Repository) ([]CommitDetails, error) {
+	r, err := l.open(repo)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := r.Log(&git.LogOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer iter.Close()
+
+	var commits []CommitDetails
+	err = iter.ForEach(func(c *object.Commit) error {
+		commits = append(commits, localCommit(c))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	slog.Debug("Fetched commits", logging.KeyWorkspace, l.Workspace(), logging.KeyRepo, repo.Slug, "count", len(commits))
+	return commits, nil
+}
+
+// CommitDetails reads a commit of repo and the numstat of its changes against its first parent, as the
+// Bitbucket diffstat reports them.
+func (l *localProvider) CommitDetails(repo Repository, commitHash string) (CommitDetails, error) {
+	r, err := l.open(repo)
+	if err != nil {
+		return CommitDetails{}, err
+	}
+	commit, err := r.CommitObject(plumbing.NewHash(commitHash))
+	if err != nil {
+		return CommitDetails{}, fmt.Errorf("failed to read commit %s: %w", commitHash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return CommitDetails{}, err
+	}
+	// Root commits are compared with the empty tree
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return CommitDetails{}, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return CommitDetails{}, err
+		}
+	}
+	changes, err := object.DiffTreeWithOptions(context.Background(), parentTree, tree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return CommitDetails{}, fmt.Errorf("failed to diff commit %s: %w", commitHash, err)
+	}
+
+	details := localCommit(commit)
+	details.Files = make([]FileChange, len(changes))
+	for i, change := range changes {
+		file, err := localFileChange(change)
+		if err != nil {
+			return CommitDetails{}, fmt.Errorf("failed to diff commit %s: %w", commitHash, err)
+		}
+		details.Summary.LinesAdded += file.LinesAdded
+		details.Summary.LinesDeleted += file.LinesDeleted
+		details.Files[i] = file
+	}
+
+	slog.Debug("Read commit details and numstat", logging.KeyWorkspace, l.Workspace(), logging.KeyRepo, repo.Slug, logging.KeyCommit, commitHash)
+	return details, nil
+}
+
+// PullRequests returns nothing, as clones hold no pull requests.
+func (l *localProvider) PullRequests(repo Repository) ([]PullRequestDetails, error) {
+	return nil, nil
+}
+
+// PullRequestActivity returns nothing, as clones hold no pull requests.
+func (l *localProvider) PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error) {
+	return nil, nil
+}
+
+// PullRequestCommits returns nothing, as clones hold no pull requests.
+func (l *localProvider) PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error) {
+	return nil, nil
+}
+
+// Pipelines returns nothing, as clones hold no pipelines.
+func (l *localProvider) Pipelines(repo Repository) ([]PipelineDetails, error) {
+	return nil, nil
+}
+
+func (l *localProvider) open(repo Repository) (*git.Repository, error) {
+	r, ok := l.repos[repo.Slug]
+	if !ok {
+		return nil, fmt.Errorf("unknown local repository %q", repo.Slug)
+	}
+	return r, nil
+}
+
+func localCommit(c *object.Commit) CommitDetails {
+	var details CommitDetails
+	details.Hash = c.Hash.String()
+	details.Message = c.Message
+	details.Date = c.Author.When.UTC()
+	details.Author.Raw = fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email)
+	details.Author.User.DisplayName = c.Author.Name
+	for _, parent := range c.ParentHashes {
+		details.Parents = append(details.Parents, CommitParent{Hash: parent.String()})
+	}
+	return details
+}
+
+// localFileChange maps a tree change to a file change with the lines its patch adds and deletes.
+func localFileChange(change *object.Change) (FileChange, error) {
+	action, err := change.Action()
+	if err != nil {
+		return FileChange{}, err
+	}
+	file := FileChange{Path: change.To.Name, Type: "modified"}
+	switch {
+	case action == merkletrie.Insert:
+		file.Type = "added"
+	case action == merkletrie.Delete:
+		file.Type = "removed"
+		file.Path = change.From.Name
+	case change.From.Name != change.To.Name:
+		file.Type = "renamed"
+	}
+
+	patch, err := change.Patch()
+	if err != nil {
+		return FileChange{}, err
+	}
+	for _, stat := range patch.Stats() {
+		file.LinesAdded += stat.Addition
+		file.LinesDeleted += stat.Deletion
+	}
+	return file, nil
+}
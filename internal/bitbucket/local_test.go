@@ -0,0 +1,156 @@
+package bitbucket
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var localAuthor = &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Date(2024, 7, 16, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))}
+
+// newLocalClones creates a directory holding the bare clone repo1.git, whose history is a root commit adding
+// a.txt and b.txt and a commit changing a.txt, deleting b.txt and adding c.txt. It returns the directory and
+// the hashes of both commits.
+func newLocalClones(t *testing.T) (string, string, string) {
+	work := t.TempDir()
+	r, err := git.PlainInit(work, false)
+	require.NoError(t, err)
+	tree, err := r.Worktree()
+	require.NoError(t, err)
+
+	commit := func(message string, files map[string]string) string {
+		for name, content := range files {
+			if content == "" {
+				_, err := tree.Remove(name)
+				require.NoError(t, err)
+				continue
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(content), 0o644))
+			_, err := tree.Add(name)
+			require.NoError(t, err)
+		}
+		hash, err := tree.Commit(message, &git.CommitOptions{Author: localAuthor})
+		require.NoError(t, err)
+		return hash.String()
+	}
+	first := commit("Initial commit", map[string]string{"a.txt": "one\ntwo\n", "b.txt": "bee\n"})
+	second := commit("feat: add c", map[string]string{"a.txt": "one\n2\nthree\n", "b.txt": "", "c.txt": "sea\n"})
+
+	clones := t.TempDir()
+	_, err = git.PlainClone(filepath.Join(clones, "repo1.git"), true, &git.CloneOptions{URL: work})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(clones, "README"), []byte("not a clone"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(clones, "empty"), 0o755))
+	return clones, first, second
+}
+
+func TestLocal_CommitDetails(t *testing.T) {
+	clones, first, second := newLocalClones(t)
+	provider := newLocalProvider(ProviderBitbucket, "lep13", clones, "", "")
+
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	assert.Equal(t, []Repository{{Name: "repo1", Slug: "repo1"}}, repos)
+
+	commits, err := provider.Commits(repos[0])
+	assert.NoError(t, err)
+	if assert.Len(t, commits, 2) {
+		assert.Equal(t, second, commits[0].Hash)
+		assert.Equal(t, first, commits[1].Hash)
+	}
+
+	details, err := provider.CommitDetails(repos[0], second)
+	assert.NoError(t, err)
+	assert.Equal(t, "feat: add c", details.Message)
+	assert.Equal(t, time.Date(2024, 7, 16, 10, 0, 0, 0, time.UTC), details.Date)
+	assert.Equal(t, "Jane Doe <jane@example.com>", details.Author.Raw)
+	assert.Equal(t, []CommitParent{{Hash: first}}, details.Parents)
+	assert.Equal(t, 3, details.Summary.LinesAdded)
+	assert.Equal(t, 2, details.Summary.LinesDeleted)
+	assert.ElementsMatch(t, []FileChange{
+		{Path: "a.txt", Type: "modified", LinesAdded: 2, LinesDeleted: 1},
+		{Path: "b.txt", Type: "removed", LinesDeleted: 1},
+		{Path: "c.txt", Type: "added", LinesAdded: 1},
+	}, details.Files)
+
+	root, err := provider.CommitDetails(repos[0], first)
+	assert.NoError(t, err)
+	assert.Empty(t, root.Parents)
+	assert.Equal(t, 3, root.Summary.LinesAdded)
+	assert.Len(t, root.Files, 2)
+}
+
+func TestLocal_SingleClone(t *testing.T) {
+	clones, _, _ := newLocalClones(t)
+	provider := newLocalProvider(ProviderBitbucket, "lep13", filepath.Join(clones, "repo1.git"), "MIR", "Mirrors")
+
+	repos, err := provider.Repositories()
+	assert.NoError(t, err)
+	expected := Repository{Name: "repo1", Slug: "repo1"}
+	expected.Project.Key = "MIR"
+	expected.Project.Name = "Mirrors"
+	assert.Equal(t, []Repository{expected}, repos)
+
+	_, err = provider.Commits(Repository{Slug: "repo2"})
+	assert.ErrorContains(t, err, "unknown local repository")
+}
+
+func TestFetchAndSaveCommits_LocalSource(t *testing.T) {
+	clones, _, second := newLocalClones(t)
+
+	oldCfg := cfg
+	sourceCfg := *cfg
+	sourceCfg.Sources = []config.Source{{Type: SourceLocal, Path: clones, ProjectKey: "MIR"}}
+	cfg = &sourceCfg
+	defer func() { cfg = oldCfg }()
+
+	commits := new(MockCollection)
+	commits.On("UpdateOne", mock.Anything, bson.M{
+		"provider": ProviderBitbucket, "workspace": "lep13", "project_key": "MIR", "repo_name": "repo1", "commit_id": second,
+	}, mock.MatchedBy(func(update bson.M) bool {
+		commit := update["$set"].(Commit)
+		return commit.Provider == ProviderBitbucket &&
+			commit.Workspace == "lep13" &&
+			commit.ProjectName == "MIR" &&
+			commit.RepoName == "repo1" &&
+			commit.CommittedBy == "Jane Doe" &&
+			commit.LinesAdded == 3 &&
+			commit.FilesAdded == 1 &&
+			commit.FilesDeleted == 1 &&
+			commit.FilesUpdated == 1 &&
+			commit.CommitType == "feat"
+	}), mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+	commits.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&mongo.UpdateResult{}, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	db.GetCollectionFunc = func() db.CollectionInterface {
+		return commits
+	}
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+
+	oldGetNamedCollection := db.GetNamedCollectionFunc
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		return new(MockCollection)
+	}
+	defer func() { db.GetNamedCollectionFunc = oldGetNamedCollection }()
+
+	oldHTTPClient := httpClient
+	httpClient = new(MockHTTPClient)
+	defer func() { httpClient = oldHTTPClient }()
+
+	_, err := FetchAndSaveCommits("fake_token")
+	assert.NoError(t, err)
+
+	commits.AssertExpectations(t)
+}
@@ -2,6 +2,8 @@ package bitbucket
 
 import (
 	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/issues"
 )
 
 // Repository struct
@@ -9,6 +11,7 @@ type Repository struct {
 	Name    string `json:"name"`
 	Slug    string `json:"slug"`
 	Project struct {
+		Key  string `json:"key"`
 		Name string `json:"name"`
 	} `json:"project"`
 }
@@ -23,14 +26,14 @@ type CommitDetails struct {
 		LinesDeleted int `json:"lines_deleted"`
 	} `json:"summary"`
 	Author struct {
+		Raw  string `json:"raw"`
 		User struct {
 			DisplayName string `json:"display_name"`
+			UUID        string `json:"uuid"`
+			Nickname    string `json:"nickname"`
 		} `json:"user"`
 	} `json:"author"`
-	Files []struct {
-		Type string `json:"type"`
-		Path string `json:"path"`
-	} `json:"values"`
+	Files      []FileChange `json:"values"`
 	ReviewedBy struct {
 		User struct {
 			DisplayName string `json:"display_name"`
@@ -39,21 +42,219 @@ type CommitDetails struct {
 	PullRequest struct {
 		ID string `json:"id"`
 	} `json:"pullrequest,omitempty"`
+	Parents []CommitParent `json:"parents"`
+}
+
+// CommitParent is a parent of a commit.
+type CommitParent struct {
+	Hash string `json:"hash"`
 }
 
 // Commit struct
 type Commit struct {
-	ProjectName   string    `bson:"project_name"`
-	RepoName      string    `bson:"repo_name"`
-	CommitMessage string    `bson:"commit_message"`
-	LinesDeleted  int       `bson:"lines_deleted"`
-	CommitID      string    `bson:"commit_id"`
-	CommittedBy   string    `bson:"committed_by"`
-	LinesAdded    int       `bson:"lines_added"`
-	CommitDate    time.Time `bson:"commit_date"`
-	FilesAdded    int       `bson:"files_added"`
-	FilesDeleted  int       `bson:"files_deleted"`
-	FilesUpdated  int       `bson:"files_updated"`
-	ReviewedBy    string    `bson:"reviewed_by,omitempty"`
-	PullRequestID string    `bson:"pull_request_id,omitempty"`
-}
\ No newline at end of file
+	Provider       string         `bson:"provider,omitempty" json:"provider,omitempty"`
+	Workspace      string         `bson:"workspace" json:"workspace"`
+	ProjectKey     string         `bson:"project_key,omitempty" json:"project_key,omitempty"`
+	ProjectName    string         `bson:"project_name" json:"project_name"`
+	RepoName       string         `bson:"repo_name" json:"repo_name"`
+	CommitMessage  string         `bson:"commit_message" json:"commit_message"`
+	LinesDeleted   int            `bson:"lines_deleted" json:"lines_deleted"`
+	CommitID       string         `bson:"commit_id" json:"commit_id"`
+	CommittedBy    string         `bson:"committed_by" json:"committed_by"`
+	PersonID       string         `bson:"person_id" json:"person_id"`
+	AuthorRaw      string         `bson:"author_raw" json:"author_raw"`
+	AuthorEmail    string         `bson:"author_email,omitempty" json:"author_email,omitempty"`
+	AuthorUUID     string         `bson:"author_uuid,omitempty" json:"author_uuid,omitempty"`
+	AuthorNickname string         `bson:"author_nickname,omitempty" json:"author_nickname,omitempty"`
+	LinesAdded     int            `bson:"lines_added" json:"lines_added"`
+	CommitDate     time.Time      `bson:"commit_date" json:"commit_date"`
+	FilesAdded     int            `bson:"files_added" json:"files_added"`
+	FilesDeleted   int            `bson:"files_deleted" json:"files_deleted"`
+	FilesUpdated   int            `bson:"files_updated" json:"files_updated"`
+	ReviewedBy     string         `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	PullRequestID  string         `bson:"pull_request_id,omitempty" json:"pull_request_id,omitempty"`
+	Files          []FileChange   `bson:"files,omitempty" json:"files,omitempty"`
+	Conventional   bool           `bson:"conventional" json:"conventional"`
+	CommitType     string         `bson:"commit_type,omitempty" json:"commit_type,omitempty"`
+	CommitScope    string         `bson:"commit_scope,omitempty" json:"commit_scope,omitempty"`
+	Breaking       bool           `bson:"breaking,omitempty" json:"breaking,omitempty"`
+	IssueKeys      []string       `bson:"issue_keys,omitempty" json:"issue_keys,omitempty"`
+	Issues         []issues.Issue `bson:"issues,omitempty" json:"issues,omitempty"`
+	ParentHashes   []string       `bson:"parent_hashes,omitempty" json:"parent_hashes,omitempty"`
+	Classification string         `bson:"classification" json:"classification"`
+	Reverts        string         `bson:"reverts,omitempty" json:"reverts,omitempty"`
+	Rework         []ReworkLink   `bson:"rework,omitempty" json:"rework,omitempty"`
+	ReworkLines    int            `bson:"rework_lines,omitempty" json:"rework_lines,omitempty"`
+}
+
+// ReworkLink records that a commit changed again lines an earlier commit added to a file shortly before.
+type ReworkLink struct {
+	CommitID   string `bson:"commit_id" json:"commit_id"`
+	Path       string `bson:"path" json:"path"`
+	Lines      int    `bson:"lines" json:"lines"`
+	SameAuthor bool   `bson:"same_author" json:"same_author"`
+}
+
+// FileChange is one file touched by a commit, as reported by the diffstat.
+// Type is added, removed, modified or renamed.
+type FileChange struct {
+	Path         string `bson:"path" json:"path"`
+	Type         string `bson:"type" json:"type"`
+	LinesAdded   int    `bson:"lines_added" json:"lines_added"`
+	LinesDeleted int    `bson:"lines_deleted" json:"lines_deleted"`
+}
+
+// PullRequestDetails struct. ClosedOn is not reported by Bitbucket Cloud, whose pull requests close when they were last updated.
+type PullRequestDetails struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Author struct {
+		DisplayName string `json:"display_name"`
+		UUID        string `json:"uuid"`
+		Nickname    string `json:"nickname"`
+	} `json:"author"`
+	Source struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"source"`
+	Destination struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"destination"`
+	MergeCommit struct {
+		Hash string `json:"hash"`
+	} `json:"merge_commit"`
+	CommentCount int       `json:"comment_count"`
+	CreatedOn    time.Time `json:"created_on"`
+	UpdatedOn    time.Time `json:"updated_on"`
+	ClosedOn     time.Time `json:"-"`
+}
+
+// PullRequest struct
+type PullRequest struct {
+	Provider           string        `bson:"provider,omitempty" json:"provider,omitempty"`
+	Workspace          string        `bson:"workspace" json:"workspace"`
+	ProjectKey         string        `bson:"project_key,omitempty" json:"project_key,omitempty"`
+	ProjectName        string        `bson:"project_name" json:"project_name"`
+	RepoName           string        `bson:"repo_name" json:"repo_name"`
+	PullRequestID      string        `bson:"pull_request_id" json:"pull_request_id"`
+	Title              string        `bson:"title" json:"title"`
+	State              string        `bson:"state" json:"state"`
+	Author             string        `bson:"author" json:"author"`
+	AuthorPersonID     string        `bson:"author_person_id" json:"author_person_id"`
+	SourceBranch       string        `bson:"source_branch" json:"source_branch"`
+	DestinationBranch  string        `bson:"destination_branch" json:"destination_branch"`
+	MergeCommit        string        `bson:"merge_commit,omitempty" json:"merge_commit,omitempty"`
+	CommentCount       int           `bson:"comment_count" json:"comment_count"`
+	CreatedOn          time.Time     `bson:"created_on" json:"created_on"`
+	UpdatedOn          time.Time     `bson:"updated_on" json:"updated_on"`
+	ClosedOn           time.Time     `bson:"closed_on,omitempty" json:"closed_on,omitempty"`
+	FirstCommitOn      time.Time     `bson:"first_commit_on,omitempty" json:"first_commit_on,omitempty"`
+	FirstReviewOn      time.Time     `bson:"first_review_on,omitempty" json:"first_review_on,omitempty"`
+	ApprovedOn         time.Time     `bson:"approved_on,omitempty" json:"approved_on,omitempty"`
+	Events             []ReviewEvent `bson:"events,omitempty" json:"events,omitempty"`
+	ReviewRounds       int           `bson:"review_rounds,omitempty" json:"review_rounds,omitempty"`
+	UpdatesAfterReview int           `bson:"updates_after_review,omitempty" json:"updates_after_review,omitempty"`
+}
+
+// Review event types.
+const (
+	EventComment          = "comment"
+	EventInlineComment    = "inline_comment"
+	EventApproval         = "approval"
+	EventChangesRequested = "changes_requested"
+	EventUpdate           = "update"
+)
+
+// ReviewEvent is one entry of the review timeline of a pull request, stored oldest first when the activity URL
+// is configured. Updates are pushes to the source branch.
+type ReviewEvent struct {
+	Type          string    `bson:"type" json:"type"`
+	Actor         string    `bson:"actor" json:"actor"`
+	ActorPersonID string    `bson:"actor_person_id" json:"actor_person_id"`
+	ByAuthor      bool      `bson:"by_author" json:"by_author"`
+	Path          string    `bson:"path,omitempty" json:"path,omitempty"`
+	At            time.Time `bson:"at" json:"at"`
+}
+
+// Account is a Bitbucket user as referenced by the pull request activity.
+type Account struct {
+	DisplayName string `json:"display_name"`
+	UUID        string `json:"uuid"`
+	Nickname    string `json:"nickname"`
+}
+
+// PullRequestActivity is one entry of the activity feed of a pull request. Exactly one of its fields is set.
+type PullRequestActivity struct {
+	Update           *ActivityUpdate  `json:"update,omitempty"`
+	Approval         *ActivityReview  `json:"approval,omitempty"`
+	ChangesRequested *ActivityReview  `json:"changes_requested,omitempty"`
+	Comment          *ActivityComment `json:"comment,omitempty"`
+}
+
+// ActivityUpdate is a change of the state or the commits of a pull request.
+type ActivityUpdate struct {
+	State  string    `json:"state"`
+	Date   time.Time `json:"date"`
+	Author Account   `json:"author"`
+}
+
+// ActivityReview is an approval or a change request on a pull request.
+type ActivityReview struct {
+	Date time.Time `json:"date"`
+	User Account   `json:"user"`
+}
+
+// ActivityComment is a comment on a pull request. Inline is set for comments on a file.
+type ActivityComment struct {
+	CreatedOn time.Time      `json:"created_on"`
+	User      Account        `json:"user"`
+	Inline    *CommentInline `json:"inline,omitempty"`
+}
+
+// CommentInline is the file an inline comment is anchored to.
+type CommentInline struct {
+	Path string `json:"path"`
+}
+
+// PipelineDetails struct
+type PipelineDetails struct {
+	UUID        string `json:"uuid"`
+	BuildNumber int    `json:"build_number"`
+	State       struct {
+		Name   string `json:"name"`
+		Result struct {
+			Name string `json:"name"`
+		} `json:"result"`
+	} `json:"state"`
+	Target struct {
+		RefName string `json:"ref_name"`
+		Commit  struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"target"`
+	CreatedOn         time.Time `json:"created_on"`
+	CompletedOn       time.Time `json:"completed_on"`
+	DurationInSeconds int       `json:"duration_in_seconds"`
+}
+
+// Pipeline struct
+type Pipeline struct {
+	Provider        string    `bson:"provider,omitempty" json:"provider,omitempty"`
+	Workspace       string    `bson:"workspace" json:"workspace"`
+	ProjectKey      string    `bson:"project_key,omitempty" json:"project_key,omitempty"`
+	ProjectName     string    `bson:"project_name" json:"project_name"`
+	RepoName        string    `bson:"repo_name" json:"repo_name"`
+	PipelineID      string    `bson:"pipeline_id" json:"pipeline_id"`
+	BuildNumber     int       `bson:"build_number" json:"build_number"`
+	State           string    `bson:"state" json:"state"`
+	Result          string    `bson:"result,omitempty" json:"result,omitempty"`
+	RefName         string    `bson:"ref_name" json:"ref_name"`
+	CommitHash      string    `bson:"commit_hash" json:"commit_hash"`
+	CreatedOn       time.Time `bson:"created_on" json:"created_on"`
+	CompletedOn     time.Time `bson:"completed_on,omitempty" json:"completed_on,omitempty"`
+	DurationSeconds int       `bson:"duration_seconds" json:"duration_seconds"`
+}
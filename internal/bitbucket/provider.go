@@ -0,0 +1,234 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/auth"
+	"github.com/lep13/bitbucket_metrics/internal/urltemplate"
+)
+
+// Source types of the configured SCM instances.
+const (
+	SourceCloud      = "cloud"
+	SourceDataCenter = "datacenter"
+	SourceGitHub     = "github"
+	SourceGitLab     = "gitlab"
+	SourceLocal      = "local"
+)
+
+// Providers the collected commits, pull requests and pipelines are tagged with.
+const (
+	ProviderBitbucket           = "bitbucket"
+	ProviderBitbucketDataCenter = "bitbucket_datacenter"
+	ProviderGitHub              = "github"
+	ProviderGitLab              = "gitlab"
+)
+
+// Default API locations of the hosted GitHub and GitLab services.
+const (
+	defaultGitHubURL = "https://api.github.com"
+	defaultGitLabURL = "https://gitlab.com"
+)
+
+// Provider reads repositories, commits, pull requests and pipelines from an SCM instance
+// and maps them to the Bitbucket Cloud models.
+type Provider interface {
+	// Name is the provider the collected data is tagged with.
+	Name() string
+	// Workspace is the workspace the data of the provider is stored under.
+	Workspace() string
+	Repositories() ([]Repository, error)
+	Commits(repo Repository) ([]CommitDetails, error)
+	CommitDetails(repo Repository, commitHash string) (CommitDetails, error)
+	PullRequests(repo Repository) ([]PullRequestDetails, error)
+	// PullRequestActivity returns nil when the provider does not collect the activity of pull requests.
+	PullRequestActivity(repo Repository, pullRequestID string) ([]PullRequestActivity, error)
+	// PullRequestCommits returns nil when the provider does not collect the commits of pull requests.
+	PullRequestCommits(repo Repository, pullRequestID string) ([]CommitDetails, error)
+	Pipelines(repo Repository) ([]PipelineDetails, error)
+}
+
+// cloudProvider reads a Bitbucket Cloud workspace through the configured URL templates.
+type cloudProvider struct {
+	workspace string
+	client    HTTPClient
+}
+
+func newCloudProvider(workspace string, authenticator auth.Authenticator) *cloudProvider {
+	return &cloudProvider{workspace: workspace, client: authenticatedClient(authenticator)}
+}
+
+// Name returns ProviderBitbucket.
+func (c *cloudProvider) Name() string {
+	return ProviderBitbucket
+}
+
+// Workspace returns the Cloud workspace.
+func (c *cloudProvider) Workspace() string {
+	return c.workspace
+}
+
+// repoValues fills the placeholders of the URL templates of repo.
+func (c *cloudProvider) repoValues(repo Repository) urltemplate.Values {
+	return urltemplate.Values{urltemplate.Workspace: c.workspace, urltemplate.RepoSlug: repo.Slug}
+}
+
+// configuredProviders returns a provider for every source configured by c, or one for the default Cloud workspace
+// when no sources are configured. The default workspace and sources without credentials of their own
+// authenticate as configured by c.Auth, or with accessToken when no authentication is configured.
+func configuredProviders(c *config.Config, accessToken string) ([]Provider, error) {
+	var defaultAuth auth.Authenticator = auth.Bearer{Token: accessToken}
+	if c.Auth != nil {
+		var err error
+		if defaultAuth, err = auth.NewFromConfig(*c.Auth, sender{}); err != nil {
+			return nil, fmt.Errorf("invalid authentication: %w", err)
+		}
+	}
+	if len(c.Sources) == 0 {
+		return []Provider{newCloudProvider(workspace, defaultAuth)}, nil
+	}
+
+	providers := make([]Provider, 0, len(c.Sources))
+	for i, source := range c.Sources {
+		provider, err := newProvider(source, defaultAuth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %d: %w", i, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+func newProvider(source config.Source, defaultAuth auth.Authenticator) (Provider, error) {
+	authenticator := defaultAuth
+	switch {
+	case source.Auth != nil:
+		var err error
+		if authenticator, err = auth.NewFromConfig(*source.Auth, sender{}); err != nil {
+			return nil, err
+		}
+	case source.Token != "":
+		authenticator = auth.Bearer{Token: source.Token}
+	}
+
+	switch source.Type {
+	case SourceCloud, "":
+		name := source.Workspace
+		if name == "" {
+			name = workspace
+		}
+		return newCloudProvider(name, authenticator), nil
+	case SourceDataCenter:
+		baseURL, err := url.Parse(source.BaseURL)
+		if err != nil || baseURL.Host == "" {
+			return nil, fmt.Errorf("invalid base URL %q", source.BaseURL)
+		}
+		// Data Center has no workspaces, so its data is stored under the host it is served from
+		name := source.Workspace
+		if name == "" {
+			name = baseURL.Host
+		}
+		return newDataCenterProvider(name, strings.TrimSuffix(source.BaseURL, "/"), authenticator, source.Projects), nil
+	case SourceGitHub, SourceGitLab:
+		if source.Workspace == "" {
+			return nil, fmt.Errorf("%s sources need a workspace", source.Type)
+		}
+		baseURL := strings.TrimSuffix(source.BaseURL, "/")
+		if source.Type == SourceGitHub {
+			if baseURL == "" {
+				baseURL = defaultGitHubURL
+			}
+			return newGitHubProvider(source.Workspace, baseURL, authenticator), nil
+		}
+		if baseURL == "" {
+			baseURL = defaultGitLabURL
+		}
+		return newGitLabProvider(source.Workspace, baseURL, authenticator), nil
+	case SourceLocal:
+		if source.Path == "" {
+			return nil, fmt.Errorf("local sources need a path")
+		}
+		name, localWorkspace := source.Provider, source.Workspace
+		if name == "" {
+			name = ProviderBitbucket
+		}
+		if localWorkspace == "" {
+			localWorkspace = workspace
+		}
+		projectName := source.ProjectName
+		if projectName == "" {
+			projectName = source.ProjectKey
+		}
+		return newLocalProvider(name, localWorkspace, source.Path, source.ProjectKey, projectName), nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", source.Type)
+}
+
+// sender sends requests through httpClient, which tests replace.
+type sender struct{}
+
+func (sender) Do(req *http.Request) (*http.Response, error) {
+	return httpClient.Do(req)
+}
+
+func (sender) RoundTrip(req *http.Request) (*http.Response, error) {
+	return httpClient.Do(req)
+}
+
+// authenticatedClient returns a client that authenticates every request with authenticator.
+func authenticatedClient(authenticator auth.Authenticator) HTTPClient {
+	return &http.Client{Transport: &auth.Transport{Base: sender{}, Auth: authenticator}}
+}
+
+// getJSON fetches requestURL and decodes its JSON response into v. It returns the response headers.
+func getJSON(endpoint, requestURL string, client HTTPClient, v interface{}) (http.Header, error) {
+	resp, err := doRequest(endpoint, requestURL, client)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch %s: status %d: %s", requestURL, resp.StatusCode, string(body))
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fetchLinkedPages fetches every page of a JSON array resource paged through the "next" relation of the
+// Link header, as GitHub and GitLab do.
+func fetchLinkedPages[T any](endpoint, requestURL string, client HTTPClient) ([]T, error) {
+	var all []T
+	for requestURL != "" {
+		var page []T
+		header, err := getJSON(endpoint, requestURL, client, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		requestURL = nextLink(header.Get("Link"))
+	}
+	return all, nil
+}
+
+// nextLink returns the URL of the "next" relation of a Link header, or "" on the last page.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, found := strings.Cut(link, ";")
+		if !found {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package bitbucket
+
+import (
+	"context"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// store receives the documents a sync produces.
+type store interface {
+	saveCommit(commit Commit) error
+	savePullRequest(pr PullRequest) error
+	savePipeline(pipeline Pipeline) error
+}
+
+// mongoStore upserts the documents into their collections, counting failures before returning them.
+type mongoStore struct{}
+
+func (mongoStore) saveCommit(newCommit Commit) error {
+	_, err := db.GetCollection().UpdateOne(
+		context.Background(),
+		commitFilter(newCommit),
+		bson.M{"$set": newCommit},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		metrics.UpsertFailures.WithLabelValues(db.CommitsCollection).Inc()
+	}
+	return err
+}
+
+func (mongoStore) savePullRequest(newPullRequest PullRequest) error {
+	_, err := db.GetNamedCollection(db.PullRequestsCollection).UpdateOne(
+		context.Background(),
+		pullRequestFilter(newPullRequest),
+		bson.M{"$set": newPullRequest},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		metrics.UpsertFailures.WithLabelValues(db.PullRequestsCollection).Inc()
+	}
+	return err
+}
+
+func (mongoStore) savePipeline(newPipeline Pipeline) error {
+	_, err := db.GetNamedCollection(db.PipelinesCollection).UpdateOne(
+		context.Background(),
+		pipelineFilter(newPipeline),
+		bson.M{"$set": newPipeline},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		metrics.UpsertFailures.WithLabelValues(db.PipelinesCollection).Inc()
+	}
+	return err
+}
+
+// commitFilter selects the stored document of commit, scoped like pullRequestFilter: forks and mirrors share
+// commit hashes across repositories.
+func commitFilter(commit Commit) bson.M {
+	return bson.M{
+		"provider":    commit.Provider,
+		"workspace":   commit.Workspace,
+		"project_key": commit.ProjectKey,
+		"repo_name":   commit.RepoName,
+		"commit_id":   commit.CommitID,
+	}
+}
+
+// pullRequestFilter selects the stored document of pr. Pull request IDs are only unique within a repository,
+// and repositories of different providers and projects can share their name.
+func pullRequestFilter(pr PullRequest) bson.M {
+	return bson.M{
+		"provider":        pr.Provider,
+		"workspace":       pr.Workspace,
+		"project_key":     pr.ProjectKey,
+		"repo_name":       pr.RepoName,
+		"pull_request_id": pr.PullRequestID,
+	}
+}
+
+// pipelineFilter selects the stored document of pipeline, scoped like pullRequestFilter.
+func pipelineFilter(pipeline Pipeline) bson.M {
+	return bson.M{
+		"provider":    pipeline.Provider,
+		"workspace":   pipeline.Workspace,
+		"project_key": pipeline.ProjectKey,
+		"repo_name":   pipeline.RepoName,
+		"pipeline_id": pipeline.PipelineID,
+	}
+}
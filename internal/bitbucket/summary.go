@@ -0,0 +1,179 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// Run statuses. A run is partial when some documents failed, and failed when it could not go through the
+// repositories or saved nothing while failing.
+const (
+	RunSucceeded = "succeeded"
+	RunPartial   = "partial"
+	RunFailed    = "failed"
+)
+
+// Causes the errors of a run are grouped by.
+const (
+	CauseFetchRepositories  = "fetch_repositories"
+	CauseFetchCommits       = "fetch_commits"
+	CauseFetchCommitDetails = "fetch_commit_details"
+	CauseFetchPullRequests  = "fetch_pull_requests"
+	CauseFetchPRActivity    = "fetch_pull_request_activity"
+	CauseFetchPRCommits     = "fetch_pull_request_commits"
+	CauseFetchPipelines     = "fetch_pipelines"
+	CauseUpsertCommit       = "upsert_commit"
+	CauseUpsertPullRequest  = "upsert_pull_request"
+	CauseUpsertPipeline     = "upsert_pipeline"
+)
+
+// maxErrorSamples is how many messages an error group keeps.
+const maxErrorSamples = 3
+
+// ErrorGroup counts the errors of a run with the same cause, keeping the first few messages as samples.
+type ErrorGroup struct {
+	Cause   string   `bson:"cause" json:"cause"`
+	Count   int      `bson:"count" json:"count"`
+	Samples []string `bson:"samples" json:"samples"`
+}
+
+// RunSummary describes what a sync run did. It is stored in the runs collection.
+type RunSummary struct {
+	// RunID correlates the summary with the logs of the run.
+	RunID                string    `bson:"run_id" json:"run_id"`
+	StartedAt            time.Time `bson:"started_at" json:"started_at"`
+	FinishedAt           time.Time `bson:"finished_at" json:"finished_at"`
+	DurationSeconds      float64   `bson:"duration_seconds" json:"duration_seconds"`
+	Status               string    `bson:"status" json:"status"`
+	ReposProcessed       int       `bson:"repos_processed" json:"repos_processed"`
+	CommitsFetched       int       `bson:"commits_fetched" json:"commits_fetched"`
+	CommitsUpserted      int       `bson:"commits_upserted" json:"commits_upserted"`
+	CommitsFailed        int       `bson:"commits_failed" json:"commits_failed"`
+	PullRequestsUpserted int       `bson:"pull_requests_upserted" json:"pull_requests_upserted"`
+	PullRequestsFailed   int       `bson:"pull_requests_failed" json:"pull_requests_failed"`
+	PipelinesUpserted    int       `bson:"pipelines_upserted" json:"pipelines_upserted"`
+	PipelinesFailed      int       `bson:"pipelines_failed" json:"pipelines_failed"`
+	// Erased counts the commits and pull requests left out because their person was erased.
+	Erased int          `bson:"erased" json:"erased"`
+	Errors []ErrorGroup `bson:"errors" json:"errors"`
+	// Error is the failure that ended the run early, if any.
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+
+	// logger logs the run, with its ID on every entry.
+	logger *slog.Logger
+}
+
+// newRunSummary starts the summary of a run at the current time, under a new run ID.
+func newRunSummary() *RunSummary {
+	runID := logging.NewRunID()
+	return &RunSummary{
+		RunID:     runID,
+		StartedAt: time.Now().UTC(),
+		Errors:    []ErrorGroup{},
+		logger:    slog.Default().With(logging.KeyRunID, runID),
+	}
+}
+
+// recordError counts err under cause. Samples are stored without the credentials they may quote, as logs are.
+func (s *RunSummary) recordError(cause string, err error) {
+	sample := logging.Redact(err.Error())
+	for i := range s.Errors {
+		if s.Errors[i].Cause == cause {
+			s.Errors[i].Count++
+			if len(s.Errors[i].Samples) < maxErrorSamples {
+				s.Errors[i].Samples = append(s.Errors[i].Samples, sample)
+			}
+			return
+		}
+	}
+	s.Errors = append(s.Errors, ErrorGroup{Cause: cause, Count: 1, Samples: []string{sample}})
+}
+
+// ErrorCount returns the number of errors of the run.
+func (s *RunSummary) ErrorCount() int {
+	count := 0
+	for _, group := range s.Errors {
+		count += group.Count
+	}
+	return count
+}
+
+// finish stamps the end of the run and derives its status, fatal being the error that ended it early, if any.
+func (s *RunSummary) finish(fatal error) {
+	s.FinishedAt = time.Now().UTC()
+	s.DurationSeconds = s.FinishedAt.Sub(s.StartedAt).Seconds()
+	if fatal != nil {
+		s.Error = logging.Redact(fatal.Error())
+	}
+
+	saved := s.CommitsUpserted + s.PullRequestsUpserted + s.PipelinesUpserted
+	switch {
+	case fatal != nil, s.ErrorCount() > 0 && saved == 0:
+		s.Status = RunFailed
+	case s.ErrorCount() > 0:
+		s.Status = RunPartial
+	default:
+		s.Status = RunSucceeded
+	}
+}
+
+// Err returns nil when the run succeeded, or an error naming its failures by cause.
+func (s *RunSummary) Err() error {
+	if s.Error != "" {
+		return fmt.Errorf("sync %s: %s", s.Status, s.Error)
+	}
+	if s.ErrorCount() == 0 {
+		return nil
+	}
+	causes := make([]string, len(s.Errors))
+	for i, group := range s.Errors {
+		causes[i] = fmt.Sprintf("%s (%d)", group.Cause, group.Count)
+	}
+	sort.Strings(causes)
+	return fmt.Errorf("sync %s with %d errors: %s", s.Status, s.ErrorCount(), strings.Join(causes, ", "))
+}
+
+// recordRun persists the summary of a finished run. It is a variable so tests can keep runs out of MongoDB.
+var recordRun = saveRunSummary
+
+// saveRunSummary inserts summary into the runs collection.
+func saveRunSummary(summary *RunSummary) error {
+	if _, err := db.GetNamedCollection(db.RunsCollection).InsertOne(context.Background(), summary); err != nil {
+		return fmt.Errorf("failed to save run summary: %w", err)
+	}
+	return nil
+}
+
+// logRunSummary logs the outcome of a run at a level matching its status, with an entry per error cause.
+func logRunSummary(summary *RunSummary) {
+	level := slog.LevelInfo
+	switch summary.Status {
+	case RunPartial:
+		level = slog.LevelWarn
+	case RunFailed:
+		level = slog.LevelError
+	}
+	summary.logger.Log(context.Background(), level, "Sync run finished",
+		"status", summary.Status,
+		"duration_seconds", summary.DurationSeconds,
+		"repos_processed", summary.ReposProcessed,
+		"commits_fetched", summary.CommitsFetched,
+		"commits_upserted", summary.CommitsUpserted,
+		"commits_failed", summary.CommitsFailed,
+		"pull_requests_upserted", summary.PullRequestsUpserted,
+		"pull_requests_failed", summary.PullRequestsFailed,
+		"pipelines_upserted", summary.PipelinesUpserted,
+		"pipelines_failed", summary.PipelinesFailed,
+		"erased", summary.Erased,
+		"errors", summary.ErrorCount())
+	for _, group := range summary.Errors {
+		summary.logger.Warn("Sync errors by cause", "cause", group.Cause, "count", group.Count, "first", group.Samples[0])
+	}
+}
@@ -0,0 +1,93 @@
+package classify
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Commit classifications.
+const (
+	Regular = "regular"
+	Merge   = "merge"
+	Revert  = "revert"
+	Bot     = "bot"
+)
+
+// All lists every classification.
+var All = []string{Regular, Merge, Revert, Bot}
+
+// Excluded lists the classifications left out of aggregates unless asked for, as they do not reflect
+// the work of people.
+var Excluded = []string{Merge, Bot}
+
+// DefaultBotPatterns match the authors of common dependency bots and release tooling.
+var DefaultBotPatterns = []string{
+	`(?i)\[bot\]`,
+	`(?i)\bdependabot\b`,
+	`(?i)\brenovate\b`,
+	`(?i)\bsemantic-release\b`,
+	`(?i)\bbitbucket-pipelines\b`,
+}
+
+// revertMessage matches the messages git writes for reverts.
+var revertMessage = regexp.MustCompile(`(?i)^revert\b|This reverts commit [0-9a-f]{7,40}`)
+
+// Commit is what classification looks at.
+type Commit struct {
+	Message      string
+	ParentHashes []string
+	// Authors lists every name and address the author is known by, such as the raw author and display name.
+	Authors []string
+}
+
+// Classifier tells regular commits from merges, reverts and bot commits.
+type Classifier struct {
+	bots []*regexp.Regexp
+}
+
+// NewClassifier returns a classifier recognising bots by the given author patterns, or by
+// DefaultBotPatterns when none are given.
+func NewClassifier(botPatterns []string) (*Classifier, error) {
+	if len(botPatterns) == 0 {
+		botPatterns = DefaultBotPatterns
+	}
+	c := &Classifier{}
+	for _, pattern := range botPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot author pattern %q: %w", pattern, err)
+		}
+		c.bots = append(c.bots, re)
+	}
+	return c, nil
+}
+
+// Classify returns the classification of commit. Bot commits are bots even when they merge or revert,
+// and merges are merges even when they merge a revert.
+func (c *Classifier) Classify(commit Commit) string {
+	for _, author := range commit.Authors {
+		for _, bot := range c.bots {
+			if author != "" && bot.MatchString(author) {
+				return Bot
+			}
+		}
+	}
+	if len(commit.ParentHashes) > 1 {
+		return Merge
+	}
+	if revertMessage.MatchString(strings.TrimSpace(commit.Message)) {
+		return Revert
+	}
+	return Regular
+}
+
+// Valid reports whether classification is known.
+func Valid(classification string) bool {
+	for _, known := range All {
+		if classification == known {
+			return true
+		}
+	}
+	return false
+}
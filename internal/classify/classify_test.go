@@ -0,0 +1,48 @@
+package classify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	classifier, err := NewClassifier(nil)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		commit Commit
+		want   string
+	}{
+		{"regular", Commit{Message: "Fix login", ParentHashes: []string{"a"}, Authors: []string{"Jane <jane@example.com>"}}, Regular},
+		{"root", Commit{Message: "Initial commit"}, Regular},
+		{"merge", Commit{Message: "Merged in feature (pull request #7)", ParentHashes: []string{"a", "b"}}, Merge},
+		{"revert", Commit{Message: "Revert \"Fix login\"\n\nThis reverts commit 0123456789abcdef.", ParentHashes: []string{"a"}}, Revert},
+		{"revert footer", Commit{Message: "Undo login fix\n\nThis reverts commit 0123456.", ParentHashes: []string{"a"}}, Revert},
+		{"reverting word", Commit{Message: "Reverted flag defaults in docs"}, Regular},
+		{"bot", Commit{Message: "Bump lodash", Authors: []string{"dependabot[bot] <support@github.com>"}}, Bot},
+		{"bot merge", Commit{Message: "Merge branch main", ParentHashes: []string{"a", "b"}, Authors: []string{"", "Renovate Bot"}}, Bot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, classifier.Classify(tt.commit))
+		})
+	}
+}
+
+func TestNewClassifier_Patterns(t *testing.T) {
+	classifier, err := NewClassifier([]string{`^release-bot@`})
+	assert.NoError(t, err)
+	assert.Equal(t, Bot, classifier.Classify(Commit{Authors: []string{"release-bot@example.com"}}))
+	assert.Equal(t, Regular, classifier.Classify(Commit{Authors: []string{"dependabot[bot]"}}), "configured patterns replace the defaults")
+
+	_, err = NewClassifier([]string{"("})
+	assert.ErrorContains(t, err, "invalid bot author pattern")
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(Merge))
+	assert.False(t, Valid("squash"))
+}
@@ -0,0 +1,69 @@
+package conventions
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Message is what a commit message declares about itself.
+type Message struct {
+	// Conventional is set when the header follows the Conventional Commits format.
+	Conventional bool
+	Type         string
+	Scope        string
+	// Breaking is set by a "!" before the colon of the header or a BREAKING CHANGE footer.
+	Breaking  bool
+	IssueKeys []string
+}
+
+// header matches a Conventional Commits header such as "feat(api)!: add export".
+var header = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^()\r\n]*)\))?(!)?: \S`)
+
+// breakingFooter matches the footer flagging a breaking change anywhere in the body.
+var breakingFooter = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: `)
+
+// issueKey matches Jira-style issue keys such as "OPS-123".
+var issueKey = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[1-9][0-9]*\b`)
+
+// Parser extracts the Conventional Commit header and issue keys of commit messages.
+type Parser struct {
+	projects map[string]bool
+}
+
+// NewParser returns a parser accepting the issue keys of the given projects, or any issue key when none
+// are given. Listing projects avoids taking terms like UTF-8 for issue keys.
+func NewParser(projects []string) *Parser {
+	p := &Parser{}
+	if len(projects) > 0 {
+		p.projects = make(map[string]bool, len(projects))
+		for _, project := range projects {
+			p.projects[strings.ToUpper(project)] = true
+		}
+	}
+	return p
+}
+
+// Parse parses message. Types are lowercased and issue keys are listed once, in order of appearance.
+func (p *Parser) Parse(message string) Message {
+	var m Message
+	if match := header.FindStringSubmatch(message); match != nil {
+		m.Conventional = true
+		m.Type = strings.ToLower(match[1])
+		m.Scope = strings.TrimSpace(match[2])
+		m.Breaking = match[3] == "!"
+	}
+	if m.Conventional && !m.Breaking {
+		m.Breaking = breakingFooter.MatchString(message)
+	}
+
+	seen := map[string]bool{}
+	for _, key := range issueKey.FindAllString(message, -1) {
+		project := key[:strings.LastIndex(key, "-")]
+		if seen[key] || (p.projects != nil && !p.projects[project]) {
+			continue
+		}
+		seen[key] = true
+		m.IssueKeys = append(m.IssueKeys, key)
+	}
+	return m
+}
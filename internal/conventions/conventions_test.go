@@ -0,0 +1,36 @@
+package conventions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	parser := NewParser(nil)
+	tests := []struct {
+		name    string
+		message string
+		want    Message
+	}{
+		{"plain", "Fix the build", Message{}},
+		{"type only", "fix: handle empty pages", Message{Conventional: true, Type: "fix"}},
+		{"scope", "Feat(api): add export\n\nRefs OPS-12", Message{Conventional: true, Type: "feat", Scope: "api", IssueKeys: []string{"OPS-12"}}},
+		{"breaking mark", "refactor(db)!: rename fields", Message{Conventional: true, Type: "refactor", Scope: "db", Breaking: true}},
+		{"breaking footer", "feat: drop v1 routes\n\nBREAKING CHANGE: clients must move to v2", Message{Conventional: true, Type: "feat", Breaking: true}},
+		{"footer without header", "Drop v1 routes\n\nBREAKING CHANGE: gone", Message{}},
+		{"no space after colon", "fix:typo", Message{}},
+		{"issue keys", "OPS-1 OPS-1 and DATA_2-30, not OPS-0 or ops-4", Message{IssueKeys: []string{"OPS-1", "DATA_2-30"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parser.Parse(tt.message))
+		})
+	}
+}
+
+func TestParse_Projects(t *testing.T) {
+	parser := NewParser([]string{"ops"})
+	assert.Equal(t, []string{"OPS-7"}, parser.Parse("OPS-7: encode as UTF-8").IssueKeys)
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TeamResolver attributes documents to teams inside an aggregation or query.
+type TeamResolver interface {
+	// TeamExpression returns an expression evaluating to the team of a document.
+	TeamExpression(personField, dateField string) interface{}
+}
+
+// Filter narrows queries over the stored commits and pull requests.
+// Zero-valued fields are ignored; Team only applies together with Teams.
+type Filter struct {
+	Provider  string
+	Workspace string
+	Project   string
+	Repo      string
+	Author    string
+	Person    string
+	Team      string
+	Teams     TeamResolver
+	From      time.Time
+	To        time.Time
+	// Classifications selects commits by classification. Without any, the classify.Excluded ones are left
+	// out; AllClassifications selects every commit.
+	Classifications []string
+}
+
+// AllClassifications selects commits of every classification.
+const AllClassifications = "all"
+
+// CommitQuery returns the filter as a query over the commits collection.
+func (f Filter) CommitQuery() bson.M {
+	query := f.query("committed_by", "person_id", "commit_date")
+	switch {
+	case len(f.Classifications) == 0:
+		query["classification"] = bson.M{"$nin": classify.Excluded}
+	case len(f.Classifications) == 1 && f.Classifications[0] == AllClassifications:
+	default:
+		// Commits stored before classification count as regular
+		in := bson.A{}
+		for _, classification := range f.Classifications {
+			in = append(in, classification)
+			if classification == classify.Regular {
+				in = append(in, nil)
+			}
+		}
+		query["classification"] = bson.M{"$in": in}
+	}
+	return query
+}
+
+// ParseClassifications parses a comma-separated list of classifications, or "all".
+func ParseClassifications(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == AllClassifications {
+		return []string{AllClassifications}, nil
+	}
+	classifications := strings.Split(value, ",")
+	for _, classification := range classifications {
+		if !classify.Valid(classification) {
+			return nil, fmt.Errorf("invalid classification %q, expected %s or %s", classification, strings.Join(classify.All, ", "), AllClassifications)
+		}
+	}
+	return classifications, nil
+}
+
+// PullRequestQuery returns the filter as a query over the pull requests collection.
+func (f Filter) PullRequestQuery() bson.M {
+	return f.query("author", "author_person_id", "created_on")
+}
+
+func (f Filter) query(authorField, personField, dateField string) bson.M {
+	query := bson.M{}
+	if f.Provider != "" {
+		query["provider"] = f.Provider
+	}
+	if f.Workspace != "" {
+		query["workspace"] = f.Workspace
+	}
+	if f.Project != "" {
+		query["project_name"] = f.Project
+	}
+	if f.Repo != "" {
+		query["repo_name"] = f.Repo
+	}
+	if f.Author != "" {
+		query[authorField] = f.Author
+	}
+	if f.Person != "" {
+		query[personField] = f.Person
+	}
+	if f.Team != "" && f.Teams != nil {
+		query["$expr"] = bson.M{"$eq": bson.A{f.Teams.TeamExpression(personField, dateField), f.Team}}
+	}
+
+	dateRange := bson.M{}
+	if !f.From.IsZero() {
+		dateRange["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		dateRange["$lt"] = f.To
+	}
+	if len(dateRange) > 0 {
+		query[dateField] = dateRange
+	}
+
+	return query
+}
+
+// ParseDateRange parses the from and to bounds of a filter, either of which may be empty.
+// Dates are accepted as RFC 3339 timestamps or as plain dates, in which case "to" includes the whole day.
+func ParseDateRange(from, to string) (time.Time, time.Time, error) {
+	var fromTime, toTime time.Time
+	var err error
+	if from != "" {
+		if fromTime, _, err = parseDate(from); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if to != "" {
+		var dateOnly bool
+		if toTime, dateOnly, err = parseDate(to); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			toTime = toTime.AddDate(0, 0, 1)
+		}
+	}
+	if !fromTime.IsZero() && !toTime.IsZero() && !fromTime.Before(toTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return fromTime, toTime, nil
+}
+
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date, got %q", value)
+	}
+	return t, true, nil
+}
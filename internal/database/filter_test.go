@@ -0,0 +1,118 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFilter_CommitQuery(t *testing.T) {
+	from := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	filter := Filter{
+		Provider:  "github",
+		Workspace: "lep13",
+		Project:   "Project1",
+		Repo:      "repo1",
+		Author:    "User1",
+		Person:    "p-1",
+		From:      from,
+		To:        to,
+	}
+
+	assert.Equal(t, bson.M{
+		"provider":       "github",
+		"workspace":      "lep13",
+		"project_name":   "Project1",
+		"repo_name":      "repo1",
+		"committed_by":   "User1",
+		"person_id":      "p-1",
+		"commit_date":    bson.M{"$gte": from, "$lt": to},
+		"classification": bson.M{"$nin": classify.Excluded},
+	}, filter.CommitQuery())
+}
+
+func TestFilter_PullRequestQuery(t *testing.T) {
+	from := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	filter := Filter{Author: "User1", Person: "p-1", From: from}
+
+	assert.Equal(t, bson.M{
+		"author":           "User1",
+		"author_person_id": "p-1",
+		"created_on":       bson.M{"$gte": from},
+	}, filter.PullRequestQuery())
+}
+
+func TestFilter_Empty(t *testing.T) {
+	assert.Equal(t, bson.M{"classification": bson.M{"$nin": classify.Excluded}}, Filter{}.CommitQuery())
+	assert.Empty(t, Filter{Classifications: []string{AllClassifications}}.CommitQuery())
+	assert.Empty(t, Filter{}.PullRequestQuery())
+}
+
+func TestFilter_Classifications(t *testing.T) {
+	assert.Equal(t, bson.M{"classification": bson.M{"$in": bson.A{classify.Merge}}},
+		Filter{Classifications: []string{classify.Merge}}.CommitQuery())
+	assert.Equal(t, bson.M{"classification": bson.M{"$in": bson.A{classify.Regular, nil, classify.Bot}}},
+		Filter{Classifications: []string{classify.Regular, classify.Bot}}.CommitQuery(),
+		"commits stored before classification count as regular")
+}
+
+func TestParseClassifications(t *testing.T) {
+	classifications, err := ParseClassifications("merge,bot")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{classify.Merge, classify.Bot}, classifications)
+
+	classifications, err = ParseClassifications("")
+	assert.NoError(t, err)
+	assert.Nil(t, classifications)
+
+	classifications, err = ParseClassifications("all")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{AllClassifications}, classifications)
+
+	_, err = ParseClassifications("merge,squash")
+	assert.ErrorContains(t, err, `invalid classification "squash"`)
+}
+
+type stubTeams struct{}
+
+func (stubTeams) TeamExpression(personField, dateField string) interface{} {
+	return "$" + personField + "@" + dateField
+}
+
+func TestFilter_Team(t *testing.T) {
+	filter := Filter{Team: "payments", Teams: stubTeams{}}
+
+	assert.Equal(t, bson.M{
+		"$expr":          bson.M{"$eq": bson.A{"$person_id@commit_date", "payments"}},
+		"classification": bson.M{"$nin": classify.Excluded},
+	}, filter.CommitQuery())
+	assert.Equal(t, bson.M{
+		"$expr": bson.M{"$eq": bson.A{"$author_person_id@created_on", "payments"}},
+	}, filter.PullRequestQuery())
+	assert.Empty(t, Filter{Team: "payments"}.PullRequestQuery())
+}
+
+func TestParseDateRange(t *testing.T) {
+	from, to, err := ParseDateRange("2024-07-01", "2024-07-31")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), to)
+
+	from, to, err = ParseDateRange("", "2024-07-31T12:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, from.IsZero())
+	assert.Equal(t, time.Date(2024, 7, 31, 12, 0, 0, 0, time.UTC), to)
+
+	_, _, err = ParseDateRange("yesterday", "")
+	assert.ErrorContains(t, err, "invalid from")
+	_, _, err = ParseDateRange("", "07/31/2024")
+	assert.ErrorContains(t, err, "invalid to")
+	_, _, err = ParseDateRange("2024-08-01", "2024-07-01")
+	assert.ErrorContains(t, err, "from must be before to")
+}
@@ -2,13 +2,18 @@ package db
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
 	"time"
 
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/lep13/bitbucket_metrics/internal/logging"
 )
 
 // MongoClientInterface defines the interface for MongoDB client methods used in our code.
@@ -30,9 +35,23 @@ func (m *MongoClientWrapper) Database(name string, opts ...*options.DatabaseOpti
 	return m.Client.Database(name, opts...)
 }
 
+func (m *MongoClientWrapper) Disconnect(ctx context.Context) error {
+	return m.Client.Disconnect(ctx)
+}
+
 // MongoClient holds the actual MongoDB client or a mock for testing.
 var MongoClient MongoClientInterface
 
+// mongoURI is the URI MongoClient is connected to, and mongoMu guards both against Reconnect.
+var (
+	mongoURI string
+	mongoMu  sync.RWMutex
+)
+
+// DisconnectDelay is how long a replaced client stays connected, so operations and cursors started on it can
+// finish. Syncs are not covered: callers reconnect between syncs.
+var DisconnectDelay = time.Minute
+
 // MongoConnectFuncType defines the function type for connecting to MongoDB.
 type MongoConnectFuncType func(ctx context.Context, uri string) (MongoClientInterface, error)
 
@@ -46,20 +65,52 @@ var DefaultMongoConnectFunc MongoConnectFuncType = func(ctx context.Context, uri
 	return &MongoClientWrapper{Client: client}, nil
 }
 
+// DatabaseName is the MongoDB database holding every collection of the tool.
+const DatabaseName = "bitbucket_metrics"
+
+// Collection names used across the tool.
+const (
+	CommitsCollection      = "metrics"
+	PullRequestsCollection = "pullrequests"
+	PipelinesCollection    = "pipelines"
+	TeamsCollection        = "teams"
+	AuditLogCollection     = "audit_log"
+	RunsCollection         = "runs"
+	ErasuresCollection     = "erasures"
+)
+
 // CollectionGetterFunc is a function type for getting a collection.
 type CollectionGetterFunc func() CollectionInterface
 
 // GetCollectionFunc is a package-level variable holding the function to get a collection.
 var GetCollectionFunc CollectionGetterFunc = defaultGetCollection
 
+// NamedCollectionGetterFunc is a function type for getting a collection by name.
+type NamedCollectionGetterFunc func(name string) CollectionInterface
+
+// GetNamedCollectionFunc is a package-level variable holding the function to get a collection by name.
+var GetNamedCollectionFunc NamedCollectionGetterFunc = defaultGetNamedCollection
+
 // CollectionInterface defines the methods to be mocked for MongoDB collection.
 type CollectionInterface interface {
 	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 }
 
 // defaultGetCollection returns the default collection.
 func defaultGetCollection() CollectionInterface {
-    return MongoClient.Database("bitbucket_metrics").Collection("metrics")
+	return defaultGetNamedCollection(CommitsCollection)
+}
+
+// defaultGetNamedCollection returns the named collection of the metrics database.
+func defaultGetNamedCollection(name string) CollectionInterface {
+	mongoMu.RLock()
+	defer mongoMu.RUnlock()
+	return MongoClient.Database(DatabaseName).Collection(name)
 }
 
 // GetCollection returns a collection from the MongoDB database.
@@ -67,6 +118,11 @@ func GetCollection() CollectionInterface {
 	return GetCollectionFunc()
 }
 
+// GetNamedCollection returns the named collection from the MongoDB database.
+func GetNamedCollection(name string) CollectionInterface {
+	return GetNamedCollectionFunc(name)
+}
+
 // MockCollection is a mock type for the mongo.Collection used for testing.
 type MockCollection struct {
 	mock.Mock
@@ -77,6 +133,46 @@ func (m *MockCollection) UpdateOne(ctx context.Context, filter interface{}, upda
 	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
 }
 
+func (m *MockCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	args := m.Called(ctx, filter, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.Cursor), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	args := m.Called(ctx, pipeline, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.Cursor), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	args := m.Called(ctx, document, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.InsertOneResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	args := m.Called(ctx, filter, update, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.UpdateResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	args := m.Called(ctx, filter, opts)
+	if args.Get(0) != nil {
+		return args.Get(0).(*mongo.DeleteResult), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 // MockDatabase is a mock type for the mongo.Database used for testing.
 type MockDatabase struct {
 	mock.Mock
@@ -97,12 +193,43 @@ func GetMockDatabase() *MockDatabase {
 	return &MockDatabase{}
 }
 
+// UseCollections routes collection lookups to the given mocks for the duration of the test. Looking up any other
+// collection fails the test.
+func UseCollections(t testing.TB, collections map[string]*MockCollection) {
+	oldGetCollection := GetCollectionFunc
+	oldGetNamedCollection := GetNamedCollectionFunc
+	t.Cleanup(func() {
+		GetCollectionFunc = oldGetCollection
+		GetNamedCollectionFunc = oldGetNamedCollection
+	})
+
+	GetNamedCollectionFunc = func(name string) CollectionInterface {
+		collection, ok := collections[name]
+		if !ok {
+			t.Fatalf("unexpected collection %s", name)
+		}
+		return collection
+	}
+	GetCollectionFunc = func() CollectionInterface {
+		return GetNamedCollectionFunc(CommitsCollection)
+	}
+}
+
+// NewCursor returns a cursor over docs, for mocked queries to return.
+func NewCursor(t testing.TB, docs ...interface{}) *mongo.Cursor {
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	return cursor
+}
+
 // InitializeMongoDB initializes the MongoDB client connection.
 func InitializeMongoDB(uri string) error {
 	var err error
 	MongoClient, err = DefaultMongoConnectFunc(context.Background(), uri)
 	if err != nil {
-		log.Printf("Failed to connect to MongoDB: %v", err)
+		slog.Error("Failed to connect to MongoDB", logging.KeyError, err)
 		return err
 	}
 
@@ -111,10 +238,56 @@ func InitializeMongoDB(uri string) error {
 
 	err = MongoClient.Ping(ctx, readpref.Primary())
 	if err != nil {
-		log.Printf("Failed to ping MongoDB: %v", err)
+		slog.Error("Failed to ping MongoDB", logging.KeyError, err)
 		return err
 	}
 
-	log.Println("Connected to MongoDB successfully")
+	mongoURI = uri
+	slog.Info("Connected to MongoDB")
 	return nil
 }
+
+// Reconnect replaces MongoClient with a client connected to uri once that client answers a ping, so rotated
+// credentials take effect without a restart. MongoClient is kept when uri is the URI in use or the new client
+// fails. The replaced client is disconnected after DisconnectDelay, once the operations still running on it end.
+func Reconnect(uri string) error {
+	mongoMu.RLock()
+	unchanged := MongoClient != nil && uri == mongoURI
+	mongoMu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	client, err := DefaultMongoConnectFunc(context.Background(), uri)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		disconnect(client)
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	mongoMu.Lock()
+	previous := MongoClient
+	MongoClient, mongoURI = client, uri
+	mongoMu.Unlock()
+
+	if previous != nil {
+		time.AfterFunc(DisconnectDelay, func() { disconnect(previous) })
+	}
+	slog.Info("Connected to MongoDB")
+	return nil
+}
+
+// disconnect closes the connections of client when it holds any, waiting for those in use to be returned.
+func disconnect(client MongoClientInterface) {
+	if c, ok := client.(interface{ Disconnect(context.Context) error }); ok {
+		if err := c.Disconnect(context.Background()); err != nil {
+			slog.Warn("Failed to disconnect from MongoDB", logging.KeyError, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Record types that can be exported.
+const (
+	TypeCommits      = "commits"
+	TypeFiles        = "files"
+	TypePullRequests = "pullrequests"
+)
+
+// batchSize is how many documents are fetched from MongoDB at a time.
+const batchSize = 500
+
+// Options selects what to export and how.
+type Options struct {
+	Type   string
+	Format string
+	Filter db.Filter
+}
+
+// Export streams the records matching the filter to w, oldest first, and returns the number of rows written.
+// Documents are read and encoded one at a time, so memory use does not grow with the size of the export.
+func Export(ctx context.Context, w io.Writer, opts Options) (int, error) {
+	// Exports are raw data, so unlike aggregates they include every commit unless asked otherwise
+	if len(opts.Filter.Classifications) == 0 {
+		opts.Filter.Classifications = []string{db.AllClassifications}
+	}
+	switch opts.Type {
+	case TypeCommits:
+		return exportRows(ctx, w, opts.Format, db.GetCollection(), opts.Filter.CommitQuery(), "commit_date", commitRows)
+	case TypeFiles:
+		return exportRows(ctx, w, opts.Format, db.GetCollection(), opts.Filter.CommitQuery(), "commit_date", fileChangeRows)
+	case TypePullRequests:
+		return exportRows(ctx, w, opts.Format, db.GetNamedCollection(db.PullRequestsCollection), opts.Filter.PullRequestQuery(), "created_on", pullRequestRows)
+	default:
+		return 0, fmt.Errorf("unknown record type %q, expected %s, %s or %s", opts.Type, TypeCommits, TypeFiles, TypePullRequests)
+	}
+}
+
+// exportRows decodes every document matching query as a D, converts it to rows and writes them in format.
+func exportRows[D, R any](ctx context.Context, w io.Writer, format string, collection db.CollectionInterface, query bson.M, dateField string, convert func(D) []R) (int, error) {
+	writer, err := newRowWriter[R](format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: dateField, Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(batchSize).
+		SetAllowDiskUse(true)
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query records: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var doc D
+		if err := cursor.Decode(&doc); err != nil {
+			return count, fmt.Errorf("failed to decode record: %w", err)
+		}
+		for _, row := range convert(doc) {
+			if err := writer.Write(row); err != nil {
+				return count, fmt.Errorf("failed to write record: %w", err)
+			}
+			count++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return count, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return count, fmt.Errorf("failed to finish export: %w", err)
+	}
+	return count, nil
+}
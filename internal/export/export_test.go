@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	commitDate = time.Date(2024, 7, 16, 10, 28, 45, 123000000, time.UTC)
+	createdOn  = time.Date(2024, 7, 17, 9, 0, 0, 0, time.UTC)
+	closedOn   = time.Date(2024, 7, 18, 12, 0, 0, 0, time.UTC)
+)
+
+func useCommits(t *testing.T, query bson.M) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, query, mock.Anything).Return(db.NewCursor(t,
+		bson.M{
+			"provider": "bitbucket", "workspace": "lep13", "project_name": "Project1", "repo_name": "repo1",
+			"commit_id": "commit1", "commit_date": commitDate, "committed_by": "User1", "person_id": "p-1",
+			"commit_message": "Fix, \"quoted\"\nbug", "lines_added": 10, "lines_deleted": 2, "files_updated": 2,
+			"commit_type": "fix", "breaking": true, "issue_keys": bson.A{"OPS-1", "OPS-2"}, "classification": "regular",
+			"files": bson.A{
+				bson.M{"path": "main.go", "type": "modified", "lines_added": 8, "lines_deleted": 2},
+				bson.M{"path": "README.md", "type": "modified", "lines_added": 2},
+			},
+		},
+		bson.M{"workspace": "lep13", "repo_name": "repo1", "commit_id": "commit2", "commit_date": commitDate.Add(time.Hour)},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+}
+
+func TestExport_CommitsCSV(t *testing.T) {
+	useCommits(t, bson.M{"repo_name": "repo1"})
+
+	var out bytes.Buffer
+	count, err := Export(context.Background(), &out, Options{Type: TypeCommits, Format: FormatCSV, Filter: db.Filter{Repo: "repo1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	records, err := csv.NewReader(&out).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, []string{"provider", "workspace", "project_name", "repo_name", "commit_id", "commit_date"}, records[0][:6])
+	assert.Equal(t, "bitbucket", records[1][0])
+	assert.Equal(t, "2024-07-16T10:28:45.123Z", records[1][5])
+	assert.Equal(t, "Fix, \"quoted\"\nbug", records[1][9])
+	assert.Equal(t, "10", records[1][10])
+	assert.Equal(t, []string{"fix", "true", "OPS-1 OPS-2", "regular"}, records[1][17:])
+	assert.Equal(t, "commit2", records[2][4])
+}
+
+func TestExport_FilesJSONL(t *testing.T) {
+	useCommits(t, bson.M{})
+
+	var out bytes.Buffer
+	count, err := Export(context.Background(), &out, Options{Type: TypeFiles, Format: FormatJSONL})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	var row FileChangeRow
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &row))
+	assert.Equal(t, FileChangeRow{
+		Provider: "bitbucket", Workspace: "lep13", ProjectName: "Project1", RepoName: "repo1", CommitID: "commit1", CommitDate: commitDate,
+		PersonID: "p-1", Path: "main.go", Type: "modified", LinesAdded: 8, LinesDeleted: 2,
+	}, row)
+}
+
+func TestExport_PullRequestsParquet(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Find", mock.Anything, bson.M{"author_person_id": "p-1"}, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"repo_name": "repo1", "pull_request_id": "7", "state": "MERGED", "author_person_id": "p-1",
+			"comment_count": 3, "created_on": createdOn, "updated_on": closedOn, "closed_on": closedOn},
+		bson.M{"repo_name": "repo1", "pull_request_id": "8", "state": "OPEN", "author_person_id": "p-1",
+			"created_on": createdOn, "updated_on": createdOn},
+	), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	var out bytes.Buffer
+	count, err := Export(context.Background(), &out, Options{Type: TypePullRequests, Format: FormatParquet, Filter: db.Filter{Person: "p-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	rows, err := parquet.Read[PullRequestRow](bytes.NewReader(out.Bytes()), int64(out.Len()))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "7", rows[0].PullRequestID)
+	assert.Equal(t, int64(3), rows[0].CommentCount)
+	assert.True(t, createdOn.Equal(rows[0].CreatedOn))
+	if assert.NotNil(t, rows[0].ClosedOn) {
+		assert.True(t, closedOn.Equal(*rows[0].ClosedOn))
+	}
+	assert.Nil(t, rows[1].ClosedOn)
+}
+
+func TestExport_EmptyCSVHasHeader(t *testing.T) {
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.PullRequestsCollection: pullRequests})
+
+	var out bytes.Buffer
+	count, err := Export(context.Background(), &out, Options{Type: TypePullRequests, Format: FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.True(t, strings.HasPrefix(out.String(), "provider,workspace,project_name,repo_name,pull_request_id,"))
+}
+
+func TestExport_InvalidOptions(t *testing.T) {
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: new(db.MockCollection)})
+
+	_, err := Export(context.Background(), &bytes.Buffer{}, Options{Type: "builds", Format: FormatCSV})
+	assert.ErrorContains(t, err, "unknown record type")
+
+	_, err = Export(context.Background(), &bytes.Buffer{}, Options{Type: TypeCommits, Format: "xlsx"})
+	assert.ErrorContains(t, err, "unknown format")
+}
+
+func TestExport_QueryError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	db.UseCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := Export(context.Background(), &bytes.Buffer{}, Options{Type: TypeCommits, Format: FormatJSONL})
+	assert.ErrorContains(t, err, "failed to query records")
+}
@@ -0,0 +1,137 @@
+package export
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/bitbucket"
+)
+
+// CommitRow is one exported commit. IssueKeys lists the linked issue keys separated by spaces.
+type CommitRow struct {
+	Provider       string    `json:"provider" parquet:"provider"`
+	Workspace      string    `json:"workspace" parquet:"workspace"`
+	ProjectName    string    `json:"project_name" parquet:"project_name"`
+	RepoName       string    `json:"repo_name" parquet:"repo_name"`
+	CommitID       string    `json:"commit_id" parquet:"commit_id"`
+	CommitDate     time.Time `json:"commit_date" parquet:"commit_date"`
+	CommittedBy    string    `json:"committed_by" parquet:"committed_by"`
+	PersonID       string    `json:"person_id" parquet:"person_id"`
+	AuthorEmail    string    `json:"author_email" parquet:"author_email"`
+	CommitMessage  string    `json:"commit_message" parquet:"commit_message"`
+	LinesAdded     int64     `json:"lines_added" parquet:"lines_added"`
+	LinesDeleted   int64     `json:"lines_deleted" parquet:"lines_deleted"`
+	FilesAdded     int64     `json:"files_added" parquet:"files_added"`
+	FilesDeleted   int64     `json:"files_deleted" parquet:"files_deleted"`
+	FilesUpdated   int64     `json:"files_updated" parquet:"files_updated"`
+	ReviewedBy     string    `json:"reviewed_by" parquet:"reviewed_by"`
+	PullRequestID  string    `json:"pull_request_id" parquet:"pull_request_id"`
+	CommitType     string    `json:"commit_type" parquet:"commit_type"`
+	Breaking       bool      `json:"breaking" parquet:"breaking"`
+	IssueKeys      string    `json:"issue_keys" parquet:"issue_keys"`
+	Classification string    `json:"classification" parquet:"classification"`
+}
+
+// FileChangeRow is one file touched by an exported commit.
+type FileChangeRow struct {
+	Provider     string    `json:"provider" parquet:"provider"`
+	Workspace    string    `json:"workspace" parquet:"workspace"`
+	ProjectName  string    `json:"project_name" parquet:"project_name"`
+	RepoName     string    `json:"repo_name" parquet:"repo_name"`
+	CommitID     string    `json:"commit_id" parquet:"commit_id"`
+	CommitDate   time.Time `json:"commit_date" parquet:"commit_date"`
+	PersonID     string    `json:"person_id" parquet:"person_id"`
+	Path         string    `json:"path" parquet:"path"`
+	Type         string    `json:"type" parquet:"type"`
+	LinesAdded   int64     `json:"lines_added" parquet:"lines_added"`
+	LinesDeleted int64     `json:"lines_deleted" parquet:"lines_deleted"`
+}
+
+// PullRequestRow is one exported pull request. ClosedOn is null while the pull request is open.
+type PullRequestRow struct {
+	Provider          string     `json:"provider" parquet:"provider"`
+	Workspace         string     `json:"workspace" parquet:"workspace"`
+	ProjectName       string     `json:"project_name" parquet:"project_name"`
+	RepoName          string     `json:"repo_name" parquet:"repo_name"`
+	PullRequestID     string     `json:"pull_request_id" parquet:"pull_request_id"`
+	Title             string     `json:"title" parquet:"title"`
+	State             string     `json:"state" parquet:"state"`
+	Author            string     `json:"author" parquet:"author"`
+	AuthorPersonID    string     `json:"author_person_id" parquet:"author_person_id"`
+	SourceBranch      string     `json:"source_branch" parquet:"source_branch"`
+	DestinationBranch string     `json:"destination_branch" parquet:"destination_branch"`
+	CommentCount      int64      `json:"comment_count" parquet:"comment_count"`
+	CreatedOn         time.Time  `json:"created_on" parquet:"created_on"`
+	UpdatedOn         time.Time  `json:"updated_on" parquet:"updated_on"`
+	ClosedOn          *time.Time `json:"closed_on" parquet:"closed_on,optional"`
+}
+
+func commitRows(c bitbucket.Commit) []CommitRow {
+	return []CommitRow{{
+		Provider:       c.Provider,
+		Workspace:      c.Workspace,
+		ProjectName:    c.ProjectName,
+		RepoName:       c.RepoName,
+		CommitID:       c.CommitID,
+		CommitDate:     c.CommitDate.UTC(),
+		CommittedBy:    c.CommittedBy,
+		PersonID:       c.PersonID,
+		AuthorEmail:    c.AuthorEmail,
+		CommitMessage:  c.CommitMessage,
+		LinesAdded:     int64(c.LinesAdded),
+		LinesDeleted:   int64(c.LinesDeleted),
+		FilesAdded:     int64(c.FilesAdded),
+		FilesDeleted:   int64(c.FilesDeleted),
+		FilesUpdated:   int64(c.FilesUpdated),
+		ReviewedBy:     c.ReviewedBy,
+		PullRequestID:  c.PullRequestID,
+		CommitType:     c.CommitType,
+		Breaking:       c.Breaking,
+		IssueKeys:      strings.Join(c.IssueKeys, " "),
+		Classification: c.Classification,
+	}}
+}
+
+func fileChangeRows(c bitbucket.Commit) []FileChangeRow {
+	rows := make([]FileChangeRow, len(c.Files))
+	for i, file := range c.Files {
+		rows[i] = FileChangeRow{
+			Provider:     c.Provider,
+			Workspace:    c.Workspace,
+			ProjectName:  c.ProjectName,
+			RepoName:     c.RepoName,
+			CommitID:     c.CommitID,
+			CommitDate:   c.CommitDate.UTC(),
+			PersonID:     c.PersonID,
+			Path:         file.Path,
+			Type:         file.Type,
+			LinesAdded:   int64(file.LinesAdded),
+			LinesDeleted: int64(file.LinesDeleted),
+		}
+	}
+	return rows
+}
+
+func pullRequestRows(pr bitbucket.PullRequest) []PullRequestRow {
+	row := PullRequestRow{
+		Provider:          pr.Provider,
+		Workspace:         pr.Workspace,
+		ProjectName:       pr.ProjectName,
+		RepoName:          pr.RepoName,
+		PullRequestID:     pr.PullRequestID,
+		Title:             pr.Title,
+		State:             pr.State,
+		Author:            pr.Author,
+		AuthorPersonID:    pr.AuthorPersonID,
+		SourceBranch:      pr.SourceBranch,
+		DestinationBranch: pr.DestinationBranch,
+		CommentCount:      int64(pr.CommentCount),
+		CreatedOn:         pr.CreatedOn.UTC(),
+		UpdatedOn:         pr.UpdatedOn.UTC(),
+	}
+	if !pr.ClosedOn.IsZero() {
+		closedOn := pr.ClosedOn.UTC()
+		row.ClosedOn = &closedOn
+	}
+	return []PullRequestRow{row}
+}
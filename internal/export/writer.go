@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Output formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// parquetRowGroupSize bounds how many rows the Parquet writer buffers before flushing a row group.
+const parquetRowGroupSize = 10000
+
+// rowWriter encodes rows one at a time. Close flushes buffered rows but leaves the underlying writer open.
+type rowWriter[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+func newRowWriter[T any](format string, w io.Writer) (rowWriter[T], error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter[T](w)
+	case FormatJSONL:
+		buffered := bufio.NewWriter(w)
+		return &jsonlWriter[T]{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case FormatParquet:
+		return &parquetWriter[T]{writer: parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize))}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatCSV, FormatJSONL, FormatParquet)
+	}
+}
+
+// csvWriter writes a header row named after the JSON fields of T, then one record per row.
+// Times are written in RFC 3339 and null times as empty cells.
+type csvWriter[T any] struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVWriter[T any](w io.Writer) (*csvWriter[T], error) {
+	rowType := reflect.TypeOf((*T)(nil)).Elem()
+	header := make([]string, rowType.NumField())
+	for i := range header {
+		header[i], _, _ = strings.Cut(rowType.Field(i).Tag.Get("json"), ",")
+	}
+
+	c := &csvWriter[T]{writer: csv.NewWriter(w), record: make([]string, len(header))}
+	if err := c.writer.Write(header); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter[T]) Write(row T) error {
+	v := reflect.ValueOf(row)
+	for i := range c.record {
+		c.record[i] = formatCSV(v.Field(i))
+	}
+	return c.writer.Write(c.record)
+}
+
+func formatCSV(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case *time.Time:
+		if value == nil {
+			return ""
+		}
+		return value.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func (c *csvWriter[T]) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonlWriter[T any] struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (j *jsonlWriter[T]) Write(row T) error {
+	return j.encoder.Encode(row)
+}
+
+func (j *jsonlWriter[T]) Close() error {
+	return j.buffered.Flush()
+}
+
+type parquetWriter[T any] struct {
+	writer *parquet.GenericWriter[T]
+}
+
+func (p *parquetWriter[T]) Write(row T) error {
+	_, err := p.writer.Write([]T{row})
+	return err
+}
+
+func (p *parquetWriter[T]) Close() error {
+	return p.writer.Close()
+}
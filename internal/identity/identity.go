@@ -0,0 +1,172 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lep13/bitbucket_metrics/config"
+)
+
+// Author holds everything Bitbucket reports about the author of a commit or pull request.
+type Author struct {
+	Raw         string
+	DisplayName string
+	AccountUUID string
+	Nickname    string
+}
+
+// Identity is the person an author resolves to.
+type Identity struct {
+	PersonID string
+	Name     string
+	Email    string
+}
+
+// rule is a compiled config.IdentityRule.
+type rule struct {
+	config.IdentityRule
+	emailPattern *regexp.Regexp
+}
+
+// Resolver maps authors to stable person identities.
+type Resolver struct {
+	rules   []rule
+	mailmap *Mailmap
+
+	mu sync.Mutex
+	// accounts links Bitbucket accounts to the first canonical email they were seen with.
+	accounts map[string]string
+}
+
+// NewResolver returns a resolver applying mailmap first and then the first matching rule.
+// Both may be empty.
+func NewResolver(rules []config.IdentityRule, mailmap *Mailmap) (*Resolver, error) {
+	r := &Resolver{mailmap: mailmap, accounts: map[string]string{}}
+	for i, ir := range rules {
+		if ir.PersonID == "" {
+			return nil, fmt.Errorf("identity rule %d: person_id is required", i)
+		}
+		compiled := rule{IdentityRule: ir}
+		if ir.EmailPattern != "" {
+			pattern, err := regexp.Compile("(?i)" + ir.EmailPattern)
+			if err != nil {
+				return nil, fmt.Errorf("identity rule %d: invalid email_pattern: %w", i, err)
+			}
+			compiled.emailPattern = pattern
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// NewResolverFromConfig builds a resolver from the identity rules and mailmap file of cfg.
+func NewResolverFromConfig(cfg *config.Config) (*Resolver, error) {
+	var mailmap *Mailmap
+	if cfg.MailmapPath != "" {
+		var err error
+		if mailmap, err = LoadMailmap(cfg.MailmapPath); err != nil {
+			return nil, err
+		}
+	}
+	return NewResolver(cfg.IdentityRules, mailmap)
+}
+
+// ParseRaw splits a raw git author string such as "Jane Doe <jane@example.com>" into name and email.
+func ParseRaw(raw string) (string, string) {
+	// Git does not quote author names, so this is not an RFC 5322 address and net/mail would misread it
+	raw = strings.TrimSpace(raw)
+	open := strings.LastIndex(raw, "<")
+	if open < 0 || !strings.HasSuffix(raw, ">") {
+		return raw, ""
+	}
+	return strings.TrimSpace(raw[:open]), strings.TrimSpace(raw[open+1 : len(raw)-1])
+}
+
+// Resolve returns the identity of author.
+//
+// The raw author string is first rewritten through the mailmap. A matching rule then decides the person;
+// otherwise the person ID is derived from the canonical email, else the Bitbucket account, else the name, so it
+// stays the same when the author renames themselves. An account seen with an email is linked to it, so authors
+// known only by their account, such as those of pull requests, resolve to the person of their commits.
+func (r *Resolver) Resolve(author Author) Identity {
+	rawName, rawEmail := ParseRaw(author.Raw)
+	name, email := r.mailmap.Map(rawName, rawEmail)
+	email = strings.ToLower(email)
+	if account := strings.ToLower(author.AccountUUID); account != "" {
+		email = r.link(account, email)
+	}
+
+	if name == rawName && author.DisplayName != "" {
+		name = author.DisplayName
+	}
+
+	for _, rule := range r.rules {
+		if rule.matches(author, rawName, name, rawEmail, email) {
+			if rule.Name != "" {
+				name = rule.Name
+			}
+			return Identity{PersonID: rule.PersonID, Name: name, Email: email}
+		}
+	}
+
+	var key string
+	switch {
+	case email != "":
+		key = "email:" + email
+	case author.AccountUUID != "":
+		key = "uuid:" + strings.ToLower(author.AccountUUID)
+	case name != "":
+		key = "name:" + strings.ToLower(name)
+	default:
+		return Identity{}
+	}
+	return Identity{PersonID: personID(key), Name: name, Email: email}
+}
+
+// link links account to email when it has none yet, and returns email, or the email account is linked to when
+// email is empty.
+func (r *Resolver) link(account, email string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if email == "" {
+		return r.accounts[account]
+	}
+	if _, ok := r.accounts[account]; !ok {
+		r.accounts[account] = email
+	}
+	return email
+}
+
+func (r rule) matches(author Author, rawName, name, rawEmail, email string) bool {
+	if containsFold(r.AccountUUIDs, author.AccountUUID) ||
+		containsFold(r.Nicknames, author.Nickname) ||
+		containsFold(r.Emails, email) ||
+		containsFold(r.Emails, rawEmail) ||
+		containsFold(r.Names, rawName) ||
+		containsFold(r.Names, name) {
+		return true
+	}
+	return r.emailPattern != nil && email != "" && r.emailPattern.MatchString(email)
+}
+
+func containsFold(values []string, v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// personID turns an identity key into an opaque, stable ID.
+func personID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "p-" + hex.EncodeToString(sum[:8])
+}
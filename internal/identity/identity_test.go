@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRaw(t *testing.T) {
+	tests := map[string][2]string{
+		"Jane Doe <jane@example.com>":     {"Jane Doe", "jane@example.com"},
+		"<jane@example.com>":              {"", "jane@example.com"},
+		"J. Doe (CI) <jane@example.com>":  {"J. Doe (CI)", "jane@example.com"},
+		"jane":                            {"jane", ""},
+		"Jane <jane@localhost>":           {"Jane", "jane@localhost"},
+		"Jane, Doe <jane@example.com>":    {"Jane, Doe", "jane@example.com"},
+		"  Jane Doe <jane@example.com>  ": {"Jane Doe", "jane@example.com"},
+	}
+	for raw, want := range tests {
+		name, email := ParseRaw(raw)
+		assert.Equal(t, want[0], name, raw)
+		assert.Equal(t, want[1], email, raw)
+	}
+}
+
+func TestResolve_StableAcrossRenames(t *testing.T) {
+	r, err := NewResolver(nil, nil)
+	assert.NoError(t, err)
+
+	before := r.Resolve(Author{Raw: "Jane Doe <jane@example.com>", DisplayName: "Jane Doe", AccountUUID: "{abc}"})
+	after := r.Resolve(Author{Raw: "Jane Smith <jane@example.com>", DisplayName: "Jane Smith", AccountUUID: "{ABC}"})
+
+	assert.Equal(t, before.PersonID, after.PersonID)
+	assert.Equal(t, "Jane Smith", after.Name)
+	assert.True(t, strings.HasPrefix(after.PersonID, "p-"))
+}
+
+func TestResolve_LinkedAndUnlinkedCommits(t *testing.T) {
+	m, err := ParseMailmap(strings.NewReader(testMailmap))
+	assert.NoError(t, err)
+	r, err := NewResolver(nil, m)
+	assert.NoError(t, err)
+
+	// The same person commits once from a machine linked to their account and once from one that is not
+	linked := r.Resolve(Author{Raw: "Jane Doe <jane@example.com>", DisplayName: "Jane Doe", AccountUUID: "{abc}"})
+	unlinked := r.Resolve(Author{Raw: "Jane D <jane.doe@users.noreply.example.com>"})
+	assert.Equal(t, linked.PersonID, unlinked.PersonID)
+
+	// Pull requests only name the account, which resolves through the email it was seen with
+	pullRequest := r.Resolve(Author{DisplayName: "Jane Doe", AccountUUID: "{ABC}"})
+	assert.Equal(t, linked.PersonID, pullRequest.PersonID)
+	assert.Equal(t, "jane@example.com", pullRequest.Email)
+}
+
+func TestResolve_UnlinkedEmail(t *testing.T) {
+	r, err := NewResolver(nil, nil)
+	assert.NoError(t, err)
+
+	id := r.Resolve(Author{Raw: "jane <Jane@Example.com>"})
+	assert.Equal(t, "jane", id.Name)
+	assert.Equal(t, "jane@example.com", id.Email)
+	assert.Equal(t, r.Resolve(Author{Raw: "Jane D <jane@example.com>"}).PersonID, id.PersonID)
+	assert.NotEqual(t, r.Resolve(Author{Raw: "jane <other@example.com>"}).PersonID, id.PersonID)
+}
+
+func TestResolve_NameOnly(t *testing.T) {
+	r, err := NewResolver(nil, nil)
+	assert.NoError(t, err)
+
+	id := r.Resolve(Author{DisplayName: "Jane Doe"})
+	assert.Equal(t, "Jane Doe", id.Name)
+	assert.NotEmpty(t, id.PersonID)
+
+	assert.Equal(t, Identity{}, r.Resolve(Author{}))
+}
+
+func TestResolve_Mailmap(t *testing.T) {
+	m, err := ParseMailmap(strings.NewReader(testMailmap))
+	assert.NoError(t, err)
+	r, err := NewResolver(nil, m)
+	assert.NoError(t, err)
+
+	noreply := r.Resolve(Author{Raw: "Jane D <jane.doe@users.noreply.example.com>"})
+	primary := r.Resolve(Author{Raw: "Jane Doe <jane@example.com>"})
+	assert.Equal(t, primary.PersonID, noreply.PersonID)
+	assert.Equal(t, "jane@example.com", noreply.Email)
+
+	renamed := r.Resolve(Author{Raw: "jane <jane@old.example.com>", DisplayName: "Jane Q"})
+	assert.Equal(t, "Jane Doe", renamed.Name)
+}
+
+func TestResolve_Rules(t *testing.T) {
+	r, err := NewResolver([]config.IdentityRule{
+		{PersonID: "jane", Name: "Jane Doe", Emails: []string{"jane@example.com"}, AccountUUIDs: []string{"{abc}"}},
+		{PersonID: "john", Nicknames: []string{"jsmith"}, Names: []string{"Johnny"}},
+		{PersonID: "contractors", EmailPattern: `@contractor\.example\.com$`},
+	}, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, Identity{PersonID: "jane", Name: "Jane Doe", Email: "jane@example.com"},
+		r.Resolve(Author{Raw: "jd <JANE@example.com>"}))
+	assert.Equal(t, "jane", r.Resolve(Author{Raw: "Jane <jane@laptop>", AccountUUID: "{ABC}"}).PersonID)
+	assert.Equal(t, "john", r.Resolve(Author{DisplayName: "John", Nickname: "JSmith"}).PersonID)
+	assert.Equal(t, "john", r.Resolve(Author{Raw: "Johnny <j@home>"}).PersonID)
+	assert.Equal(t, "john", r.Resolve(Author{Raw: "Johnny <j@home>", DisplayName: "John Smith"}).PersonID)
+	assert.Equal(t, "contractors", r.Resolve(Author{Raw: "Bob <bob@Contractor.example.com>"}).PersonID)
+	assert.NotEqual(t, "contractors", r.Resolve(Author{Raw: "Bob <bob@contractor.example.com.evil>"}).PersonID)
+}
+
+func TestNewResolver_InvalidRules(t *testing.T) {
+	_, err := NewResolver([]config.IdentityRule{{Emails: []string{"jane@example.com"}}}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "person_id is required")
+
+	_, err = NewResolver([]config.IdentityRule{{PersonID: "x", EmailPattern: "("}}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid email_pattern")
+}
+
+func TestNewResolverFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mailmap")
+	assert.NoError(t, os.WriteFile(path, []byte(testMailmap), 0o600))
+
+	r, err := NewResolverFromConfig(&config.Config{
+		MailmapPath:   path,
+		IdentityRules: []config.IdentityRule{{PersonID: "john", Emails: []string{"john@example.com"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "john", r.Resolve(Author{Raw: "js <jsmith@laptop.local>"}).PersonID)
+
+	_, err = NewResolverFromConfig(&config.Config{MailmapPath: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
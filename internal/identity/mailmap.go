@@ -0,0 +1,133 @@
+package identity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// mailmapEntry is one line of a mailmap file. Empty proper fields keep the commit value.
+type mailmapEntry struct {
+	properName  string
+	properEmail string
+	commitName  string
+	commitEmail string
+}
+
+// Mailmap rewrites commit names and emails to canonical ones, following the format of git's .mailmap:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+type Mailmap struct {
+	entries []mailmapEntry
+}
+
+// LoadMailmap reads a mailmap file from path.
+func LoadMailmap(path string) (*Mailmap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mailmap: %w", err)
+	}
+	defer f.Close()
+
+	return ParseMailmap(f)
+}
+
+// ParseMailmap reads mailmap entries from r. Blank lines and # comments are ignored.
+func ParseMailmap(r io.Reader) (*Mailmap, error) {
+	m := &Mailmap{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		entry, err := parseMailmapLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("mailmap line %d: %w", lineNo, err)
+		}
+		m.entries = append(m.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mailmap: %w", err)
+	}
+	return m, nil
+}
+
+func parseMailmapLine(line string) (mailmapEntry, error) {
+	var names, emails []string
+	rest := line
+	for {
+		open := strings.Index(rest, "<")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(rest[open:], ">")
+		if end < 0 {
+			return mailmapEntry{}, fmt.Errorf("unterminated email in %q", line)
+		}
+		names = append(names, strings.TrimSpace(rest[:open]))
+		emails = append(emails, strings.TrimSpace(rest[open+1:open+end]))
+		rest = rest[open+end+1:]
+	}
+	if strings.TrimSpace(rest) != "" {
+		return mailmapEntry{}, fmt.Errorf("unexpected text after last email in %q", line)
+	}
+
+	switch len(emails) {
+	case 1:
+		if names[0] == "" {
+			return mailmapEntry{}, fmt.Errorf("missing proper name in %q", line)
+		}
+		return mailmapEntry{properName: names[0], commitEmail: emails[0]}, nil
+	case 2:
+		return mailmapEntry{properName: names[0], properEmail: emails[0], commitName: names[1], commitEmail: emails[1]}, nil
+	default:
+		return mailmapEntry{}, fmt.Errorf("expected one or two emails in %q", line)
+	}
+}
+
+// Map returns the canonical name and email for a commit name and email.
+// Entries naming the commit name take precedence over entries matching the email alone.
+func (m *Mailmap) Map(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	var match *mailmapEntry
+	for i := range m.entries {
+		entry := &m.entries[i]
+		if !strings.EqualFold(entry.commitEmail, email) {
+			continue
+		}
+		if entry.commitName != "" {
+			if strings.EqualFold(entry.commitName, name) {
+				match = entry
+				break
+			}
+			continue
+		}
+		if match == nil {
+			match = entry
+		}
+	}
+	if match == nil {
+		return name, email
+	}
+
+	if match.properName != "" {
+		name = match.properName
+	}
+	if match.properEmail != "" {
+		email = match.properEmail
+	}
+	return name, email
+}
@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMailmap = `
+# Canonical names and emails
+Jane Doe <jane@old.example.com>
+<jane@example.com> <jane.doe@users.noreply.example.com>
+John Smith <john@example.com> <jsmith@laptop.local>
+John Smith <john@example.com> build bot <ci@example.com>
+`
+
+func TestParseMailmap(t *testing.T) {
+	m, err := ParseMailmap(strings.NewReader(testMailmap))
+	assert.NoError(t, err)
+	assert.Len(t, m.entries, 4)
+}
+
+func TestParseMailmap_Errors(t *testing.T) {
+	for _, line := range []string{
+		"Jane Doe <jane@example.com",
+		"<jane@example.com>",
+		"Jane <a@example.com> <b@example.com> <c@example.com>",
+		"Jane <a@example.com> trailing",
+	} {
+		_, err := ParseMailmap(strings.NewReader(line))
+		assert.Error(t, err, line)
+	}
+}
+
+func TestMailmap_Map(t *testing.T) {
+	m, err := ParseMailmap(strings.NewReader(testMailmap))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name, email         string
+		wantName, wantEmail string
+	}{
+		{"jane", "jane@old.example.com", "Jane Doe", "jane@old.example.com"},
+		{"Jane D", "jane.doe@users.noreply.example.com", "Jane D", "jane@example.com"},
+		{"john", "JSMITH@laptop.local", "John Smith", "john@example.com"},
+		{"Build Bot", "ci@example.com", "John Smith", "john@example.com"},
+		{"Release Bot", "ci@example.com", "Release Bot", "ci@example.com"},
+		{"Someone", "someone@example.com", "Someone", "someone@example.com"},
+	}
+	for _, tt := range tests {
+		name, email := m.Map(tt.name, tt.email)
+		assert.Equal(t, tt.wantName, name, tt.email)
+		assert.Equal(t, tt.wantEmail, email, tt.email)
+	}
+}
+
+func TestMailmap_MapNil(t *testing.T) {
+	var m *Mailmap
+	name, email := m.Map("Jane", "jane@example.com")
+	assert.Equal(t, "Jane", name)
+	assert.Equal(t, "jane@example.com", email)
+}
+
+func TestLoadMailmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mailmap")
+	assert.NoError(t, os.WriteFile(path, []byte(testMailmap), 0o600))
+
+	m, err := LoadMailmap(path)
+	assert.NoError(t, err)
+	assert.Len(t, m.entries, 4)
+
+	_, err = LoadMailmap(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open mailmap")
+}
@@ -0,0 +1,93 @@
+package issues
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+)
+
+// Issue is what commits record about an issue they link to.
+type Issue struct {
+	Key      string `bson:"key" json:"key"`
+	Type     string `bson:"type" json:"type"`
+	Priority string `bson:"priority,omitempty" json:"priority,omitempty"`
+	Epic     string `bson:"epic,omitempty" json:"epic,omitempty"`
+	Status   string `bson:"status,omitempty" json:"status,omitempty"`
+}
+
+// Tracker looks up issues in an issue tracker. Keys the tracker does not know are left out of the result.
+type Tracker interface {
+	Issues(ctx context.Context, keys []string) (map[string]Issue, error)
+}
+
+// HTTPClient is the part of http.Client the connectors use.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// NewFromConfig returns the tracker configured by cfg, or nil when none is.
+func NewFromConfig(cfg *config.Config) (Tracker, error) {
+	if cfg.Jira == nil {
+		return nil, nil
+	}
+	jira, err := NewJira(*cfg.Jira, &http.Client{})
+	if err != nil {
+		return nil, err
+	}
+	return jira, nil
+}
+
+// Enricher looks up the issues linked by commits, remembering every issue for the lifetime of the
+// enricher so a sync asks the tracker about each issue once.
+type Enricher struct {
+	tracker Tracker
+	cache   map[string]*Issue
+}
+
+// NewEnricher returns an enricher backed by tracker. A nil tracker enriches nothing.
+func NewEnricher(tracker Tracker) *Enricher {
+	return &Enricher{tracker: tracker, cache: map[string]*Issue{}}
+}
+
+// Lookup returns the known issues among keys, in the order of keys. Tracker failures are logged and leave
+// the issues out, so a tracker outage does not fail a sync.
+func (e *Enricher) Lookup(ctx context.Context, keys []string) []Issue {
+	if e == nil || e.tracker == nil || len(keys) == 0 {
+		return nil
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if _, ok := e.cache[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		found, err := e.tracker.Issues(ctx, missing)
+		if err != nil {
+			slog.Warn("Failed to look up issues", "issues", missing, logging.KeyError, err)
+			return e.cached(keys)
+		}
+		for _, key := range missing {
+			if issue, ok := found[key]; ok {
+				e.cache[key] = &issue
+			} else {
+				e.cache[key] = nil
+			}
+		}
+	}
+	return e.cached(keys)
+}
+
+func (e *Enricher) cached(keys []string) []Issue {
+	var found []Issue
+	for _, key := range keys {
+		if issue := e.cache[key]; issue != nil {
+			found = append(found, *issue)
+		}
+	}
+	return found
+}
@@ -0,0 +1,50 @@
+package issues
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockTracker struct {
+	mock.Mock
+}
+
+func (m *MockTracker) Issues(ctx context.Context, keys []string) (map[string]Issue, error) {
+	args := m.Called(ctx, keys)
+	found, _ := args.Get(0).(map[string]Issue)
+	return found, args.Error(1)
+}
+
+func TestEnricher_Lookup(t *testing.T) {
+	bug := Issue{Key: "OPS-1", Type: "bug"}
+	tracker := new(MockTracker)
+	tracker.On("Issues", mock.Anything, []string{"OPS-1", "OPS-2"}).Return(map[string]Issue{"OPS-1": bug}, nil).Once()
+	tracker.On("Issues", mock.Anything, []string{"OPS-3"}).Return(nil, errors.New("timeout")).Once()
+	tracker.On("Issues", mock.Anything, []string{"OPS-3"}).Return(map[string]Issue{"OPS-3": {Key: "OPS-3"}}, nil).Once()
+
+	enricher := NewEnricher(tracker)
+	assert.Equal(t, []Issue{bug}, enricher.Lookup(context.Background(), []string{"OPS-1", "OPS-2"}))
+	assert.Equal(t, []Issue{bug}, enricher.Lookup(context.Background(), []string{"OPS-2", "OPS-1"}), "known and unknown keys are cached")
+	assert.Equal(t, []Issue{bug}, enricher.Lookup(context.Background(), []string{"OPS-1", "OPS-3"}))
+	assert.Equal(t, []Issue{bug, {Key: "OPS-3"}}, enricher.Lookup(context.Background(), []string{"OPS-1", "OPS-3"}), "failed lookups are not cached")
+	tracker.AssertExpectations(t)
+}
+
+func TestEnricher_WithoutTracker(t *testing.T) {
+	assert.Nil(t, NewEnricher(nil).Lookup(context.Background(), []string{"OPS-1"}))
+}
+
+func TestNewFromConfig(t *testing.T) {
+	tracker, err := NewFromConfig(&config.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, tracker)
+
+	tracker, err = NewFromConfig(&config.Config{Jira: &config.JiraConfig{BaseURL: "https://example.atlassian.net", Email: "bot@example.com", APIToken: "secret"}})
+	assert.NoError(t, err)
+	assert.IsType(t, &Jira{}, tracker)
+}
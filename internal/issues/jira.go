@@ -0,0 +1,160 @@
+package issues
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lep13/bitbucket_metrics/config"
+)
+
+// jiraBatchSize caps the issue keys looked up per search request.
+const jiraBatchSize = 50
+
+// errQueryRejected is returned for searches Jira rejects as invalid.
+var errQueryRejected = errors.New("jira rejected the search")
+
+// Jira looks up issues through the Jira Cloud REST API.
+type Jira struct {
+	cfg    config.JiraConfig
+	client HTTPClient
+}
+
+// NewJira returns a Jira connector authenticating with the email and API token of cfg.
+func NewJira(cfg config.JiraConfig, client HTTPClient) (*Jira, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("jira base URL is required")
+	}
+	if cfg.Email == "" || cfg.APIToken == "" {
+		return nil, fmt.Errorf("jira email and API token are required")
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &Jira{cfg: cfg, client: client}, nil
+}
+
+type jiraIssue struct {
+	Key    string                     `json:"key"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+type jiraNamed struct {
+	Name string `json:"name"`
+}
+
+type jiraParent struct {
+	Key    string `json:"key"`
+	Fields struct {
+		IssueType jiraNamed `json:"issuetype"`
+	} `json:"fields"`
+}
+
+// Issues looks up keys with JQL searches of at most jiraBatchSize keys each.
+func (j *Jira) Issues(ctx context.Context, keys []string) (map[string]Issue, error) {
+	found := make(map[string]Issue, len(keys))
+	for start := 0; start < len(keys); start += jiraBatchSize {
+		end := min(start+jiraBatchSize, len(keys))
+		if err := j.lookup(ctx, keys[start:end], found); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// lookup searches a batch of keys. Unknown keys only raise warnings, but a batch Jira still rejects is searched
+// again key by key, so one invalid key does not hide the issues of the others. A key rejected on its own is not
+// an issue.
+func (j *Jira) lookup(ctx context.Context, keys []string, found map[string]Issue) error {
+	err := j.search(ctx, keys, found)
+	if !errors.Is(err, errQueryRejected) {
+		return err
+	}
+	if len(keys) == 1 {
+		return nil
+	}
+	for _, key := range keys {
+		if err := j.search(ctx, []string{key}, found); err != nil && !errors.Is(err, errQueryRejected) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Jira) search(ctx context.Context, keys []string, found map[string]Issue) error {
+	fields := []string{"issuetype", "priority", "status", "parent"}
+	if j.cfg.EpicField != "" {
+		fields = append(fields, j.cfg.EpicField)
+	}
+	query := url.Values{
+		"jql":        {fmt.Sprintf("key in (%s)", strings.Join(keys, ","))},
+		"fields":     {strings.Join(fields, ",")},
+		"maxResults": {fmt.Sprint(len(keys))},
+		// Keys that do not exist are reported as warnings instead of failing the search
+		"validateQuery": {"warn"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.cfg.BaseURL+"/rest/api/3/search/jql?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create jira request: %w", err)
+	}
+	req.SetBasicAuth(j.cfg.Email, j.cfg.APIToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to search jira issues: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read jira response: %w", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", errQueryRejected, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jira returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Issues []jiraIssue `json:"issues"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to decode jira response: %w", err)
+	}
+	for _, issue := range result.Issues {
+		found[issue.Key] = j.convert(issue)
+	}
+	return nil
+}
+
+func (j *Jira) convert(issue jiraIssue) Issue {
+	var issueType, priority, status jiraNamed
+	var parent jiraParent
+	json.Unmarshal(issue.Fields["issuetype"], &issueType)
+	json.Unmarshal(issue.Fields["priority"], &priority)
+	json.Unmarshal(issue.Fields["status"], &status)
+	json.Unmarshal(issue.Fields["parent"], &parent)
+
+	// Team-managed projects link issues to their epic as parent, company-managed ones through a custom field
+	var epic string
+	if j.cfg.EpicField != "" {
+		json.Unmarshal(issue.Fields[j.cfg.EpicField], &epic)
+	}
+	if epic == "" && strings.EqualFold(parent.Fields.IssueType.Name, "epic") {
+		epic = parent.Key
+	}
+
+	return Issue{
+		Key:      issue.Key,
+		Type:     strings.ToLower(issueType.Name),
+		Priority: priority.Name,
+		Epic:     epic,
+		Status:   status.Name,
+	}
+}
@@ -0,0 +1,134 @@
+package issues
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeJira serves the issue search of a Jira site knowing the given issues, recording every searched JQL.
+// Unknown keys fail the search unless it only asks for warnings, and keys known with nil fields are invalid and
+// always fail it.
+func fakeJira(t *testing.T, known map[string]map[string]interface{}) (*httptest.Server, *[]string) {
+	var searches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, token, ok := r.BasicAuth()
+		if !ok || email != "bot@example.com" || token != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/rest/api/3/search/jql" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		jql := r.URL.Query().Get("jql")
+		searches = append(searches, jql)
+		var issues []interface{}
+		for _, key := range regexp.MustCompile(`[A-Z]+-\d+`).FindAllString(jql, -1) {
+			fields, ok := known[key]
+			if ok && fields == nil || !ok && r.URL.Query().Get("validateQuery") != "warn" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"errorMessages": ["An issue with key '%s' does not exist"]}`, key)
+				return
+			}
+			if !ok {
+				continue
+			}
+			issues = append(issues, map[string]interface{}{"key": key, "fields": fields})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"issues": issues})
+	}))
+	t.Cleanup(server.Close)
+	return server, &searches
+}
+
+func newTestJira(t *testing.T, url, epicField string) *Jira {
+	jira, err := NewJira(config.JiraConfig{BaseURL: url + "/", Email: "bot@example.com", APIToken: "secret", EpicField: epicField}, &http.Client{})
+	assert.NoError(t, err)
+	return jira
+}
+
+func TestJira_Issues(t *testing.T) {
+	server, searches := fakeJira(t, map[string]map[string]interface{}{
+		"OPS-1": {
+			"issuetype": map[string]string{"name": "Bug"},
+			"priority":  map[string]string{"name": "High"},
+			"status":    map[string]string{"name": "Done"},
+			"parent":    map[string]interface{}{"key": "OPS-9", "fields": map[string]interface{}{"issuetype": map[string]string{"name": "Epic"}}},
+		},
+		"OPS-2": {
+			"issuetype":         map[string]string{"name": "Story"},
+			"status":            map[string]string{"name": "In Progress"},
+			"customfield_10014": "OPS-8",
+		},
+	})
+
+	found, err := newTestJira(t, server.URL, "customfield_10014").Issues(context.Background(), []string{"OPS-1", "OPS-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Issue{
+		"OPS-1": {Key: "OPS-1", Type: "bug", Priority: "High", Epic: "OPS-9", Status: "Done"},
+		"OPS-2": {Key: "OPS-2", Type: "story", Epic: "OPS-8", Status: "In Progress"},
+	}, found)
+	assert.Equal(t, []string{"key in (OPS-1,OPS-2)"}, *searches)
+}
+
+func TestJira_Batches(t *testing.T) {
+	known := map[string]map[string]interface{}{}
+	var keys []string
+	for i := 1; i <= jiraBatchSize+1; i++ {
+		key := fmt.Sprintf("OPS-%d", i)
+		known[key] = map[string]interface{}{"issuetype": map[string]string{"name": "Task"}}
+		keys = append(keys, key)
+	}
+	server, searches := fakeJira(t, known)
+
+	found, err := newTestJira(t, server.URL, "").Issues(context.Background(), keys)
+	assert.NoError(t, err)
+	assert.Len(t, found, jiraBatchSize+1)
+	assert.Len(t, *searches, 2)
+}
+
+func TestJira_UnknownKeys(t *testing.T) {
+	server, searches := fakeJira(t, map[string]map[string]interface{}{
+		"OPS-1": {"issuetype": map[string]string{"name": "Bug"}},
+	})
+
+	found, err := newTestJira(t, server.URL, "").Issues(context.Background(), []string{"UTF-8", "OPS-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Issue{"OPS-1": {Key: "OPS-1", Type: "bug"}}, found)
+	assert.Len(t, *searches, 1)
+}
+
+func TestJira_RejectedBatchSearchedPerKey(t *testing.T) {
+	server, searches := fakeJira(t, map[string]map[string]interface{}{
+		"OPS-1": {"issuetype": map[string]string{"name": "Bug"}},
+		"BAD-1": nil,
+	})
+
+	found, err := newTestJira(t, server.URL, "").Issues(context.Background(), []string{"BAD-1", "OPS-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Issue{"OPS-1": {Key: "OPS-1", Type: "bug"}}, found)
+	assert.Equal(t, []string{"key in (BAD-1,OPS-1)", "key in (BAD-1)", "key in (OPS-1)"}, *searches)
+}
+
+func TestJira_Errors(t *testing.T) {
+	server, _ := fakeJira(t, nil)
+	jira, err := NewJira(config.JiraConfig{BaseURL: server.URL, Email: "bot@example.com", APIToken: "wrong"}, &http.Client{})
+	assert.NoError(t, err)
+
+	_, err = jira.Issues(context.Background(), []string{"OPS-1"})
+	assert.ErrorContains(t, err, "jira returned status 401")
+
+	_, err = NewJira(config.JiraConfig{Email: "bot@example.com", APIToken: "secret"}, &http.Client{})
+	assert.ErrorContains(t, err, "base URL is required")
+	_, err = NewJira(config.JiraConfig{BaseURL: server.URL}, &http.Client{})
+	assert.ErrorContains(t, err, "email and API token are required")
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Environment variables configuring the logs.
+const (
+	EnvLogLevel  = "BITBUCKET_METRICS_LOG_LEVEL"
+	EnvLogFormat = "BITBUCKET_METRICS_LOG_FORMAT"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Keys of the fields shared by the logs of every package.
+const (
+	KeyRunID     = "run_id"
+	KeyWorkspace = "workspace"
+	KeyRepo      = "repo"
+	KeyCommit    = "commit"
+	KeyError     = "error"
+)
+
+// Options select the level and format of the logs.
+type Options struct {
+	Level  slog.Level
+	Format string
+}
+
+// OptionsFromEnv reads the options from EnvLogLevel and EnvLogFormat, defaulting to info level text logs.
+func OptionsFromEnv() (Options, error) {
+	opts := Options{Level: slog.LevelInfo, Format: FormatText}
+	if level := os.Getenv(EnvLogLevel); level != "" {
+		if err := opts.Level.UnmarshalText([]byte(level)); err != nil {
+			return Options{}, fmt.Errorf("invalid %s %q: %w", EnvLogLevel, level, err)
+		}
+	}
+	if format := os.Getenv(EnvLogFormat); format != "" {
+		opts.Format = strings.ToLower(format)
+	}
+	return opts, nil
+}
+
+// New returns a logger writing to w in the format of opts, redacting credentials from every field.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: redactAttr}
+	switch opts.Format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+}
+
+// Setup makes a logger writing to stderr the default, which the log package writes through as well.
+func Setup(opts Options) error {
+	logger, err := New(os.Stderr, opts)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// NewRunID returns a random ID correlating the logs of a run.
+func NewRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate run ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,33 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+)
+
+// now is the clock used for retention cutoffs and audit timestamps.
+var now = time.Now
+
+// AuditEntry records one deletion or anonymization in the audit log collection.
+type AuditEntry struct {
+	Action     string    `bson:"action"`
+	Collection string    `bson:"collection"`
+	Workspace  string    `bson:"workspace,omitempty"`
+	PersonID   string    `bson:"person_id,omitempty"`
+	Cutoff     time.Time `bson:"cutoff,omitempty"`
+	Count      int64     `bson:"count"`
+	Actor      string    `bson:"actor"`
+	At         time.Time `bson:"at"`
+}
+
+// audit appends entry to the audit log, stamped with the current time.
+func audit(ctx context.Context, entry AuditEntry) error {
+	entry.At = now().UTC()
+	if _, err := db.GetNamedCollection(db.AuditLogCollection).InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write audit log entry for %s on %s: %w", entry.Action, entry.Collection, err)
+	}
+	return nil
+}
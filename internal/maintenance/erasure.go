@@ -0,0 +1,189 @@
+package maintenance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Erasure modes.
+const (
+	// ModeDelete deletes the commits and pull requests of the person.
+	ModeDelete = "delete"
+	// ModeAnonymize keeps them for aggregates but unlinks them from the person.
+	ModeAnonymize = "anonymize"
+)
+
+// ErasedName replaces the names of erased people.
+const ErasedName = "erased"
+
+// ErasureRequest asks for every record attributable to one person to be erased.
+type ErasureRequest struct {
+	// PersonID is the person ID as stored, which is a pseudonym in privacy mode.
+	PersonID string
+	// RegistryPersonID is the person ID as listed in the team registry, which is never pseudonymized.
+	RegistryPersonID string
+	Mode             string
+	Actor            string
+	// Hasher keys the hashes the names of the person are recorded by, so syncs can recognise them as reviewers.
+	Hasher *privacy.Pseudonymizer
+}
+
+// errNoHasher is returned when erasures are recorded or applied without a pseudonym key.
+var errNoHasher = errors.New("erasures need a pseudonym key to hash the names of erased people")
+
+// newErasedID returns the person ID anonymized records are moved to. It is random so erased people cannot
+// be linked back together, while their records still count as one distinct author.
+var newErasedID = func() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "erased-" + hex.EncodeToString(b)
+}
+
+// Erase deletes or anonymizes the commits and pull requests of a person, removes their name from the
+// commits they reviewed, deletes or anonymizes their pull request review events and removes their memberships
+// from the teams collection, writing an audit log entry for every change. The person is recorded in the
+// erasures collection first, so syncs keep them erased.
+func Erase(ctx context.Context, req ErasureRequest) error {
+	if req.PersonID == "" {
+		return fmt.Errorf("person ID is required")
+	}
+	if req.Mode != ModeDelete && req.Mode != ModeAnonymize {
+		return fmt.Errorf("unknown erasure mode %q, expected %s or %s", req.Mode, ModeDelete, ModeAnonymize)
+	}
+	if req.Hasher == nil {
+		return errNoHasher
+	}
+	if req.RegistryPersonID == "" {
+		req.RegistryPersonID = req.PersonID
+	}
+
+	// Reviewers are stored by name only, so the names the person committed and opened pull requests
+	// under are collected before their records change
+	aliases, err := collectAliases(ctx, req.PersonID)
+	if err != nil {
+		return err
+	}
+
+	erasedID := newErasedID()
+	hashedAliases := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		hashedAliases = append(hashedAliases, req.Hasher.Pseudonym(alias))
+	}
+	erasure := Erasure{PersonID: req.PersonID, Aliases: hashedAliases, Mode: req.Mode, ErasedID: erasedID, At: now().UTC()}
+	if err := recordErasure(ctx, erasure); err != nil {
+		return err
+	}
+
+	commits := db.GetNamedCollection(db.CommitsCollection)
+	pullRequests := db.GetNamedCollection(db.PullRequestsCollection)
+
+	if req.Mode == ModeDelete {
+		if err := eraseDelete(ctx, req, commits, db.CommitsCollection, bson.M{"person_id": req.PersonID}); err != nil {
+			return err
+		}
+		if err := eraseDelete(ctx, req, pullRequests, db.PullRequestsCollection, bson.M{"author_person_id": req.PersonID}); err != nil {
+			return err
+		}
+	} else {
+		update := bson.M{
+			"$set":   bson.M{"committed_by": ErasedName, "person_id": erasedID},
+			"$unset": bson.M{"author_raw": "", "author_email": "", "author_uuid": "", "author_nickname": ""},
+		}
+		if err := eraseUpdate(ctx, req, commits, db.CommitsCollection, bson.M{"person_id": req.PersonID}, update); err != nil {
+			return err
+		}
+		update = bson.M{"$set": bson.M{"author": ErasedName, "author_person_id": erasedID}}
+		if err := eraseUpdate(ctx, req, pullRequests, db.PullRequestsCollection, bson.M{"author_person_id": req.PersonID}, update); err != nil {
+			return err
+		}
+	}
+
+	if len(aliases) > 0 {
+		query := bson.M{"reviewed_by": bson.M{"$in": aliases}}
+		update := bson.M{"$set": bson.M{"reviewed_by": ErasedName}}
+		if err := eraseUpdate(ctx, req, commits, db.CommitsCollection, query, update); err != nil {
+			return err
+		}
+	}
+
+	// Review events of other people's pull requests are pulled on deletion and unlinked otherwise
+	query := bson.M{"events.actor_person_id": req.PersonID}
+	update := bson.M{"$pull": bson.M{"events": bson.M{"actor_person_id": req.PersonID}}}
+	var opts []*options.UpdateOptions
+	if req.Mode == ModeAnonymize {
+		update = bson.M{"$set": bson.M{"events.$[e].actor": ErasedName, "events.$[e].actor_person_id": erasedID}}
+		opts = append(opts, options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"e.actor_person_id": req.PersonID}},
+		}))
+	}
+	if err := eraseUpdate(ctx, req, pullRequests, db.PullRequestsCollection, query, update, opts...); err != nil {
+		return err
+	}
+
+	teams := db.GetNamedCollection(db.TeamsCollection)
+	query = bson.M{"members.person_id": req.RegistryPersonID}
+	update = bson.M{"$pull": bson.M{"members": bson.M{"person_id": req.RegistryPersonID}}}
+	return eraseUpdate(ctx, req, teams, db.TeamsCollection, query, update)
+}
+
+func eraseDelete(ctx context.Context, req ErasureRequest, collection db.CollectionInterface, name string, query bson.M) error {
+	result, err := collection.DeleteMany(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to erase from %s: %w", name, err)
+	}
+	slog.Info("Erasure deleted documents", "collection", name, "count", result.DeletedCount)
+	return audit(ctx, AuditEntry{Action: ModeDelete, Collection: name, PersonID: req.PersonID, Count: result.DeletedCount, Actor: req.Actor})
+}
+
+func eraseUpdate(ctx context.Context, req ErasureRequest, collection db.CollectionInterface, name string, query, update bson.M, opts ...*options.UpdateOptions) error {
+	result, err := collection.UpdateMany(ctx, query, update, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to erase from %s: %w", name, err)
+	}
+	slog.Info("Erasure anonymized documents", "collection", name, "count", result.ModifiedCount)
+	return audit(ctx, AuditEntry{Action: ModeAnonymize, Collection: name, PersonID: req.PersonID, Count: result.ModifiedCount, Actor: req.Actor})
+}
+
+// collectAliases returns every name personID committed or opened pull requests under.
+func collectAliases(ctx context.Context, personID string) ([]string, error) {
+	seen := map[string]bool{}
+	var aliases []string
+	sources := []struct {
+		collection  string
+		personField string
+		nameField   string
+	}{
+		{db.CommitsCollection, "person_id", "committed_by"},
+		{db.PullRequestsCollection, "author_person_id", "author"},
+	}
+
+	for _, source := range sources {
+		opts := options.Find().SetProjection(bson.M{source.nameField: 1})
+		cursor, err := db.GetNamedCollection(source.collection).Find(ctx, bson.M{source.personField: personID}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up names in %s: %w", source.collection, err)
+		}
+		for cursor.Next(ctx) {
+			name, _ := cursor.Current.Lookup(source.nameField).StringValueOK()
+			if name != "" && !seen[name] {
+				seen[name] = true
+				aliases = append(aliases, name)
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up names in %s: %w", source.collection, err)
+		}
+	}
+	return aliases, nil
+}
@@ -0,0 +1,211 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testHasher hashes the names of erased people.
+var testHasher, _ = privacy.New("0123456789abcdef0123456789abcdef", false)
+
+type erasureMocks struct {
+	commits, pullRequests, teams, auditLog, erasures *db.MockCollection
+	entries                                          []AuditEntry
+}
+
+func newErasureMocks(t *testing.T) *erasureMocks {
+	m := &erasureMocks{
+		commits:      new(db.MockCollection),
+		pullRequests: new(db.MockCollection),
+		teams:        new(db.MockCollection),
+		auditLog:     new(db.MockCollection),
+		erasures:     new(db.MockCollection),
+	}
+	useCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      m.commits,
+		db.PullRequestsCollection: m.pullRequests,
+		db.TeamsCollection:        m.teams,
+		db.AuditLogCollection:     m.auditLog,
+		db.ErasuresCollection:     m.erasures,
+	})
+
+	oldNewErasedID := newErasedID
+	t.Cleanup(func() { newErasedID = oldNewErasedID })
+	newErasedID = func() string { return "erased-1" }
+
+	m.commits.On("Find", mock.Anything, bson.M{"person_id": "p-1"}, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"committed_by": "Jane"}, bson.M{"committed_by": "jdoe"}, bson.M{"committed_by": "Jane"},
+	), nil).Once()
+	m.pullRequests.On("Find", mock.Anything, bson.M{"author_person_id": "p-1"}, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"author": "Jane Doe"},
+	), nil).Once()
+	m.commits.On("UpdateMany", mock.Anything,
+		bson.M{"reviewed_by": bson.M{"$in": []string{"Jane", "jdoe", "Jane Doe"}}},
+		bson.M{"$set": bson.M{"reviewed_by": ErasedName}}, mock.Anything).
+		Return(&mongo.UpdateResult{ModifiedCount: 4}, nil).Once()
+	m.teams.On("UpdateMany", mock.Anything,
+		bson.M{"members.person_id": "jane"},
+		bson.M{"$pull": bson.M{"members": bson.M{"person_id": "jane"}}}, mock.Anything).
+		Return(&mongo.UpdateResult{ModifiedCount: 1}, nil).Once()
+	m.erasures.On("UpdateOne", mock.Anything, bson.M{"person_id": "p-1"}, mock.Anything, mock.Anything).
+		Return(&mongo.UpdateResult{UpsertedCount: 1}, nil).Once()
+	m.auditLog.On("InsertOne", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		m.entries = append(m.entries, args.Get(1).(AuditEntry))
+	}).Return(&mongo.InsertOneResult{}, nil)
+	return m
+}
+
+func (m *erasureMocks) assertExpectations(t *testing.T) {
+	m.commits.AssertExpectations(t)
+	m.pullRequests.AssertExpectations(t)
+	m.teams.AssertExpectations(t)
+	m.erasures.AssertExpectations(t)
+}
+
+// recordedErasure returns the update the erasure of the person was recorded with.
+func (m *erasureMocks) recordedErasure(t *testing.T) bson.M {
+	for _, call := range m.erasures.Calls {
+		if call.Method == "UpdateOne" {
+			return call.Arguments.Get(2).(bson.M)
+		}
+	}
+	t.Fatal("erasure was not recorded")
+	return nil
+}
+
+func TestErase_Delete(t *testing.T) {
+	m := newErasureMocks(t)
+	m.commits.On("DeleteMany", mock.Anything, bson.M{"person_id": "p-1"}, mock.Anything).
+		Return(&mongo.DeleteResult{DeletedCount: 3}, nil).Once()
+	m.pullRequests.On("DeleteMany", mock.Anything, bson.M{"author_person_id": "p-1"}, mock.Anything).
+		Return(&mongo.DeleteResult{DeletedCount: 1}, nil).Once()
+	m.pullRequests.On("UpdateMany", mock.Anything,
+		bson.M{"events.actor_person_id": "p-1"},
+		bson.M{"$pull": bson.M{"events": bson.M{"actor_person_id": "p-1"}}},
+		[]*options.UpdateOptions(nil)).
+		Return(&mongo.UpdateResult{ModifiedCount: 2}, nil).Once()
+
+	err := Erase(context.Background(), ErasureRequest{PersonID: "p-1", RegistryPersonID: "jane", Mode: ModeDelete, Actor: "dpo", Hasher: testHasher})
+	assert.NoError(t, err)
+	m.assertExpectations(t)
+
+	assert.Len(t, m.entries, 5)
+	assert.Equal(t, AuditEntry{Action: ModeDelete, Collection: db.CommitsCollection, PersonID: "p-1", Count: 3, Actor: "dpo", At: testNow}, m.entries[0])
+	assert.Equal(t, AuditEntry{Action: ModeAnonymize, Collection: db.CommitsCollection, PersonID: "p-1", Count: 4, Actor: "dpo", At: testNow}, m.entries[2])
+	assert.Equal(t, AuditEntry{Action: ModeAnonymize, Collection: db.PullRequestsCollection, PersonID: "p-1", Count: 2, Actor: "dpo", At: testNow}, m.entries[3])
+	assert.Equal(t, db.TeamsCollection, m.entries[4].Collection)
+
+	assert.Equal(t, bson.M{
+		"$set": bson.M{"mode": ModeDelete, "erased_id": "erased-1", "at": testNow},
+		"$addToSet": bson.M{"aliases": bson.M{"$each": []string{
+			testHasher.Pseudonym("Jane"), testHasher.Pseudonym("jdoe"), testHasher.Pseudonym("Jane Doe"),
+		}}},
+	}, m.recordedErasure(t))
+}
+
+func TestErase_Anonymize(t *testing.T) {
+	m := newErasureMocks(t)
+	m.commits.On("UpdateMany", mock.Anything, bson.M{"person_id": "p-1"}, bson.M{
+		"$set":   bson.M{"committed_by": ErasedName, "person_id": "erased-1"},
+		"$unset": bson.M{"author_raw": "", "author_email": "", "author_uuid": "", "author_nickname": ""},
+	}, mock.Anything).Return(&mongo.UpdateResult{ModifiedCount: 3}, nil).Once()
+	m.pullRequests.On("UpdateMany", mock.Anything, bson.M{"author_person_id": "p-1"}, bson.M{
+		"$set": bson.M{"author": ErasedName, "author_person_id": "erased-1"},
+	}, mock.Anything).Return(&mongo.UpdateResult{ModifiedCount: 1}, nil).Once()
+	m.pullRequests.On("UpdateMany", mock.Anything, bson.M{"events.actor_person_id": "p-1"}, bson.M{
+		"$set": bson.M{"events.$[e].actor": ErasedName, "events.$[e].actor_person_id": "erased-1"},
+	}, mock.MatchedBy(func(opts []*options.UpdateOptions) bool {
+		return len(opts) == 1 && assert.Equal(t, []interface{}{bson.M{"e.actor_person_id": "p-1"}}, opts[0].ArrayFilters.Filters)
+	})).Return(&mongo.UpdateResult{ModifiedCount: 2}, nil).Once()
+
+	err := Erase(context.Background(), ErasureRequest{PersonID: "p-1", RegistryPersonID: "jane", Mode: ModeAnonymize, Actor: "dpo", Hasher: testHasher})
+	assert.NoError(t, err)
+	m.assertExpectations(t)
+
+	assert.Len(t, m.entries, 5)
+	for _, entry := range m.entries {
+		assert.Equal(t, ModeAnonymize, entry.Action)
+	}
+	assert.Equal(t, ModeAnonymize, m.recordedErasure(t)["$set"].(bson.M)["mode"])
+}
+
+func TestErase_RecordError(t *testing.T) {
+	commits, pullRequests, erasures := new(db.MockCollection), new(db.MockCollection), new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	pullRequests.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	erasures.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return((*mongo.UpdateResult)(nil), errors.New("timeout")).Once()
+	useCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+		db.ErasuresCollection:     erasures,
+	})
+
+	// Nothing is erased unless the erasure is recorded, so syncs cannot restore it
+	err := Erase(context.Background(), ErasureRequest{PersonID: "p-1", Mode: ModeDelete, Hasher: testHasher})
+	assert.EqualError(t, err, "failed to record erasure: timeout")
+	commits.AssertNotCalled(t, "DeleteMany", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoadSuppressions(t *testing.T) {
+	erasures := new(db.MockCollection)
+	for i := 0; i < 2; i++ {
+		erasures.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(db.NewCursor(t,
+			Erasure{PersonID: "p-1", Aliases: []string{testHasher.Pseudonym("Jane"), testHasher.Pseudonym("jdoe")}, Mode: ModeDelete},
+			Erasure{PersonID: "p-2", Aliases: []string{testHasher.Pseudonym("Rick")}, Mode: ModeAnonymize, ErasedID: "erased-2"},
+		), nil).Once()
+	}
+	useCollections(t, map[string]*db.MockCollection{db.ErasuresCollection: erasures})
+
+	// Names are only recorded as hashes, so they cannot be matched without the key
+	_, err := LoadSuppressions(context.Background(), nil)
+	assert.EqualError(t, err, "erasures need a pseudonym key to hash the names of erased people")
+
+	suppressions, err := LoadSuppressions(context.Background(), testHasher)
+	assert.NoError(t, err)
+
+	erasure, ok := suppressions.Person("p-2")
+	assert.True(t, ok)
+	assert.Equal(t, "erased-2", erasure.ErasedID)
+	_, ok = suppressions.Person("p-3")
+	assert.False(t, ok)
+	assert.True(t, suppressions.Alias("jdoe"))
+	assert.False(t, suppressions.Alias("Rick Roe"))
+
+	var none *Suppressions
+	_, ok = none.Person("p-1")
+	assert.False(t, ok)
+	assert.False(t, none.Alias("Jane"))
+}
+
+func TestErase_InvalidRequest(t *testing.T) {
+	useCollections(t, map[string]*db.MockCollection{})
+
+	assert.ErrorContains(t, Erase(context.Background(), ErasureRequest{Mode: ModeDelete}), "person ID is required")
+	assert.ErrorContains(t, Erase(context.Background(), ErasureRequest{PersonID: "p-1", Mode: "purge"}), "unknown erasure mode")
+	assert.ErrorContains(t, Erase(context.Background(), ErasureRequest{PersonID: "p-1", Mode: ModeDelete}), "need a pseudonym key")
+}
+
+func TestErase_LookupError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	useCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	err := Erase(context.Background(), ErasureRequest{PersonID: "p-1", Mode: ModeDelete, Hasher: testHasher})
+	assert.ErrorContains(t, err, "failed to look up names in metrics")
+}
+
+func TestNewErasedID(t *testing.T) {
+	id := newErasedID()
+	assert.Regexp(t, `^erased-[0-9a-f]{16}$`, id)
+	assert.NotEqual(t, id, newErasedID())
+}
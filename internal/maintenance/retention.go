@@ -0,0 +1,74 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RetentionActor is the actor recorded in the audit log for retention deletions.
+const RetentionActor = "retention-job"
+
+// retainedCollections maps each collection subject to retention to the date field its age is measured by.
+var retainedCollections = []struct {
+	name      string
+	dateField string
+}{
+	{db.CommitsCollection, "commit_date"},
+	{db.PullRequestsCollection, "created_on"},
+	{db.PipelinesCollection, "created_on"},
+}
+
+// EnforceRetention deletes the commits, pull requests and pipelines older than the retention period of their
+// workspace, writing an audit log entry for every deletion.
+func EnforceRetention(ctx context.Context, policies []config.RetentionPolicy) error {
+	var explicit []string
+	seen := map[string]bool{}
+	for _, policy := range policies {
+		if policy.Months < 1 {
+			return fmt.Errorf("retention policy for workspace %q: months must be positive", policy.Workspace)
+		}
+		if seen[policy.Workspace] {
+			return fmt.Errorf("retention policy for workspace %q is defined twice", policy.Workspace)
+		}
+		seen[policy.Workspace] = true
+		if policy.Workspace != "" {
+			explicit = append(explicit, policy.Workspace)
+		}
+	}
+
+	for _, policy := range policies {
+		cutoff := now().UTC().AddDate(0, -policy.Months, 0)
+		for _, c := range retainedCollections {
+			query := bson.M{c.dateField: bson.M{"$lt": cutoff}}
+			if policy.Workspace != "" {
+				query["workspace"] = policy.Workspace
+			} else if len(explicit) > 0 {
+				query["workspace"] = bson.M{"$nin": explicit}
+			}
+
+			result, err := db.GetNamedCollection(c.name).DeleteMany(ctx, query)
+			if err != nil {
+				return fmt.Errorf("failed to apply retention to %s: %w", c.name, err)
+			}
+			slog.Info("Retention deleted documents", "collection", c.name, "cutoff", cutoff.Format("2006-01-02"), "count", result.DeletedCount)
+
+			err = audit(ctx, AuditEntry{
+				Action:     "retention",
+				Collection: c.name,
+				Workspace:  policy.Workspace,
+				Cutoff:     cutoff,
+				Count:      result.DeletedCount,
+				Actor:      RetentionActor,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var testNow = time.Date(2024, 9, 15, 12, 0, 0, 0, time.UTC)
+
+// useCollections routes collection lookups to the given mocks and freezes the clock for the duration of the test.
+func useCollections(t *testing.T, collections map[string]*db.MockCollection) {
+	db.UseCollections(t, collections)
+	oldNow := now
+	t.Cleanup(func() { now = oldNow })
+	now = func() time.Time { return testNow }
+}
+
+func TestEnforceRetention(t *testing.T) {
+	commits := new(db.MockCollection)
+	pullRequests := new(db.MockCollection)
+	pipelines := new(db.MockCollection)
+	auditLog := new(db.MockCollection)
+	useCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+		db.PipelinesCollection:    pipelines,
+		db.AuditLogCollection:     auditLog,
+	})
+
+	lep13Cutoff := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	defaultCutoff := time.Date(2023, 9, 15, 12, 0, 0, 0, time.UTC)
+
+	commits.On("DeleteMany", mock.Anything, bson.M{"commit_date": bson.M{"$lt": lep13Cutoff}, "workspace": "lep13"}, mock.Anything).
+		Return(&mongo.DeleteResult{DeletedCount: 5}, nil).Once()
+	commits.On("DeleteMany", mock.Anything, bson.M{"commit_date": bson.M{"$lt": defaultCutoff}, "workspace": bson.M{"$nin": []string{"lep13"}}}, mock.Anything).
+		Return(&mongo.DeleteResult{DeletedCount: 1}, nil).Once()
+	pullRequests.On("DeleteMany", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.DeleteResult{}, nil).Twice()
+	pipelines.On("DeleteMany", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.DeleteResult{}, nil).Twice()
+
+	var entries []AuditEntry
+	auditLog.On("InsertOne", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		entries = append(entries, args.Get(1).(AuditEntry))
+	}).Return(&mongo.InsertOneResult{}, nil)
+
+	err := EnforceRetention(context.Background(), []config.RetentionPolicy{
+		{Workspace: "lep13", Months: 6},
+		{Months: 12},
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, entries, 6)
+	assert.Equal(t, AuditEntry{
+		Action:     "retention",
+		Collection: db.CommitsCollection,
+		Workspace:  "lep13",
+		Cutoff:     lep13Cutoff,
+		Count:      5,
+		Actor:      RetentionActor,
+		At:         testNow,
+	}, entries[0])
+	assert.Equal(t, int64(1), entries[3].Count)
+	commits.AssertExpectations(t)
+	pullRequests.AssertExpectations(t)
+	pipelines.AssertExpectations(t)
+}
+
+func TestEnforceRetention_InvalidPolicies(t *testing.T) {
+	useCollections(t, map[string]*db.MockCollection{})
+
+	err := EnforceRetention(context.Background(), []config.RetentionPolicy{{Workspace: "lep13"}})
+	assert.ErrorContains(t, err, "months must be positive")
+
+	err = EnforceRetention(context.Background(), []config.RetentionPolicy{{Months: 1}, {Months: 2}})
+	assert.ErrorContains(t, err, "defined twice")
+}
+
+func TestEnforceRetention_DeleteError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("DeleteMany", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	useCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	err := EnforceRetention(context.Background(), []config.RetentionPolicy{{Months: 12}})
+	assert.ErrorContains(t, err, "failed to apply retention to metrics")
+}
+
+func TestEnforceRetention_AuditError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("DeleteMany", mock.Anything, mock.Anything, mock.Anything).Return(&mongo.DeleteResult{}, nil).Once()
+	auditLog := new(db.MockCollection)
+	auditLog.On("InsertOne", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	useCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:  commits,
+		db.AuditLogCollection: auditLog,
+	})
+
+	err := EnforceRetention(context.Background(), []config.RetentionPolicy{{Months: 12}})
+	assert.ErrorContains(t, err, "failed to write audit log entry")
+}
@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Erasure records an erased person in the erasures collection, so that syncs keep them erased instead of
+// restoring their records from the source.
+type Erasure struct {
+	// PersonID is the person ID as stored, which is a pseudonym in privacy mode.
+	PersonID string `bson:"person_id"`
+	// Aliases are keyed hashes of the names the person committed and opened pull requests under, which reviewers
+	// are stored by. The names themselves are never stored.
+	Aliases []string `bson:"aliases"`
+	Mode    string   `bson:"mode"`
+	// ErasedID is the person ID anonymized records were moved to.
+	ErasedID string    `bson:"erased_id"`
+	At       time.Time `bson:"at"`
+}
+
+// recordErasure adds or updates the erasure of a person, keeping the aliases of earlier erasures.
+func recordErasure(ctx context.Context, erasure Erasure) error {
+	aliases := erasure.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+	_, err := db.GetNamedCollection(db.ErasuresCollection).UpdateOne(ctx,
+		bson.M{"person_id": erasure.PersonID},
+		bson.M{
+			"$set":      bson.M{"mode": erasure.Mode, "erased_id": erasure.ErasedID, "at": erasure.At},
+			"$addToSet": bson.M{"aliases": bson.M{"$each": aliases}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record erasure: %w", err)
+	}
+	return nil
+}
+
+// Suppressions are the recorded erasures a sync applies to the records it fetches. The zero value and nil
+// suppress nothing.
+type Suppressions struct {
+	people  map[string]Erasure
+	aliases map[string]bool
+	hasher  *privacy.Pseudonymizer
+}
+
+// LoadSuppressions reads every recorded erasure. Names are matched against the aliases of erased people by their
+// hashes keyed by hasher, which must be the one the erasures were recorded with.
+func LoadSuppressions(ctx context.Context, hasher *privacy.Pseudonymizer) (*Suppressions, error) {
+	cursor, err := db.GetNamedCollection(db.ErasuresCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load erasures: %w", err)
+	}
+	var erasures []Erasure
+	if err := cursor.All(ctx, &erasures); err != nil {
+		return nil, fmt.Errorf("failed to load erasures: %w", err)
+	}
+
+	s := &Suppressions{people: map[string]Erasure{}, aliases: map[string]bool{}, hasher: hasher}
+	for _, erasure := range erasures {
+		if hasher == nil && len(erasure.Aliases) > 0 {
+			return nil, errNoHasher
+		}
+		s.people[erasure.PersonID] = erasure
+		for _, alias := range erasure.Aliases {
+			s.aliases[alias] = true
+		}
+	}
+	return s, nil
+}
+
+// Person returns the erasure of personID, if they were erased.
+func (s *Suppressions) Person(personID string) (Erasure, bool) {
+	if s == nil || personID == "" {
+		return Erasure{}, false
+	}
+	erasure, ok := s.people[personID]
+	return erasure, ok
+}
+
+// Alias reports whether name is a name an erased person went by.
+func (s *Suppressions) Alias(name string) bool {
+	return s != nil && name != "" && s.aliases[s.hasher.Pseudonym(name)]
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bitbucket_metrics"
+
+// Registry holds every metric exposed on /metrics.
+var Registry = prometheus.NewRegistry()
+
+// HTTPRequests counts the calls made to the Bitbucket API by endpoint and response status.
+var HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_requests_total",
+	Help:      "Bitbucket API calls by endpoint and status code (\"error\" when no response was received).",
+}, []string{"endpoint", "status"})
+
+// HTTPRetries counts the Bitbucket API calls retried after a throttled or unavailable response.
+var HTTPRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_retries_total",
+	Help:      "Bitbucket API calls retried by endpoint.",
+}, []string{"endpoint"})
+
+// UpsertFailures counts the documents that could not be written by collection.
+var UpsertFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "upsert_failures_total",
+	Help:      "Failed MongoDB upserts by collection.",
+}, []string{"collection"})
+
+// RunDuration observes how long each sync run takes.
+var RunDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "sync_run_duration_seconds",
+	Help:      "Duration of sync runs.",
+	Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+})
+
+// LastRunTimestamp records when the last sync run finished.
+var LastRunTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "sync_last_run_timestamp_seconds",
+	Help:      "Unix time at which the last sync run finished.",
+})
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRetries,
+		UpsertFailures,
+		RunDuration,
+		LastRunTimestamp,
+		NewStoreCollector(),
+	)
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
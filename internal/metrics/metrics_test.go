@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandler(t *testing.T) {
+	empty := new(db.MockCollection)
+	for i := 0; i < 4; i++ {
+		empty.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	}
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      empty,
+		db.PullRequestsCollection: empty,
+		db.PipelinesCollection:    empty,
+	})
+
+	HTTPRequests.WithLabelValues("repositories", "200").Inc()
+	UpsertFailures.WithLabelValues(db.CommitsCollection).Inc()
+	RunDuration.Observe(12)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	body, _ := io.ReadAll(rec.Body)
+	assert.Contains(t, string(body), `bitbucket_metrics_http_requests_total{endpoint="repositories",status="200"}`)
+	assert.Contains(t, string(body), `bitbucket_metrics_upsert_failures_total{collection="metrics"}`)
+	assert.Contains(t, string(body), "bitbucket_metrics_sync_run_duration_seconds_count 1")
+	assert.Contains(t, string(body), "bitbucket_metrics_store_up 1")
+	assert.Contains(t, string(body), "go_goroutines")
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// now is replaced in tests to get stable windows and ages.
+var now = time.Now
+
+// ActivityWindowDays is how many days of daily commit counts and pipeline results are exported.
+var ActivityWindowDays = 7
+
+// scrapeTimeout bounds the queries run for a single scrape.
+const scrapeTimeout = 10 * time.Second
+
+var repoLabels = []string{"workspace", "project", "repo"}
+
+var (
+	commitsDesc = prometheus.NewDesc(namespace+"_repo_commits",
+		"Commits per repository and day over the activity window.",
+		append(repoLabels, "day"), nil)
+	linesAddedDesc = prometheus.NewDesc(namespace+"_repo_lines_added",
+		"Lines added by all stored commits of the repository.",
+		repoLabels, nil)
+	linesDeletedDesc = prometheus.NewDesc(namespace+"_repo_lines_deleted",
+		"Lines deleted by all stored commits of the repository.",
+		repoLabels, nil)
+	openPullRequestsDesc = prometheus.NewDesc(namespace+"_repo_open_pull_requests",
+		"Open pull requests of the repository.",
+		repoLabels, nil)
+	openPullRequestMaxAgeDesc = prometheus.NewDesc(namespace+"_repo_open_pull_request_max_age_seconds",
+		"Age of the oldest open pull request of the repository.",
+		repoLabels, nil)
+	openPullRequestAvgAgeDesc = prometheus.NewDesc(namespace+"_repo_open_pull_request_avg_age_seconds",
+		"Average age of the open pull requests of the repository.",
+		repoLabels, nil)
+	pipelinesDesc = prometheus.NewDesc(namespace+"_repo_pipelines_completed",
+		"Pipelines completed over the activity window.",
+		repoLabels, nil)
+	pipelineSuccessDesc = prometheus.NewDesc(namespace+"_repo_pipeline_success_ratio",
+		"Share of the pipelines completed over the activity window that succeeded.",
+		repoLabels, nil)
+	storeUpDesc = prometheus.NewDesc(namespace+"_store_up",
+		"Whether the last scrape of the metrics store succeeded.",
+		nil, nil)
+)
+
+// repoKey identifies a repository in aggregation results.
+type repoKey struct {
+	Workspace   string `bson:"workspace"`
+	ProjectName string `bson:"project_name"`
+	RepoName    string `bson:"repo_name"`
+}
+
+func (k repoKey) labels() []string {
+	return []string{k.Workspace, k.ProjectName, k.RepoName}
+}
+
+var repoGroupKey = bson.D{
+	{Key: "workspace", Value: "$workspace"},
+	{Key: "project_name", Value: "$project_name"},
+	{Key: "repo_name", Value: "$repo_name"},
+}
+
+// StoreCollector derives repository activity metrics from the stored commits, pull requests and pipelines
+// each time it is scraped.
+type StoreCollector struct{}
+
+// NewStoreCollector returns a collector querying the metrics store.
+func NewStoreCollector() *StoreCollector {
+	return &StoreCollector{}
+}
+
+// Describe implements prometheus.Collector.
+func (c *StoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		commitsDesc, linesAddedDesc, linesDeletedDesc,
+		openPullRequestsDesc, openPullRequestMaxAgeDesc, openPullRequestAvgAgeDesc,
+		pipelinesDesc, pipelineSuccessDesc, storeUpDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *StoreCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	up := 1.0
+	for _, collect := range []func(context.Context, chan<- prometheus.Metric) error{
+		collectCommits,
+		collectLines,
+		collectOpenPullRequests,
+		collectPipelines,
+	} {
+		if err := collect(ctx, ch); err != nil {
+			slog.Warn("Failed to collect store metrics", logging.KeyError, err)
+			up = 0
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(storeUpDesc, prometheus.GaugeValue, up)
+}
+
+func windowStart() time.Time {
+	today := now().UTC().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, -(ActivityWindowDays - 1))
+}
+
+// collectCommits and collectLines leave out the classifications aggregates exclude by default, such as merges
+// and bot commits, like the API does.
+func collectCommits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: db.Filter{From: windowStart()}.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.D{
+				{Key: "workspace", Value: "$workspace"},
+				{Key: "project_name", Value: "$project_name"},
+				{Key: "repo_name", Value: "$repo_name"},
+				{Key: "day", Value: bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$commit_date"}}},
+			},
+			"commits": bson.M{"$sum": 1},
+		}}},
+	}
+	var results []struct {
+		Key struct {
+			Workspace   string `bson:"workspace"`
+			ProjectName string `bson:"project_name"`
+			RepoName    string `bson:"repo_name"`
+			Day         string `bson:"day"`
+		} `bson:"_id"`
+		Commits int `bson:"commits"`
+	}
+	if err := aggregate(ctx, db.GetCollection(), pipeline, &results); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		ch <- prometheus.MustNewConstMetric(commitsDesc, prometheus.GaugeValue, float64(r.Commits),
+			r.Key.Workspace, r.Key.ProjectName, r.Key.RepoName, r.Key.Day)
+	}
+	return nil
+}
+
+func collectLines(ctx context.Context, ch chan<- prometheus.Metric) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: db.Filter{}.CommitQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           repoGroupKey,
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		}}},
+	}
+	var results []struct {
+		Key          repoKey `bson:"_id"`
+		LinesAdded   int     `bson:"lines_added"`
+		LinesDeleted int     `bson:"lines_deleted"`
+	}
+	if err := aggregate(ctx, db.GetCollection(), pipeline, &results); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		ch <- prometheus.MustNewConstMetric(linesAddedDesc, prometheus.GaugeValue, float64(r.LinesAdded), r.Key.labels()...)
+		ch <- prometheus.MustNewConstMetric(linesDeletedDesc, prometheus.GaugeValue, float64(r.LinesDeleted), r.Key.labels()...)
+	}
+	return nil
+}
+
+func collectOpenPullRequests(ctx context.Context, ch chan<- prometheus.Metric) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"state": "OPEN"}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         repoGroupKey,
+			"open":        bson.M{"$sum": 1},
+			"oldest":      bson.M{"$min": "$created_on"},
+			"avg_created": bson.M{"$avg": bson.M{"$toLong": "$created_on"}},
+		}}},
+	}
+	var results []struct {
+		Key        repoKey   `bson:"_id"`
+		Open       int       `bson:"open"`
+		Oldest     time.Time `bson:"oldest"`
+		AvgCreated float64   `bson:"avg_created"`
+	}
+	if err := aggregate(ctx, db.GetNamedCollection(db.PullRequestsCollection), pipeline, &results); err != nil {
+		return err
+	}
+
+	current := now()
+	for _, r := range results {
+		avgCreated := time.UnixMilli(int64(r.AvgCreated))
+		ch <- prometheus.MustNewConstMetric(openPullRequestsDesc, prometheus.GaugeValue, float64(r.Open), r.Key.labels()...)
+		ch <- prometheus.MustNewConstMetric(openPullRequestMaxAgeDesc, prometheus.GaugeValue, current.Sub(r.Oldest).Seconds(), r.Key.labels()...)
+		ch <- prometheus.MustNewConstMetric(openPullRequestAvgAgeDesc, prometheus.GaugeValue, current.Sub(avgCreated).Seconds(), r.Key.labels()...)
+	}
+	return nil
+}
+
+func collectPipelines(ctx context.Context, ch chan<- prometheus.Metric) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"state": "COMPLETED", "created_on": bson.M{"$gte": windowStart()}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        repoGroupKey,
+			"completed":  bson.M{"$sum": 1},
+			"successful": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$result", "SUCCESSFUL"}}, 1, 0}}},
+		}}},
+	}
+	var results []struct {
+		Key        repoKey `bson:"_id"`
+		Completed  int     `bson:"completed"`
+		Successful int     `bson:"successful"`
+	}
+	if err := aggregate(ctx, db.GetNamedCollection(db.PipelinesCollection), pipeline, &results); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		ch <- prometheus.MustNewConstMetric(pipelinesDesc, prometheus.GaugeValue, float64(r.Completed), r.Key.labels()...)
+		ch <- prometheus.MustNewConstMetric(pipelineSuccessDesc, prometheus.GaugeValue, float64(r.Successful)/float64(r.Completed), r.Key.labels()...)
+	}
+	return nil
+}
+
+func aggregate(ctx context.Context, collection db.CollectionInterface, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fixNow pins the collector clock for the duration of the test.
+func fixNow(t *testing.T, current time.Time) {
+	oldNow := now
+	t.Cleanup(func() { now = oldNow })
+	now = func() time.Time { return current }
+}
+
+func repo(name string) bson.M {
+	return bson.M{"workspace": "lep13", "project_name": "Project1", "repo_name": name}
+}
+
+func TestStoreCollector(t *testing.T) {
+	current := time.Date(2024, 7, 17, 12, 0, 0, 0, time.UTC)
+	fixNow(t, current)
+
+	commitDay := bson.M{"workspace": "lep13", "project_name": "Project1", "repo_name": "repo1", "day": "2024-07-16"}
+
+	// Both commit aggregates leave out merges and bot commits, and only the daily counts are windowed
+	excluded := bson.M{"$nin": classify.Excluded}
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.MatchedBy(func(pipeline mongo.Pipeline) bool {
+		match := pipeline[0][0].Value.(bson.M)
+		return assert.ObjectsAreEqual(bson.M{"commit_date": bson.M{"$gte": windowStart()}, "classification": excluded}, match)
+	}), mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": commitDay, "commits": 3},
+	), nil).Once()
+	commits.On("Aggregate", mock.Anything, mock.MatchedBy(func(pipeline mongo.Pipeline) bool {
+		match := pipeline[0][0].Value.(bson.M)
+		return assert.ObjectsAreEqual(bson.M{"classification": excluded}, match)
+	}), mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": repo("repo1"), "lines_added": 120, "lines_deleted": 30},
+	), nil).Once()
+
+	pullRequests := new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{
+			"_id":         repo("repo1"),
+			"open":        2,
+			"oldest":      current.Add(-48 * time.Hour),
+			"avg_created": float64(current.Add(-24 * time.Hour).UnixMilli()),
+		},
+	), nil).Once()
+
+	pipelines := new(db.MockCollection)
+	pipelines.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": repo("repo1"), "completed": 4, "successful": 3},
+	), nil).Once()
+
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+		db.PipelinesCollection:    pipelines,
+	})
+
+	expected := `
+# HELP bitbucket_metrics_repo_commits Commits per repository and day over the activity window.
+# TYPE bitbucket_metrics_repo_commits gauge
+bitbucket_metrics_repo_commits{day="2024-07-16",project="Project1",repo="repo1",workspace="lep13"} 3
+# HELP bitbucket_metrics_repo_lines_added Lines added by all stored commits of the repository.
+# TYPE bitbucket_metrics_repo_lines_added gauge
+bitbucket_metrics_repo_lines_added{project="Project1",repo="repo1",workspace="lep13"} 120
+# HELP bitbucket_metrics_repo_lines_deleted Lines deleted by all stored commits of the repository.
+# TYPE bitbucket_metrics_repo_lines_deleted gauge
+bitbucket_metrics_repo_lines_deleted{project="Project1",repo="repo1",workspace="lep13"} 30
+# HELP bitbucket_metrics_repo_open_pull_request_avg_age_seconds Average age of the open pull requests of the repository.
+# TYPE bitbucket_metrics_repo_open_pull_request_avg_age_seconds gauge
+bitbucket_metrics_repo_open_pull_request_avg_age_seconds{project="Project1",repo="repo1",workspace="lep13"} 86400
+# HELP bitbucket_metrics_repo_open_pull_request_max_age_seconds Age of the oldest open pull request of the repository.
+# TYPE bitbucket_metrics_repo_open_pull_request_max_age_seconds gauge
+bitbucket_metrics_repo_open_pull_request_max_age_seconds{project="Project1",repo="repo1",workspace="lep13"} 172800
+# HELP bitbucket_metrics_repo_open_pull_requests Open pull requests of the repository.
+# TYPE bitbucket_metrics_repo_open_pull_requests gauge
+bitbucket_metrics_repo_open_pull_requests{project="Project1",repo="repo1",workspace="lep13"} 2
+# HELP bitbucket_metrics_repo_pipeline_success_ratio Share of the pipelines completed over the activity window that succeeded.
+# TYPE bitbucket_metrics_repo_pipeline_success_ratio gauge
+bitbucket_metrics_repo_pipeline_success_ratio{project="Project1",repo="repo1",workspace="lep13"} 0.75
+# HELP bitbucket_metrics_repo_pipelines_completed Pipelines completed over the activity window.
+# TYPE bitbucket_metrics_repo_pipelines_completed gauge
+bitbucket_metrics_repo_pipelines_completed{project="Project1",repo="repo1",workspace="lep13"} 4
+# HELP bitbucket_metrics_store_up Whether the last scrape of the metrics store succeeded.
+# TYPE bitbucket_metrics_store_up gauge
+bitbucket_metrics_store_up 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewStoreCollector(), strings.NewReader(expected)))
+
+	commits.AssertExpectations(t)
+	pullRequests.AssertExpectations(t)
+	pipelines.AssertExpectations(t)
+}
+
+func TestStoreCollector_StoreDown(t *testing.T) {
+	failing := new(db.MockCollection)
+	failing.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("server selection timeout"))
+
+	db.UseCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      failing,
+		db.PullRequestsCollection: failing,
+		db.PipelinesCollection:    failing,
+	})
+
+	expected := `
+# HELP bitbucket_metrics_store_up Whether the last scrape of the metrics store succeeded.
+# TYPE bitbucket_metrics_store_up gauge
+bitbucket_metrics_store_up 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewStoreCollector(), strings.NewReader(expected)))
+}
+
+func TestWindowStart(t *testing.T) {
+	fixNow(t, time.Date(2024, 7, 17, 12, 0, 0, 0, time.UTC))
+
+	oldWindow := ActivityWindowDays
+	defer func() { ActivityWindowDays = oldWindow }()
+	ActivityWindowDays = 7
+
+	assert.Equal(t, time.Date(2024, 7, 11, 0, 0, 0, 0, time.UTC), windowStart())
+}
@@ -0,0 +1,69 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lep13/bitbucket_metrics/config"
+)
+
+// MinKeyLength is the shortest pseudonym key accepted, in bytes.
+const MinKeyLength = 32
+
+// RedactedMessage replaces commit messages when message redaction is enabled.
+const RedactedMessage = "[redacted]"
+
+// Pseudonymizer replaces personal data with keyed pseudonyms before it is stored.
+//
+// Pseudonyms are HMAC-SHA256 digests, so the same value always maps to the same pseudonym and aggregates
+// by person or team keep working, while nobody without the key can recover or recompute them.
+// A nil Pseudonymizer leaves every value unchanged.
+type Pseudonymizer struct {
+	key            []byte
+	redactMessages bool
+}
+
+// New returns a pseudonymizer keyed with key, optionally redacting commit messages.
+func New(key string, redactMessages bool) (*Pseudonymizer, error) {
+	if len(key) < MinKeyLength {
+		return nil, fmt.Errorf("pseudonym key must be at least %d bytes", MinKeyLength)
+	}
+	return &Pseudonymizer{key: []byte(key), redactMessages: redactMessages}, nil
+}
+
+// NewFromConfig returns the pseudonymizer configured by cfg, or nil when privacy mode is off.
+func NewFromConfig(cfg *config.Config) (*Pseudonymizer, error) {
+	if !cfg.PrivacyMode {
+		return nil, nil
+	}
+	return New(cfg.PseudonymKey, cfg.RedactCommitMessages)
+}
+
+// NewHasherFromConfig returns a pseudonymizer keyed with the pseudonym key of cfg whatever the privacy mode, for
+// personal data that is only ever compared, such as the names of erased people. It returns nil when no key is set.
+func NewHasherFromConfig(cfg *config.Config) (*Pseudonymizer, error) {
+	if cfg.PseudonymKey == "" {
+		return nil, nil
+	}
+	return New(cfg.PseudonymKey, false)
+}
+
+// Pseudonym returns the pseudonym of value. Empty values stay empty so missing data remains recognisable.
+func (p *Pseudonymizer) Pseudonym(value string) string {
+	if p == nil || value == "" {
+		return value
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:10])
+}
+
+// Message returns the commit message to store.
+func (p *Pseudonymizer) Message(message string) string {
+	if p == nil || !p.redactMessages || message == "" {
+		return message
+	}
+	return RedactedMessage
+}
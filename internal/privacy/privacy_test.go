@@ -0,0 +1,78 @@
+package privacy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "0123456789abcdef0123456789abcdef"
+
+func TestPseudonym(t *testing.T) {
+	p, err := New(testKey, false)
+	assert.NoError(t, err)
+
+	pseudonym := p.Pseudonym("jane@example.com")
+	assert.True(t, strings.HasPrefix(pseudonym, "anon-"))
+	assert.Len(t, pseudonym, len("anon-")+20)
+	assert.NotContains(t, pseudonym, "jane")
+	assert.Equal(t, pseudonym, p.Pseudonym("jane@example.com"))
+	assert.NotEqual(t, pseudonym, p.Pseudonym("john@example.com"))
+	assert.Equal(t, "", p.Pseudonym(""))
+
+	other, err := New(strings.Repeat("k", MinKeyLength), false)
+	assert.NoError(t, err)
+	assert.NotEqual(t, pseudonym, other.Pseudonym("jane@example.com"))
+}
+
+func TestMessage(t *testing.T) {
+	keep, err := New(testKey, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Fix login", keep.Message("Fix login"))
+
+	redact, err := New(testKey, true)
+	assert.NoError(t, err)
+	assert.Equal(t, RedactedMessage, redact.Message("Fix login"))
+	assert.Equal(t, "", redact.Message(""))
+}
+
+func TestNilPseudonymizer(t *testing.T) {
+	var p *Pseudonymizer
+	assert.Equal(t, "jane", p.Pseudonym("jane"))
+	assert.Equal(t, "Fix login", p.Message("Fix login"))
+}
+
+func TestNew_ShortKey(t *testing.T) {
+	_, err := New("short", false)
+	assert.Error(t, err)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	p, err := NewFromConfig(&config.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = NewFromConfig(&config.Config{PrivacyMode: true})
+	assert.Error(t, err)
+
+	p, err = NewFromConfig(&config.Config{PrivacyMode: true, PseudonymKey: testKey, RedactCommitMessages: true})
+	assert.NoError(t, err)
+	assert.Equal(t, RedactedMessage, p.Message("Fix login"))
+}
+
+func TestNewHasherFromConfig(t *testing.T) {
+	h, err := NewHasherFromConfig(&config.Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, h)
+
+	_, err = NewHasherFromConfig(&config.Config{PseudonymKey: "short"})
+	assert.Error(t, err)
+
+	// The key hashes values outside privacy mode too, and never redacts messages
+	h, err = NewHasherFromConfig(&config.Config{PseudonymKey: testKey, RedactCommitMessages: true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "Jane", h.Pseudonym("Jane"))
+	assert.Equal(t, "Fix login", h.Message("Fix login"))
+}
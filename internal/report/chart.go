@@ -0,0 +1,139 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+)
+
+// Series is one set of bars of a chart.
+type Series struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+const (
+	chartWidth   = 720
+	chartHeight  = 240
+	chartMargin  = 40
+	labelWidth   = 160
+	barHeight    = 18
+	maxXAxisTick = 12
+)
+
+// barChart renders a standalone SVG with one group of vertical bars per label and a legend.
+func barChart(title string, labels []string, series []Series) string {
+	var b strings.Builder
+	plotWidth := float64(chartWidth - 2*chartMargin)
+	plotHeight := float64(chartHeight - 2*chartMargin)
+	max := niceMax(series)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`,
+		chartWidth, chartHeight, chartWidth, chartHeight, html.EscapeString(title))
+	fmt.Fprintf(&b, `<style>text{font:11px sans-serif;fill:#444}</style>`)
+	fmt.Fprintf(&b, `<text x="%d" y="16" style="font-weight:bold">%s</text>`, chartMargin, html.EscapeString(title))
+
+	// Gridlines at 0, 50% and 100% of the scale
+	for _, f := range []float64{0, 0.5, 1} {
+		y := float64(chartHeight-chartMargin) - f*plotHeight
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartMargin, y, chartWidth-chartMargin, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`, chartMargin-4, y+4, formatValue(f*max))
+	}
+
+	if len(labels) > 0 && len(series) > 0 {
+		groupWidth := plotWidth / float64(len(labels))
+		barWidth := groupWidth * 0.8 / float64(len(series))
+		tickEvery := int(math.Ceil(float64(len(labels)) / maxXAxisTick))
+
+		for i, label := range labels {
+			x := float64(chartMargin) + float64(i)*groupWidth + groupWidth*0.1
+			for j, s := range series {
+				h := 0.0
+				if max > 0 {
+					h = s.Values[i] / max * plotHeight
+				}
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s %s: %s</title></rect>`,
+					x+float64(j)*barWidth, float64(chartHeight-chartMargin)-h, barWidth, h, s.Color,
+					html.EscapeString(label), html.EscapeString(s.Name), formatValue(s.Values[i]))
+			}
+			if i%tickEvery == 0 {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+					x+groupWidth*0.4, chartHeight-chartMargin+14, html.EscapeString(label))
+			}
+		}
+	}
+
+	for j, s := range series {
+		x := chartMargin + j*140
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, x, chartHeight-14, s.Color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, x+14, chartHeight-5, html.EscapeString(s.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// horizontalBarChart renders a standalone SVG with one labelled horizontal bar per value.
+func horizontalBarChart(title string, labels []string, values []float64, color string) string {
+	var b strings.Builder
+	height := 28 + len(labels)*(barHeight+6)
+	plotWidth := float64(chartWidth - labelWidth - chartMargin)
+	max := niceMax([]Series{{Values: values}})
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`,
+		chartWidth, height, chartWidth, height, html.EscapeString(title))
+	fmt.Fprintf(&b, `<style>text{font:11px sans-serif;fill:#444}</style>`)
+	fmt.Fprintf(&b, `<text x="0" y="16" style="font-weight:bold">%s</text>`, html.EscapeString(title))
+
+	for i, label := range labels {
+		y := 28 + i*(barHeight+6)
+		w := 0.0
+		if max > 0 {
+			w = values[i] / max * plotWidth
+		}
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, labelWidth-6, y+barHeight-5, html.EscapeString(truncate(label, 24)))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"><title>%s: %s</title></rect>`,
+			labelWidth, y, w, barHeight, color, html.EscapeString(label), formatValue(values[i]))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s</text>`, float64(labelWidth)+w+4, y+barHeight-5, formatValue(values[i]))
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// niceMax returns the top of the value scale: the largest value rounded up to 1, 2 or 5 times a power of ten.
+func niceMax(series []Series) float64 {
+	max := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	if max <= 0 {
+		return 0
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(max)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if max <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertWellFormed(t *testing.T, svg string) {
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		_, err := decoder.Token()
+		if err != nil {
+			assert.Equal(t, "EOF", err.Error())
+			return
+		}
+	}
+}
+
+func TestBarChart(t *testing.T) {
+	svg := barChart("Commits <per> day", []string{"a", "b"}, []Series{
+		{Name: "Added", Color: "#000", Values: []float64{3, 7}},
+		{Name: "Deleted", Color: "#fff", Values: []float64{1, 0}},
+	})
+	assertWellFormed(t, svg)
+	assert.Contains(t, svg, "Commits &lt;per&gt; day")
+	assert.Equal(t, 4, strings.Count(svg, "<title>"))
+	assert.Contains(t, svg, "<title>b Added: 7</title>")
+	assert.Contains(t, svg, ">10</text>")
+}
+
+func TestBarChart_Empty(t *testing.T) {
+	svg := barChart("Nothing", nil, nil)
+	assertWellFormed(t, svg)
+	assert.NotContains(t, svg, "<title>")
+}
+
+func TestHorizontalBarChart(t *testing.T) {
+	svg := horizontalBarChart("Top", []string{"A very long contributor name indeed", "B & C"}, []float64{4, 2.5}, "#000")
+	assertWellFormed(t, svg)
+	assert.Contains(t, svg, "A very long contributor…")
+	assert.Contains(t, svg, "B &amp; C")
+	assert.Contains(t, svg, ">2.5</text>")
+}
+
+func TestNiceMax(t *testing.T) {
+	assert.Equal(t, 0.0, niceMax(nil))
+	assert.Equal(t, 1.0, niceMax([]Series{{Values: []float64{1}}}))
+	assert.Equal(t, 20.0, niceMax([]Series{{Values: []float64{13}}}))
+	assert.Equal(t, 500.0, niceMax([]Series{{Values: []float64{260}}, {Values: []float64{3}}}))
+	assert.Equal(t, 1000.0, niceMax([]Series{{Values: []float64{999}}}))
+}
@@ -0,0 +1,180 @@
+package report
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+)
+
+// Output formats.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("report.html.tmpl").Funcs(htmltemplate.FuncMap{
+		"date":   formatDate,
+		"hours":  formatHours,
+		"svg":    func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+		"metric": metricLabel,
+		"value":  formatMetric,
+		"change": formatChange,
+	}).ParseFS(templates, "templates/report.html.tmpl"))
+
+	markdownTemplate = texttemplate.Must(texttemplate.New("report.md.tmpl").Funcs(texttemplate.FuncMap{
+		"date":   formatDate,
+		"hours":  formatHours,
+		"cell":   markdownCell,
+		"image":  markdownImage,
+		"metric": metricLabel,
+		"value":  formatMetric,
+		"change": formatChange,
+	}).ParseFS(templates, "templates/report.md.tmpl"))
+)
+
+// view is a report together with its charts, as handed to the templates.
+type view struct {
+	*Report
+	ActivityChart     string
+	ChurnChart        string
+	PullRequestChart  string
+	ContributorsChart string
+	FileTypesChart    string
+	// ComparisonGroups lists the overall comparison first, then the one of each repository or team.
+	ComparisonGroups []analytics.Group
+}
+
+// Render writes r to w in format, with every chart embedded as inline SVG.
+func Render(w io.Writer, r *Report, format string) error {
+	v := newView(r)
+	switch format {
+	case FormatHTML:
+		return htmlTemplate.Execute(w, v)
+	case FormatMarkdown:
+		return markdownTemplate.Execute(w, v)
+	default:
+		return fmt.Errorf("unknown format %q, expected %s or %s", format, FormatHTML, FormatMarkdown)
+	}
+}
+
+func newView(r *Report) view {
+	labels := make([]string, len(r.Activity))
+	commits := make([]float64, len(r.Activity))
+	added := make([]float64, len(r.Activity))
+	deleted := make([]float64, len(r.Activity))
+	opened := make([]float64, len(r.Activity))
+	merged := make([]float64, len(r.Activity))
+	for i, b := range r.Activity {
+		labels[i] = b.Label
+		commits[i] = float64(b.Commits)
+		added[i] = float64(b.LinesAdded)
+		deleted[i] = float64(b.LinesDeleted)
+		opened[i] = float64(b.Opened)
+		merged[i] = float64(b.Merged)
+	}
+
+	contributorLabels := make([]string, len(r.Contributors))
+	contributorCommits := make([]float64, len(r.Contributors))
+	for i, c := range r.Contributors {
+		contributorLabels[i] = c.Author
+		contributorCommits[i] = float64(c.Commits)
+	}
+
+	extensions := make([]string, len(r.FileTypes))
+	changes := make([]float64, len(r.FileTypes))
+	for i, f := range r.FileTypes {
+		extensions[i] = f.Extension
+		changes[i] = float64(f.Changes)
+	}
+
+	var comparisonGroups []analytics.Group
+	if r.Comparison != nil {
+		comparisonGroups = append([]analytics.Group{r.Comparison.Total}, r.Comparison.Groups...)
+	}
+
+	return view{
+		Report:            r,
+		ComparisonGroups:  comparisonGroups,
+		ActivityChart:     barChart("Commits per "+r.Granularity, labels, []Series{{Name: "Commits", Color: "#4e79a7", Values: commits}}),
+		ChurnChart:        barChart("Lines changed per "+r.Granularity, labels, []Series{{Name: "Added", Color: "#59a14f", Values: added}, {Name: "Deleted", Color: "#e15759", Values: deleted}}),
+		PullRequestChart:  barChart("Pull requests per "+r.Granularity, labels, []Series{{Name: "Opened", Color: "#f28e2b", Values: opened}, {Name: "Merged", Color: "#76b7b2", Values: merged}}),
+		ContributorsChart: horizontalBarChart("Top contributors by commits", contributorLabels, contributorCommits, "#4e79a7"),
+		FileTypesChart:    horizontalBarChart("File changes by type", extensions, changes, "#b07aa1"),
+	}
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+func formatHours(h float64) string {
+	if h >= 48 {
+		return fmt.Sprintf("%.1f days", h/24)
+	}
+	return fmt.Sprintf("%.1f h", h)
+}
+
+// markdownCell escapes a value for a Markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// markdownImage embeds an SVG as a data URI image, so wiki pages need no separate assets.
+func markdownImage(alt, svg string) string {
+	return fmt.Sprintf("![%s](data:image/svg+xml;base64,%s)", markdownCell(alt), base64.StdEncoding.EncodeToString([]byte(svg)))
+}
+
+func metricLabel(name string) string {
+	switch name {
+	case analytics.MetricCommits:
+		return "Commits"
+	case analytics.MetricChurn:
+		return "Lines changed"
+	case analytics.MetricHoursToMerge:
+		return "Median time to merge"
+	case analytics.MetricReviewParticipation:
+		return "Reviewed commits"
+	default:
+		return name
+	}
+}
+
+func formatMetric(name string, v float64) string {
+	switch name {
+	case analytics.MetricHoursToMerge:
+		return formatHours(v)
+	case analytics.MetricReviewParticipation:
+		return fmt.Sprintf("%.0f%%", v*100)
+	default:
+		return fmt.Sprintf("%.0f", v)
+	}
+}
+
+// formatChange describes the relative change of m, flagging significant ones.
+func formatChange(m analytics.Metric) string {
+	var change string
+	switch {
+	case m.Current == m.Previous:
+		change = "no change"
+	case m.Previous == 0:
+		change = "new"
+	default:
+		change = fmt.Sprintf("%+.0f%%", m.Change*100)
+	}
+	if m.Significant {
+		change += " (significant)"
+	}
+	return change
+}
@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestReport(t *testing.T) *Report {
+	useStore(t)
+	r, err := Build(context.Background(), Options{Title: "July | sprint", Filter: db.Filter{From: from, To: to}})
+	assert.NoError(t, err)
+	return r
+}
+
+func TestRender_HTML(t *testing.T) {
+	r := buildTestReport(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Render(&out, r, FormatHTML))
+	html := out.String()
+
+	assert.Contains(t, html, "<title>July | sprint</title>")
+	assert.Equal(t, 5, strings.Count(html, "<svg "))
+	assert.Contains(t, html, "Jane &lt;Doe&gt;")
+	assert.NotContains(t, html, "Jane <Doe>")
+	assert.Contains(t, html, "6.0 h")
+	assert.Contains(t, html, "Time to first review")
+
+	// Self-contained: the only URL is the SVG namespace
+	for _, url := range regexp.MustCompile(`(?:src|href)=|https?://[^"]*`).FindAllString(html, -1) {
+		assert.Equal(t, "http://www.w3.org/2000/svg", url)
+	}
+}
+
+func TestRender_Markdown(t *testing.T) {
+	r := buildTestReport(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Render(&out, r, FormatMarkdown))
+	md := out.String()
+
+	assert.True(t, strings.HasPrefix(md, `# July \| sprint`))
+	assert.Contains(t, md, "| Jane <Doe> | 2 | 20 | 1 |")
+	assert.Contains(t, md, "| lep13/repo1 | Project1 | 3 | 2 | 30 | 6 |")
+
+	images := regexp.MustCompile(`\(data:image/svg\+xml;base64,([^)]+)\)`).FindAllStringSubmatch(md, -1)
+	assert.Len(t, images, 3)
+	svg, err := base64.StdEncoding.DecodeString(images[0][1])
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(svg), "<svg "))
+}
+
+func TestRender_UnknownFormat(t *testing.T) {
+	assert.Error(t, Render(&bytes.Buffer{}, &Report{}, "pdf"))
+}
+
+func TestRender_Comparison(t *testing.T) {
+	r := buildTestReport(t)
+	r.Comparison = &analytics.Comparison{
+		Previous: analytics.Period{From: from.AddDate(0, 0, -3), To: from},
+		Total: analytics.Group{Key: "all", Metrics: []analytics.Metric{
+			{Name: analytics.MetricCommits, Previous: 10, Current: 30, Change: 2, PValue: 0.01, Significant: true},
+		}},
+		Groups: []analytics.Group{{Key: "lep13/repo|1", Metrics: []analytics.Metric{
+			{Name: analytics.MetricHoursToMerge, Previous: 4, Current: 4, PValue: 1},
+			{Name: analytics.MetricReviewParticipation, Previous: 0, Current: 0.5, PValue: 0.2},
+		}}},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, Render(&out, r, FormatMarkdown))
+	md := out.String()
+	assert.Contains(t, md, "## Compared with 2024-06-28 to 2024-07-01")
+	assert.Contains(t, md, "### All")
+	assert.Contains(t, md, "| Commits | 10 | 30 | +200% (significant) | 0.010 |")
+	assert.Contains(t, md, `### lep13/repo\|1`)
+	assert.Contains(t, md, "| Median time to merge | 4.0 h | 4.0 h | no change | 1.000 |")
+	assert.Contains(t, md, "| Reviewed commits | 0% | 50% | new | 0.200 |")
+
+	out.Reset()
+	assert.NoError(t, Render(&out, r, FormatHTML))
+	assert.Contains(t, out.String(), `<tr class="significant"><td>Commits</td><td>10</td><td>30</td><td>&#43;200% (significant)</td>`)
+}
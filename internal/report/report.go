@@ -0,0 +1,456 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Bucket granularities, picked from the length of the period.
+const (
+	Daily   = "day"
+	Weekly  = "week"
+	Monthly = "month"
+)
+
+// topN caps the contributor and file type tables.
+const topN = 10
+
+// Options selects the scope and period of a report. From and To are required.
+// Compare adds a comparison with the previous period of the same length, grouped by repository or team.
+type Options struct {
+	Title   string
+	Filter  db.Filter
+	Compare string
+}
+
+// Report is everything a rendered report shows.
+type Report struct {
+	Title        string
+	Scope        string
+	From         time.Time
+	To           time.Time
+	GeneratedAt  time.Time
+	Granularity  string
+	Totals       Totals
+	Activity     []Bucket
+	Contributors []Contributor
+	FileTypes    []FileType
+	Repositories []Repository
+	PullRequests PullRequests
+	Reviews      Reviews
+	Comparison   *analytics.Comparison
+}
+
+// Totals sums the commits of the period.
+type Totals struct {
+	Commits      int `bson:"commits"`
+	Authors      int `bson:"authors"`
+	Repositories int `bson:"repositories"`
+	LinesAdded   int `bson:"lines_added"`
+	LinesDeleted int `bson:"lines_deleted"`
+}
+
+// Bucket is the activity of one day, week or month.
+type Bucket struct {
+	Label        string `bson:"_id"`
+	Commits      int    `bson:"commits"`
+	LinesAdded   int    `bson:"lines_added"`
+	LinesDeleted int    `bson:"lines_deleted"`
+	Opened       int    `bson:"-"`
+	Merged       int    `bson:"-"`
+}
+
+// Contributor is one of the most active people of the period.
+type Contributor struct {
+	PersonID     string `bson:"_id"`
+	Author       string `bson:"author"`
+	Commits      int    `bson:"commits"`
+	LinesAdded   int    `bson:"lines_added"`
+	LinesDeleted int    `bson:"lines_deleted"`
+}
+
+// FileType sums the file changes of one file extension.
+type FileType struct {
+	Extension    string `bson:"_id"`
+	Changes      int    `bson:"changes"`
+	LinesAdded   int    `bson:"lines_added"`
+	LinesDeleted int    `bson:"lines_deleted"`
+}
+
+// Repository sums the commits of one repository.
+type Repository struct {
+	Workspace    string `bson:"workspace"`
+	ProjectName  string `bson:"project_name"`
+	RepoName     string `bson:"repo_name"`
+	Commits      int    `bson:"commits"`
+	Authors      int    `bson:"authors"`
+	LinesAdded   int    `bson:"lines_added"`
+	LinesDeleted int    `bson:"lines_deleted"`
+}
+
+// PullRequests summarizes pull request throughput and how long merged pull requests stayed open.
+type PullRequests struct {
+	Opened             int
+	Merged             int
+	MedianHoursToMerge float64
+	P90HoursToMerge    float64
+	AvgHoursToMerge    float64
+}
+
+// Reviews summarizes the review turnaround of the pull requests opened in the period: how long they waited for their
+// first review by someone other than the author, and how long it took from that review to the first approval. Only
+// pull requests whose review activity was collected count.
+type Reviews struct {
+	Reviewed                 int
+	MedianHoursToFirstReview float64
+	P90HoursToFirstReview    float64
+	AvgHoursToFirstReview    float64
+	Approved                 int
+	MedianHoursToApproval    float64
+	P90HoursToApproval       float64
+	AvgHoursToApproval       float64
+}
+
+// now is the clock stamped on generated reports.
+var now = time.Now
+
+// Build gathers the data of a report from the store.
+func Build(ctx context.Context, opts Options) (*Report, error) {
+	filter := opts.Filter
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return nil, fmt.Errorf("report period needs both a start and an end")
+	}
+
+	r := &Report{
+		Title:       opts.Title,
+		Scope:       scope(filter),
+		From:        filter.From,
+		To:          filter.To,
+		GeneratedAt: now().UTC(),
+		Granularity: granularity(filter.From, filter.To),
+	}
+	if r.Title == "" {
+		r.Title = "Activity report"
+	}
+
+	commits := db.GetCollection()
+	match := bson.D{{Key: "$match", Value: filter.CommitQuery()}}
+	bucketFormat := dateFormat(r.Granularity)
+
+	var totals []Totals
+	err := aggregateAll(ctx, commits, mongo.Pipeline{
+		match,
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+			"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+			"repositories":  bson.M{"$addToSet": bson.A{"$workspace", "$project_name", "$repo_name"}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"commits":       1,
+			"lines_added":   1,
+			"lines_deleted": 1,
+			"authors":       bson.M{"$size": "$authors"},
+			"repositories":  bson.M{"$size": "$repositories"},
+		}}},
+	}, &totals)
+	if err != nil {
+		return nil, err
+	}
+	if len(totals) > 0 {
+		r.Totals = totals[0]
+	}
+
+	var activity []Bucket
+	err = aggregateAll(ctx, commits, mongo.Pipeline{
+		match,
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"$dateToString": bson.M{"format": bucketFormat, "date": "$commit_date"}},
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		}}},
+	}, &activity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commits are sorted by date so the name shown is the one each person used last
+	err = aggregateAll(ctx, commits, mongo.Pipeline{
+		match,
+		{{Key: "$sort", Value: bson.D{{Key: "commit_date", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}},
+			"author":        bson.M{"$last": "$committed_by"},
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "commits", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: topN}},
+	}, &r.Contributors)
+	if err != nil {
+		return nil, err
+	}
+
+	extension := bson.M{"$let": bson.M{
+		"vars": bson.M{"match": bson.M{"$regexFind": bson.M{"input": "$files.path", "regex": `\.([^./]+)$`}}},
+		"in":   bson.M{"$toLower": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$$match.captures", 0}}, "(none)"}}},
+	}}
+	err = aggregateAll(ctx, commits, mongo.Pipeline{
+		match,
+		{{Key: "$unwind", Value: "$files"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           extension,
+			"changes":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$files.lines_added"},
+			"lines_deleted": bson.M{"$sum": "$files.lines_deleted"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "changes", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: topN}},
+	}, &r.FileTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = aggregateAll(ctx, commits, mongo.Pipeline{
+		match,
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.D{{Key: "workspace", Value: "$workspace"}, {Key: "project_name", Value: "$project_name"}, {Key: "repo_name", Value: "$repo_name"}},
+			"commits":       bson.M{"$sum": 1},
+			"lines_added":   bson.M{"$sum": "$lines_added"},
+			"lines_deleted": bson.M{"$sum": "$lines_deleted"},
+			"authors":       bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$person_id", "$committed_by"}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"workspace":     "$_id.workspace",
+			"project_name":  "$_id.project_name",
+			"repo_name":     "$_id.repo_name",
+			"commits":       1,
+			"lines_added":   1,
+			"lines_deleted": 1,
+			"authors":       bson.M{"$size": "$authors"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "commits", Value: -1}, {Key: "repo_name", Value: 1}}}},
+	}, &r.Repositories)
+	if err != nil {
+		return nil, err
+	}
+
+	opened, merged, err := pullRequestActivity(ctx, filter, bucketFormat, &r.PullRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := reviewTurnaround(ctx, filter, &r.Reviews); err != nil {
+		return nil, err
+	}
+
+	r.Activity = fillBuckets(r.Granularity, filter.From, filter.To, activity, opened, merged)
+
+	if opts.Compare != "" {
+		if r.Comparison, err = analytics.Compare(ctx, analytics.CompareOptions{Filter: filter, GroupBy: opts.Compare}); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// pullRequestActivity counts the pull requests opened and merged per bucket and summarizes time to merge.
+// Pull requests count as opened in the bucket they were created in and as merged in the bucket they closed in.
+func pullRequestActivity(ctx context.Context, filter db.Filter, bucketFormat string, summary *PullRequests) (map[string]int, map[string]int, error) {
+	pullRequests := db.GetNamedCollection(db.PullRequestsCollection)
+
+	var openedBuckets []struct {
+		Label string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := aggregateAll(ctx, pullRequests, mongo.Pipeline{
+		{{Key: "$match", Value: filter.PullRequestQuery()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": bucketFormat, "date": "$created_on"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}, &openedBuckets)
+	if err != nil {
+		return nil, nil, err
+	}
+	opened := map[string]int{}
+	for _, b := range openedBuckets {
+		opened[b.Label] = b.Count
+		summary.Opened += b.Count
+	}
+
+	// Merges are matched on when they closed, so the created_on range of the filter is swapped for closed_on
+	closedFilter := filter
+	closedFilter.From, closedFilter.To = time.Time{}, time.Time{}
+	query := closedFilter.PullRequestQuery()
+	query["state"] = "MERGED"
+	query["closed_on"] = bson.M{"$gte": filter.From, "$lt": filter.To}
+
+	var mergedPRs []struct {
+		Label  string  `bson:"label"`
+		Millis float64 `bson:"millis"`
+	}
+	err = aggregateAll(ctx, pullRequests, mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$project", Value: bson.M{
+			"label":  bson.M{"$dateToString": bson.M{"format": bucketFormat, "date": "$closed_on"}},
+			"millis": bson.M{"$subtract": bson.A{"$closed_on", "$created_on"}},
+		}}},
+	}, &mergedPRs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := map[string]int{}
+	hours := make([]float64, len(mergedPRs))
+	for i, pr := range mergedPRs {
+		merged[pr.Label]++
+		hours[i] = time.Duration(pr.Millis * float64(time.Millisecond)).Hours()
+	}
+	summary.Merged = len(mergedPRs)
+	summary.MedianHoursToMerge, summary.P90HoursToMerge, summary.AvgHoursToMerge = summarizeHours(hours)
+	return opened, merged, nil
+}
+
+// reviewTurnaround summarizes the time to first review and from first review to approval of the pull requests
+// opened in the period.
+func reviewTurnaround(ctx context.Context, filter db.Filter, summary *Reviews) error {
+	query := filter.PullRequestQuery()
+	query["first_review_on"] = bson.M{"$exists": true}
+
+	var reviewed []analytics.PullRequestCycleTime
+	err := aggregateAll(ctx, db.GetNamedCollection(db.PullRequestsCollection), mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$project", Value: bson.M{"created_on": 1, "first_review_on": 1, "approved_on": 1}}},
+	}, &reviewed)
+	if err != nil {
+		return err
+	}
+
+	var toFirstReview, toApproval []float64
+	for _, pr := range reviewed {
+		if d, ok := pr.Pickup(); ok {
+			toFirstReview = append(toFirstReview, d.Hours())
+		}
+		if d, ok := pr.Review(); ok {
+			toApproval = append(toApproval, d.Hours())
+		}
+	}
+	summary.Reviewed = len(toFirstReview)
+	summary.MedianHoursToFirstReview, summary.P90HoursToFirstReview, summary.AvgHoursToFirstReview = summarizeHours(toFirstReview)
+	summary.Approved = len(toApproval)
+	summary.MedianHoursToApproval, summary.P90HoursToApproval, summary.AvgHoursToApproval = summarizeHours(toApproval)
+	return nil
+}
+
+// summarizeHours returns the median, 90th percentile and mean of hours, sorting it, or zeros when it is empty.
+func summarizeHours(hours []float64) (median, p90, mean float64) {
+	if len(hours) == 0 {
+		return 0, 0, 0
+	}
+	sort.Float64s(hours)
+	return analytics.Percentile(hours, 50), analytics.Percentile(hours, 90), analytics.Mean(hours)
+}
+
+// granularity picks daily buckets for up to a month, weekly ones for up to half a year and monthly ones beyond.
+func granularity(from, to time.Time) string {
+	switch days := to.Sub(from).Hours() / 24; {
+	case days <= 31:
+		return Daily
+	case days <= 183:
+		return Weekly
+	default:
+		return Monthly
+	}
+}
+
+// dateFormat is the $dateToString format of the bucket labels of a granularity.
+func dateFormat(granularity string) string {
+	switch granularity {
+	case Weekly:
+		return "%G-W%V"
+	case Monthly:
+		return "%Y-%m"
+	default:
+		return "%Y-%m-%d"
+	}
+}
+
+// bucketLabel formats t like dateFormat does in MongoDB.
+func bucketLabel(granularity string, t time.Time) string {
+	switch granularity {
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case Monthly:
+		return t.Format("2006-01")
+	default:
+		return t.Format(time.DateOnly)
+	}
+}
+
+// fillBuckets returns one bucket per day, week or month of the period, including empty ones, in order.
+func fillBuckets(granularity string, from, to time.Time, activity []Bucket, opened, merged map[string]int) []Bucket {
+	byLabel := map[string]Bucket{}
+	for _, b := range activity {
+		byLabel[b.Label] = b
+	}
+
+	var buckets []Bucket
+	seen := map[string]bool{}
+	for t := from.UTC(); t.Before(to); t = t.AddDate(0, 0, 1) {
+		label := bucketLabel(granularity, t)
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+
+		b := byLabel[label]
+		b.Label = label
+		b.Opened = opened[label]
+		b.Merged = merged[label]
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// scope describes the filter in words, for the report header.
+func scope(filter db.Filter) string {
+	var parts []string
+	for _, part := range []struct{ name, value string }{
+		{"workspace", filter.Workspace},
+		{"project", filter.Project},
+		{"repository", filter.Repo},
+		{"team", filter.Team},
+		{"author", filter.Author},
+		{"person", filter.Person},
+	} {
+		if part.value != "" {
+			parts = append(parts, part.name+" "+part.value)
+		}
+	}
+	if len(parts) == 0 {
+		return "all repositories"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func aggregateAll(ctx context.Context, collection db.CollectionInterface, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics store: %w", err)
+	}
+	return cursor.All(ctx, results)
+}
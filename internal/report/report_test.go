@@ -0,0 +1,135 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	from = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	to   = time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)
+)
+
+// useCollections routes collection lookups to the given mocks and freezes the clock for the duration of the test.
+func useCollections(t *testing.T, collections map[string]*db.MockCollection) {
+	db.UseCollections(t, collections)
+	oldNow := now
+	t.Cleanup(func() { now = oldNow })
+	now = func() time.Time { return to.Add(time.Hour) }
+}
+
+// useStore serves a small data set: commits on July 1st and 3rd and two merged pull requests, both reviewed and one
+// approved.
+func useStore(t *testing.T) (commits, pullRequests *db.MockCollection) {
+	commits = new(db.MockCollection)
+	for _, docs := range [][]interface{}{
+		{bson.M{"commits": 3, "authors": 2, "repositories": 1, "lines_added": 30, "lines_deleted": 6}},
+		{bson.M{"_id": "2024-07-01", "commits": 2, "lines_added": 20, "lines_deleted": 1}, bson.M{"_id": "2024-07-03", "commits": 1, "lines_added": 10, "lines_deleted": 5}},
+		{bson.M{"_id": "p-1", "author": "Jane <Doe>", "commits": 2, "lines_added": 20, "lines_deleted": 1}, bson.M{"_id": "p-2", "author": "John", "commits": 1, "lines_added": 10, "lines_deleted": 5}},
+		{bson.M{"_id": "go", "changes": 4, "lines_added": 25, "lines_deleted": 6}, bson.M{"_id": "(none)", "changes": 1, "lines_added": 5}},
+		{bson.M{"workspace": "lep13", "project_name": "Project1", "repo_name": "repo1", "commits": 3, "authors": 2, "lines_added": 30, "lines_deleted": 6}},
+	} {
+		commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t, docs...), nil).Once()
+	}
+
+	pullRequests = new(db.MockCollection)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"_id": "2024-07-02", "count": 2},
+	), nil).Once()
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"label": "2024-07-02", "millis": float64(2 * time.Hour / time.Millisecond)},
+		bson.M{"label": "2024-07-03", "millis": float64(10 * time.Hour / time.Millisecond)},
+	), nil).Once()
+	opened := time.Date(2024, 7, 2, 9, 0, 0, 0, time.UTC)
+	pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+		bson.M{"created_on": opened, "first_review_on": opened.Add(time.Hour), "approved_on": opened.Add(4 * time.Hour)},
+		bson.M{"created_on": opened, "first_review_on": opened.Add(3 * time.Hour)},
+	), nil).Once()
+
+	useCollections(t, map[string]*db.MockCollection{
+		db.CommitsCollection:      commits,
+		db.PullRequestsCollection: pullRequests,
+	})
+	return commits, pullRequests
+}
+
+func TestBuild(t *testing.T) {
+	useStore(t)
+
+	r, err := Build(context.Background(), Options{Filter: db.Filter{Workspace: "lep13", Repo: "repo1", From: from, To: to}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Activity report", r.Title)
+	assert.Equal(t, "workspace lep13, repository repo1", r.Scope)
+	assert.Equal(t, Daily, r.Granularity)
+	assert.Equal(t, Totals{Commits: 3, Authors: 2, Repositories: 1, LinesAdded: 30, LinesDeleted: 6}, r.Totals)
+	assert.Equal(t, []Bucket{
+		{Label: "2024-07-01", Commits: 2, LinesAdded: 20, LinesDeleted: 1},
+		{Label: "2024-07-02", Opened: 2, Merged: 1},
+		{Label: "2024-07-03", Commits: 1, LinesAdded: 10, LinesDeleted: 5, Merged: 1},
+	}, r.Activity)
+	assert.Len(t, r.Contributors, 2)
+	assert.Equal(t, "go", r.FileTypes[0].Extension)
+	assert.Equal(t, "repo1", r.Repositories[0].RepoName)
+	assert.Equal(t, PullRequests{Opened: 2, Merged: 2, MedianHoursToMerge: 6, P90HoursToMerge: 9.2, AvgHoursToMerge: 6}, r.PullRequests)
+	assert.Equal(t, Reviews{
+		Reviewed: 2, MedianHoursToFirstReview: 2, P90HoursToFirstReview: 2.8, AvgHoursToFirstReview: 2,
+		Approved: 1, MedianHoursToApproval: 3, P90HoursToApproval: 3, AvgHoursToApproval: 3,
+	}, r.Reviews)
+}
+
+func TestBuild_Compare(t *testing.T) {
+	commits, pullRequests := useStore(t)
+	for i := 0; i < 2; i++ {
+		commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t,
+			bson.M{"_id": bson.M{"group": "lep13/repo1", "day": "2024-06-29"}, "commits": 1, "churn": 4, "reviewed": 0},
+			bson.M{"_id": bson.M{"group": "lep13/repo1", "day": "2024-07-02"}, "commits": 2, "churn": 4, "reviewed": 1},
+		), nil).Once()
+		pullRequests.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(db.NewCursor(t), nil).Once()
+	}
+
+	r, err := Build(context.Background(), Options{Filter: db.Filter{From: from, To: to}, Compare: analytics.GroupByRepository})
+	assert.NoError(t, err)
+	if assert.NotNil(t, r.Comparison) {
+		assert.Equal(t, analytics.Period{From: from.AddDate(0, 0, -3), To: from}, r.Comparison.Previous)
+		assert.Equal(t, 2.0, r.Comparison.Total.Metrics[0].Current)
+		assert.Equal(t, 1.0, r.Comparison.Total.Metrics[0].Previous)
+		assert.Equal(t, "lep13/repo1", r.Comparison.Groups[0].Key)
+	}
+	commits.AssertExpectations(t)
+	pullRequests.AssertExpectations(t)
+}
+
+func TestBuild_RequiresPeriod(t *testing.T) {
+	_, err := Build(context.Background(), Options{Filter: db.Filter{From: from}})
+	assert.Error(t, err)
+}
+
+func TestBuild_StoreError(t *testing.T) {
+	commits := new(db.MockCollection)
+	commits.On("Aggregate", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	useCollections(t, map[string]*db.MockCollection{db.CommitsCollection: commits})
+
+	_, err := Build(context.Background(), Options{Filter: db.Filter{From: from, To: to}})
+	assert.ErrorContains(t, err, "failed to query metrics store")
+}
+
+func TestGranularity(t *testing.T) {
+	assert.Equal(t, Daily, granularity(from, from.AddDate(0, 0, 31)))
+	assert.Equal(t, Weekly, granularity(from, from.AddDate(0, 3, 0)))
+	assert.Equal(t, Monthly, granularity(from, from.AddDate(1, 0, 0)))
+}
+
+func TestFillBuckets_Weekly(t *testing.T) {
+	buckets := fillBuckets(Weekly, time.Date(2024, 12, 23, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC),
+		[]Bucket{{Label: "2025-W01", Commits: 4}}, nil, nil)
+	assert.Equal(t, []Bucket{{Label: "2024-W52"}, {Label: "2025-W01", Commits: 4}, {Label: "2025-W02"}}, buckets)
+}
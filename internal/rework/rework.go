@@ -0,0 +1,229 @@
+package rework
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/bitbucket"
+	"github.com/lep13/bitbucket_metrics/internal/classify"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultWindow is how recent code must be for changing it again to count as rework.
+const DefaultWindow = 21 * 24 * time.Hour
+
+// revertedHash matches the line git adds to revert messages.
+var revertedHash = regexp.MustCompile(`This reverts commit ([0-9a-f]{7,40})`)
+
+// revertedSubject matches the subject git gives reverts, quoting the subject of the reverted commit.
+var revertedSubject = regexp.MustCompile(`^Revert "(.+)"\s*$`)
+
+// Result counts what a detection run looked at and changed.
+type Result struct {
+	Commits int
+	Updated int
+	Reverts int
+}
+
+// storedCommit is the part of a commit detection reads and writes.
+type storedCommit struct {
+	CommitID      string                 `bson:"commit_id"`
+	Provider      string                 `bson:"provider"`
+	Workspace     string                 `bson:"workspace"`
+	ProjectKey    string                 `bson:"project_key"`
+	RepoName      string                 `bson:"repo_name"`
+	PersonID      string                 `bson:"person_id"`
+	CommitMessage string                 `bson:"commit_message"`
+	CommitDate    time.Time              `bson:"commit_date"`
+	Class         string                 `bson:"classification"`
+	Files         []bitbucket.FileChange `bson:"files"`
+	Reverts       string                 `bson:"reverts"`
+	Rework        []bitbucket.ReworkLink `bson:"rework"`
+	ReworkLines   int                    `bson:"rework_lines"`
+}
+
+// change is lines a commit added to a file that later commits may still rework.
+type change struct {
+	commitID string
+	personID string
+	at       time.Time
+	lines    int
+}
+
+// repository holds the history of the repository being scanned.
+type repository struct {
+	key       string
+	hashes    []string
+	bySubject map[string]string
+	files     map[string][]*change
+}
+
+// Detect scans every stored commit, oldest first per repository, linking reverts to the commits they revert
+// and recent changes to the commits whose lines they change again, and stores the links that changed.
+//
+// Bitbucket only reports changed line counts per file, so rework is estimated per file: the lines a commit
+// deletes from a file rework the lines added to that file within window, most recent first. A modified
+// line counts as both deleted and added. Merge commits repeat the changes they merge and are skipped.
+func Detect(ctx context.Context, window time.Duration) (Result, error) {
+	var result Result
+	if window <= 0 {
+		return result, fmt.Errorf("rework window must be positive")
+	}
+
+	collection := db.GetCollection()
+	opts := options.Find().
+		SetSort(bson.D{
+			{Key: "provider", Value: 1}, {Key: "workspace", Value: 1}, {Key: "project_key", Value: 1}, {Key: "repo_name", Value: 1},
+			{Key: "commit_date", Value: 1}, {Key: "_id", Value: 1},
+		}).
+		SetProjection(bson.M{
+			"commit_id": 1, "provider": 1, "workspace": 1, "project_key": 1, "repo_name": 1, "person_id": 1,
+			"commit_message": 1, "commit_date": 1, "classification": 1, "files": 1, "reverts": 1, "rework": 1, "rework_lines": 1,
+		})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return result, fmt.Errorf("failed to read commits: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var repo *repository
+	for cursor.Next(ctx) {
+		var commit storedCommit
+		if err := cursor.Decode(&commit); err != nil {
+			return result, fmt.Errorf("failed to decode commit: %w", err)
+		}
+		result.Commits++
+
+		key := strings.Join([]string{commit.Provider, commit.Workspace, commit.ProjectKey, commit.RepoName}, "/")
+		if repo == nil || repo.key != key {
+			repo = &repository{key: key, bySubject: map[string]string{}, files: map[string][]*change{}}
+		}
+		if commit.Class == classify.Merge {
+			continue
+		}
+
+		reverts := repo.reverted(commit.CommitMessage)
+		links, lines := repo.rework(commit, window)
+		repo.record(commit)
+
+		if reverts != "" {
+			result.Reverts++
+		}
+		if reverts == commit.Reverts && lines == commit.ReworkLines && sameLinks(links, commit.Rework) {
+			continue
+		}
+		_, err := collection.UpdateOne(ctx, commit.filter(), bson.M{"$set": bson.M{
+			"reverts":      reverts,
+			"rework":       links,
+			"rework_lines": lines,
+		}})
+		if err != nil {
+			return result, fmt.Errorf("failed to store rework of commit %s: %w", commit.CommitID, err)
+		}
+		result.Updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return result, fmt.Errorf("failed to read commits: %w", err)
+	}
+
+	slog.Info("Rework detection finished", "commits", result.Commits, "reverts", result.Reverts, "updated", result.Updated)
+	return result, nil
+}
+
+// filter selects the stored document of commit, scoped like the sync stores it.
+func (c storedCommit) filter() bson.M {
+	return bson.M{
+		"provider":    c.Provider,
+		"workspace":   c.Workspace,
+		"project_key": c.ProjectKey,
+		"repo_name":   c.RepoName,
+		"commit_id":   c.CommitID,
+	}
+}
+
+// reverted returns the ID of the earlier commit message reverts, matched by the reverted hash or else by
+// the quoted subject.
+func (r *repository) reverted(message string) string {
+	if match := revertedHash.FindStringSubmatch(message); match != nil {
+		for i := len(r.hashes) - 1; i >= 0; i-- {
+			if strings.HasPrefix(r.hashes[i], match[1]) {
+				return r.hashes[i]
+			}
+		}
+	}
+	if match := revertedSubject.FindStringSubmatch(subject(message)); match != nil {
+		return r.bySubject[match[1]]
+	}
+	return ""
+}
+
+// rework links commit to the recent changes its deletions rework, consuming the reworked lines.
+func (r *repository) rework(commit storedCommit, window time.Duration) ([]bitbucket.ReworkLink, int) {
+	var links []bitbucket.ReworkLink
+	total := 0
+	cutoff := commit.CommitDate.Add(-window)
+	for _, file := range commit.Files {
+		remaining := file.LinesDeleted
+		history := r.files[file.Path]
+		for i := len(history) - 1; i >= 0 && remaining > 0; i-- {
+			earlier := history[i]
+			if earlier.at.Before(cutoff) {
+				break
+			}
+			lines := min(remaining, earlier.lines)
+			if lines == 0 {
+				continue
+			}
+			earlier.lines -= lines
+			remaining -= lines
+			total += lines
+			links = append(links, bitbucket.ReworkLink{
+				CommitID:   earlier.commitID,
+				Path:       file.Path,
+				Lines:      lines,
+				SameAuthor: earlier.personID != "" && earlier.personID == commit.PersonID,
+			})
+		}
+	}
+	return links, total
+}
+
+// record adds commit to the history, dropping changes no later commit can rework anymore.
+func (r *repository) record(commit storedCommit) {
+	r.hashes = append(r.hashes, commit.CommitID)
+	if s := subject(commit.CommitMessage); s != "" {
+		r.bySubject[s] = commit.CommitID
+	}
+	for _, file := range commit.Files {
+		history := r.files[file.Path]
+		kept := history[:0]
+		for _, c := range history {
+			if c.lines > 0 {
+				kept = append(kept, c)
+			}
+		}
+		if file.LinesAdded > 0 {
+			kept = append(kept, &change{commitID: commit.CommitID, personID: commit.PersonID, at: commit.CommitDate, lines: file.LinesAdded})
+		}
+		r.files[file.Path] = kept
+	}
+}
+
+func subject(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return strings.TrimSpace(line)
+}
+
+func sameLinks(a, b []bitbucket.ReworkLink) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
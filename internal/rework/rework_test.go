@@ -0,0 +1,116 @@
+package rework
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/internal/bitbucket"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var day0 = time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+
+func useCommits(t *testing.T, docs ...interface{}) *db.MockCollection {
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	assert.NoError(t, err)
+
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(cursor, nil).Once()
+
+	oldGetCollection := db.GetCollectionFunc
+	t.Cleanup(func() { db.GetCollectionFunc = oldGetCollection })
+	db.GetCollectionFunc = func() db.CollectionInterface { return commits }
+	return commits
+}
+
+func commit(id, repo, person, message string, day int, files ...bson.M) bson.M {
+	return bson.M{
+		"commit_id": id, "provider": "bitbucket", "workspace": "lep13", "project_key": "PRJ", "repo_name": repo,
+		"person_id": person, "commit_message": message,
+		"commit_date": day0.AddDate(0, 0, day), "classification": "regular", "files": files,
+	}
+}
+
+func file(path string, added, deleted int) bson.M {
+	return bson.M{"path": path, "type": "modified", "lines_added": added, "lines_deleted": deleted}
+}
+
+func expectUpdate(commits *db.MockCollection, id, reverts string, links []bitbucket.ReworkLink, lines int) {
+	filter := bson.M{"provider": "bitbucket", "workspace": "lep13", "project_key": "PRJ", "repo_name": "repo1", "commit_id": id}
+	commits.On("UpdateOne", mock.Anything, filter, bson.M{"$set": bson.M{
+		"reverts": reverts, "rework": links, "rework_lines": lines,
+	}}, mock.Anything).Return(&mongo.UpdateResult{ModifiedCount: 1}, nil).Once()
+}
+
+func TestDetect(t *testing.T) {
+	merge := commit("m1", "repo1", "p-1", "Merge branch feature", 2, file("main.go", 40, 40))
+	merge["classification"] = "merge"
+	unchanged := commit("c6", "repo2", "p-1", "Tidy", 1, file("main.go", 0, 5))
+	unchanged["reverts"] = ""
+
+	commits := useCommits(t,
+		commit("c1", "repo1", "p-1", "Add login", 0, file("main.go", 30, 0), file("util.go", 10, 0)),
+		commit("c2", "repo1", "p-2", "Add logout", 1, file("main.go", 5, 0)),
+		merge,
+		commit("c3", "repo1", "p-1", "Rework login", 3, file("main.go", 8, 8)),
+		commit("c4", "repo1", "p-2", "Revert \"Add logout\"\n\nThis reverts commit c2.", 4, file("main.go", 0, 5)),
+		commit("c5", "repo1", "p-1", "Cleanup", 40, file("util.go", 0, 10)),
+		unchanged,
+	)
+	expectUpdate(commits, "c3", "", []bitbucket.ReworkLink{
+		{CommitID: "c2", Path: "main.go", Lines: 5},
+		{CommitID: "c1", Path: "main.go", Lines: 3, SameAuthor: true},
+	}, 8)
+	expectUpdate(commits, "c4", "c2", []bitbucket.ReworkLink{
+		{CommitID: "c3", Path: "main.go", Lines: 5, SameAuthor: false},
+	}, 5)
+
+	result, err := Detect(context.Background(), DefaultWindow)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Commits: 7, Updated: 2, Reverts: 1}, result)
+	commits.AssertExpectations(t)
+}
+
+func TestDetect_RevertBySubject(t *testing.T) {
+	commits := useCommits(t,
+		commit("c1", "repo1", "p-1", "Add login\n\nDetails", 0),
+		commit("c2", "repo1", "p-1", "Revert \"Add login\"", 1),
+	)
+	expectUpdate(commits, "c2", "c1", nil, 0)
+
+	result, err := Detect(context.Background(), DefaultWindow)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Reverts)
+	commits.AssertExpectations(t)
+}
+
+func TestDetect_SameRepositoryNameInOtherProject(t *testing.T) {
+	other := commit("c2", "repo1", "p-1", "Revert \"Add login\"", 1)
+	other["project_key"] = "OTH"
+	commits := useCommits(t, commit("c1", "repo1", "p-1", "Add login", 0), other)
+
+	result, err := Detect(context.Background(), DefaultWindow)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Reverts)
+	commits.AssertNotCalled(t, "UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDetect_Errors(t *testing.T) {
+	_, err := Detect(context.Background(), 0)
+	assert.ErrorContains(t, err, "window must be positive")
+
+	commits := new(db.MockCollection)
+	commits.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	oldGetCollection := db.GetCollectionFunc
+	defer func() { db.GetCollectionFunc = oldGetCollection }()
+	db.GetCollectionFunc = func() db.CollectionInterface { return commits }
+
+	_, err = Detect(context.Background(), DefaultWindow)
+	assert.ErrorContains(t, err, "failed to read commits")
+}
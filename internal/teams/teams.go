@@ -0,0 +1,262 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Unassigned is the team of commits and pull requests no team claims.
+const Unassigned = "unassigned"
+
+// Period bounds a membership. A zero From or To leaves that end open; To is exclusive.
+type Period struct {
+	From time.Time `json:"from,omitempty" bson:"from,omitempty"`
+	To   time.Time `json:"to,omitempty" bson:"to,omitempty"`
+}
+
+// Contains reports whether t falls within the period.
+func (p Period) Contains(t time.Time) bool {
+	return (p.From.IsZero() || !t.Before(p.From)) && (p.To.IsZero() || t.Before(p.To))
+}
+
+// Member assigns a person, as resolved by the identity package, to a team.
+type Member struct {
+	PersonID string `json:"person_id" bson:"person_id"`
+	Period   `bson:",inline"`
+}
+
+// Repository assigns a repository to a team. An empty workspace matches the repository in any workspace.
+type Repository struct {
+	Workspace string `json:"workspace,omitempty" bson:"workspace,omitempty"`
+	Repo      string `json:"repo" bson:"repo"`
+	Period    `bson:",inline"`
+}
+
+// Team is one entry of the registry, stored as one document of the teams collection or one element of the teams file.
+type Team struct {
+	Name         string       `json:"name" bson:"name"`
+	Members      []Member     `json:"members,omitempty" bson:"members,omitempty"`
+	Repositories []Repository `json:"repositories,omitempty" bson:"repositories,omitempty"`
+}
+
+// Registry maps people and repositories to teams.
+//
+// Work is attributed to the team its author was a member of at the time, falling back to the team owning
+// the repository at the time, else Unassigned. When several teams match, the first one listed wins.
+type Registry struct {
+	teams []Team
+}
+
+// Source loads the current team registry.
+type Source func(ctx context.Context) (*Registry, error)
+
+// NewRegistry validates teams and returns a registry over them.
+func NewRegistry(teams []Team) (*Registry, error) {
+	seen := map[string]bool{}
+	for i, team := range teams {
+		if team.Name == "" {
+			return nil, fmt.Errorf("team %d: name is required", i)
+		}
+		if team.Name == Unassigned {
+			return nil, fmt.Errorf("team %d: name %q is reserved", i, Unassigned)
+		}
+		if seen[team.Name] {
+			return nil, fmt.Errorf("team %q is defined twice", team.Name)
+		}
+		seen[team.Name] = true
+
+		for _, member := range team.Members {
+			if member.PersonID == "" {
+				return nil, fmt.Errorf("team %q: member person_id is required", team.Name)
+			}
+			if err := member.Period.validate(); err != nil {
+				return nil, fmt.Errorf("team %q: member %s: %w", team.Name, member.PersonID, err)
+			}
+		}
+		for _, repo := range team.Repositories {
+			if repo.Repo == "" {
+				return nil, fmt.Errorf("team %q: repository repo is required", team.Name)
+			}
+			if err := repo.Period.validate(); err != nil {
+				return nil, fmt.Errorf("team %q: repository %s: %w", team.Name, repo.Repo, err)
+			}
+		}
+	}
+	return &Registry{teams: teams}, nil
+}
+
+func (p Period) validate() error {
+	if !p.From.IsZero() && !p.To.IsZero() && !p.From.Before(p.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// NewSource returns the registry source configured by cfg: the teams file when one is set, which is read
+// once, else the teams collection, which is read on every call so edits apply without a restart.
+//
+// The registry lists people by their clear person IDs. In privacy mode they are pseudonymized on load with
+// the key used at ingestion, so they match the stored data.
+func NewSource(cfg *config.Config) (Source, error) {
+	pseudonymizer, err := privacy.NewFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TeamsPath == "" {
+		return func(ctx context.Context) (*Registry, error) {
+			registry, err := LoadCollection(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return registry.mapPersonIDs(pseudonymizer.Pseudonym), nil
+		}, nil
+	}
+
+	registry, err := LoadFile(cfg.TeamsPath)
+	if err != nil {
+		return nil, err
+	}
+	registry = registry.mapPersonIDs(pseudonymizer.Pseudonym)
+	return func(context.Context) (*Registry, error) {
+		return registry, nil
+	}, nil
+}
+
+// mapPersonIDs returns a copy of the registry with every member person ID passed through mapping.
+func (r *Registry) mapPersonIDs(mapping func(string) string) *Registry {
+	teams := make([]Team, len(r.teams))
+	for i, team := range r.teams {
+		team.Members = append([]Member(nil), team.Members...)
+		for j := range team.Members {
+			team.Members[j].PersonID = mapping(team.Members[j].PersonID)
+		}
+		teams[i] = team
+	}
+	return &Registry{teams: teams}
+}
+
+// LoadFile reads a registry from a JSON file of the form {"teams": [...]}.
+func LoadFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read teams file: %w", err)
+	}
+
+	var file struct {
+		Teams []Team `json:"teams"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse teams file: %w", err)
+	}
+	return NewRegistry(file.Teams)
+}
+
+// LoadCollection reads a registry from the teams collection, in name order.
+func LoadCollection(ctx context.Context) (*Registry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := db.GetNamedCollection(db.TeamsCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query teams: %w", err)
+	}
+
+	var teams []Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, fmt.Errorf("failed to decode teams: %w", err)
+	}
+	return NewRegistry(teams)
+}
+
+// Names returns the names of the registered teams in registry order.
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.teams))
+	for i, team := range r.teams {
+		names[i] = team.Name
+	}
+	return names
+}
+
+// Has reports whether name is a registered team or Unassigned.
+func (r *Registry) Has(name string) bool {
+	if name == Unassigned {
+		return true
+	}
+	for _, team := range r.teams {
+		if team.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TeamOf returns the team credited with work by personID in a repository at a given time.
+func (r *Registry) TeamOf(personID, workspace, repo string, at time.Time) string {
+	for _, team := range r.teams {
+		for _, member := range team.Members {
+			if personID != "" && member.PersonID == personID && member.Contains(at) {
+				return team.Name
+			}
+		}
+	}
+	for _, team := range r.teams {
+		for _, owned := range team.Repositories {
+			if owned.matches(workspace, repo) && owned.Contains(at) {
+				return team.Name
+			}
+		}
+	}
+	return Unassigned
+}
+
+func (o Repository) matches(workspace, repo string) bool {
+	return o.Repo == repo && (o.Workspace == "" || o.Workspace == workspace)
+}
+
+// TeamExpression returns an aggregation expression evaluating to the team of a document, following the same
+// rules as TeamOf. personField and dateField name the person and date fields of the document; the workspace
+// and repository are read from the workspace and repo_name fields every collection shares.
+func (r *Registry) TeamExpression(personField, dateField string) interface{} {
+	var branches bson.A
+	for _, team := range r.teams {
+		for _, member := range team.Members {
+			conditions := append(bson.A{bson.M{"$eq": bson.A{"$" + personField, member.PersonID}}}, member.Period.conditions(dateField)...)
+			branches = append(branches, bson.M{"case": bson.M{"$and": conditions}, "then": team.Name})
+		}
+	}
+	for _, team := range r.teams {
+		for _, owned := range team.Repositories {
+			conditions := bson.A{bson.M{"$eq": bson.A{"$repo_name", owned.Repo}}}
+			if owned.Workspace != "" {
+				conditions = append(conditions, bson.M{"$eq": bson.A{"$workspace", owned.Workspace}})
+			}
+			conditions = append(conditions, owned.Period.conditions(dateField)...)
+			branches = append(branches, bson.M{"case": bson.M{"$and": conditions}, "then": team.Name})
+		}
+	}
+
+	// $switch rejects an empty list of branches
+	if len(branches) == 0 {
+		return bson.M{"$literal": Unassigned}
+	}
+	return bson.M{"$switch": bson.M{"branches": branches, "default": Unassigned}}
+}
+
+func (p Period) conditions(dateField string) bson.A {
+	var conditions bson.A
+	if !p.From.IsZero() {
+		conditions = append(conditions, bson.M{"$gte": bson.A{"$" + dateField, p.From}})
+	}
+	if !p.To.IsZero() {
+		conditions = append(conditions, bson.M{"$lt": bson.A{"$" + dateField, p.To}})
+	}
+	return conditions
+}
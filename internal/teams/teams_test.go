@@ -0,0 +1,194 @@
+package teams
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lep13/bitbucket_metrics/config"
+	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	jan = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jul = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	registry, err := NewRegistry([]Team{
+		{
+			Name: "payments",
+			Members: []Member{
+				{PersonID: "jane", Period: Period{To: jul}},
+				{PersonID: "john"},
+			},
+			Repositories: []Repository{{Repo: "billing"}},
+		},
+		{
+			Name:         "platform",
+			Members:      []Member{{PersonID: "jane", Period: Period{From: jul}}},
+			Repositories: []Repository{{Workspace: "lep13", Repo: "infra", Period: Period{From: jan}}},
+		},
+	})
+	assert.NoError(t, err)
+	return registry
+}
+
+func TestTeamOf(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	assert.Equal(t, "payments", registry.TeamOf("jane", "lep13", "infra", jan))
+	assert.Equal(t, "platform", registry.TeamOf("jane", "lep13", "billing", jul))
+	assert.Equal(t, "payments", registry.TeamOf("john", "lep13", "infra", jul))
+	assert.Equal(t, "payments", registry.TeamOf("someone", "other", "billing", jul))
+	assert.Equal(t, "platform", registry.TeamOf("", "lep13", "infra", jul))
+	assert.Equal(t, Unassigned, registry.TeamOf("", "other", "infra", jul))
+	assert.Equal(t, Unassigned, registry.TeamOf("", "lep13", "infra", jan.Add(-time.Hour)))
+}
+
+func TestTeamExpression(t *testing.T) {
+	expr := newTestRegistry(t).TeamExpression("person_id", "commit_date").(bson.M)
+
+	branches := expr["$switch"].(bson.M)["branches"].(bson.A)
+	assert.Len(t, branches, 5)
+	assert.Equal(t, bson.M{
+		"case": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$person_id", "jane"}},
+			bson.M{"$lt": bson.A{"$commit_date", jul}},
+		}},
+		"then": "payments",
+	}, branches[0])
+	assert.Equal(t, bson.M{
+		"case": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$repo_name", "infra"}},
+			bson.M{"$eq": bson.A{"$workspace", "lep13"}},
+			bson.M{"$gte": bson.A{"$commit_date", jan}},
+		}},
+		"then": "platform",
+	}, branches[4])
+	assert.Equal(t, Unassigned, expr["$switch"].(bson.M)["default"])
+}
+
+func TestTeamExpression_Empty(t *testing.T) {
+	registry, err := NewRegistry(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$literal": Unassigned}, registry.TeamExpression("person_id", "commit_date"))
+	assert.Empty(t, registry.Names())
+}
+
+func TestHas(t *testing.T) {
+	registry := newTestRegistry(t)
+	assert.Equal(t, []string{"payments", "platform"}, registry.Names())
+	assert.True(t, registry.Has("platform"))
+	assert.True(t, registry.Has(Unassigned))
+	assert.False(t, registry.Has("marketing"))
+}
+
+func TestNewRegistry_Invalid(t *testing.T) {
+	tests := map[string][]Team{
+		"name is required":      {{}},
+		"is reserved":           {{Name: Unassigned}},
+		"is defined twice":      {{Name: "a"}, {Name: "a"}},
+		"person_id is required": {{Name: "a", Members: []Member{{}}}},
+		"repo is required":      {{Name: "a", Repositories: []Repository{{}}}},
+		"from must be before":   {{Name: "a", Members: []Member{{PersonID: "jane", Period: Period{From: jul, To: jan}}}}},
+	}
+	for msg, teams := range tests {
+		_, err := NewRegistry(teams)
+		if assert.Error(t, err, msg) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"teams": [
+		{"name": "payments", "members": [{"person_id": "jane", "to": "2024-07-01T00:00:00Z"}], "repositories": [{"repo": "billing"}]}
+	]}`), 0o600))
+
+	registry, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", registry.TeamOf("jane", "lep13", "infra", jan))
+	assert.Equal(t, Unassigned, registry.TeamOf("jane", "lep13", "infra", jul))
+
+	_, err = LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestNewSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"teams": [{"name": "payments"}]}`), 0o600))
+
+	source, err := NewSource(&config.Config{TeamsPath: path})
+	assert.NoError(t, err)
+	registry, err := source(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"payments"}, registry.Names())
+
+	_, err = NewSource(&config.Config{TeamsPath: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestLoadCollection(t *testing.T) {
+	cursor, err := mongo.NewCursorFromDocuments([]interface{}{
+		bson.M{"name": "payments", "members": bson.A{bson.M{"person_id": "jane", "from": jan}}},
+		bson.M{"name": "platform", "repositories": bson.A{bson.M{"repo": "infra"}}},
+	}, nil, nil)
+	assert.NoError(t, err)
+
+	collection := new(db.MockCollection)
+	collection.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(cursor, nil).Once()
+	useCollection(t, collection)
+
+	registry, err := LoadCollection(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", registry.TeamOf("jane", "lep13", "infra", jul))
+	assert.Equal(t, "platform", registry.TeamOf("jane", "lep13", "infra", jan.Add(-time.Hour)))
+	collection.AssertExpectations(t)
+}
+
+func TestLoadCollection_Error(t *testing.T) {
+	collection := new(db.MockCollection)
+	collection.On("Find", mock.Anything, bson.M{}, mock.Anything).Return(nil, errors.New("timeout")).Once()
+	useCollection(t, collection)
+
+	_, err := LoadCollection(context.Background())
+	assert.ErrorContains(t, err, "failed to query teams")
+}
+
+func useCollection(t *testing.T, collection *db.MockCollection) {
+	old := db.GetNamedCollectionFunc
+	t.Cleanup(func() { db.GetNamedCollectionFunc = old })
+	db.GetNamedCollectionFunc = func(name string) db.CollectionInterface {
+		assert.Equal(t, db.TeamsCollection, name)
+		return collection
+	}
+}
+
+func TestNewSource_PrivacyMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"teams": [{"name": "payments", "members": [{"person_id": "jane"}]}]}`), 0o600))
+
+	cfg := &config.Config{TeamsPath: path, PrivacyMode: true, PseudonymKey: "0123456789abcdef0123456789abcdef"}
+	pseudonymizer, err := privacy.NewFromConfig(cfg)
+	assert.NoError(t, err)
+
+	source, err := NewSource(cfg)
+	assert.NoError(t, err)
+	registry, err := source(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", registry.TeamOf(pseudonymizer.Pseudonym("jane"), "lep13", "infra", jul))
+	assert.Equal(t, Unassigned, registry.TeamOf("jane", "lep13", "infra", jul))
+
+	_, err = NewSource(&config.Config{TeamsPath: path, PrivacyMode: true})
+	assert.Error(t, err)
+}
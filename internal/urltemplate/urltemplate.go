@@ -0,0 +1,147 @@
+// Package urltemplate fills the named placeholders of configured URL templates, such as
+// https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}.
+package urltemplate
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Placeholders of the Bitbucket Cloud URL templates.
+const (
+	Workspace     = "username"
+	RepoSlug      = "repo_slug"
+	CommitHash    = "commit_hash"
+	PullRequestID = "pull_request_id"
+)
+
+// Values maps placeholder names to the values filled in for them.
+type Values map[string]string
+
+// segment is a literal part of a template, or a placeholder when name is set.
+type segment struct {
+	literal string
+	name    string
+	query   bool
+}
+
+// parse splits template into literals and placeholders. Placeholders after the "?" are part of the query.
+func parse(template string) ([]segment, error) {
+	if template == "" {
+		return nil, fmt.Errorf("empty URL template")
+	}
+
+	var segments []segment
+	query := false
+	rest := template
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			segments = append(segments, segment{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("unexpected } at offset %d of %s", len(template)-len(rest)+open, template)
+		}
+		if open > 0 {
+			segments = append(segments, segment{literal: rest[:open]})
+			query = query || strings.Contains(rest[:open], "?")
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return nil, fmt.Errorf("unclosed placeholder at offset %d of %s", len(template)-len(rest)+open, template)
+		}
+		name := rest[open+1 : open+1+end]
+		if name == "" {
+			return nil, fmt.Errorf("empty placeholder at offset %d of %s", len(template)-len(rest)+open, template)
+		}
+		segments = append(segments, segment{name: name, query: query})
+		rest = rest[open+end+2:]
+	}
+	return segments, nil
+}
+
+// Validate checks that template is well formed and holds each of the given placeholders and no other.
+func Validate(template string, placeholders ...string) error {
+	segments, err := parse(template)
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for _, s := range segments {
+		if s.name == "" {
+			continue
+		}
+		known := false
+		for _, placeholder := range placeholders {
+			known = known || s.name == placeholder
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder {%s} in %s, expected %s", s.name, template, list(placeholders))
+		}
+		used[s.name] = true
+	}
+	for _, placeholder := range placeholders {
+		if !used[placeholder] {
+			return fmt.Errorf("missing placeholder {%s} in %s", placeholder, template)
+		}
+	}
+
+	u, err := url.Parse(example(segments))
+	if err != nil {
+		return fmt.Errorf("invalid URL template %s: %w", template, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("URL template %s is not an absolute URL", template)
+	}
+	return nil
+}
+
+// Expand fills the placeholders of template with values, escaping them for the path or, after the "?", the query.
+func Expand(template string, values Values) (string, error) {
+	segments, err := parse(template)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, s := range segments {
+		if s.name == "" {
+			b.WriteString(s.literal)
+			continue
+		}
+		value, ok := values[s.name]
+		if !ok {
+			return "", fmt.Errorf("missing value for placeholder {%s} in %s", s.name, template)
+		}
+		if s.query {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+	}
+	return b.String(), nil
+}
+
+// example fills every placeholder with its name, giving a URL of the shape the template expands to.
+func example(segments []segment) string {
+	var b strings.Builder
+	for _, s := range segments {
+		if s.name == "" {
+			b.WriteString(s.literal)
+		} else {
+			b.WriteString(s.name)
+		}
+	}
+	return b.String()
+}
+
+func list(placeholders []string) string {
+	names := make([]string, len(placeholders))
+	for i, placeholder := range placeholders {
+		names[i] = "{" + placeholder + "}"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,67 @@
+package urltemplate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const commitTemplate = "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}"
+
+func TestExpand(t *testing.T) {
+	url, err := Expand(commitTemplate, Values{Workspace: "lep13", RepoSlug: "repo1", CommitHash: "abc123"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.bitbucket.org/2.0/repositories/lep13/repo1/commit/abc123", url)
+
+	// Values are escaped for the part of the URL they end up in
+	url, err = Expand("https://git.example.com/{username}/{repo_slug}?branch={commit_hash}", Values{Workspace: "a/b", RepoSlug: "my repo", CommitHash: "feature/x&y"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://git.example.com/a%2Fb/my%20repo?branch=feature%2Fx%26y", url)
+
+	_, err = Expand(commitTemplate, Values{Workspace: "lep13", RepoSlug: "repo1"})
+	assert.EqualError(t, err, "missing value for placeholder {commit_hash} in "+commitTemplate)
+
+	_, err = Expand("", Values{})
+	assert.EqualError(t, err, "empty URL template")
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(commitTemplate, Workspace, RepoSlug, CommitHash))
+
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/",
+			err:      "missing placeholder {commit_hash} in https://api.bitbucket.org/2.0/repositories/{username}/{repo_slug}/commit/",
+		},
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/{workspace}/{repo_slug}/commit/{commit_hash}",
+			err:      "unknown placeholder {workspace} in https://api.bitbucket.org/2.0/repositories/{workspace}/{repo_slug}/commit/{commit_hash}, expected {username}, {repo_slug}, {commit_hash}",
+		},
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/%s/%s/commit/%s",
+			err:      "missing placeholder {username} in https://api.bitbucket.org/2.0/repositories/%s/%s/commit/%s",
+		},
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/{username/{repo_slug}/commit/{commit_hash}",
+			err:      "unclosed placeholder at offset 43 of https://api.bitbucket.org/2.0/repositories/{username/{repo_slug}/commit/{commit_hash}",
+		},
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/{}/{username}/{repo_slug}/commit/{commit_hash}",
+			err:      "empty placeholder at offset 43 of https://api.bitbucket.org/2.0/repositories/{}/{username}/{repo_slug}/commit/{commit_hash}",
+		},
+		{
+			template: "https://api.bitbucket.org/2.0/repositories/username}/{repo_slug}/commit/{commit_hash}",
+			err:      "unexpected } at offset 51 of https://api.bitbucket.org/2.0/repositories/username}/{repo_slug}/commit/{commit_hash}",
+		},
+		{
+			template: "/2.0/repositories/{username}/{repo_slug}/commit/{commit_hash}",
+			err:      "URL template /2.0/repositories/{username}/{repo_slug}/commit/{commit_hash} is not an absolute URL",
+		},
+	}
+	for _, tt := range tests {
+		assert.EqualError(t, Validate(tt.template, Workspace, RepoSlug, CommitHash), tt.err)
+	}
+}
@@ -1,31 +1,417 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/lep13/bitbucket_metrics/config"
+	"github.com/lep13/bitbucket_metrics/internal/analytics"
+	"github.com/lep13/bitbucket_metrics/internal/api"
 	"github.com/lep13/bitbucket_metrics/internal/bitbucket"
 	db "github.com/lep13/bitbucket_metrics/internal/database"
+	"github.com/lep13/bitbucket_metrics/internal/export"
+	"github.com/lep13/bitbucket_metrics/internal/logging"
+	"github.com/lep13/bitbucket_metrics/internal/maintenance"
+	"github.com/lep13/bitbucket_metrics/internal/metrics"
+	"github.com/lep13/bitbucket_metrics/internal/privacy"
+	"github.com/lep13/bitbucket_metrics/internal/report"
+	"github.com/lep13/bitbucket_metrics/internal/rework"
+	"github.com/lep13/bitbucket_metrics/internal/teams"
 )
 
 func main() {
-	// Initialize configuration
-	config, err := config.LoadConfig()
+	// The first argument selects the command, sync being the default
+	command, args := "sync", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// Logs are configured through the environment, before anything is logged
+	logOptions, err := logging.OptionsFromEnv()
+	if err != nil {
+		fatal("Error configuring logs", logging.KeyError, err)
+	}
+	if err := logging.Setup(logOptions); err != nil {
+		fatal("Error configuring logs", logging.KeyError, err)
+	}
+
+	// Initialize configuration, connecting to MongoDB with its credentials
+	secretProvider, err := config.SecretProviderFunc()
+	if err != nil {
+		fatal("Error configuring secret provider", logging.KeyError, err)
+	}
+	reloader := config.NewReloader(secretProvider, applyConfig)
+	config, err := reloader.Load()
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("Error loading config", logging.KeyError, err)
+	}
+
+	switch command {
+	case "sync":
+		runSync(config.BitbucketAccessToken, args)
+	case "serve":
+		teamSource, err := teams.NewSource(config)
+		if err != nil {
+			fatal("Error loading teams", logging.KeyError, err)
+		}
+		api.TeamSource = teamSource
+		runServe(reloader, args)
+	case "retention":
+		runRetention(config.RetentionPolicies)
+	case "erase":
+		runErase(config, args)
+	case "export":
+		runExport(config, args)
+	case "report":
+		runReport(config, args)
+	case "rework":
+		runRework(config, args)
+	default:
+		fatal("Unknown command, expected sync, serve, retention, erase, export, report or rework", "command", command)
 	}
+}
+
+// syncMu keeps configuration changes from being applied during a sync, and guards syncConfig.
+var syncMu sync.Mutex
+
+// syncConfig is the configuration last applied, which background syncs run with.
+var syncConfig *config.Config
+
+// applyConfig connects to the MongoDB of cfg and configures the sync with cfg, failing when either is invalid.
+// The sync configuration is checked before connecting, so a rejected configuration changes nothing. It waits for
+// the sync in progress, so the client a reconnect replaces is no longer in use by a sync.
+// It waits for a running sync to finish first.
+func applyConfig(cfg *config.Config) error {
+	syncMu.Lock()
+	defer syncMu.Unlock()
 
-	// Initialize MongoDB
-	err = db.InitializeMongoDB(config.MongoDBURI)
+	prepared, err := bitbucket.Prepare(cfg)
 	if err != nil {
-		log.Fatalf("Error initializing MongoDB: %v", err)
+		return err
 	}
+	if err := db.Reconnect(cfg.MongoDBURI); err != nil {
+		return fmt.Errorf("failed to initialize MongoDB: %w", err)
+	}
+	prepared.Apply()
+	syncConfig = cfg
+	return nil
+}
 
-	// Fetch commit data from Bitbucket and save to MongoDB
-	err = bitbucket.FetchAndSaveCommits(config.BitbucketAccessToken)
+// runSync fetches commit data from Bitbucket and saves it to MongoDB, or only reports what it would save on a dry run.
+// It exits with exitSyncPartial when some of the data failed and exitSyncFailed when the sync failed.
+func runSync(accessToken string, args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "fetch and transform without writing, printing the changes per repository")
+	dump := flags.String("dump", "", "on a dry run, file to write the documents a sync would write to as JSON lines")
+	flags.Parse(args)
+
+	if *dryRun {
+		runDryRun(accessToken, *dump)
+		return
+	}
+	if *dump != "" {
+		fatal("The -dump flag requires -dry-run")
+	}
+
+	summary, err := bitbucket.FetchAndSaveCommits(accessToken)
 	if err != nil {
-		log.Fatalf("Error fetching and saving commits: %v", err)
+		slog.Error("Failed to fetch and save commits", logging.KeyError, err)
+		os.Exit(syncExitCode(summary))
+	}
+
+	slog.Info("Successfully fetched and saved commit data")
+}
+
+// Exit codes of the sync command besides success.
+const (
+	exitSyncFailed  = 1
+	exitSyncPartial = 2
+)
+
+// syncExitCode tells a sync that saved some of the data apart from one that failed.
+func syncExitCode(summary *bitbucket.RunSummary) int {
+	if summary.Status == bitbucket.RunPartial {
+		return exitSyncPartial
 	}
+	return exitSyncFailed
+}
+
+// runDryRun prints what a sync would change, optionally dumping the documents it would write to a file.
+func runDryRun(accessToken, dump string) {
+	// The dump stays a nil writer unless asked for, as a nil *os.File would not be
+	var w io.Writer
+	var out *os.File
+	if dump != "" {
+		var err error
+		if out, err = os.Create(dump); err != nil {
+			fatal("Error creating dump file", logging.KeyError, err)
+		}
+		w = out
+	}
+
+	changes, err := bitbucket.DryRun(accessToken, w)
+	if err != nil {
+		fatal("Error running sync dry run", logging.KeyError, err)
+	}
+	if out != nil {
+		if err := out.Close(); err != nil {
+			fatal("Error closing dump file", logging.KeyError, err)
+		}
+	}
+	if err := changes.Print(os.Stdout); err != nil {
+		fatal("Error printing dry run", logging.KeyError, err)
+	}
+}
+
+// runRetention deletes the data older than the configured retention policies.
+func runRetention(policies []config.RetentionPolicy) {
+	if err := maintenance.EnforceRetention(context.Background(), policies); err != nil {
+		fatal("Error enforcing retention", logging.KeyError, err)
+	}
+
+	slog.Info("Successfully enforced retention policies")
+}
+
+// runRework links reverts and reworked changes between the stored commits.
+func runRework(cfg *config.Config, args []string) {
+	window := rework.DefaultWindow
+	if cfg.ReworkWindowDays > 0 {
+		window = time.Duration(cfg.ReworkWindowDays) * 24 * time.Hour
+	}
+	flags := flag.NewFlagSet("rework", flag.ExitOnError)
+	flags.DurationVar(&window, "window", window, "how recent changed lines must be to count as rework")
+	flags.Parse(args)
+
+	if _, err := rework.Detect(context.Background(), window); err != nil {
+		fatal("Error detecting rework", logging.KeyError, err)
+	}
+
+	slog.Info("Successfully detected rework")
+}
+
+// runErase deletes or anonymizes every record of one person.
+func runErase(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("erase", flag.ExitOnError)
+	person := flags.String("person", "", "person ID to erase, as resolved by the identity rules")
+	mode := flags.String("mode", maintenance.ModeAnonymize, "delete or anonymize the records of the person")
+	actor := flags.String("actor", os.Getenv("USER"), "who requested the erasure, recorded in the audit log")
+	flags.Parse(args)
+
+	if *person == "" {
+		fatal("Missing -person")
+	}
+
+	// Stored person IDs are pseudonyms in privacy mode, while the team registry lists clear ones
+	pseudonymizer, err := privacy.NewFromConfig(cfg)
+	if err != nil {
+		fatal("Error configuring privacy mode", logging.KeyError, err)
+	}
+
+	// The names of the person are recorded as hashes keyed with the pseudonym key, whatever the privacy mode
+	hasher, err := privacy.NewHasherFromConfig(cfg)
+	if err != nil {
+		fatal("Error configuring pseudonym key", logging.KeyError, err)
+	}
+
+	err = maintenance.Erase(context.Background(), maintenance.ErasureRequest{
+		PersonID:         pseudonymizer.Pseudonym(*person),
+		RegistryPersonID: *person,
+		Mode:             *mode,
+		Actor:            *actor,
+		Hasher:           hasher,
+	})
+	if err != nil {
+		fatal("Error erasing person", "person", *person, logging.KeyError, err)
+	}
+
+	slog.Info("Successfully erased person", "person", *person, "mode", *mode)
+}
+
+// runExport writes the stored records matching the given filters to a file or stdout.
+func runExport(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	recordType := flags.String("type", export.TypeCommits, "records to export: commits, files or pullrequests")
+	format := flags.String("format", export.FormatCSV, "output format: csv, jsonl or parquet")
+	output := flags.String("o", "", "output file, stdout when empty")
+	filter := db.Filter{}
+	flags.StringVar(&filter.Workspace, "workspace", "", "only export this workspace")
+	flags.StringVar(&filter.Project, "project", "", "only export this project")
+	flags.StringVar(&filter.Repo, "repo", "", "only export this repository")
+	flags.StringVar(&filter.Author, "author", "", "only export this author name")
+	flags.StringVar(&filter.Person, "person", "", "only export this person ID")
+	flags.StringVar(&filter.Team, "team", "", "only export this team")
+	from := flags.String("from", "", "only export records on or after this date")
+	to := flags.String("to", "", "only export records before this timestamp or up to this date")
+	classifications := flags.String("classification", db.AllClassifications, "only export commits of these comma-separated classifications")
+	flags.Parse(args)
+
+	var err error
+	if filter.From, filter.To, err = db.ParseDateRange(*from, *to); err != nil {
+		fatal("Error parsing dates", logging.KeyError, err)
+	}
+	if filter.Classifications, err = db.ParseClassifications(*classifications); err != nil {
+		fatal("Error parsing classifications", logging.KeyError, err)
+	}
+	loadTeamFilter(cfg, &filter)
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fatal("Error creating output file", logging.KeyError, err)
+		}
+	}
+
+	count, err := export.Export(context.Background(), out, export.Options{Type: *recordType, Format: *format, Filter: filter})
+	if err != nil {
+		fatal("Error exporting", "type", *recordType, logging.KeyError, err)
+	}
+	if err := out.Close(); err != nil {
+		fatal("Error closing output file", logging.KeyError, err)
+	}
+
+	slog.Info("Successfully exported rows", "type", *recordType, "count", count)
+}
+
+// runReport renders an activity report for the given period and filters to a file or stdout.
+func runReport(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", report.FormatHTML, "output format: html or markdown")
+	output := flags.String("o", "", "output file, stdout when empty")
+	title := flags.String("title", "", "report title")
+	compare := flags.String("compare", "", "compare with the previous period, grouped by repository or team")
+	filter := db.Filter{}
+	flags.StringVar(&filter.Workspace, "workspace", "", "only report on this workspace")
+	flags.StringVar(&filter.Project, "project", "", "only report on this project")
+	flags.StringVar(&filter.Repo, "repo", "", "only report on this repository")
+	flags.StringVar(&filter.Author, "author", "", "only report on this author name")
+	flags.StringVar(&filter.Person, "person", "", "only report on this person ID")
+	flags.StringVar(&filter.Team, "team", "", "only report on this team")
+	from := flags.String("from", "", "start of the period, 30 days ago when empty")
+	to := flags.String("to", "", "end of the period as a timestamp or inclusive date, today when empty")
+	classifications := flags.String("classification", "", "only count commits of these comma-separated classifications, all but merges and bots when empty")
+	flags.Parse(args)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if *from == "" {
+		*from = today.AddDate(0, 0, -30).Format(time.DateOnly)
+	}
+	if *to == "" {
+		*to = today.Format(time.DateOnly)
+	}
+	var err error
+	if filter.From, filter.To, err = db.ParseDateRange(*from, *to); err != nil {
+		fatal("Error parsing dates", logging.KeyError, err)
+	}
+	if filter.Classifications, err = db.ParseClassifications(*classifications); err != nil {
+		fatal("Error parsing classifications", logging.KeyError, err)
+	}
+	loadTeamFilter(cfg, &filter)
+	if *compare == analytics.GroupByTeam && filter.Teams == nil {
+		filter.Teams = loadTeams(cfg)
+	}
+
+	r, err := report.Build(context.Background(), report.Options{Title: *title, Filter: filter, Compare: *compare})
+	if err != nil {
+		fatal("Error building report", logging.KeyError, err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fatal("Error creating output file", logging.KeyError, err)
+		}
+	}
+	if err := report.Render(out, r, *format); err != nil {
+		fatal("Error rendering report", logging.KeyError, err)
+	}
+	if err := out.Close(); err != nil {
+		fatal("Error closing output file", logging.KeyError, err)
+	}
+
+	slog.Info("Successfully rendered report", "format", *format, "scope", r.Scope)
+}
+
+// loadTeamFilter attaches the team registry to filter when it selects a team, exiting on unknown teams.
+func loadTeamFilter(cfg *config.Config, filter *db.Filter) {
+	if filter.Team == "" {
+		return
+	}
+	registry := loadTeams(cfg)
+	if !registry.Has(filter.Team) {
+		fatal("Unknown team", "team", filter.Team)
+	}
+	filter.Teams = registry
+}
+
+// loadTeams loads the team registry, exiting on failure.
+func loadTeams(cfg *config.Config) *teams.Registry {
+	teamSource, err := teams.NewSource(cfg)
+	if err != nil {
+		fatal("Error loading teams", logging.KeyError, err)
+	}
+	registry, err := teamSource(context.Background())
+	if err != nil {
+		fatal("Error loading teams", logging.KeyError, err)
+	}
+	return registry
+}
+
+// runServe serves the read-only API and the Prometheus metrics, optionally syncing and enforcing retention
+// on a fixed interval and picking up rotated secrets on another.
+func runServe(reloader *config.Reloader, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	syncInterval := flags.Duration("sync-interval", 0, "interval between background syncs, 0 to disable")
+	secretRefreshInterval := flags.Duration("secret-refresh-interval", 0, "interval between checks for rotated secrets, 0 to disable")
+	flags.Parse(args)
+
+	if *secretRefreshInterval > 0 {
+		go reloader.RefreshPeriodically(*secretRefreshInterval)
+	}
+	if *syncInterval > 0 {
+		go syncPeriodically(*syncInterval)
+	}
+
+	mux := api.NewHandler()
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	slog.Info("Serving API and metrics", "addr", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fatal("Error serving API", logging.KeyError, err)
+	}
+}
+
+// syncPeriodically runs a sync, followed by retention when policies are configured, immediately and then
+// once per interval, logging failures instead of exiting. Every run uses the configuration applied last.
+func syncPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		syncMu.Lock()
+		cfg := syncConfig
+		if _, err := bitbucket.FetchAndSaveCommits(cfg.BitbucketAccessToken); err != nil {
+			slog.Error("Failed to fetch and save commits", logging.KeyError, err)
+		}
+		if len(cfg.RetentionPolicies) > 0 {
+			if err := maintenance.EnforceRetention(context.Background(), cfg.RetentionPolicies); err != nil {
+				slog.Error("Failed to enforce retention", logging.KeyError, err)
+			}
+		}
+		syncMu.Unlock()
+		<-ticker.C
+	}
+}
 
-	log.Println("Successfully fetched and saved commit data")
+// fatal logs msg and args as an error and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }